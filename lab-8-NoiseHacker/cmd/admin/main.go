@@ -2,25 +2,46 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"text/tabwriter"
 	"time"
 	"tritontube/internal/proto"
+	"tritontube/internal/telemetry"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+var (
+	timeout    = flag.Duration("timeout", 0, "how long to wait for the server, 0 for the command's default")
+	jsonOutput = flag.Bool("json", false, "print the server's response as JSON")
+)
+
+// Default timeouts. Membership changes wait for their migration to cut over.
+const (
+	queryTimeout     = 5 * time.Second
+	migrationTimeout = time.Hour
+	scanTimeout      = 10 * time.Minute
 )
 
 func main() {
-	if len(os.Args) < 3 { // Minimum 3 args: program, command, server_address
+	flag.Usage = printUsageAndExit
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 { // Minimum 2 args: command, server_address
 		printUsageAndExit()
 	}
 
-	cmd := os.Args[1]
-	serverAddr := os.Args[2]
+	cmd := args[0]
+	serverAddr := args[1]
 
-	conn, err := grpc.NewClient(serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(serverAddr, append(telemetry.DialOptions(), grpc.WithTransportCredentials(insecure.NewCredentials()))...)
 	if err != nil {
 		log.Fatalf("Failed to connect to server: %v", err)
 	}
@@ -30,23 +51,55 @@ func main() {
 
 	switch cmd {
 	case "add":
-		if len(os.Args) != 4 {
+		if len(args) != 3 {
 			fmt.Println("Usage: add <server_address> <node_address>")
 			os.Exit(1)
 		}
-		addNode(client, os.Args[3])
+		addNode(client, args[2])
 	case "remove":
-		if len(os.Args) != 4 {
+		if len(args) != 3 {
 			fmt.Println("Usage: remove <server_address> <node_address>")
 			os.Exit(1)
 		}
-		removeNode(client, os.Args[3])
+		removeNode(client, args[2])
 	case "list":
-		if len(os.Args) != 3 {
+		if len(args) != 2 {
 			fmt.Println("Usage: list <server_address>")
 			os.Exit(1)
 		}
 		listNodes(client)
+	case "migration":
+		if len(args) != 2 {
+			fmt.Println("Usage: migration <server_address>")
+			os.Exit(1)
+		}
+		migrationStatus(client)
+	case "repair":
+		if len(args) != 2 {
+			fmt.Println("Usage: repair <server_address>")
+			os.Exit(1)
+		}
+		repair(client)
+	case "drain":
+		if len(args) != 3 {
+			fmt.Println("Usage: drain <server_address> <node_address>")
+			os.Exit(1)
+		}
+		drainNode(client, args[2])
+	case "rebalance":
+		if len(args) != 2 {
+			fmt.Println("Usage: rebalance <server_address>")
+			os.Exit(1)
+		}
+		rebalance(client)
+	case "verify":
+		verify(client, args[2:])
+	case "status":
+		if len(args) != 2 {
+			fmt.Println("Usage: status <server_address>")
+			os.Exit(1)
+		}
+		clusterStatus(client)
 	default:
 		fmt.Printf("Unknown command: %s\n", cmd)
 		printUsageAndExit()
@@ -54,22 +107,58 @@ func main() {
 }
 
 func printUsageAndExit() {
-	fmt.Println("Usage:")
+	fmt.Println("Usage: admin [-timeout <duration>] [-json] <command> <server_address> [args]")
+	fmt.Println()
+	fmt.Println("Commands:")
 	fmt.Println("  add <server_address> <node_address>     - Add a node to the cluster")
 	fmt.Println("  remove <server_address> <node_address>  - Remove a node from the cluster")
-	fmt.Println("  list <server_address>                   - List all nodes in the cluster")
+	fmt.Println("  list <server_address>                   - List all nodes in the cluster with their health and usage")
+	fmt.Println("  migration <server_address>              - Show progress of the current or last migration")
+	fmt.Println("  repair <server_address>                 - Repair files missing from or differing between storage nodes")
+	fmt.Println("  drain <server_address> <node_address>   - Stop placing keys on a node, move its keys off and remove it")
+	fmt.Println("  rebalance <server_address>              - Reweight nodes by their capacity and move keys to their owners")
+	fmt.Println("  verify <server_address> [video_id...]   - Check every file of the videos is on all of its owners")
+	fmt.Println("  status <server_address>                 - Show the cluster layout and each node's keys, bytes and health")
+	fmt.Println()
+	fmt.Println("Flags:")
+	flag.PrintDefaults()
 	os.Exit(1)
 }
 
+// Returns the context for one RPC, with the -timeout flag or else the
+// command's default
+func rpcContext(defaultTimeout time.Duration) (context.Context, context.CancelFunc) {
+	if *timeout > 0 {
+		defaultTimeout = *timeout
+	}
+	return context.WithTimeout(telemetry.Background(), defaultTimeout)
+}
+
+// Prints a response as JSON for -json, and reports whether it did
+func printJSON(response protobuf.Message) bool {
+	if !*jsonOutput {
+		return false
+	}
+	out, err := protojson.MarshalOptions{Multiline: true, Indent: "  ", EmitUnpopulated: true, UseProtoNames: true}.Marshal(response)
+	if err != nil {
+		log.Fatalf("Failed to encode response: %v", err)
+	}
+	fmt.Println(string(out))
+	return true
+}
+
 func addNode(client proto.VideoContentAdminServiceClient, nodeAddr string) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := rpcContext(migrationTimeout)
 	defer cancel()
 
 	response, err := client.AddNode(ctx, &proto.AddNodeRequest{
 		NodeAddress: nodeAddr,
 	})
 	if err != nil {
-		log.Fatalf("AddNode RPC failed: %v", err)
+		log.Fatalf("AddNode RPC failed (request ID %s): %v", telemetry.RequestID(ctx), err)
+	}
+	if printJSON(response) {
+		return
 	}
 
 	fmt.Printf("Successfully added node: %s\n", nodeAddr)
@@ -77,14 +166,17 @@ func addNode(client proto.VideoContentAdminServiceClient, nodeAddr string) {
 }
 
 func removeNode(client proto.VideoContentAdminServiceClient, nodeAddr string) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := rpcContext(migrationTimeout)
 	defer cancel()
 
 	response, err := client.RemoveNode(ctx, &proto.RemoveNodeRequest{
 		NodeAddress: nodeAddr,
 	})
 	if err != nil {
-		log.Fatalf("RemoveNode RPC failed: %v", err)
+		log.Fatalf("RemoveNode RPC failed (request ID %s): %v", telemetry.RequestID(ctx), err)
+	}
+	if printJSON(response) {
+		return
 	}
 
 	fmt.Printf("Successfully removed node: %s\n", nodeAddr)
@@ -92,20 +184,255 @@ func removeNode(client proto.VideoContentAdminServiceClient, nodeAddr string) {
 }
 
 func listNodes(client proto.VideoContentAdminServiceClient) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	// Leaves the server time to ask every node for its usage
+	ctx, cancel := rpcContext(queryTimeout)
 	defer cancel()
 
 	response, err := client.ListNodes(ctx, &proto.ListNodesRequest{})
 	if err != nil {
-		log.Fatalf("ListNodes RPC failed: %v", err)
+		log.Fatalf("ListNodes RPC failed (request ID %s): %v", telemetry.RequestID(ctx), err)
+	}
+	if printJSON(response) {
+		return
 	}
 
 	fmt.Println("Storage cluster nodes:")
 	if len(response.Nodes) == 0 {
 		fmt.Println("  No nodes in cluster")
 	} else {
-		for _, node := range response.Nodes {
-			fmt.Printf("  - %s\n", node)
+		for i, node := range response.Nodes {
+			if i >= len(response.NodeStatuses) {
+				fmt.Printf("  - %s\n", node)
+				continue
+			}
+			status := response.NodeStatuses[i]
+			lastSeen := "never"
+			if status.LastSeen != 0 {
+				lastSeen = time.Unix(status.LastSeen, 0).Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("  - %s  health: %s  last seen: %s", node, status.Health, lastSeen)
+			if status.ConsecutiveFailures > 0 {
+				fmt.Printf("  failed checks: %d", status.ConsecutiveFailures)
+			}
+			fmt.Println()
+			if status.StatsError != "" {
+				fmt.Printf("      usage: unknown (%s)  vnodes: %d\n", status.StatsError, status.Vnodes)
+				continue
+			}
+			utilization := 0.0
+			if status.CapacityBytes > 0 {
+				utilization = 100 * float64(status.UsedBytes) / float64(status.CapacityBytes)
+			}
+			fmt.Printf("      usage: %s / %s (%.1f%%)  files: %d  vnodes: %d\n",
+				formatBytes(status.UsedBytes), formatBytes(status.CapacityBytes), utilization, status.FileCount, status.Vnodes)
+		}
+	}
+}
+
+// Formats a byte count with a binary unit, e.g. 1.5 GiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func migrationStatus(client proto.VideoContentAdminServiceClient) {
+	ctx, cancel := rpcContext(queryTimeout)
+	defer cancel()
+
+	response, err := client.MigrationStatus(ctx, &proto.MigrationStatusRequest{})
+	if err != nil {
+		log.Fatalf("MigrationStatus RPC failed (request ID %s): %v", telemetry.RequestID(ctx), err)
+	}
+	if printJSON(response) {
+		return
+	}
+
+	if response.StartedAt == 0 {
+		fmt.Println("No migration has run yet")
+		return
+	}
+	state := "finished"
+	if response.InProgress {
+		state = "in progress"
+	}
+	operation := response.Operation
+	if response.NodeAddress != "" {
+		operation += " " + response.NodeAddress
+	}
+	fmt.Printf("Migration (%s): %s\n", operation, state)
+	fmt.Printf("  Started at: %s\n", time.Unix(response.StartedAt, 0).Format("2006-01-02 15:04:05"))
+	if !response.InProgress {
+		fmt.Printf("  Finished at: %s\n", time.Unix(response.FinishedAt, 0).Format("2006-01-02 15:04:05"))
+	}
+	fmt.Printf("  Files migrated: %d/%d\n", response.MigratedFileCount, response.TotalFileCount)
+	if response.FailedFileCount > 0 {
+		fmt.Printf("  Files failed: %d\n", response.FailedFileCount)
+	}
+}
+
+func repair(client proto.VideoContentAdminServiceClient) {
+	// A pass reads a Merkle tree from every node and copies what differs
+	ctx, cancel := rpcContext(scanTimeout)
+	defer cancel()
+
+	response, err := client.Repair(ctx, &proto.RepairRequest{})
+	if err != nil {
+		log.Fatalf("Repair RPC failed (request ID %s): %v", telemetry.RequestID(ctx), err)
+	}
+	if printJSON(response) {
+		return
+	}
+
+	fmt.Printf("Files compared: %d\n", response.KeyCount)
+	fmt.Printf("  Divergent: %d\n", response.DivergentCount)
+	fmt.Printf("  Repaired: %d\n", response.RepairedCount)
+	if response.FailedCount > 0 {
+		fmt.Printf("  Failed: %d\n", response.FailedCount)
+	}
+	if response.ConflictCount > 0 {
+		fmt.Printf("  Conflicting versions, left alone: %d\n", response.ConflictCount)
+	}
+	if response.SkippedRangeCount > 0 {
+		fmt.Printf("  Ring ranges skipped, too few owners up: %d\n", response.SkippedRangeCount)
+	}
+}
+
+func drainNode(client proto.VideoContentAdminServiceClient, nodeAddr string) {
+	ctx, cancel := rpcContext(migrationTimeout)
+	defer cancel()
+
+	response, err := client.Drain(ctx, &proto.DrainRequest{
+		NodeAddress: nodeAddr,
+	})
+	if err != nil {
+		log.Fatalf("Drain RPC failed (request ID %s): %v", telemetry.RequestID(ctx), err)
+	}
+	if printJSON(response) {
+		return
+	}
+
+	fmt.Printf("Number of files migrated: %d\n", response.MigratedFileCount)
+	if response.FailedFileCount > 0 {
+		fmt.Printf("Number of files failed: %d\n", response.FailedFileCount)
+	}
+	if response.Removed {
+		fmt.Printf("Successfully drained and removed node: %s\n", nodeAddr)
+	} else {
+		fmt.Printf("Node %s still holds files and stays draining; run drain again to retry\n", nodeAddr)
+		os.Exit(1)
+	}
+}
+
+func rebalance(client proto.VideoContentAdminServiceClient) {
+	ctx, cancel := rpcContext(migrationTimeout)
+	defer cancel()
+
+	response, err := client.Rebalance(ctx, &proto.RebalanceRequest{})
+	if err != nil {
+		log.Fatalf("Rebalance RPC failed (request ID %s): %v", telemetry.RequestID(ctx), err)
+	}
+	if printJSON(response) {
+		return
+	}
+
+	if len(response.Reweighted) == 0 {
+		fmt.Println("No node weights changed")
+	}
+	for _, w := range response.Reweighted {
+		fmt.Printf("  - %s  vnodes: %d -> %d\n", w.Address, w.OldVnodes, w.NewVnodes)
+	}
+	fmt.Printf("Number of files migrated: %d\n", response.MigratedFileCount)
+	if response.FailedFileCount > 0 {
+		fmt.Printf("Number of files failed: %d\n", response.FailedFileCount)
+	}
+}
+
+// Exits with status 1 if any file is missing or misplaced
+func verify(client proto.VideoContentAdminServiceClient, videoIDs []string) {
+	ctx, cancel := rpcContext(scanTimeout)
+	defer cancel()
+
+	response, err := client.Verify(ctx, &proto.VerifyRequest{VideoIds: videoIDs})
+	if err != nil {
+		log.Fatalf("Verify RPC failed (request ID %s): %v", telemetry.RequestID(ctx), err)
+	}
+	if !printJSON(response) {
+		fmt.Printf("Videos checked: %d\n", response.VideoCount)
+		fmt.Printf("Files checked: %d\n", response.FileCount)
+		if len(response.UnreachableNodes) > 0 {
+			fmt.Printf("Nodes not checked, unreachable: %s\n", strings.Join(response.UnreachableNodes, ", "))
+		}
+		for _, problem := range response.Problems {
+			fmt.Printf("  - %s\n", problem.VideoId)
+			for _, filename := range problem.MissingFiles {
+				fmt.Printf("      missing: %s\n", filename)
+			}
+			for _, p := range problem.Misplaced {
+				fmt.Printf("      not on all owners: %s  missing from: %s\n", p.Filename, strings.Join(p.MissingFrom, ", "))
+			}
+		}
+		if len(response.Problems) == 0 {
+			fmt.Println("All files are on their owners")
+		}
+	}
+	if len(response.Problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+func clusterStatus(client proto.VideoContentAdminServiceClient) {
+	// Leaves the server time to ask every node for its usage
+	ctx, cancel := rpcContext(queryTimeout)
+	defer cancel()
+
+	response, err := client.ClusterStatus(ctx, &proto.ClusterStatusRequest{})
+	if err != nil {
+		log.Fatalf("ClusterStatus RPC failed (request ID %s): %v", telemetry.RequestID(ctx), err)
+	}
+	if printJSON(response) {
+		return
+	}
+
+	if response.DataShards > 0 {
+		fmt.Printf("Erasure coding: %d data + %d parity shards\n", response.DataShards, response.ParityShards)
+	} else {
+		fmt.Printf("Replicas: %d\n", response.Replicas)
+	}
+	if response.VnodeSize > 0 {
+		fmt.Printf("Vnodes weighted by capacity: one per %s\n", formatBytes(response.VnodeSize))
+	}
+	if m := response.Migration; m != nil && m.InProgress {
+		fmt.Printf("Migration in progress (%s %s): %d/%d files\n", m.Operation, m.NodeAddress, m.MigratedFileCount, m.TotalFileCount)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tHEALTH\tFILES\tUSED\tCAPACITY\tSHARE\tVNODES\tSTATE")
+	for _, node := range response.Nodes {
+		files, used, capacity := "?", "?", "?"
+		if node.StatsError == "" {
+			files = fmt.Sprint(node.FileCount)
+			used = formatBytes(node.UsedBytes)
+			capacity = formatBytes(node.CapacityBytes)
+		}
+		state := "active"
+		if node.Draining {
+			state = "draining"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%.1f%%\t%d\t%s\n",
+			node.Address, node.Health, files, used, capacity, 100*node.RingShare, node.Vnodes, state)
+	}
+	w.Flush()
+	for _, node := range response.Nodes {
+		if node.StatsError != "" {
+			fmt.Printf("%s: usage unknown (%s)\n", node.Address, node.StatsError)
 		}
 	}
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"tritontube/internal/edge"
+	"tritontube/internal/telemetry"
+)
+
+// printUsage prints the usage information for the edge proxy
+func printUsage() {
+	fmt.Println("Usage: edge [OPTIONS] ORIGIN [ORIGIN...]")
+	fmt.Println()
+	fmt.Println("Arguments:")
+	fmt.Println("  ORIGIN                Address of a TritonTube web server (e.g., localhost:8080)")
+	fmt.Println()
+	fmt.Println("Options:")
+	flag.PrintDefaults()
+	fmt.Println()
+	fmt.Println("Example: edge -port 8081 -disk-dir /tmp/edge-cache localhost:8080")
+}
+
+func main() {
+	host := flag.String("host", "localhost", "Host address for the edge proxy")
+	port := flag.Int("port", 8081, "Port number for the edge proxy")
+	memorySize := flag.Int64("memory-size", 256<<20, "Bytes of content cached in memory")
+	diskDir := flag.String("disk-dir", "", "Directory for the disk cache tier (disabled if empty; cleared on start)")
+	diskSize := flag.Int64("disk-size", 4<<30, "Bytes of content cached on disk")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	logLevel := flag.String("log-level", "info", "Lowest level logged: debug, info, warn or error")
+	flag.Usage = printUsage
+	flag.Parse()
+
+	if *port <= 0 {
+		panic("Error: Port number must be positive")
+	}
+	if err := telemetry.SetupLogging(*logFormat, *logLevel); err != nil {
+		panic("Error: " + err.Error())
+	}
+	if flag.NArg() < 1 {
+		printUsage()
+		return
+	}
+
+	e, err := edge.NewEdge(flag.Args(), *memorySize, *diskDir, *diskSize)
+	if err != nil {
+		log.Fatalf("Failed to initialize edge: %v", err)
+	}
+
+	addr := fmt.Sprintf("%s:%d", *host, *port)
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", addr, err)
+	}
+	slog.Info("Starting edge proxy", "addr", addr, "origins", flag.Args())
+	if err := http.Serve(lis, e); err != nil {
+		log.Fatalf("Failed to serve: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+
+	"tritontube/internal/metadata"
+	"tritontube/internal/proto"
+	"tritontube/internal/raft"
+	"tritontube/internal/telemetry"
+
+	"google.golang.org/grpc"
+)
+
+func main() {
+	host := flag.String("host", "localhost", "Host address for the server")
+	port := flag.Int("port", 8070, "Port number for the server")
+	peers := flag.String("peers", "", "Comma separated addresses of every metadata node in the group, this one included (default: just this one)")
+	electionTimeout := flag.Duration("election-timeout", raft.DefaultElectionTimeout, "How long a follower waits to hear from a leader before starting an election")
+	heartbeatInterval := flag.Duration("heartbeat-interval", raft.DefaultHeartbeatInterval, "How often the leader sends heartbeats")
+	snapshotThreshold := flag.Uint64("snapshot-threshold", raft.DefaultSnapshotThreshold, "Log entries applied between snapshots (0 never compacts the log)")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	logLevel := flag.String("log-level", "info", "Lowest level logged: debug, info, warn or error")
+	flag.Parse()
+
+	// Validate arguments
+	if *port <= 0 {
+		panic("Error: Port number must be positive")
+	}
+	if err := telemetry.SetupLogging(*logFormat, *logLevel); err != nil {
+		panic("Error: " + err.Error())
+	}
+
+	if flag.NArg() < 1 {
+		fmt.Println("Usage: metadata [OPTIONS] <dataDir>")
+		fmt.Println("Error: Data directory argument is required")
+		return
+	}
+	dataDir := flag.Arg(0)
+
+	// Nodes are known by the address they serve on
+	addr := fmt.Sprintf("%s:%d", *host, *port)
+	members := []string{addr}
+	if *peers != "" {
+		members = strings.Split(*peers, ",")
+	}
+	if !slices.Contains(members, addr) {
+		log.Fatalf("-peers must include this node's own address %s", addr)
+	}
+	if *heartbeatInterval >= *electionTimeout {
+		log.Fatalf("-heartbeat-interval must be shorter than -election-timeout")
+	}
+
+	slog.Info("Starting metadata server", "addr", addr, "peers", strings.Join(members, ","), "data_dir", dataDir)
+
+	storage, err := raft.NewFileStorage(dataDir)
+	if err != nil {
+		log.Fatalf("Failed to open data dir: %v", err)
+	}
+	store := metadata.NewStore()
+	node := raft.NewNode(addr, members, storage, raft.NewGRPCTransport(), store)
+	node.ElectionTimeout = *electionTimeout
+	node.HeartbeatInterval = *heartbeatInterval
+	node.SnapshotThreshold = *snapshotThreshold
+	if err := node.Start(); err != nil {
+		log.Fatalf("Failed to start raft node: %v", err)
+	}
+	go func() {
+		<-node.Done()
+		log.Fatalf("Raft node stopped: %v", node.Err())
+	}()
+
+	listen, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", addr, err)
+	}
+	grpcServer := grpc.NewServer(raft.ServerOptions()...)
+	proto.RegisterRaftServiceServer(grpcServer, raft.NewGRPCServer(node))
+	proto.RegisterMetadataServiceServer(grpcServer, metadata.NewServer(node, store))
+
+	slog.Info("Metadata server listening", "addr", addr, "data_dir", dataDir)
+	err = grpcServer.Serve(listen)
+	if err != nil {
+		log.Fatalf("Failed to serve gRPC server: %v", err)
+	}
+}
@@ -4,23 +4,45 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
+	"os"
+	"time"
 
+	"tritontube/internal/cas"
 	"tritontube/internal/proto"
 	"tritontube/internal/storage"
+	"tritontube/internal/telemetry"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
 	host := flag.String("host", "localhost", "Host address for the server")
 	port := flag.Int("port", 8090, "Port number for the server")
+	dedup := flag.Bool("dedup", false, "Store files by content so identical files are kept once (a base directory must always be served the same way)")
+	blobGCInterval := flag.Duration("blob-gc-interval", 10*time.Minute, "How often blobs no file refers to are removed with -dedup")
+	fsck := flag.Bool("fsck", false, "Check the -dedup base directory for corrupt and missing blobs, then exit")
+	quota := flag.Int64("quota", 0, "Bytes this node may store, also reported as its capacity (0 for the size of the disk)")
+	scrubInterval := flag.Duration("scrub-interval", 24*time.Hour, "How often every file is read back and checked against its checksum (0 disables scrubbing)")
+	metricsAddr := flag.String("metrics-addr", "", "Address to serve Prometheus metrics on at /metrics, e.g. localhost:9090 (default: not served)")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	logLevel := flag.String("log-level", "info", "Lowest level logged: debug, info, warn or error")
 	flag.Parse()
 
 	// Validate arguments
 	if *port <= 0 {
 		panic("Error: Port number must be positive")
 	}
+	if *quota < 0 {
+		panic("Error: Quota must not be negative")
+	}
+	if err := telemetry.SetupLogging(*logFormat, *logLevel); err != nil {
+		panic("Error: " + err.Error())
+	}
 
 	if flag.NArg() < 1 {
 		fmt.Println("Usage: storage [OPTIONS] <baseDir>")
@@ -29,10 +51,12 @@ func main() {
 	}
 	baseDir := flag.Arg(0)
 
-	fmt.Println("Starting storage server...")
-	fmt.Printf("Host: %s\n", *host)
-	fmt.Printf("Port: %d\n", *port)
-	fmt.Printf("Base Directory: %s\n", baseDir)
+	if *fsck {
+		os.Exit(check(baseDir))
+	}
+
+	slog.Info("Starting storage server", "host", *host, "port", *port, "base_dir", baseDir,
+		"quota_bytes", *quota, "dedup", *dedup)
 
 	addr := fmt.Sprintf("%s:%d", *host, *port)
 	listen, err := net.Listen("tcp", addr)
@@ -40,13 +64,64 @@ func main() {
 		log.Fatalf("Failed to listen on %s: %v", addr, err)
 	}
 
-	grpcServer := grpc.NewServer()
-	handler := storage.NewStorageHandler(baseDir)
+	grpcServer := grpc.NewServer(telemetry.ServerOptions()...)
+	handler, err := storage.NewStorageHandler(baseDir, *quota, *dedup)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", baseDir, err)
+	}
+	go handler.CollectGarbage(*blobGCInterval)
+	if *scrubInterval > 0 {
+		go handler.StartScrubbing(*scrubInterval)
+	}
 	proto.RegisterStorageServiceServer(grpcServer, handler)
+	prometheus.MustRegister(handler)
+	if *metricsAddr != "" {
+		go func() {
+			log.Fatalf("Failed to serve metrics on %s: %v", *metricsAddr, telemetry.ServeMetrics(*metricsAddr))
+		}()
+	}
 
-	log.Printf("Storage server listening at %s, baseDir: %s", addr, baseDir)
+	// Lets the web server's health checker detect when this node goes away
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus(proto.StorageService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	slog.Info("Storage server listening", "addr", addr, "base_dir", baseDir)
 	err = grpcServer.Serve(listen)
 	if err != nil {
 		log.Fatalf("Failed to serve gRPC server: %v", err)
 	}
 }
+
+// Checks the deduplicating store under baseDir, which should not be served
+// meanwhile, and returns the exit status: 0 if it is healthy
+func check(baseDir string) int {
+	store, err := cas.Open(baseDir)
+	if err != nil {
+		fmt.Println("Failed to open store:", err)
+		return 2
+	}
+	report, err := store.Check()
+	if err != nil {
+		fmt.Println("Check failed:", err)
+		return 2
+	}
+	fmt.Printf("Refs: %d (%d bytes without deduplication)\n", report.Refs, report.LogicalBytes)
+	fmt.Printf("Blobs: %d (%d bytes)\n", report.Blobs, report.BlobBytes)
+	fmt.Printf("Unreferenced blobs: %d (%d bytes, removed by the next GC)\n", report.Unreferenced, report.UnreferencedBytes)
+	for _, digest := range report.Corrupt {
+		fmt.Println("Corrupt blob:", digest)
+	}
+	for _, digest := range report.Missing {
+		fmt.Println("Missing blob:", digest)
+	}
+	for _, ref := range report.InvalidRefs {
+		fmt.Println("Invalid ref:", ref)
+	}
+	if report.Healthy() {
+		fmt.Println("OK")
+		return 0
+	}
+	return 1
+}
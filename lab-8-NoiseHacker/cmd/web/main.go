@@ -1,12 +1,20 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
+	"os"
 	"strings"
+	"time"
+	"tritontube/internal/telemetry"
 	"tritontube/internal/web"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // printUsage prints the usage information for the application
@@ -14,9 +22,9 @@ func printUsage() {
 	fmt.Println("Usage: ./program [OPTIONS] METADATA_TYPE METADATA_OPTIONS CONTENT_TYPE CONTENT_OPTIONS")
 	fmt.Println()
 	fmt.Println("Arguments:")
-	fmt.Println("  METADATA_TYPE         Metadata service type (sqlite, etcd)")
-	fmt.Println("  METADATA_OPTIONS      Options for metadata service (e.g., db path)")
-	fmt.Println("  CONTENT_TYPE          Content service type (fs, nw)")
+	fmt.Println("  METADATA_TYPE         Metadata service type (sqlite, raft)")
+	fmt.Println("  METADATA_OPTIONS      Options for metadata service (e.g., db path, or metadata node addresses for raft)")
+	fmt.Println("  CONTENT_TYPE          Content service type (fs, cas, nw)")
 	fmt.Println("  CONTENT_OPTIONS       Options for content service (e.g., base dir, network addresses)")
 	fmt.Println()
 	fmt.Println("Options:")
@@ -29,12 +37,39 @@ func main() {
 	// Define flags
 	port := flag.Int("port", 8080, "Port number for the web server")
 	host := flag.String("host", "localhost", "Host address for the web server")
+	replicas := flag.Int("replicas", 1, "Number of storage nodes each file is written to in nw mode")
+	ecData := flag.Int("ec-data", 0, "Erasure code files in nw mode with this many data shards instead of replicating them (0 replicates)")
+	ecParity := flag.Int("ec-parity", 2, "Parity shards per file with -ec-data, how many shards can be lost")
+	healthInterval := flag.Duration("health-interval", 2*time.Second, "How often storage nodes are health checked in nw mode")
+	maxUploadSize := flag.Int64("max-upload-size", web.DefaultMaxUploadSize, "Maximum size of an uploaded video in bytes")
+	spoolDir := flag.String("spool-dir", "", "Directory uploads wait in until they are transcoded (default: a dir under the system temp dir)")
+	transcodeWorkers := flag.Int("transcode-workers", web.DefaultTranscodeWorkers, "Number of videos transcoded concurrently")
+	maxLiveStreams := flag.Int("max-live-streams", web.DefaultMaxLiveStreams, "Number of live streams ingested concurrently")
+	uploadSessionTTL := flag.Duration("upload-session-ttl", web.DefaultUploadSessionTTL, "How long a resumable upload nobody writes to is kept")
+	ladderPath := flag.String("ladder", "", "JSON file with the encoding ladder (default: 240p, 480p, 720p and 1080p)")
+	gcInterval := flag.Duration("gc-interval", web.DefaultGCInterval, "How often deletes that failed midway are retried")
+	blobGCInterval := flag.Duration("blob-gc-interval", web.DefaultBlobGCInterval, "How often blobs no file refers to are removed in cas mode")
+	hls := flag.Bool("hls", false, "Also produce HLS playlists for uploads, so browsers without MSE (Safari on iOS) can play them")
+	signingKeyPath := flag.String("signing-key-file", "", "File with the key that signs content URLs of private videos (default: a random key, so signed URLs break on restart)")
+	contentURLTTL := flag.Duration("content-url-ttl", web.DefaultContentURLTTL, "How long signed content URLs of private videos stay valid")
+	membershipPath := flag.String("membership", "", "File to persist nw storage membership in (shared by web servers using the same cluster)")
+	repairInterval := flag.Duration("repair-interval", web.DefaultRepairInterval, "How often storage nodes are compared and missing or divergent files repaired in nw mode (0 disables it)")
+	vnodeSize := flag.Int64("vnode-size", 0, fmt.Sprintf("Bytes of storage node capacity per point on the nw hash ring for nodes added later, e.g. %d (0 gives every node one point)", web.DefaultVnodeSize))
+	admins := flag.String("admin", "", "Comma-separated usernames of existing accounts to make admins")
+	firstUserAdmin := flag.Bool("first-user-admin", false, "Make the first account to sign up an admin, for a fresh install with no accounts")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	logLevel := flag.String("log-level", "info", "Lowest level logged: debug, info, warn or error")
 
 	// Set custom usage message
 	flag.Usage = printUsage
 
 	// Parse flags
 	flag.Parse()
+	if err := telemetry.SetupLogging(*logFormat, *logLevel); err != nil {
+		fmt.Println("Error:", err)
+		printUsage()
+		return
+	}
 
 	// Check if the correct number of positional arguments is provided
 	if len(flag.Args()) != 4 {
@@ -48,7 +83,6 @@ func main() {
 	metadataServiceOptions := flag.Arg(1)
 	contentServiceType := flag.Arg(2)
 	contentServiceOptions := flag.Arg(3)
-	fmt.Println("Parsing args done.")
 	// Validate port number (already an int from flag, check if positive)
 	if *port <= 0 {
 		fmt.Println("Error: Invalid port number:", *port)
@@ -58,7 +92,7 @@ func main() {
 
 	// Construct metadata service
 	var metadataService web.VideoMetadataService
-	fmt.Println("Creating metadata service of type", metadataServiceType, "with options", metadataServiceOptions)
+	slog.Info("Creating metadata service", "type", metadataServiceType, "options", metadataServiceOptions)
 	// TODO: Implement metadata service creation logic
 	switch metadataServiceType {
 	case "sqlite":
@@ -67,17 +101,44 @@ func main() {
 		if err != nil {
 			log.Fatalf("Failed to create SQLite metadata service: %v", err)
 		}
+	case "raft":
+		var err error
+		metadataService, err = web.NewRaftVideoMetadataService(strings.Split(metadataServiceOptions, ","))
+		if err != nil {
+			log.Fatalf("Failed to create Raft metadata service: %v", err)
+		}
 	default:
 		log.Fatalf("Unsupported metadata type: %s", metadataServiceType)
 	}
 
+	for _, username := range strings.Split(*admins, ",") {
+		if username = strings.TrimSpace(username); username == "" {
+			continue
+		}
+		err := metadataService.SetAdmin(username)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Fatalf("No account %q to make an admin, sign it up first", username)
+		}
+		if err != nil {
+			log.Fatalf("Failed to make %q an admin: %v", username, err)
+		}
+		slog.Info("Made account an admin", "username", username)
+	}
+
 	// Construct content service
 	var contentService web.VideoContentService
-	fmt.Println("Creating content service of type", contentServiceType, "with options", contentServiceOptions)
+	slog.Info("Creating content service", "type", contentServiceType, "options", contentServiceOptions)
 	// TODO: Implement content service creation logic
 	switch contentServiceType {
 	case "fs":
 		contentService = web.NewFSVideoContentService(contentServiceOptions)
+	case "cas":
+		casService, err := web.NewCASVideoContentService(contentServiceOptions)
+		if err != nil {
+			log.Fatalf("Failed to open content store: %v", err)
+		}
+		go casService.CollectGarbage(*blobGCInterval)
+		contentService = casService
 	case "nw":
 		addrs := strings.Split(contentServiceOptions, ",")
 		if len(addrs) < 2 {
@@ -85,11 +146,35 @@ func main() {
 		}
 		adminAddr := addrs[0]
 		storageAddrs := addrs[1:]
-		nwService, err := web.NewNetworkVideoContentService(adminAddr, storageAddrs)
+		nwService, err := web.NewNetworkVideoContentService(adminAddr, storageAddrs, *replicas)
 		if err != nil {
 			log.Fatalf("Failed to initialize NetworkVideoContentService: %v", err)
 		}
+		if *vnodeSize > 0 {
+			nwService.UseCapacityWeights(*vnodeSize)
+		}
+		// Before loading the membership, which may resume a migration
+		if *ecData > 0 {
+			if *replicas != 1 {
+				log.Fatalf("-replicas and -ec-data cannot be combined")
+			}
+			if err := nwService.UseErasureCoding(*ecData, *ecParity); err != nil {
+				log.Fatalf("Failed to set up erasure coding: %v", err)
+			}
+		}
+		if *membershipPath != "" {
+			if err := nwService.UseMembershipFile(*membershipPath); err != nil {
+				log.Fatalf("Failed to load membership: %v", err)
+			}
+			go nwService.WatchMembership(2 * time.Second)
+		}
+		go nwService.StartHealthChecks(*healthInterval)
+		if *repairInterval > 0 {
+			go nwService.StartRepairs(*repairInterval)
+		}
+		nwService.UseVideoCatalog(metadataService)
 		go nwService.StartAdminGRPCServer()
+		prometheus.MustRegister(nwService)
 		contentService = nwService
 	default:
 		log.Fatalf("Unsupported content type: %s", contentServiceType)
@@ -97,18 +182,46 @@ func main() {
 	
 	// Start the server
 	server := web.NewServer(metadataService, contentService)
+	server.MaxUploadSize = *maxUploadSize
+	server.TranscodeWorkers = *transcodeWorkers
+	server.MaxLiveStreams = *maxLiveStreams
+	server.UploadSessionTTL = *uploadSessionTTL
+	server.FirstUserAdmin = *firstUserAdmin
+	if *spoolDir != "" {
+		server.SpoolDir = *spoolDir
+	}
+	if *ladderPath != "" {
+		ladder, err := web.LoadLadder(*ladderPath)
+		if err != nil {
+			log.Fatalf("Failed to load encoding ladder: %v", err)
+		}
+		server.Ladder = ladder
+	}
+	server.HLS = *hls
+	server.GCInterval = *gcInterval
+	server.ContentURLTTL = *contentURLTTL
+	if *signingKeyPath != "" {
+		key, err := os.ReadFile(*signingKeyPath)
+		if err != nil {
+			log.Fatalf("Failed to read signing key: %v", err)
+		}
+		if len(key) < 16 {
+			log.Fatalf("Signing key in %s is too short, it needs at least 16 bytes", *signingKeyPath)
+		}
+		server.ContentSigningKey = key
+	}
 	listenAddr := fmt.Sprintf("%s:%d", *host, *port)
 	lis, err := net.Listen("tcp", listenAddr)
 	if err != nil {
-		fmt.Println("Error starting listener:", err)
+		slog.Error("Error starting listener", "addr", listenAddr, "err", err)
 		return
 	}
 	defer lis.Close()
 
-	fmt.Println("Starting web server on", listenAddr)
+	slog.Info("Starting web server", "addr", listenAddr)
 	err = server.Start(lis)
 	if err != nil {
-		fmt.Println("Error starting server:", err)
+		slog.Error("Error starting server", "err", err)
 		return
 	}
 }
@@ -0,0 +1,584 @@
+// Package cas stores content files by the SHA-256 digest of their bytes, so
+// files with identical content, such as the init segments of every upload or
+// a video uploaded twice, are kept once.
+//
+// A store has two directories under its root. blobs/ holds the content, one
+// file per digest, sharded by the digest's first two hex digits. refs/ maps
+// names to content: it is laid out like a plain content directory,
+// {videoId}/{filename} plus the staging area of contentkey, but each file only
+// holds the hex digest of its blob.
+//
+// How many refs point at each blob is counted in memory; the counts are
+// rebuilt from refs/ on Open, so nothing but the files themselves needs to
+// survive a crash. A blob is always in place before the first ref to it, and
+// removing the last ref leaves the blob behind for GC, so a crash at any point
+// leaves at worst an unreferenced blob.
+package cas
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tritontube/internal/contentkey"
+)
+
+const (
+	blobsDirName = "blobs"
+	refsDirName  = "refs"
+	dirPerm      = 0755
+	filePerm     = 0644
+)
+
+// Temp files of blob writes older than this are taken for leftovers of a crash
+const tmpGrace = time.Hour
+
+// ErrNotStore is returned by Open for a directory that holds other files
+var ErrNotStore = errors.New("not a content-addressed store")
+
+// Store is a deduplicating content store rooted at a directory. It is safe for
+// concurrent use.
+type Store struct {
+	blobsDir string
+	refsDir  string
+
+	// mu serializes linking refs to blobs with GC, so a blob that gains a ref
+	// is never collected
+	mu     sync.Mutex
+	counts map[string]int // refs by digest, staged refs included
+
+	blobBytes atomic.Int64 // bytes of the stored blobs
+}
+
+// IsStore reports whether dir looks like the root of a Store
+func IsStore(dir string) bool {
+	for _, name := range []string{blobsDirName, refsDirName} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// Open opens the store rooted at root, creating it if root is missing or
+// empty. Fails with ErrNotStore if root holds anything else, such as the
+// files of a plain content directory.
+func Open(root string) (*Store, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Name() != blobsDirName && entry.Name() != refsDirName {
+			return nil, fmt.Errorf("%w: %s contains %s", ErrNotStore, root, entry.Name())
+		}
+	}
+
+	s := &Store{
+		blobsDir: filepath.Join(root, blobsDirName),
+		refsDir:  filepath.Join(root, refsDirName),
+	}
+	for _, dir := range []string{s.blobsDir, s.refsDir} {
+		if err := os.MkdirAll(dir, dirPerm); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	counts, _, err := s.scanRefs()
+	if err != nil {
+		return nil, err
+	}
+	s.counts = counts
+	err = s.walkBlobs(func(path, digest string, info fs.FileInfo) error {
+		if digest != "" {
+			s.blobBytes.Add(info.Size())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure blobs: %w", err)
+	}
+	return s, nil
+}
+
+// BlobBytes returns the bytes the stored blobs take, which only grow when
+// content that was not stored yet is written, and shrink on GC
+func (s *Store) BlobBytes() int64 {
+	return s.blobBytes.Load()
+}
+
+// RefsDir returns the directory the refs are laid out in like plain content
+// files, for listing keys
+func (s *Store) RefsDir() string {
+	return s.refsDir
+}
+
+func (s *Store) blobPath(digest string) string {
+	return filepath.Join(s.blobsDir, digest[:2], digest)
+}
+
+// Returns the digest a ref file holds
+func readRef(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	digest := string(bytes.TrimSpace(data))
+	if !validDigest(digest) {
+		return "", fmt.Errorf("ref %s holds no valid digest", path)
+	}
+	return digest, nil
+}
+
+func validDigest(digest string) bool {
+	if len(digest) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil && strings.ToLower(digest) == digest
+}
+
+// Streams r into a temp file in the blob store and returns its path and the
+// digest of the content. The caller removes the file once done with it.
+func (s *Store) writeTemp(r io.Reader) (string, string, error) {
+	tmp, err := os.CreateTemp(s.blobsDir, ".tmp-*")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), r); err != nil {
+		tmp.Close()
+		return tmpPath, "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return tmpPath, "", fmt.Errorf("failed to sync blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return tmpPath, "", err
+	}
+	if err := os.Chmod(tmpPath, filePerm); err != nil {
+		return tmpPath, "", err
+	}
+	return tmpPath, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Moves a temp file into the blob store as digest. A blob that is already
+// stored is left as it is, and the temp file with it. Callers must hold s.mu.
+func (s *Store) placeLocked(tmpPath, digest string) error {
+	path := s.blobPath(digest)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), dirPerm); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to store blob %s: %w", digest, err)
+	}
+	s.blobBytes.Add(info.Size())
+	return nil
+}
+
+// Points the ref for k under refsDir to digest, whose blob must be stored,
+// and adjusts the counts. Callers must hold s.mu.
+func (s *Store) linkLocked(refsDir string, k contentkey.Key, digest string) error {
+	old, _ := readRef(k.Path(refsDir))
+	if err := contentkey.WriteFile(refsDir, k, []byte(digest)); err != nil {
+		return err
+	}
+	s.counts[digest]++
+	if old != "" {
+		s.releaseLocked(old)
+	}
+	return nil
+}
+
+func (s *Store) releaseLocked(digest string) {
+	s.counts[digest]--
+	if s.counts[digest] <= 0 {
+		delete(s.counts, digest)
+	}
+}
+
+// WriteFrom stores the content for k read from r, replacing any previous
+// content. Readers see the old or the new content, never a partial file.
+func (s *Store) WriteFrom(k contentkey.Key, r io.Reader) error {
+	return s.write(s.refsDir, k, r)
+}
+
+// WriteFile stores data for k, see WriteFrom
+func (s *Store) WriteFile(k contentkey.Key, data []byte) error {
+	return s.WriteFrom(k, bytes.NewReader(data))
+}
+
+// StageFrom is like WriteFrom, but keeps the file out of sight until
+// CommitStaged publishes the transaction txID. Its blob is referenced, and so
+// kept, from the moment it is staged.
+func (s *Store) StageFrom(txID string, k contentkey.Key, r io.Reader) error {
+	dir, err := contentkey.StagingDir(s.refsDir, txID)
+	if err != nil {
+		return err
+	}
+	return s.write(dir, k, r)
+}
+
+// Stores the content, which can take a while, before taking the lock to place
+// it and link the ref in one go, so GC never sees the blob unreferenced
+func (s *Store) write(refsDir string, k contentkey.Key, r io.Reader) error {
+	tmpPath, digest, err := s.writeTemp(r)
+	if tmpPath != "" {
+		defer os.Remove(tmpPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", k, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.placeLocked(tmpPath, digest); err != nil {
+		return fmt.Errorf("failed to write %s: %w", k, err)
+	}
+	return s.linkLocked(refsDir, k, digest)
+}
+
+// ReadFile returns the content stored for k. A missing key fails with an error
+// matching os.ErrNotExist.
+func (s *Store) ReadFile(k contentkey.Key) ([]byte, error) {
+	digest, err := readRef(k.Path(s.refsDir))
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(s.blobPath(digest))
+}
+
+// Open opens the blob stored for k for reading. A missing key fails with an
+// error matching os.ErrNotExist.
+func (s *Store) Open(k contentkey.Key) (*os.File, error) {
+	digest, err := readRef(k.Path(s.refsDir))
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(s.blobPath(digest))
+}
+
+// Digest returns the hex SHA-256 of the content stored for k
+func (s *Store) Digest(k contentkey.Key) (string, error) {
+	return readRef(k.Path(s.refsDir))
+}
+
+// Remove deletes the ref for k. Its blob stays until GC finds it unreferenced.
+func (s *Store) Remove(k contentkey.Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	digest, readErr := readRef(k.Path(s.refsDir))
+	if err := contentkey.Remove(s.refsDir, k); err != nil {
+		return err
+	}
+	if readErr == nil {
+		s.releaseLocked(digest)
+	}
+	return nil
+}
+
+// CommitStaged publishes the files staged under txID, see
+// contentkey.CommitStaged, and returns how many it moved
+func (s *Store) CommitStaged(txID string) (int, error) {
+	keys, err := contentkey.StagedKeys(s.refsDir, txID)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The staged refs are counted already; the published ones they replace
+	// are dropped
+	var replaced []string
+	for _, k := range keys {
+		if digest, err := readRef(k.Path(s.refsDir)); err == nil {
+			replaced = append(replaced, digest)
+		}
+	}
+	count, err := contentkey.CommitStaged(s.refsDir, txID)
+	if err != nil {
+		// Some refs may have moved; counting again is simpler than working out which
+		s.recountLocked()
+		return count, err
+	}
+	for _, digest := range replaced {
+		s.releaseLocked(digest)
+	}
+	return count, nil
+}
+
+// AbortStaged discards the files staged under txID
+func (s *Store) AbortStaged(txID string) error {
+	dir, err := contentkey.StagingDir(s.refsDir, txID)
+	if err != nil {
+		return err
+	}
+	keys, err := contentkey.StagedKeys(s.refsDir, txID)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var staged []string
+	for _, k := range keys {
+		if digest, err := readRef(k.Path(dir)); err == nil {
+			staged = append(staged, digest)
+		}
+	}
+	if err := contentkey.AbortStaged(s.refsDir, txID); err != nil {
+		s.recountLocked()
+		return err
+	}
+	for _, digest := range staged {
+		s.releaseLocked(digest)
+	}
+	return nil
+}
+
+// Replaces the counts with the refs on disk; callers must hold s.mu
+func (s *Store) recountLocked() {
+	counts, _, err := s.scanRefs()
+	if err != nil {
+		return
+	}
+	s.counts = counts
+}
+
+// Counts the refs to each digest under refs/, staged ones included, and
+// returns the keys of published refs whose file holds no valid digest
+func (s *Store) scanRefs() (map[string]int, []string, error) {
+	counts := make(map[string]int)
+	var invalid []string
+	err := filepath.WalkDir(s.refsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		digest, err := readRef(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			rel, _ := filepath.Rel(s.refsDir, path)
+			invalid = append(invalid, filepath.ToSlash(rel))
+			return nil
+		}
+		counts[digest]++
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to scan refs: %w", err)
+	}
+	return counts, invalid, nil
+}
+
+// Digests returns the digest of every published file by its key. Refs that
+// hold no valid digest are left out; Check reports them.
+func (s *Store) Digests() (map[string]string, error) {
+	digests := make(map[string]string)
+	videoDirs, err := os.ReadDir(s.refsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, videoDir := range videoDirs {
+		if !videoDir.IsDir() || contentkey.ValidateComponent(videoDir.Name()) != nil {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(s.refsDir, videoDir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			k, err := contentkey.New(videoDir.Name(), file.Name())
+			if err != nil || file.IsDir() {
+				continue
+			}
+			if digest, err := readRef(k.Path(s.refsDir)); err == nil {
+				digests[k.String()] = digest
+			}
+		}
+	}
+	return digests, nil
+}
+
+// Calls fn for every blob file with its digest, or "" for a temp file
+func (s *Store) walkBlobs(fn func(path, digest string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(s.blobsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		digest := d.Name()
+		if !validDigest(digest) {
+			digest = ""
+		}
+		return fn(path, digest, info)
+	})
+}
+
+// GCResult is what one GC pass removed
+type GCResult struct {
+	Blobs int   // unreferenced blobs
+	Bytes int64 // their size, and that of stale temp files
+}
+
+// GC removes the blobs no ref points at, and temp files of blob writes that
+// were interrupted long ago. Writes wait while it runs.
+func (s *Store) GC() (GCResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result GCResult
+	err := s.walkBlobs(func(path, digest string, info fs.FileInfo) error {
+		if digest == "" {
+			// Temp files of writes in progress are young
+			if time.Since(info.ModTime()) < tmpGrace {
+				return nil
+			}
+		} else if s.counts[digest] > 0 {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if digest != "" {
+			result.Blobs++
+			s.blobBytes.Add(-info.Size())
+		}
+		result.Bytes += info.Size()
+		return nil
+	})
+	return result, err
+}
+
+// CheckReport is what Check found. A healthy store has no Corrupt, Missing,
+// InvalidRefs or Miscounted entries; unreferenced blobs are normal until the
+// next GC.
+type CheckReport struct {
+	Refs              int      // refs, staged ones included
+	Blobs             int      // stored blobs
+	BlobBytes         int64    // bytes stored
+	LogicalBytes      int64    // bytes the refs would take without deduplication
+	Corrupt           []string // digests of blobs whose content hashes to something else
+	Missing           []string // digests referenced but not stored
+	InvalidRefs       []string // refs, as paths under refs/, that hold no digest
+	Unreferenced      int      // blobs GC would remove
+	UnreferencedBytes int64
+	Miscounted        []string // digests whose in-memory ref count was wrong, now corrected
+}
+
+// Healthy reports whether the check found nothing to fix
+func (r *CheckReport) Healthy() bool {
+	return len(r.Corrupt) == 0 && len(r.Missing) == 0 && len(r.InvalidRefs) == 0 && len(r.Miscounted) == 0
+}
+
+// Check verifies that every blob hashes to its digest, that every ref points
+// at a stored blob and that the ref counts match the refs on disk, correcting
+// the counts if they do not. Blobs are hashed without holding up writes, so
+// on a busy store a blob written during the check may show up as
+// unreferenced.
+func (s *Store) Check() (*CheckReport, error) {
+	s.mu.Lock()
+	counts, invalid, err := s.scanRefs()
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	report := &CheckReport{InvalidRefs: invalid}
+	for digest, n := range counts {
+		report.Refs += n
+		if s.counts[digest] != n {
+			report.Miscounted = append(report.Miscounted, digest)
+		}
+	}
+	for digest := range s.counts {
+		if _, ok := counts[digest]; !ok {
+			report.Miscounted = append(report.Miscounted, digest)
+		}
+	}
+	s.counts = counts
+	s.mu.Unlock()
+
+	sizes := make(map[string]int64)
+	err = s.walkBlobs(func(path, digest string, info fs.FileInfo) error {
+		if digest == "" {
+			return nil
+		}
+		report.Blobs++
+		report.BlobBytes += info.Size()
+		sizes[digest] = info.Size()
+		actual, err := hashFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Collected while checking
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if actual != digest {
+			report.Corrupt = append(report.Corrupt, digest)
+		}
+		if counts[digest] == 0 {
+			report.Unreferenced++
+			report.UnreferencedBytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check blobs: %w", err)
+	}
+	for digest, n := range counts {
+		size, ok := sizes[digest]
+		if !ok {
+			report.Missing = append(report.Missing, digest)
+			continue
+		}
+		report.LogicalBytes += size * int64(n)
+	}
+	return report, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
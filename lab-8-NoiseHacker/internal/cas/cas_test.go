@@ -0,0 +1,221 @@
+package cas
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"tritontube/internal/contentkey"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func key(t *testing.T, videoID, filename string) contentkey.Key {
+	t.Helper()
+	k, err := contentkey.New(videoID, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestSameContentIsStoredOnce(t *testing.T) {
+	s := openTestStore(t)
+	data := []byte("an init segment every upload shares")
+	a, b := key(t, "video-a", "init-0.m4s"), key(t, "video-b", "init-0.m4s")
+	for _, k := range []contentkey.Key{a, b} {
+		if err := s.WriteFile(k, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := s.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Refs != 2 || report.Blobs != 1 || report.LogicalBytes != 2*int64(len(data)) || !report.Healthy() {
+		t.Fatalf("two refs to the same bytes gave %+v", report)
+	}
+	if s.BlobBytes() != int64(len(data)) {
+		t.Fatalf("BlobBytes is %d, want the blob's %d", s.BlobBytes(), len(data))
+	}
+
+	// The blob stays while a ref is left
+	if err := s.Remove(a); err != nil {
+		t.Fatal(err)
+	}
+	result, err := s.GC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blobs != 0 {
+		t.Fatalf("GC removed %d blobs that b still refers to", result.Blobs)
+	}
+	if got, err := s.ReadFile(b); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadFile(b) after removing a returned %q, %v", got, err)
+	}
+
+	if err := s.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	result, err = s.GC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blobs != 1 || result.Bytes != int64(len(data)) {
+		t.Fatalf("GC after removing the last ref returned %+v", result)
+	}
+	if s.BlobBytes() != 0 {
+		t.Fatalf("BlobBytes is %d after GC removed every blob", s.BlobBytes())
+	}
+	if _, err := os.Stat(s.blobPath(digestOf(data))); !os.IsNotExist(err) {
+		t.Fatalf("blob is still stored: %v", err)
+	}
+}
+
+// Rewriting a file releases its old blob, and a reopened store counts the
+// refs on disk the same way
+func TestRewriteReleasesTheOldBlob(t *testing.T) {
+	root := t.TempDir()
+	s, err := Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := key(t, "video", "manifest.mpd")
+	if err := s.WriteFile(k, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteFile(k, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StageFrom("tx", key(t, "video", "thumbnail.jpg"), bytes.NewReader([]byte("first"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AbortStaged("tx"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(reopened.counts, s.counts) {
+		t.Fatalf("reopened store counts %v, the store that wrote counts %v", reopened.counts, s.counts)
+	}
+	if reopened.BlobBytes() != s.BlobBytes() {
+		t.Fatalf("reopened store measures %d blob bytes, the store that wrote %d", reopened.BlobBytes(), s.BlobBytes())
+	}
+	result, err := s.GC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blobs != 1 || result.Bytes != int64(len("first")) {
+		t.Fatalf("GC returned %+v, want the first write's blob", result)
+	}
+	if got, err := s.ReadFile(k); err != nil || string(got) != "second" {
+		t.Fatalf("ReadFile returned %q, %v", got, err)
+	}
+}
+
+func TestGCCollectsOrphansAndStaleTempFiles(t *testing.T) {
+	s := openTestStore(t)
+	kept := []byte("referenced")
+	if err := s.WriteFile(key(t, "video", "a.m4s"), kept); err != nil {
+		t.Fatal(err)
+	}
+	// A blob left by a crash between storing it and linking its ref
+	orphan := []byte("nobody refers to this")
+	orphanPath := s.blobPath(digestOf(orphan))
+	if err := os.MkdirAll(filepath.Dir(orphanPath), dirPerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(orphanPath, orphan, filePerm); err != nil {
+		t.Fatal(err)
+	}
+	stale := filepath.Join(s.blobsDir, ".tmp-stale")
+	young := filepath.Join(s.blobsDir, ".tmp-young")
+	for _, path := range []string{stale, young} {
+		if err := os.WriteFile(path, []byte("partial"), filePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * tmpGrace)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unreferenced != 1 || report.UnreferencedBytes != int64(len(orphan)) {
+		t.Fatalf("Check found %d unreferenced blobs (%d bytes), want the orphan", report.Unreferenced, report.UnreferencedBytes)
+	}
+	result, err := s.GC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blobs != 1 || result.Bytes != int64(len(orphan)+len("partial")) {
+		t.Fatalf("GC returned %+v, want the orphan and the stale temp file", result)
+	}
+	for path, want := range map[string]bool{orphanPath: false, stale: false, young: true, s.blobPath(digestOf(kept)): true} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s exists: %v, want %v", path, err == nil, want)
+		}
+	}
+}
+
+func TestCheckReportsCorruptAndMissingBlobs(t *testing.T) {
+	s := openTestStore(t)
+	corrupt, missing := []byte("bits rot"), []byte("deleted behind our back")
+	if err := s.WriteFile(key(t, "video", "corrupt.m4s"), corrupt); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteFile(key(t, "video", "missing.m4s"), missing); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.blobPath(digestOf(corrupt)), []byte("bits r0t"), filePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(s.blobPath(digestOf(missing))); err != nil {
+		t.Fatal(err)
+	}
+	invalidRef := key(t, "video", "invalid.m4s")
+	if err := contentkey.WriteFile(s.refsDir, invalidRef, []byte("not a digest")); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Healthy() {
+		t.Fatal("Check found a damaged store healthy")
+	}
+	if !slices.Equal(report.Corrupt, []string{digestOf(corrupt)}) {
+		t.Errorf("Corrupt = %v, want %s", report.Corrupt, digestOf(corrupt))
+	}
+	if !slices.Equal(report.Missing, []string{digestOf(missing)}) {
+		t.Errorf("Missing = %v, want %s", report.Missing, digestOf(missing))
+	}
+	if len(report.InvalidRefs) != 1 {
+		t.Errorf("InvalidRefs = %v, want the ref holding no digest", report.InvalidRefs)
+	}
+}
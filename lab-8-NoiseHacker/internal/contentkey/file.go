@@ -0,0 +1,80 @@
+package contentkey
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	dirPerm  = 0755
+	filePerm = 0644
+)
+
+// WriteFile stores data for k under baseDir. See WriteFrom.
+func WriteFile(baseDir string, k Key, data []byte) error {
+	return WriteFrom(baseDir, k, bytes.NewReader(data))
+}
+
+// WriteFrom streams the content for k from r into a temporary file in the same
+// directory and renames it into place once r is exhausted, so readers see either
+// the old or the new content and never a partial file.
+func WriteFrom(baseDir string, k Key, r io.Reader) error {
+	path := k.Path(baseDir)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write %s: %w", k, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to sync %s: %w", k, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close %s: %w", k, err)
+	}
+	if err := os.Chmod(tmpPath, filePerm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to commit %s: %w", k, err)
+	}
+	return nil
+}
+
+// ReadFile returns the content stored for k under baseDir
+func ReadFile(baseDir string, k Key) ([]byte, error) {
+	return os.ReadFile(k.Path(baseDir))
+}
+
+// Open opens the content stored for k under baseDir for reading
+func Open(baseDir string, k Key) (*os.File, error) {
+	return os.Open(k.Path(baseDir))
+}
+
+// Remove deletes the file for k under baseDir, and its video directory once empty
+func Remove(baseDir string, k Key) error {
+	path := k.Path(baseDir)
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	// Fails harmlessly while other files of the video remain
+	os.Remove(filepath.Dir(path))
+	return nil
+}
@@ -0,0 +1,24 @@
+package contentkey
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// Hash returns the position of s on the consistent hashing ring the network
+// content service spreads keys over: the first 8 bytes of its SHA-256. Storage
+// nodes use it to tell which of their keys fall into a range of the ring.
+func Hash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// InRange reports whether hash falls into the ring range (start, end]. The
+// range wraps around past the largest hash when start >= end, so start == end
+// is the whole ring.
+func InRange(hash, start, end uint64) bool {
+	if start < end {
+		return start < hash && hash <= end
+	}
+	return hash > start || hash <= end
+}
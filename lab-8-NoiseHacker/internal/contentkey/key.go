@@ -0,0 +1,110 @@
+// Package contentkey validates the "videoId/filename" keys that name video
+// content, and reads and writes the files they refer to under a base directory.
+// Both the local filesystem content service and the storage nodes use it, so a
+// key that is safe for one is safe for the other.
+package contentkey
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Reasons a key can be rejected. Use errors.Is on the error returned by New or
+// Parse to tell them apart.
+var (
+	ErrEmpty       = errors.New("empty key component")
+	ErrMalformed   = errors.New("key must have the form videoId/filename")
+	ErrTraversal   = errors.New("key component refers to a parent or hidden path")
+	ErrAbsolute    = errors.New("key is an absolute path")
+	ErrInvalidChar = errors.New("key contains an unsafe character")
+	ErrTooLong     = errors.New("key component is too long")
+)
+
+// maxComponentLen matches the file name limit of common filesystems
+const maxComponentLen = 255
+
+// InvalidKeyError reports which key was rejected and why.
+type InvalidKeyError struct {
+	Key    string
+	Reason error
+}
+
+func (e *InvalidKeyError) Error() string {
+	return fmt.Sprintf("invalid content key %q: %v", e.Key, e.Reason)
+}
+
+func (e *InvalidKeyError) Unwrap() error {
+	return e.Reason
+}
+
+// Key is a validated content key. The zero value is not valid; obtain keys from
+// New or Parse.
+type Key struct {
+	VideoID  string
+	Filename string
+}
+
+// New validates a video ID and filename and combines them into a Key
+func New(videoID, filename string) (Key, error) {
+	k := Key{VideoID: videoID, Filename: filename}
+	if err := ValidateComponent(videoID); err != nil {
+		return Key{}, &InvalidKeyError{Key: k.String(), Reason: err}
+	}
+	if err := ValidateComponent(filename); err != nil {
+		return Key{}, &InvalidKeyError{Key: k.String(), Reason: err}
+	}
+	return k, nil
+}
+
+// Parse validates a key in its "videoId/filename" wire form
+func Parse(key string) (Key, error) {
+	if strings.HasPrefix(key, "/") || filepath.IsAbs(key) {
+		return Key{}, &InvalidKeyError{Key: key, Reason: ErrAbsolute}
+	}
+	if slices.Contains(strings.Split(key, "/"), "..") {
+		return Key{}, &InvalidKeyError{Key: key, Reason: ErrTraversal}
+	}
+	videoID, filename, ok := strings.Cut(key, "/")
+	if !ok || strings.Contains(filename, "/") {
+		return Key{}, &InvalidKeyError{Key: key, Reason: ErrMalformed}
+	}
+	return New(videoID, filename)
+}
+
+// ValidateComponent checks a single video ID or filename. It must be a non-empty
+// name that cannot be interpreted as a path: no separators, no "." or ".."
+// (or any other leading dot, which is reserved for temporary files), and no
+// control or shell-special characters.
+func ValidateComponent(s string) error {
+	switch {
+	case s == "":
+		return ErrEmpty
+	case len(s) > maxComponentLen:
+		return ErrTooLong
+	case strings.HasPrefix(s, "."):
+		return ErrTraversal
+	case !utf8.ValidString(s):
+		return ErrInvalidChar
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return ErrInvalidChar
+		}
+	}
+	return nil
+}
+
+// String returns the key in its "videoId/filename" wire form
+func (k Key) String() string {
+	return k.VideoID + "/" + k.Filename
+}
+
+// Path returns where the key is stored under baseDir
+func (k Key) Path(baseDir string) string {
+	return filepath.Join(baseDir, k.VideoID, k.Filename)
+}
@@ -0,0 +1,78 @@
+package contentkey
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		key  string
+		want error // nil for a valid key
+	}{
+		{"video/manifest.mpd", nil},
+		{"abc-123/chunk-0-00001.m4s", nil},
+		{"video/name with spaces.jpg", nil},
+		{"../x", ErrTraversal},
+		{"a/../b", ErrTraversal},
+		{"video/..", ErrTraversal},
+		{"/a/b", ErrAbsolute},
+		{"a/b/c", ErrMalformed},
+		{"video", ErrMalformed},
+		{`video\..\x/file`, ErrInvalidChar},
+		{`video/..\x`, ErrTraversal},
+		{`video/a\b`, ErrInvalidChar},
+		{"video/a\x00b", ErrInvalidChar},
+		{"video/a\nb", ErrInvalidChar},
+		{"C:/x", ErrInvalidChar},
+		{"video/C:x", ErrInvalidChar},
+		{"video/", ErrEmpty},
+		{"/", ErrAbsolute},
+		{"", ErrMalformed},
+		{".hidden/file", ErrTraversal},
+		{"video/.tmp-123", ErrTraversal},
+		{"video/.", ErrTraversal},
+		{"video/" + strings.Repeat("a", maxComponentLen+1), ErrTooLong},
+		{"video/\xff", ErrInvalidChar},
+	} {
+		k, err := Parse(tc.key)
+		if tc.want == nil {
+			if err != nil {
+				t.Errorf("Parse(%q) failed: %v", tc.key, err)
+			} else if k.String() != tc.key {
+				t.Errorf("Parse(%q).String() = %q", tc.key, k.String())
+			}
+			continue
+		}
+		var keyErr *InvalidKeyError
+		if !errors.Is(err, tc.want) || !errors.As(err, &keyErr) {
+			t.Errorf("Parse(%q) returned %v, want an InvalidKeyError for %v", tc.key, err, tc.want)
+		}
+	}
+}
+
+func TestValidateComponent(t *testing.T) {
+	for _, tc := range []struct {
+		component string
+		want      error
+	}{
+		{"manifest.mpd", nil},
+		{"a..b", nil},
+		{"", ErrEmpty},
+		{".", ErrTraversal},
+		{"..", ErrTraversal},
+		{".staging", ErrTraversal},
+		{"a/b", ErrInvalidChar},
+		{`a\b`, ErrInvalidChar},
+		{"a\x00", ErrInvalidChar},
+		{"C:", ErrInvalidChar},
+		{"a*b", ErrInvalidChar},
+		{strings.Repeat("a", maxComponentLen), nil},
+		{strings.Repeat("a", maxComponentLen+1), ErrTooLong},
+	} {
+		if err := ValidateComponent(tc.component); !errors.Is(err, tc.want) {
+			t.Errorf("ValidateComponent(%q) = %v, want %v", tc.component, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package contentkey
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// stagingDirName holds staged files under a base directory. Its leading dot
+// keeps it from ever being taken for a video directory.
+const stagingDirName = ".staging"
+
+// StagingDir returns the directory the files of transaction txID are staged
+// in; a staged file's path is k.Path of it
+func StagingDir(baseDir, txID string) (string, error) {
+	if err := ValidateComponent(txID); err != nil {
+		return "", &InvalidKeyError{Key: txID, Reason: err}
+	}
+	return filepath.Join(baseDir, stagingDirName, txID), nil
+}
+
+// StageFrom is like WriteFrom, but keeps the file out of sight until
+// CommitStaged publishes the transaction txID
+func StageFrom(baseDir, txID string, k Key, r io.Reader) error {
+	dir, err := StagingDir(baseDir, txID)
+	if err != nil {
+		return err
+	}
+	return WriteFrom(dir, k, r)
+}
+
+// StagedKeys returns the keys of the files staged under txID, none for an
+// unknown transaction
+func StagedKeys(baseDir, txID string) ([]Key, error) {
+	dir, err := StagingDir(baseDir, txID)
+	if err != nil {
+		return nil, err
+	}
+	videoDirs, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+	for _, videoDir := range videoDirs {
+		files, err := os.ReadDir(filepath.Join(dir, videoDir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			// Skips temp files of staging writes that never finished
+			if k, err := New(videoDir.Name(), file.Name()); err == nil {
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// CommitStaged moves every file staged under txID into place and returns how
+// many it moved. Manifests and playlists move last, so a reader never finds one
+// whose segments are missing. A commit interrupted by a crash can be repeated;
+// committing an unknown transaction moves nothing.
+func CommitStaged(baseDir, txID string) (int, error) {
+	dir, err := StagingDir(baseDir, txID)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := StagedKeys(baseDir, txID)
+	if err != nil {
+		return 0, err
+	}
+	if keys == nil {
+		// Nothing staged, or an interrupted commit that moved everything already
+		return 0, os.RemoveAll(dir)
+	}
+	slices.SortStableFunc(keys, func(a, b Key) int {
+		if isManifest(a.Filename) == isManifest(b.Filename) {
+			return 0
+		}
+		if isManifest(a.Filename) {
+			return 1
+		}
+		return -1
+	})
+
+	for _, k := range keys {
+		if err := os.MkdirAll(filepath.Join(baseDir, k.VideoID), dirPerm); err != nil {
+			return 0, fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := os.Rename(k.Path(dir), k.Path(baseDir)); err != nil {
+			return 0, fmt.Errorf("failed to commit %s: %w", k, err)
+		}
+	}
+	return len(keys), os.RemoveAll(dir)
+}
+
+// AbortStaged discards the files staged under txID
+func AbortStaged(baseDir, txID string) error {
+	dir, err := StagingDir(baseDir, txID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func isManifest(filename string) bool {
+	return strings.HasSuffix(filename, ".mpd") || strings.HasSuffix(filename, ".m3u8")
+}
@@ -0,0 +1,225 @@
+// Two-tier LRU cache for the edge proxy.
+//
+// Entries live in memory until the memory tier is full, then the least recently
+// used ones are demoted to the disk tier, and dropped for good once the disk tier
+// is full too. A disk hit promotes the entry back into memory, unless it is
+// larger than the memory tier and so was stored on disk directly. The disk tier is
+// only an extension of memory: its index is not persisted, so it starts empty.
+
+package edge
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// entry is one cached response
+type entry struct {
+	key     string
+	header  http.Header // the response headers worth replaying, see cachedHeaders
+	body    []byte      // nil while the entry is on disk
+	size    int64
+	expires time.Time
+	onDisk  bool
+}
+
+func (e *entry) fresh(now time.Time) bool {
+	return now.Before(e.expires)
+}
+
+type cache struct {
+	mu sync.Mutex
+
+	memory      *list.List // of *entry, most recently used first
+	memoryBytes int64
+	maxMemory   int64
+
+	disk      *list.List // of *entry, most recently used first
+	diskBytes int64
+	maxDisk   int64
+	diskDir   string // "" disables the disk tier
+
+	index map[string]*list.Element
+
+	stats *stats
+}
+
+func newCache(maxMemory int64, diskDir string, maxDisk int64, stats *stats) (*cache, error) {
+	if diskDir != "" {
+		// Leftovers from a previous run are unreachable without the index
+		if err := os.RemoveAll(diskDir); err != nil {
+			return nil, fmt.Errorf("failed to clear disk cache: %w", err)
+		}
+		if err := os.MkdirAll(diskDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create disk cache: %w", err)
+		}
+	}
+	return &cache{
+		memory:    list.New(),
+		maxMemory: maxMemory,
+		disk:      list.New(),
+		maxDisk:   maxDisk,
+		diskDir:   diskDir,
+		index:     make(map[string]*list.Element),
+		stats:     stats,
+	}, nil
+}
+
+// Returns a copy of the entry for key with its body loaded, fresh or not, and
+// whether it came from disk. Copies stay valid when the entry is later demoted.
+func (c *cache) get(key string) (*entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.index[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*entry)
+	if !e.onDisk {
+		c.memory.MoveToFront(elem)
+		cp := *e
+		return &cp, false
+	}
+
+	body, err := os.ReadFile(c.diskPath(key))
+	if err != nil {
+		// Treat a broken disk entry as a miss
+		c.removeLocked(elem)
+		return nil, false
+	}
+	if e.size > c.maxMemory {
+		// Promoting it would only evict everything else and then itself
+		c.disk.MoveToFront(elem)
+		cp := *e
+		cp.body = body
+		cp.onDisk = false
+		return &cp, true
+	}
+	c.disk.Remove(elem)
+	c.diskBytes -= e.size
+	os.Remove(c.diskPath(key))
+	e.onDisk = false
+	e.body = body
+	c.insertMemoryLocked(e)
+	cp := *e
+	return &cp, true
+}
+
+// Stores e, replacing any entry with the same key. Entries too large for both
+// tiers are not cached.
+func (c *cache) put(e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.index[e.key]; ok {
+		c.removeLocked(elem)
+	}
+	if e.size <= c.maxMemory {
+		c.insertMemoryLocked(e)
+	} else if c.diskDir != "" && e.size <= c.maxDisk {
+		c.insertDiskLocked(e)
+	}
+}
+
+// Extends the lifetime of an entry after the origin confirmed it is unchanged
+func (c *cache) refresh(key string, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.index[key]; ok {
+		elem.Value.(*entry).expires = expires
+	}
+}
+
+// Drops the entry for key, if there is one
+func (c *cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.index[key]; ok {
+		c.removeLocked(elem)
+	}
+}
+
+func (c *cache) insertMemoryLocked(e *entry) {
+	c.index[e.key] = c.memory.PushFront(e)
+	c.memoryBytes += e.size
+	for c.memoryBytes > c.maxMemory {
+		oldest := c.memory.Back()
+		victim := oldest.Value.(*entry)
+		c.memory.Remove(oldest)
+		c.memoryBytes -= victim.size
+		delete(c.index, victim.key)
+
+		if c.diskDir != "" && victim.size <= c.maxDisk {
+			c.insertDiskLocked(victim)
+		} else {
+			c.stats.Evictions.Add(1)
+		}
+	}
+	c.updateSizesLocked()
+}
+
+func (c *cache) insertDiskLocked(e *entry) {
+	if err := writeFileAtomic(c.diskPath(e.key), e.body); err != nil {
+		c.stats.Evictions.Add(1)
+		return
+	}
+	e.body = nil
+	e.onDisk = true
+	c.index[e.key] = c.disk.PushFront(e)
+	c.diskBytes += e.size
+	for c.diskBytes > c.maxDisk {
+		c.removeLocked(c.disk.Back())
+		c.stats.Evictions.Add(1)
+	}
+	c.updateSizesLocked()
+}
+
+func (c *cache) removeLocked(elem *list.Element) {
+	e := elem.Value.(*entry)
+	if e.onDisk {
+		c.disk.Remove(elem)
+		c.diskBytes -= e.size
+		os.Remove(c.diskPath(e.key))
+	} else {
+		c.memory.Remove(elem)
+		c.memoryBytes -= e.size
+	}
+	delete(c.index, e.key)
+	c.updateSizesLocked()
+}
+
+func (c *cache) updateSizesLocked() {
+	c.stats.MemoryBytes.Store(c.memoryBytes)
+	c.stats.DiskBytes.Store(c.diskBytes)
+	c.stats.Entries.Store(int64(len(c.index)))
+}
+
+func (c *cache) diskPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.diskDir, hex.EncodeToString(sum[:]))
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
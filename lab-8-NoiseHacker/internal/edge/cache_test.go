@@ -0,0 +1,76 @@
+package edge
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T, maxMemory, maxDisk int64) *cache {
+	t.Helper()
+	c, err := newCache(maxMemory, t.TempDir(), maxDisk, &stats{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func testEntry(key string, size int) *entry {
+	return &entry{
+		key:     key,
+		body:    bytes.Repeat([]byte{'x'}, size),
+		size:    int64(size),
+		expires: time.Now().Add(time.Minute),
+	}
+}
+
+func TestCacheDemotesToDiskAndPromotes(t *testing.T) {
+	c := newTestCache(t, 100, 1000)
+	c.put(testEntry("a", 60))
+	c.put(testEntry("b", 60))
+
+	e, fromDisk := c.get("a")
+	if e == nil || !fromDisk {
+		t.Fatalf("get(a) = %v, %v, want a disk hit", e, fromDisk)
+	}
+	if len(e.body) != 60 {
+		t.Fatalf("body has %d bytes, want 60", len(e.body))
+	}
+	// a is back in memory and pushed b out
+	if e, fromDisk := c.get("a"); e == nil || fromDisk {
+		t.Fatalf("get(a) again = %v, %v, want a memory hit", e, fromDisk)
+	}
+	if e, fromDisk := c.get("b"); e == nil || !fromDisk {
+		t.Fatalf("get(b) = %v, %v, want a disk hit", e, fromDisk)
+	}
+}
+
+func TestCacheServesEntryLargerThanMemoryFromDisk(t *testing.T) {
+	c := newTestCache(t, 100, 1000)
+	c.put(testEntry("small", 50))
+	c.put(testEntry("big", 300))
+
+	for i := range 2 {
+		e, fromDisk := c.get("big")
+		if e == nil || !fromDisk {
+			t.Fatalf("get(big) #%d = %v, %v, want a disk hit", i, e, fromDisk)
+		}
+		if len(e.body) != 300 {
+			t.Fatalf("get(big) #%d returned %d bytes, want 300", i, len(e.body))
+		}
+	}
+	if e, fromDisk := c.get("small"); e == nil || fromDisk {
+		t.Fatalf("get(small) = %v, %v, want it still in memory", e, fromDisk)
+	}
+	if c.memoryBytes != 50 || c.diskBytes != 300 {
+		t.Fatalf("memory %d bytes, disk %d bytes, want 50 and 300", c.memoryBytes, c.diskBytes)
+	}
+}
+
+func TestCacheDropsEntriesTooLargeForBothTiers(t *testing.T) {
+	c := newTestCache(t, 100, 200)
+	c.put(testEntry("huge", 300))
+	if e, _ := c.get("huge"); e != nil {
+		t.Fatalf("get(huge) = %v, want a miss", e)
+	}
+}
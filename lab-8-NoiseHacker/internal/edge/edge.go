@@ -0,0 +1,324 @@
+// Edge caching proxy for TritonTube web servers.
+//
+// /content/ responses are cached according to their Cache-Control headers and
+// served from the cache, including Range and conditional requests. Concurrent
+// misses for the same path share one request to the origin. Everything else is
+// proxied to the origins uncached.
+
+package edge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stats counts what the edge did since it started
+type stats struct {
+	MemoryHits     atomic.Int64
+	DiskHits       atomic.Int64
+	Misses         atomic.Int64
+	Revalidations  atomic.Int64 // stale entries the origin confirmed with a 304
+	Coalesced      atomic.Int64 // misses that waited for another request's fetch
+	Evictions      atomic.Int64
+	UpstreamErrors atomic.Int64
+	MemoryBytes    atomic.Int64
+	DiskBytes      atomic.Int64
+	Entries        atomic.Int64
+}
+
+// Headers of an origin response that are replayed for cache hits
+var cachedHeaders = []string{"Content-Type", "Cache-Control", "ETag", "Last-Modified"}
+
+// Edge is an http.Handler fronting one or more TritonTube web servers
+type Edge struct {
+	origins []*url.URL
+	next    atomic.Uint64 // round-robin position in origins
+	client  *http.Client
+	proxy   *httputil.ReverseProxy
+	cache   *cache
+	stats   stats
+
+	mu       sync.Mutex
+	inflight map[string]*fetchCall
+}
+
+// fetchCall is an origin request that concurrent misses wait on
+type fetchCall struct {
+	done chan struct{}
+	resp *originResponse
+	err  error
+}
+
+// originResponse is a complete response read from an origin
+type originResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// NewEdge creates an edge for the given origin base URLs. Cached content takes
+// up to maxMemory bytes of memory and, if diskDir is not empty, up to maxDisk
+// bytes in diskDir.
+func NewEdge(origins []string, maxMemory int64, diskDir string, maxDisk int64) (*Edge, error) {
+	if len(origins) == 0 {
+		return nil, fmt.Errorf("at least one origin is required")
+	}
+	e := &Edge{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		inflight: make(map[string]*fetchCall),
+	}
+	for _, o := range origins {
+		if !strings.Contains(o, "://") {
+			o = "http://" + o
+		}
+		u, err := url.Parse(o)
+		if err != nil {
+			return nil, fmt.Errorf("invalid origin %q: %w", o, err)
+		}
+		e.origins = append(e.origins, u)
+	}
+	c, err := newCache(maxMemory, diskDir, maxDisk, &e.stats)
+	if err != nil {
+		return nil, err
+	}
+	e.cache = c
+	e.proxy = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(e.pickOrigin())
+			r.SetXForwarded()
+		},
+	}
+	return e, nil
+}
+
+func (e *Edge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/edge/stats":
+		e.handleStats(w, r)
+	case strings.HasPrefix(r.URL.Path, "/content/") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		e.handleContent(w, r)
+	default:
+		e.proxy.ServeHTTP(w, r)
+	}
+}
+
+func (e *Edge) handleContent(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.EscapedPath()
+
+	ent, fromDisk := e.cache.get(key)
+	if ent != nil && ent.fresh(time.Now()) {
+		if fromDisk {
+			e.stats.DiskHits.Add(1)
+		} else {
+			e.stats.MemoryHits.Add(1)
+		}
+		serveEntry(w, r, ent, "HIT")
+		return
+	}
+
+	resp, err := e.fetch(key, ent)
+	if err != nil {
+		e.stats.UpstreamErrors.Add(1)
+		if ent != nil {
+			// Better a stale segment than none
+			serveEntry(w, r, ent, "STALE")
+			return
+		}
+		slog.ErrorContext(r.Context(), "Edge fetch failed", "key", key, "err", err)
+		http.Error(w, "Origin unavailable", http.StatusBadGateway)
+		return
+	}
+	if resp.status == http.StatusNotModified && ent != nil {
+		serveEntry(w, r, ent, "REVALIDATED")
+		return
+	}
+	if resp.status != http.StatusOK {
+		for name, values := range resp.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("X-Cache", "MISS")
+		w.WriteHeader(resp.status)
+		w.Write(resp.body)
+		return
+	}
+	serveEntry(w, r, responseEntry(key, resp), "MISS")
+}
+
+// Fetches key from an origin, sharing the request with concurrent callers for
+// the same key. A stale entry is revalidated with If-None-Match, and the cache
+// is updated before the callers are released.
+func (e *Edge) fetch(key string, stale *entry) (*originResponse, error) {
+	e.mu.Lock()
+	if call, ok := e.inflight[key]; ok {
+		e.mu.Unlock()
+		e.stats.Coalesced.Add(1)
+		<-call.done
+		return call.resp, call.err
+	}
+	call := &fetchCall{done: make(chan struct{})}
+	e.inflight[key] = call
+	e.mu.Unlock()
+
+	e.stats.Misses.Add(1)
+	call.resp, call.err = e.fetchFromOrigin(key, stale)
+	if call.err == nil {
+		e.store(key, call.resp, stale)
+	}
+
+	e.mu.Lock()
+	delete(e.inflight, key)
+	e.mu.Unlock()
+	close(call.done)
+	return call.resp, call.err
+}
+
+// Tries each origin once, starting at the next one in round-robin order
+func (e *Edge) fetchFromOrigin(key string, stale *entry) (*originResponse, error) {
+	var lastErr error
+	for range e.origins {
+		origin := e.pickOrigin()
+		req, err := http.NewRequest(http.MethodGet, origin.String()+key, nil)
+		if err != nil {
+			return nil, err
+		}
+		if stale != nil && stale.header.Get("ETag") != "" {
+			req.Header.Set("If-None-Match", stale.header.Get("ETag"))
+		}
+		resp, err := e.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("%s returned %s", origin.Host, resp.Status)
+			continue
+		}
+		return &originResponse{status: resp.StatusCode, header: resp.Header, body: body}, nil
+	}
+	return nil, lastErr
+}
+
+// Updates the cache with an origin response
+func (e *Edge) store(key string, resp *originResponse, stale *entry) {
+	switch resp.status {
+	case http.StatusNotModified:
+		e.stats.Revalidations.Add(1)
+		if stale != nil {
+			if ttl, ok := cacheTTL(resp.header.Get("Cache-Control")); ok {
+				e.cache.refresh(key, time.Now().Add(ttl))
+			} else if ttl, ok := cacheTTL(stale.header.Get("Cache-Control")); ok {
+				e.cache.refresh(key, time.Now().Add(ttl))
+			}
+		}
+	case http.StatusOK:
+		if _, ok := cacheTTL(resp.header.Get("Cache-Control")); ok {
+			e.cache.put(responseEntry(key, resp))
+		} else if stale != nil {
+			e.cache.remove(key)
+		}
+	default:
+		// The content is gone or no longer public, so the stale copy must not
+		// outlive it
+		if stale != nil {
+			e.cache.remove(key)
+		}
+	}
+}
+
+func (e *Edge) pickOrigin() *url.URL {
+	n := e.next.Add(1) - 1
+	return e.origins[n%uint64(len(e.origins))]
+}
+
+// Returns how long a response may be served from the cache, or false if it must
+// not be cached at all
+func cacheTTL(cacheControl string) (time.Duration, bool) {
+	var maxAge, sMaxAge = -1, -1
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache", "private":
+			return 0, false
+		case "max-age":
+			maxAge, _ = strconv.Atoi(value)
+		case "s-maxage":
+			sMaxAge, _ = strconv.Atoi(value)
+		}
+	}
+	if sMaxAge >= 0 {
+		maxAge = sMaxAge
+	}
+	if maxAge <= 0 {
+		return 0, false
+	}
+	return time.Duration(maxAge) * time.Second, true
+}
+
+func responseEntry(key string, resp *originResponse) *entry {
+	header := make(http.Header)
+	for _, name := range cachedHeaders {
+		if v := resp.header.Get(name); v != "" {
+			header.Set(name, v)
+		}
+	}
+	ttl, _ := cacheTTL(resp.header.Get("Cache-Control"))
+	return &entry{
+		key:     key,
+		header:  header,
+		body:    resp.body,
+		size:    int64(len(resp.body)),
+		expires: time.Now().Add(ttl),
+	}
+}
+
+// Writes a cached response; ServeContent takes care of Range and conditional
+// requests using the replayed ETag and Last-Modified headers
+func serveEntry(w http.ResponseWriter, r *http.Request, ent *entry, cacheStatus string) {
+	for name, values := range ent.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("X-Cache", cacheStatus)
+	modTime, _ := http.ParseTime(ent.header.Get("Last-Modified"))
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(ent.body))
+}
+
+// Handles "/edge/stats", reporting the counters as JSON
+func (e *Edge) handleStats(w http.ResponseWriter, r *http.Request) {
+	hits := e.stats.MemoryHits.Load() + e.stats.DiskHits.Load()
+	requests := hits + e.stats.Misses.Load() + e.stats.Coalesced.Load()
+	hitRatio := 0.0
+	if requests > 0 {
+		hitRatio = float64(hits) / float64(requests)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"memory_hits":     e.stats.MemoryHits.Load(),
+		"disk_hits":       e.stats.DiskHits.Load(),
+		"misses":          e.stats.Misses.Load(),
+		"coalesced":       e.stats.Coalesced.Load(),
+		"revalidations":   e.stats.Revalidations.Load(),
+		"evictions":       e.stats.Evictions.Load(),
+		"upstream_errors": e.stats.UpstreamErrors.Load(),
+		"hit_ratio":       hitRatio,
+		"entries":         e.stats.Entries.Load(),
+		"memory_bytes":    e.stats.MemoryBytes.Load(),
+		"disk_bytes":      e.stats.DiskBytes.Load(),
+	})
+}
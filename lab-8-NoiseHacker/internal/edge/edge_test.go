@@ -0,0 +1,69 @@
+package edge
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheTTLPrefersSharedMaxAge(t *testing.T) {
+	for _, tc := range []struct {
+		cacheControl string
+		ttl          time.Duration
+		ok           bool
+	}{
+		{"public, max-age=31536000, s-maxage=60", time.Minute, true},
+		{"public, max-age=10", 10 * time.Second, true},
+		{"public, no-cache", 0, false},
+		{"private, max-age=31536000, s-maxage=60", 0, false},
+		{"", 0, false},
+	} {
+		ttl, ok := cacheTTL(tc.cacheControl)
+		if ttl != tc.ttl || ok != tc.ok {
+			t.Errorf("cacheTTL(%q) = %v, %v, want %v, %v", tc.cacheControl, ttl, ok, tc.ttl, tc.ok)
+		}
+	}
+}
+
+// A segment the origin stops serving, because its video was deleted or made
+// private, is dropped at the next revalidation
+func TestEdgeDropsContentTheOriginNoLongerServes(t *testing.T) {
+	var gone atomic.Bool
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if gone.Load() {
+			http.Error(w, "Content not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=31536000, s-maxage=60")
+		w.Header().Set("ETag", `"abc"`)
+		w.Write([]byte("segment"))
+	}))
+	defer origin.Close()
+	e, err := NewEdge([]string{origin.URL}, 1<<20, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/content/v/chunk-0-00001.m4s", nil))
+		return rec
+	}
+
+	if rec := get(); rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first request: %d %s", rec.Code, rec.Header().Get("X-Cache"))
+	}
+	if rec := get(); rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("second request: %s, want HIT", rec.Header().Get("X-Cache"))
+	}
+
+	gone.Store(true)
+	e.cache.refresh("/content/v/chunk-0-00001.m4s", time.Now().Add(-time.Second))
+	if rec := get(); rec.Code != http.StatusNotFound {
+		t.Fatalf("request after the content was deleted: %d, want 404", rec.Code)
+	}
+	if ent, _ := e.cache.get("/content/v/chunk-0-00001.m4s"); ent != nil {
+		t.Fatal("the deleted content is still cached")
+	}
+}
@@ -0,0 +1,211 @@
+// Package erasure implements systematic Reed-Solomon erasure coding over
+// GF(2^8).
+//
+// Data is split into k data shards of equal size, and m parity shards are
+// computed from them. Any k of the k+m shards are enough to get the data
+// back, so up to m can be lost. The code is systematic: the data shards hold
+// the data itself, and reading it back needs no decoding while they are all
+// there.
+//
+// The encoding matrix is a (k+m)×k Vandermonde matrix multiplied by the
+// inverse of its top k rows. Any k rows of a Vandermonde matrix with distinct
+// points are invertible, and multiplying by an invertible matrix keeps them
+// so, which is what makes every choice of k shards decodable. The top rows
+// become the identity, giving the systematic form.
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTooFewShards is returned when fewer than k shards are left to rebuild from
+var ErrTooFewShards = errors.New("erasure: too few shards to reconstruct")
+
+// ErrShardSize is returned when the shards given are not all the same size
+var ErrShardSize = errors.New("erasure: shards differ in size")
+
+// Code encodes and reconstructs shards for one choice of k and m. It is safe
+// for concurrent use.
+type Code struct {
+	dataShards   int
+	parityShards int
+	matrix       matrix // (k+m)×k; the top k rows are the identity
+}
+
+// New returns a code with dataShards data and parityShards parity shards.
+// Together they can be at most 256, the size of the field.
+func New(dataShards, parityShards int) (*Code, error) {
+	if dataShards < 1 || parityShards < 0 {
+		return nil, fmt.Errorf("erasure: need at least 1 data shard and no negative parity, got %d+%d", dataShards, parityShards)
+	}
+	if dataShards+parityShards > 256 {
+		return nil, fmt.Errorf("erasure: at most 256 shards, got %d+%d", dataShards, parityShards)
+	}
+	total := dataShards + parityShards
+	vm := vandermonde(total, dataShards)
+	top, err := vm.subMatrix(0, dataShards).invert()
+	if err != nil {
+		// Cannot happen for distinct points
+		return nil, err
+	}
+	return &Code{dataShards: dataShards, parityShards: parityShards, matrix: vm.multiply(top)}, nil
+}
+
+func (c *Code) DataShards() int   { return c.dataShards }
+func (c *Code) ParityShards() int { return c.parityShards }
+func (c *Code) TotalShards() int  { return c.dataShards + c.parityShards }
+
+// ShardSize returns the size of each shard for size bytes of data
+func (c *Code) ShardSize(size int) int {
+	return (size + c.dataShards - 1) / c.dataShards
+}
+
+// Split cuts data into k data shards, zero-padding the last, and allocates
+// the m parity shards for Encode to fill. Empty data gives empty shards.
+func (c *Code) Split(data []byte) [][]byte {
+	shardSize := c.ShardSize(len(data))
+	// One allocation for all shards; the data shards copy the data so the
+	// caller's slice is never written to
+	buf := make([]byte, shardSize*c.TotalShards())
+	copy(buf, data)
+	shards := make([][]byte, c.TotalShards())
+	for i := range shards {
+		shards[i] = buf[i*shardSize : (i+1)*shardSize : (i+1)*shardSize]
+	}
+	return shards
+}
+
+// Encode computes the parity shards from the data shards. All k+m shards must
+// be allocated and of the same size.
+func (c *Code) Encode(shards [][]byte) error {
+	if len(shards) != c.TotalShards() {
+		return fmt.Errorf("erasure: want %d shards, got %d", c.TotalShards(), len(shards))
+	}
+	for i, shard := range shards {
+		if shard == nil {
+			return fmt.Errorf("erasure: shard %d is missing", i)
+		}
+	}
+	if _, err := shardSize(shards); err != nil {
+		return err
+	}
+	c.codeShards(c.matrix[c.dataShards:], shards[:c.dataShards], shards[c.dataShards:])
+	return nil
+}
+
+// Reconstruct fills in the shards that are nil from any k of the others, data
+// and parity alike. Fails with ErrTooFewShards if fewer than k are present.
+func (c *Code) Reconstruct(shards [][]byte) error {
+	if len(shards) != c.TotalShards() {
+		return fmt.Errorf("erasure: want %d shards, got %d", c.TotalShards(), len(shards))
+	}
+	size, err := shardSize(shards)
+	if err != nil {
+		return err
+	}
+
+	// Decodes the data from the first k shards present
+	var rows []int
+	for i, shard := range shards {
+		if shard != nil {
+			rows = append(rows, i)
+		}
+	}
+	if len(rows) < c.dataShards {
+		return ErrTooFewShards
+	}
+	if len(rows) == c.TotalShards() {
+		return nil
+	}
+	rows = rows[:c.dataShards]
+
+	dataMissing := false
+	for i := 0; i < c.dataShards; i++ {
+		if shards[i] == nil {
+			dataMissing = true
+		}
+	}
+	if dataMissing {
+		sub := make(matrix, c.dataShards)
+		inputs := make([][]byte, c.dataShards)
+		for i, row := range rows {
+			sub[i] = c.matrix[row]
+			inputs[i] = shards[row]
+		}
+		decode, err := sub.invert()
+		if err != nil {
+			return err
+		}
+		var missingRows matrix
+		var outputs [][]byte
+		for i := 0; i < c.dataShards; i++ {
+			if shards[i] == nil {
+				shards[i] = make([]byte, size)
+				missingRows = append(missingRows, decode[i])
+				outputs = append(outputs, shards[i])
+			}
+		}
+		c.codeShards(missingRows, inputs, outputs)
+	}
+
+	// The data is complete now, so missing parity is encoded afresh
+	var parityRows matrix
+	var outputs [][]byte
+	for i := c.dataShards; i < c.TotalShards(); i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+			parityRows = append(parityRows, c.matrix[i])
+			outputs = append(outputs, shards[i])
+		}
+	}
+	c.codeShards(parityRows, shards[:c.dataShards], outputs)
+	return nil
+}
+
+// Join concatenates the data shards and cuts the padding off, returning size
+// bytes. The data shards must all be present.
+func (c *Code) Join(shards [][]byte, size int) ([]byte, error) {
+	if len(shards) < c.dataShards {
+		return nil, ErrTooFewShards
+	}
+	data := make([]byte, 0, size)
+	for _, shard := range shards[:c.dataShards] {
+		if shard == nil {
+			return nil, ErrTooFewShards
+		}
+		data = append(data, shard...)
+	}
+	if len(data) < size {
+		return nil, fmt.Errorf("erasure: shards hold %d bytes, want %d", len(data), size)
+	}
+	return data[:size], nil
+}
+
+// Sets each output to the product of its row with the inputs
+func (c *Code) codeShards(rows matrix, inputs, outputs [][]byte) {
+	for i, out := range outputs {
+		clear(out)
+		for j, in := range inputs {
+			mulAdd(out, in, rows[i][j])
+		}
+	}
+}
+
+// Returns the size shared by the present shards
+func shardSize(shards [][]byte) (int, error) {
+	size := -1
+	for _, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		if size >= 0 && len(shard) != size {
+			return 0, ErrShardSize
+		}
+		size = len(shard)
+	}
+	if size < 0 {
+		return 0, ErrTooFewShards
+	}
+	return size, nil
+}
@@ -0,0 +1,128 @@
+package erasure
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"testing"
+)
+
+func TestFieldInverses(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if got := gfMul(byte(a), gfInv(byte(a))); got != 1 {
+			t.Fatalf("%d * inv(%d) = %d, want 1", a, a, got)
+		}
+		if got := gfMul(byte(a), 0); got != 0 {
+			t.Fatalf("%d * 0 = %d, want 0", a, got)
+		}
+	}
+	// Multiplication distributes over addition, which is XOR
+	for a := 0; a < 256; a++ {
+		for _, b := range []byte{0, 1, 2, 0x1d, 0x80, 0xff} {
+			for _, c := range []byte{3, 0x53, 0xca} {
+				if gfMul(byte(a), b^c) != gfMul(byte(a), b)^gfMul(byte(a), c) {
+					t.Fatalf("%d * (%d + %d) does not distribute", a, b, c)
+				}
+			}
+		}
+	}
+	if got := gfPow(2, 8); got != 0x1d {
+		t.Fatalf("2^8 = %#x, want %#x, the low bits of the polynomial", got, 0x1d)
+	}
+}
+
+var codes = []struct{ k, m int }{{1, 0}, {1, 2}, {2, 1}, {4, 2}, {6, 3}, {10, 4}}
+
+// Sizes around the shard boundaries of every code above, up to 4097
+var sizes = []int{0, 1, 2, 3, 5, 7, 9, 10, 11, 64, 255, 1000, 4096, 4097}
+
+func encoded(t *testing.T, code *Code, data []byte) [][]byte {
+	t.Helper()
+	shards := code.Split(data)
+	if err := code.Encode(shards); err != nil {
+		t.Fatal(err)
+	}
+	return shards
+}
+
+// Every way of losing up to m shards is recovered from
+func TestReconstructEveryLoss(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, tc := range codes {
+		code, err := New(tc.k, tc.m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total := code.TotalShards()
+		for _, size := range sizes {
+			data := make([]byte, size)
+			rng.Read(data)
+			want := encoded(t, code, data)
+			if joined, err := code.Join(want, size); err != nil || !bytes.Equal(joined, data) {
+				t.Fatalf("%d+%d, %d bytes: data shards do not hold the data: %v", tc.k, tc.m, size, err)
+			}
+
+			for lost := 0; lost < 1<<total; lost++ {
+				if bits.OnesCount(uint(lost)) > tc.m {
+					continue
+				}
+				shards := make([][]byte, total)
+				for i := range shards {
+					if lost&(1<<i) == 0 {
+						shards[i] = bytes.Clone(want[i])
+					}
+				}
+				name := fmt.Sprintf("%d+%d, %d bytes, lost %0*b", tc.k, tc.m, size, total, lost)
+				if err := code.Reconstruct(shards); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				for i := range shards {
+					if !bytes.Equal(shards[i], want[i]) {
+						t.Fatalf("%s: shard %d rebuilt wrong", name, i)
+					}
+				}
+				if joined, err := code.Join(shards, size); err != nil || !bytes.Equal(joined, data) {
+					t.Fatalf("%s: rebuilt data differs: %v", name, err)
+				}
+			}
+		}
+	}
+}
+
+func TestReconstructTooManyLosses(t *testing.T) {
+	code, err := New(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := encoded(t, code, []byte("too many shards are gone"))
+	shards[0], shards[3], shards[5] = nil, nil, nil
+	if err := code.Reconstruct(shards); !errors.Is(err, ErrTooFewShards) {
+		t.Fatalf("Reconstruct with 3 of 6 shards lost returned %v, want ErrTooFewShards", err)
+	}
+	if _, err := code.Join(shards, 24); !errors.Is(err, ErrTooFewShards) {
+		t.Fatalf("Join with a data shard missing returned %v, want ErrTooFewShards", err)
+	}
+}
+
+func TestReconstructRejectsShardsOfDifferentSizes(t *testing.T) {
+	code, err := New(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := encoded(t, code, []byte("abcdef"))
+	shards[1] = shards[1][:1]
+	shards[2] = nil
+	if err := code.Reconstruct(shards); !errors.Is(err, ErrShardSize) {
+		t.Fatalf("Reconstruct returned %v, want ErrShardSize", err)
+	}
+}
+
+func TestNewRejectsBadParameters(t *testing.T) {
+	for _, tc := range []struct{ k, m int }{{0, 2}, {2, -1}, {200, 57}} {
+		if _, err := New(tc.k, tc.m); err == nil {
+			t.Errorf("New(%d, %d) succeeded", tc.k, tc.m)
+		}
+	}
+}
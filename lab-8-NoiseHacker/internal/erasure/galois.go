@@ -0,0 +1,152 @@
+package erasure
+
+import "errors"
+
+// Arithmetic in GF(2^8) with the polynomial x^8+x^4+x^3+x^2+1 (0x11d), whose
+// generator is 2. Addition is XOR.
+
+var (
+	expTable [510]byte      // 2^i, repeated so a sum of two logs needs no reduction
+	logTable [256]byte      // log2 of 1..255
+	mulTable [256][256]byte // full products, so coding is one lookup per byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			mulTable[a][b] = expTable[int(logTable[a])+int(logTable[b])]
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	return mulTable[a][b]
+}
+
+// Returns the inverse of a, which must not be 0
+func gfInv(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
+
+// Returns a^n
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])*n)%255]
+}
+
+// Adds c times in to out
+func mulAdd(out, in []byte, c byte) {
+	switch c {
+	case 0:
+		return
+	case 1:
+		for i, v := range in {
+			out[i] ^= v
+		}
+		return
+	}
+	row := &mulTable[c]
+	for i, v := range in {
+		out[i] ^= row[v]
+	}
+}
+
+// matrix is a matrix over GF(2^8), by rows
+type matrix [][]byte
+
+var errSingular = errors.New("erasure: singular matrix")
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+// Returns the rows×cols matrix whose row i is 1, i, i^2, ...
+func vandermonde(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for i := range m {
+		for j := range m[i] {
+			m[i][j] = gfPow(byte(i), j)
+		}
+	}
+	return m
+}
+
+// Returns a copy of rows [from, to)
+func (m matrix) subMatrix(from, to int) matrix {
+	sub := make(matrix, to-from)
+	for i := range sub {
+		sub[i] = append([]byte(nil), m[from+i]...)
+	}
+	return sub
+}
+
+func (m matrix) multiply(other matrix) matrix {
+	result := newMatrix(len(m), len(other[0]))
+	for i := range m {
+		for j := range other[0] {
+			var v byte
+			for k := range other {
+				v ^= gfMul(m[i][k], other[k][j])
+			}
+			result[i][j] = v
+		}
+	}
+	return result
+}
+
+// Returns the inverse of a square matrix by Gauss-Jordan elimination
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+	work := newMatrix(n, 2*n)
+	for i := range m {
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for row := col; row < n; row++ {
+			if work[row][col] != 0 {
+				pivot = row
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errSingular
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		if inv := gfInv(work[col][col]); inv != 1 {
+			for j := range work[col] {
+				work[col][j] = gfMul(work[col][j], inv)
+			}
+		}
+		for row := 0; row < n; row++ {
+			if row != col && work[row][col] != 0 {
+				mulAdd(work[row], work[col], work[row][col])
+			}
+		}
+	}
+	inverse := make(matrix, n)
+	for i := range work {
+		inverse[i] = work[i][n:]
+	}
+	return inverse, nil
+}
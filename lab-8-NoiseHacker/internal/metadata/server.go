@@ -0,0 +1,150 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"tritontube/internal/proto"
+	"tritontube/internal/raft"
+	"tritontube/internal/web"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	gproto "google.golang.org/protobuf/proto"
+)
+
+// Server serves the MetadataService of one metadata node. A follower forwards
+// every request to the leader, so clients can use any node.
+type Server struct {
+	node  *raft.Node
+	store *Store
+
+	mu      sync.Mutex
+	leaders map[string]proto.MetadataServiceClient // for forwarding, by address
+
+	proto.UnimplementedMetadataServiceServer
+}
+
+// NewServer serves store, which must be the state machine of node. The node's
+// ID must be the address its MetadataService is reachable at.
+func NewServer(node *raft.Node, store *Store) *Server {
+	return &Server{node: node, store: store, leaders: make(map[string]proto.MetadataServiceClient)}
+}
+
+func (s *Server) Execute(ctx context.Context, req *proto.MetadataRequest) (*proto.MetadataResponse, error) {
+	write := IsWrite(req.Op)
+	if !write && !IsRead(req.Op) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown op %q", req.Op)
+	}
+
+	if write {
+		cmd, err := json.Marshal(command{RequestId: req.RequestId, Op: req.Op, Args: req.Args, Time: time.Now()})
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		out, err := s.node.Propose(ctx, cmd)
+		if errors.Is(err, raft.ErrNotLeader) {
+			return s.forward(ctx, req)
+		}
+		if err != nil {
+			return nil, raftError(err)
+		}
+		var res result
+		if err := json.Unmarshal(out, &res); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if res.Error != "" {
+			return nil, statusError(errorFromText(res.Error))
+		}
+		return &proto.MetadataResponse{Result: res.Value}, nil
+	}
+
+	err := s.node.ReadIndex(ctx)
+	if errors.Is(err, raft.ErrNotLeader) {
+		return s.forward(ctx, req)
+	}
+	if err != nil {
+		return nil, raftError(err)
+	}
+	out, err := s.store.Query(req.Op, req.Args)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &proto.MetadataResponse{Result: out}, nil
+}
+
+// Passes a request on to the leader. A request is only forwarded once, so two
+// nodes with stale ideas of the leader cannot bounce it between them.
+func (s *Server) forward(ctx context.Context, req *proto.MetadataRequest) (*proto.MetadataResponse, error) {
+	leader := s.node.Leader()
+	if leader == "" || leader == s.node.ID() || req.Forwarded {
+		return nil, status.Error(codes.Unavailable, "no metadata leader, try again")
+	}
+	client, err := s.leader(leader)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	fwd := gproto.Clone(req).(*proto.MetadataRequest)
+	fwd.Forwarded = true
+	return client.Execute(ctx, fwd)
+}
+
+func (s *Server) leader(addr string) (proto.MetadataServiceClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.leaders[addr]; ok {
+		return c, nil
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	c := proto.NewMetadataServiceClient(conn)
+	s.leaders[addr] = c
+	return c, nil
+}
+
+func (s *Server) Status(ctx context.Context, req *proto.MetadataStatusRequest) (*proto.MetadataStatusResponse, error) {
+	st := s.node.Status()
+	return &proto.MetadataStatusResponse{
+		Id:            st.ID,
+		State:         st.State.String(),
+		Term:          st.Term,
+		Leader:        st.Leader,
+		CommitIndex:   st.CommitIndex,
+		AppliedIndex:  st.AppliedIndex,
+		SnapshotIndex: st.SnapshotIndex,
+		Peers:         st.Peers,
+	}, nil
+}
+
+// Maps the errors VideoMetadataService callers check for to status codes, see
+// metadata.proto
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, web.ErrVideoIDTaken), errors.Is(err, web.ErrUsernameTaken):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, web.ErrInvalidCursor):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Maps a failure to get a request through Raft to a status code
+func raftError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return status.FromContextError(err).Err()
+	}
+	if errors.Is(err, raft.ErrStopped) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
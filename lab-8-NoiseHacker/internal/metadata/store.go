@@ -0,0 +1,625 @@
+// Package metadata runs the video metadata as a state machine replicated by
+// Raft, for web servers that use the raft metadata service instead of a local
+// SQLite database.
+//
+// Every VideoMetadataService method is an op. Ops that change state are
+// proposed to the Raft log as commands, stamped with the leader's clock so
+// every node applies them identically; the others are answered by the leader
+// from its copy of the state, after ReadIndex confirms it is current.
+package metadata
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tritontube/internal/raft"
+	"tritontube/internal/web"
+)
+
+// How many recent write results are remembered to answer retries
+const maxRememberedRequests = 10000
+
+// command is one write in the Raft log
+type command struct {
+	RequestId string          `json:"requestId"`
+	Op        string          `json:"op"`
+	Args      json.RawMessage `json:"args"` // JSON array of the method's arguments
+	Time      time.Time       `json:"time"` // the leader's clock when it was proposed
+}
+
+// result is what applying a command returned
+type result struct {
+	Value json.RawMessage `json:"value,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// The errors that travel through the log and over the wire by their text
+var knownErrors = []error{sql.ErrNoRows, web.ErrVideoIDTaken, web.ErrUsernameTaken, web.ErrInvalidCursor}
+
+func errorFromText(text string) error {
+	for _, err := range knownErrors {
+		if err.Error() == text {
+			return err
+		}
+	}
+	return errors.New(text)
+}
+
+// Store is the replicated metadata. It keeps everything in memory; the Raft
+// log and its snapshots are what make it durable.
+type Store struct {
+	mu    sync.RWMutex
+	state state
+}
+
+var _ raft.StateMachine = (*Store)(nil)
+
+// state is everything a snapshot holds
+type state struct {
+	Videos   map[string]*video            `json:"videos"`  // including tombstoned ones
+	Pending  map[string]web.VideoMetadata `json:"pending"` // by upload transaction ID
+	Jobs     map[string]web.TranscodeJob  `json:"jobs"`
+	Users    map[string]web.User          `json:"users"`
+	Sessions map[string]web.Session       `json:"sessions"` // by token hash
+	// Results of recent writes by request ID, oldest first, so a retried
+	// write is not applied twice
+	Requests []rememberedRequest `json:"requests"`
+
+	usernames map[string]string // lower case username to user ID
+	requests  map[string]result
+}
+
+type video struct {
+	web.VideoMetadata
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+type rememberedRequest struct {
+	Id     string `json:"id"`
+	Result result `json:"result"`
+}
+
+func NewStore() *Store {
+	s := &Store{state: newState()}
+	s.state.index()
+	return s
+}
+
+func newState() state {
+	return state{
+		Videos:   make(map[string]*video),
+		Pending:  make(map[string]web.VideoMetadata),
+		Jobs:     make(map[string]web.TranscodeJob),
+		Users:    make(map[string]web.User),
+		Sessions: make(map[string]web.Session),
+	}
+}
+
+// Rebuilds the lookups that are left out of snapshots
+func (st *state) index() {
+	st.usernames = make(map[string]string)
+	for _, u := range st.Users {
+		st.usernames[strings.ToLower(u.Username)] = u.Id
+	}
+	st.requests = make(map[string]result)
+	for _, r := range st.Requests {
+		st.requests[r.Id] = r.Result
+	}
+}
+
+func (s *Store) Snapshot() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return json.Marshal(&s.state)
+}
+
+func (s *Store) Restore(snapshot []byte) error {
+	st := newState()
+	if err := json.Unmarshal(snapshot, &st); err != nil {
+		return err
+	}
+	st.index()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = st
+	return nil
+}
+
+// Apply runs a write command. A command whose request ID was already applied
+// returns the result it got then.
+func (s *Store) Apply(data []byte) []byte {
+	var cmd command
+	var res result
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		res.Error = fmt.Sprintf("invalid command: %v", err)
+		out, _ := json.Marshal(res)
+		return out
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.state.requests[cmd.RequestId]; ok && cmd.RequestId != "" {
+		out, _ := json.Marshal(prev)
+		return out
+	}
+	value, err := s.applyOp(cmd)
+	if err != nil {
+		res.Error = err.Error()
+	} else if value != nil {
+		res.Value, _ = json.Marshal(value)
+	}
+	if cmd.RequestId != "" {
+		s.state.Requests = append(s.state.Requests, rememberedRequest{Id: cmd.RequestId, Result: res})
+		s.state.requests[cmd.RequestId] = res
+		if len(s.state.Requests) > maxRememberedRequests {
+			delete(s.state.requests, s.state.Requests[0].Id)
+			s.state.Requests = slices.Delete(s.state.Requests, 0, 1)
+		}
+	}
+	out, _ := json.Marshal(res)
+	return out
+}
+
+// IsWrite reports whether op changes state and so goes through the log
+func IsWrite(op string) bool {
+	_, ok := writes[op]
+	return ok
+}
+
+// IsRead reports whether op is answered from a node's state
+func IsRead(op string) bool {
+	_, ok := reads[op]
+	return ok
+}
+
+// Decodes a JSON array of arguments into dst, one pointer per argument
+func decodeArgs(raw json.RawMessage, dst ...any) error {
+	var args []json.RawMessage
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	if len(args) != len(dst) {
+		return fmt.Errorf("invalid arguments: want %d, got %d", len(dst), len(args))
+	}
+	for i := range args {
+		if err := json.Unmarshal(args[i], dst[i]); err != nil {
+			return fmt.Errorf("invalid argument %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// The ops that change state, run with s.mu held for writing
+var writes = map[string]func(st *state, args json.RawMessage, now time.Time) (any, error){
+	"PrepareCreate": func(st *state, args json.RawMessage, now time.Time) (any, error) {
+		var txId string
+		var meta web.VideoMetadata
+		if err := decodeArgs(args, &txId, &meta); err != nil {
+			return nil, err
+		}
+		// IDs are never reused, so tombstoned videos count
+		if _, ok := st.Videos[meta.Id]; ok {
+			return nil, web.ErrVideoIDTaken
+		}
+		for otherTx, pending := range st.Pending {
+			if pending.Id == meta.Id && otherTx != txId {
+				return nil, web.ErrVideoIDTaken
+			}
+		}
+		st.Pending[txId] = meta
+		return nil, nil
+	},
+	"CommitCreate": func(st *state, args json.RawMessage, now time.Time) (any, error) {
+		var txId string
+		if err := decodeArgs(args, &txId); err != nil {
+			return nil, err
+		}
+		if meta, ok := st.Pending[txId]; ok {
+			st.Videos[meta.Id] = &video{VideoMetadata: meta}
+			delete(st.Pending, txId)
+		}
+		return nil, nil
+	},
+	"AbortCreate": func(st *state, args json.RawMessage, now time.Time) (any, error) {
+		var txId string
+		if err := decodeArgs(args, &txId); err != nil {
+			return nil, err
+		}
+		delete(st.Pending, txId)
+		return nil, nil
+	},
+	"Update": func(st *state, args json.RawMessage, now time.Time) (any, error) {
+		var videoId, title, description string
+		var visibility web.Visibility
+		if err := decodeArgs(args, &videoId, &title, &description, &visibility); err != nil {
+			return nil, err
+		}
+		v, ok := st.Videos[videoId]
+		if !ok || v.DeletedAt != nil {
+			return nil, sql.ErrNoRows
+		}
+		v.Title, v.Description, v.Visibility = title, description, visibility
+		return nil, nil
+	},
+	"EndLive": func(st *state, args json.RawMessage, now time.Time) (any, error) {
+		var videoId string
+		var duration time.Duration
+		if err := decodeArgs(args, &videoId, &duration); err != nil {
+			return nil, err
+		}
+		v, ok := st.Videos[videoId]
+		if !ok || v.DeletedAt != nil {
+			return nil, sql.ErrNoRows
+		}
+		v.Live, v.Duration = false, duration
+		return nil, nil
+	},
+	"MarkDeleted": func(st *state, args json.RawMessage, now time.Time) (any, error) {
+		var videoId string
+		if err := decodeArgs(args, &videoId); err != nil {
+			return nil, err
+		}
+		v, ok := st.Videos[videoId]
+		if !ok || v.DeletedAt != nil {
+			return nil, sql.ErrNoRows
+		}
+		v.DeletedAt = &now
+		return nil, nil
+	},
+	"Delete": func(st *state, args json.RawMessage, now time.Time) (any, error) {
+		var videoId string
+		if err := decodeArgs(args, &videoId); err != nil {
+			return nil, err
+		}
+		if v, ok := st.Videos[videoId]; ok && v.DeletedAt != nil {
+			delete(st.Videos, videoId)
+		}
+		return nil, nil
+	},
+	"CreateJob": func(st *state, args json.RawMessage, now time.Time) (any, error) {
+		var job web.TranscodeJob
+		if err := decodeArgs(args, &job); err != nil {
+			return nil, err
+		}
+		return nil, st.createJob(job)
+	},
+	"CreateUniqueJob": func(st *state, args json.RawMessage, now time.Time) (any, error) {
+		var job web.TranscodeJob
+		if err := decodeArgs(args, &job); err != nil {
+			return nil, err
+		}
+		// The newest of the owner's uploads of the file, picked the same way
+		// on every node
+		var existing *web.TranscodeJob
+		for _, j := range st.Jobs {
+			if j.SHA256 == "" || j.OwnerId != job.OwnerId || j.SHA256 != job.SHA256 {
+				continue
+			}
+			v, ok := st.Videos[j.VideoId]
+			pending := j.Status == web.JobQueued || j.Status == web.JobRunning
+			if !pending && (!ok || v.DeletedAt != nil || v.SHA256 != job.SHA256) {
+				continue
+			}
+			if existing == nil || j.CreatedAt.After(existing.CreatedAt) || j.CreatedAt.Equal(existing.CreatedAt) && j.Id > existing.Id {
+				existing = &j
+			}
+		}
+		if existing != nil {
+			return existing, nil
+		}
+		return nil, st.createJob(job)
+	},
+	"UpdateJob": func(st *state, args json.RawMessage, now time.Time) (any, error) {
+		var jobId, errMsg string
+		var status web.JobStatus
+		if err := decodeArgs(args, &jobId, &status, &errMsg); err != nil {
+			return nil, err
+		}
+		job, ok := st.Jobs[jobId]
+		if !ok {
+			return nil, sql.ErrNoRows
+		}
+		job.Status, job.Error, job.UpdatedAt = status, errMsg, now
+		st.Jobs[jobId] = job
+		return nil, nil
+	},
+	"CreateUser": func(st *state, args json.RawMessage, now time.Time) (any, error) {
+		var user web.User
+		var adminIfFirst bool
+		if err := decodeArgs(args, &user, &adminIfFirst); err != nil {
+			return nil, err
+		}
+		name := strings.ToLower(user.Username)
+		if _, ok := st.usernames[name]; ok {
+			return nil, web.ErrUsernameTaken
+		}
+		user.IsAdmin = user.IsAdmin || adminIfFirst && len(st.Users) == 0
+		st.Users[user.Id] = user
+		st.usernames[name] = user.Id
+		return user, nil
+	},
+	"SetAdmin": func(st *state, args json.RawMessage, now time.Time) (any, error) {
+		var username string
+		if err := decodeArgs(args, &username); err != nil {
+			return nil, err
+		}
+		id, ok := st.usernames[strings.ToLower(username)]
+		if !ok {
+			return nil, sql.ErrNoRows
+		}
+		user := st.Users[id]
+		user.IsAdmin = true
+		st.Users[id] = user
+		return nil, nil
+	},
+	"CreateSession": func(st *state, args json.RawMessage, now time.Time) (any, error) {
+		var session web.Session
+		if err := decodeArgs(args, &session); err != nil {
+			return nil, err
+		}
+		for hash, s := range st.Sessions {
+			if !s.ExpiresAt.After(now) {
+				delete(st.Sessions, hash)
+			}
+		}
+		st.Sessions[session.TokenHash] = session
+		return nil, nil
+	},
+	"DeleteSession": func(st *state, args json.RawMessage, now time.Time) (any, error) {
+		var tokenHash string
+		if err := decodeArgs(args, &tokenHash); err != nil {
+			return nil, err
+		}
+		delete(st.Sessions, tokenHash)
+		return nil, nil
+	},
+}
+
+// Reserves the job's video ID, see CreateJob
+func (st *state) createJob(job web.TranscodeJob) error {
+	if _, ok := st.Videos[job.VideoId]; ok {
+		return web.ErrVideoIDTaken
+	}
+	for _, j := range st.Jobs {
+		if j.VideoId == job.VideoId {
+			return web.ErrVideoIDTaken
+		}
+	}
+	st.Jobs[job.Id] = job
+	return nil
+}
+
+// Requires s.mu held for writing
+func (s *Store) applyOp(cmd command) (any, error) {
+	apply, ok := writes[cmd.Op]
+	if !ok {
+		return nil, fmt.Errorf("unknown op %q", cmd.Op)
+	}
+	return apply(&s.state, cmd.Args, cmd.Time)
+}
+
+// The ops that only read, run with s.mu held for reading
+var reads = map[string]func(st *state, args json.RawMessage) (any, error){
+	"Read": func(st *state, args json.RawMessage) (any, error) {
+		var videoId string
+		if err := decodeArgs(args, &videoId); err != nil {
+			return nil, err
+		}
+		v, ok := st.Videos[videoId]
+		if !ok || v.DeletedAt != nil {
+			return nil, sql.ErrNoRows
+		}
+		return v.VideoMetadata, nil
+	},
+	"List": func(st *state, args json.RawMessage) (any, error) {
+		var opts web.ListOptions
+		if err := decodeArgs(args, &opts); err != nil {
+			return nil, err
+		}
+		return st.list(opts)
+	},
+	"ListDeleted": func(st *state, args json.RawMessage) (any, error) {
+		var deleted []*video
+		for _, v := range st.Videos {
+			if v.DeletedAt != nil {
+				deleted = append(deleted, v)
+			}
+		}
+		slices.SortFunc(deleted, func(a, b *video) int { return a.DeletedAt.Compare(*b.DeletedAt) })
+		ids := []string{}
+		for _, v := range deleted {
+			ids = append(ids, v.Id)
+		}
+		return ids, nil
+	},
+	"ReadJob": func(st *state, args json.RawMessage) (any, error) {
+		var jobId string
+		if err := decodeArgs(args, &jobId); err != nil {
+			return nil, err
+		}
+		job, ok := st.Jobs[jobId]
+		if !ok {
+			return nil, sql.ErrNoRows
+		}
+		return job, nil
+	},
+	"ReadJobByVideo": func(st *state, args json.RawMessage) (any, error) {
+		var videoId string
+		if err := decodeArgs(args, &videoId); err != nil {
+			return nil, err
+		}
+		var latest *web.TranscodeJob
+		for _, j := range st.Jobs {
+			if j.VideoId == videoId && (latest == nil || j.CreatedAt.After(latest.CreatedAt)) {
+				latest = &j
+			}
+		}
+		if latest == nil {
+			return nil, sql.ErrNoRows
+		}
+		return latest, nil
+	},
+	"ListJobs": func(st *state, args json.RawMessage) (any, error) {
+		var statuses []web.JobStatus
+		if err := decodeArgs(args, &statuses); err != nil {
+			return nil, err
+		}
+		jobs := []web.TranscodeJob{}
+		for _, j := range st.Jobs {
+			if len(statuses) == 0 || slices.Contains(statuses, j.Status) {
+				jobs = append(jobs, j)
+			}
+		}
+		slices.SortFunc(jobs, func(a, b web.TranscodeJob) int {
+			return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.Id, b.Id))
+		})
+		return jobs, nil
+	},
+	"ReadUser": func(st *state, args json.RawMessage) (any, error) {
+		var userId string
+		if err := decodeArgs(args, &userId); err != nil {
+			return nil, err
+		}
+		user, ok := st.Users[userId]
+		if !ok {
+			return nil, sql.ErrNoRows
+		}
+		return user, nil
+	},
+	"ReadUserByName": func(st *state, args json.RawMessage) (any, error) {
+		var username string
+		if err := decodeArgs(args, &username); err != nil {
+			return nil, err
+		}
+		id, ok := st.usernames[strings.ToLower(username)]
+		if !ok {
+			return nil, sql.ErrNoRows
+		}
+		return st.Users[id], nil
+	},
+	"ReadSession": func(st *state, args json.RawMessage) (any, error) {
+		var tokenHash string
+		if err := decodeArgs(args, &tokenHash); err != nil {
+			return nil, err
+		}
+		session, ok := st.Sessions[tokenHash]
+		if !ok || !session.ExpiresAt.After(time.Now()) {
+			return nil, sql.ErrNoRows
+		}
+		return session, nil
+	},
+}
+
+// Query answers a read op from this node's state, returning the JSON encoded
+// result
+func (s *Store) Query(op string, args json.RawMessage) (json.RawMessage, error) {
+	read, ok := reads[op]
+	if !ok {
+		return nil, fmt.Errorf("unknown op %q", op)
+	}
+	s.mu.RLock()
+	value, err := read(&s.state, args)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// Orders videos newest first, like the SQLite listing
+func newestFirst(a, b *video) int {
+	return cmp.Or(b.UploadedAt.Compare(a.UploadedAt), strings.Compare(b.Id, a.Id))
+}
+
+// Cursors hold the sort key of a page's first or last video
+func encodeCursor(v *video) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(v.UploadedAt.UTC().Format(time.RFC3339Nano) + "\x00" + v.Id))
+}
+
+func decodeCursor(cursor string) (*video, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, web.ErrInvalidCursor
+	}
+	uploadedAt, id, ok := strings.Cut(string(raw), "\x00")
+	if !ok {
+		return nil, web.ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, uploadedAt)
+	if err != nil {
+		return nil, web.ErrInvalidCursor
+	}
+	return &video{VideoMetadata: web.VideoMetadata{Id: id, UploadedAt: t}}, nil
+}
+
+// Returns one page of the listing. Videos are filtered and sorted on every
+// call, which is fine for the few thousand videos a deployment like this has.
+func (st *state) list(opts web.ListOptions) (*web.VideoPage, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = web.DefaultPageSize
+	}
+	var at *video
+	if opts.Cursor != "" {
+		var err error
+		if at, err = decodeCursor(opts.Cursor); err != nil {
+			return nil, err
+		}
+	}
+	query := strings.ToLower(opts.Query)
+
+	var matches []*video
+	for _, v := range st.Videos {
+		if v.DeletedAt != nil {
+			continue
+		}
+		if !opts.AllVideos && v.Visibility != web.Public && (opts.ViewerId == "" || v.OwnerId != opts.ViewerId) {
+			continue
+		}
+		if query != "" && !strings.HasPrefix(strings.ToLower(v.Title), query) && !strings.HasPrefix(strings.ToLower(v.Id), query) {
+			continue
+		}
+		// Keeps what comes after the cursor, or before it going backward
+		if at != nil {
+			if c := newestFirst(v, at); c == 0 || (c < 0) != opts.Before {
+				continue
+			}
+		}
+		matches = append(matches, v)
+	}
+	slices.SortFunc(matches, newestFirst)
+
+	// Going backward, the page is the end of what comes before the cursor
+	more := len(matches) > opts.Limit
+	if opts.Before {
+		matches = matches[max(0, len(matches)-opts.Limit):]
+	} else {
+		matches = matches[:min(len(matches), opts.Limit)]
+	}
+
+	page := &web.VideoPage{Videos: []web.VideoMetadata{}}
+	for _, v := range matches {
+		page.Videos = append(page.Videos, v.VideoMetadata)
+	}
+	if len(matches) == 0 {
+		return page, nil
+	}
+	hasNext, hasPrev := more, opts.Cursor != ""
+	if opts.Before {
+		hasNext, hasPrev = opts.Cursor != "", more
+	}
+	if hasNext {
+		page.NextCursor = encodeCursor(matches[len(matches)-1])
+	}
+	if hasPrev {
+		page.PrevCursor = encodeCursor(matches[0])
+	}
+	return page, nil
+}
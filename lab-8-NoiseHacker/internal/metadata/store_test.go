@@ -0,0 +1,170 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"tritontube/internal/raft"
+	"tritontube/internal/web"
+)
+
+func createUserCommand(t *testing.T, requestId string, user web.User) []byte {
+	t.Helper()
+	args, err := json.Marshal([]any{user, false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(command{RequestId: requestId, Op: "CreateUser", Args: args, Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func decodeResult(t *testing.T, data []byte) result {
+	t.Helper()
+	var res result
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestApplyAnswersRetriedRequestWithItsResult(t *testing.T) {
+	s := NewStore()
+	alice := createUserCommand(t, "r1", web.User{Id: "u1", Username: "alice"})
+
+	if res := decodeResult(t, s.Apply(alice)); res.Error != "" {
+		t.Fatalf("first CreateUser failed: %s", res.Error)
+	}
+	// Applied again, the user would already exist
+	if res := decodeResult(t, s.Apply(alice)); res.Error != "" {
+		t.Fatalf("retried CreateUser was applied again: %s", res.Error)
+	}
+	other := createUserCommand(t, "r2", web.User{Id: "u2", Username: "Alice"})
+	if res := decodeResult(t, s.Apply(other)); errorFromText(res.Error) != web.ErrUsernameTaken {
+		t.Fatalf("CreateUser with a new request ID returned %q, want %q", res.Error, web.ErrUsernameTaken)
+	}
+
+	// The remembered results survive a snapshot
+	snap, err := s.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewStore()
+	if err := restored.Restore(snap); err != nil {
+		t.Fatal(err)
+	}
+	if res := decodeResult(t, restored.Apply(alice)); res.Error != "" {
+		t.Fatalf("retried CreateUser after a restore was applied again: %s", res.Error)
+	}
+	if len(restored.state.Users) != 1 {
+		t.Fatalf("restored store has %d users, want 1", len(restored.state.Users))
+	}
+}
+
+func TestApplyForgetsOldestRequests(t *testing.T) {
+	s := NewStore()
+	first := createUserCommand(t, "r0", web.User{Id: "u0", Username: "user0"})
+	s.Apply(first)
+	for i := 1; i <= maxRememberedRequests; i++ {
+		s.Apply(createUserCommand(t, fmt.Sprintf("r%d", i), web.User{Id: "x", Username: "user0"}))
+	}
+	if _, ok := s.state.requests["r0"]; ok {
+		t.Fatal("oldest request still remembered")
+	}
+	if len(s.state.Requests) != maxRememberedRequests || len(s.state.requests) != maxRememberedRequests {
+		t.Fatalf("remembering %d/%d requests, want %d", len(s.state.Requests), len(s.state.requests), maxRememberedRequests)
+	}
+}
+
+func TestCreateUniqueJobReturnsOwnersUpload(t *testing.T) {
+	s := NewStore()
+	apply := func(requestId string, job web.TranscodeJob) result {
+		t.Helper()
+		args, err := json.Marshal([]any{job})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(command{RequestId: requestId, Op: "CreateUniqueJob", Args: args, Time: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decodeResult(t, s.Apply(data))
+	}
+	first := web.TranscodeJob{Id: "j1", VideoId: "v1", OwnerId: "u1", SHA256: "abc", Status: web.JobQueued}
+	if res := apply("r1", first); res.Error != "" || res.Value != nil {
+		t.Fatalf("first upload = %s %s, want it created", res.Value, res.Error)
+	}
+
+	res := apply("r2", web.TranscodeJob{Id: "j2", VideoId: "v2", OwnerId: "u1", SHA256: "abc", Status: web.JobQueued})
+	var existing web.TranscodeJob
+	if err := json.Unmarshal(res.Value, &existing); err != nil || existing.Id != "j1" {
+		t.Fatalf("second upload of the file = %s %s, want job j1", res.Value, res.Error)
+	}
+	if _, ok := s.state.Jobs["j2"]; ok {
+		t.Fatal("duplicate job was created")
+	}
+	// Someone else's upload of the same file is not a duplicate
+	if res := apply("r3", web.TranscodeJob{Id: "j3", VideoId: "v3", OwnerId: "u2", SHA256: "abc", Status: web.JobQueued}); res.Value != nil {
+		t.Fatalf("another owner's upload = %s, want it created", res.Value)
+	}
+}
+
+// A retry through Raft, as a client does after a leader change, is applied
+// once on every node
+func TestRetriedProposalAppliedOnce(t *testing.T) {
+	network := raft.NewMemNetwork()
+	ids := []string{"n1", "n2", "n3"}
+	stores := make(map[string]*Store)
+	var nodes []*raft.Node
+	for _, id := range ids {
+		stores[id] = NewStore()
+		node := raft.NewNode(id, ids, raft.NewMemoryStorage(), network.Transport(id), stores[id])
+		node.ElectionTimeout = 50 * time.Millisecond
+		node.HeartbeatInterval = 10 * time.Millisecond
+		network.Add(node)
+		nodes = append(nodes, node)
+	}
+	for _, node := range nodes {
+		if err := node.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer node.Stop()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	alice := createUserCommand(t, "r1", web.User{Id: "u1", Username: "alice"})
+	var first, retry []byte
+	for {
+		var leader *raft.Node
+		for _, node := range nodes {
+			if node.Status().State == raft.Leader {
+				leader = node
+			}
+		}
+		if leader != nil {
+			var err error
+			if first, err = leader.Propose(ctx, alice); err == nil {
+				if retry, err = leader.Propose(ctx, alice); err != nil {
+					t.Fatal(err)
+				}
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			t.Fatal("no leader accepted the proposal")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if string(first) != string(retry) {
+		t.Fatalf("retry returned %s, want the first result %s", retry, first)
+	}
+	if res := decodeResult(t, retry); res.Error != "" {
+		t.Fatalf("retried CreateUser was applied again: %s", res.Error)
+	}
+}
@@ -236,6 +236,7 @@ func (*ListNodesRequest) Descriptor() ([]byte, []int) {
 type ListNodesResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Nodes         []string               `protobuf:"bytes,1,rep,name=nodes,proto3" json:"nodes,omitempty"`
+	NodeStatuses  []*NodeStatus          `protobuf:"bytes,2,rep,name=node_statuses,json=nodeStatuses,proto3" json:"node_statuses,omitempty"` // same order as nodes
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -277,6 +278,997 @@ func (x *ListNodesResponse) GetNodes() []string {
 	return nil
 }
 
+func (x *ListNodesResponse) GetNodeStatuses() []*NodeStatus {
+	if x != nil {
+		return x.NodeStatuses
+	}
+	return nil
+}
+
+type NodeStatus struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	Address             string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	Health              string                 `protobuf:"bytes,2,opt,name=health,proto3" json:"health,omitempty"`                      // "unknown", "healthy", "suspect" or "down"
+	LastSeen            int64                  `protobuf:"varint,3,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"` // unix seconds of the last successful health check, 0 if never
+	ConsecutiveFailures int32                  `protobuf:"varint,4,opt,name=consecutive_failures,json=consecutiveFailures,proto3" json:"consecutive_failures,omitempty"`
+	CapacityBytes       int64                  `protobuf:"varint,5,opt,name=capacity_bytes,json=capacityBytes,proto3" json:"capacity_bytes,omitempty"` // from the node's Stats, 0 if it could not be asked
+	UsedBytes           int64                  `protobuf:"varint,6,opt,name=used_bytes,json=usedBytes,proto3" json:"used_bytes,omitempty"`
+	FileCount           int64                  `protobuf:"varint,7,opt,name=file_count,json=fileCount,proto3" json:"file_count,omitempty"`
+	Vnodes              int32                  `protobuf:"varint,8,opt,name=vnodes,proto3" json:"vnodes,omitempty"`                          // points the node has on the hash ring
+	StatsError          string                 `protobuf:"bytes,9,opt,name=stats_error,json=statsError,proto3" json:"stats_error,omitempty"` // why Stats failed, empty if it worked
+	Draining            bool                   `protobuf:"varint,10,opt,name=draining,proto3" json:"draining,omitempty"`                     // left the ring with Drain, still holding keys that failed to move
+	RingShare           float64                `protobuf:"fixed64,11,opt,name=ring_share,json=ringShare,proto3" json:"ring_share,omitempty"` // fraction of the ring the node holds a copy of, the share of keys it should hold
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *NodeStatus) Reset() {
+	*x = NodeStatus{}
+	mi := &file_proto_admin_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NodeStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NodeStatus) ProtoMessage() {}
+
+func (x *NodeStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NodeStatus.ProtoReflect.Descriptor instead.
+func (*NodeStatus) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *NodeStatus) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *NodeStatus) GetHealth() string {
+	if x != nil {
+		return x.Health
+	}
+	return ""
+}
+
+func (x *NodeStatus) GetLastSeen() int64 {
+	if x != nil {
+		return x.LastSeen
+	}
+	return 0
+}
+
+func (x *NodeStatus) GetConsecutiveFailures() int32 {
+	if x != nil {
+		return x.ConsecutiveFailures
+	}
+	return 0
+}
+
+func (x *NodeStatus) GetCapacityBytes() int64 {
+	if x != nil {
+		return x.CapacityBytes
+	}
+	return 0
+}
+
+func (x *NodeStatus) GetUsedBytes() int64 {
+	if x != nil {
+		return x.UsedBytes
+	}
+	return 0
+}
+
+func (x *NodeStatus) GetFileCount() int64 {
+	if x != nil {
+		return x.FileCount
+	}
+	return 0
+}
+
+func (x *NodeStatus) GetVnodes() int32 {
+	if x != nil {
+		return x.Vnodes
+	}
+	return 0
+}
+
+func (x *NodeStatus) GetStatsError() string {
+	if x != nil {
+		return x.StatsError
+	}
+	return ""
+}
+
+func (x *NodeStatus) GetDraining() bool {
+	if x != nil {
+		return x.Draining
+	}
+	return false
+}
+
+func (x *NodeStatus) GetRingShare() float64 {
+	if x != nil {
+		return x.RingShare
+	}
+	return 0
+}
+
+type MigrationStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MigrationStatusRequest) Reset() {
+	*x = MigrationStatusRequest{}
+	mi := &file_proto_admin_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MigrationStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MigrationStatusRequest) ProtoMessage() {}
+
+func (x *MigrationStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MigrationStatusRequest.ProtoReflect.Descriptor instead.
+func (*MigrationStatusRequest) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{7}
+}
+
+type MigrationStatusResponse struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	InProgress        bool                   `protobuf:"varint,1,opt,name=in_progress,json=inProgress,proto3" json:"in_progress,omitempty"`
+	Operation         string                 `protobuf:"bytes,2,opt,name=operation,proto3" json:"operation,omitempty"` // "add" or "remove"
+	NodeAddress       string                 `protobuf:"bytes,3,opt,name=node_address,json=nodeAddress,proto3" json:"node_address,omitempty"`
+	TotalFileCount    int32                  `protobuf:"varint,4,opt,name=total_file_count,json=totalFileCount,proto3" json:"total_file_count,omitempty"`
+	MigratedFileCount int32                  `protobuf:"varint,5,opt,name=migrated_file_count,json=migratedFileCount,proto3" json:"migrated_file_count,omitempty"`
+	FailedFileCount   int32                  `protobuf:"varint,6,opt,name=failed_file_count,json=failedFileCount,proto3" json:"failed_file_count,omitempty"`
+	StartedAt         int64                  `protobuf:"varint,7,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`    // unix seconds, 0 if no migration has run
+	FinishedAt        int64                  `protobuf:"varint,8,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"` // unix seconds, 0 while in progress
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *MigrationStatusResponse) Reset() {
+	*x = MigrationStatusResponse{}
+	mi := &file_proto_admin_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MigrationStatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MigrationStatusResponse) ProtoMessage() {}
+
+func (x *MigrationStatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MigrationStatusResponse.ProtoReflect.Descriptor instead.
+func (*MigrationStatusResponse) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *MigrationStatusResponse) GetInProgress() bool {
+	if x != nil {
+		return x.InProgress
+	}
+	return false
+}
+
+func (x *MigrationStatusResponse) GetOperation() string {
+	if x != nil {
+		return x.Operation
+	}
+	return ""
+}
+
+func (x *MigrationStatusResponse) GetNodeAddress() string {
+	if x != nil {
+		return x.NodeAddress
+	}
+	return ""
+}
+
+func (x *MigrationStatusResponse) GetTotalFileCount() int32 {
+	if x != nil {
+		return x.TotalFileCount
+	}
+	return 0
+}
+
+func (x *MigrationStatusResponse) GetMigratedFileCount() int32 {
+	if x != nil {
+		return x.MigratedFileCount
+	}
+	return 0
+}
+
+func (x *MigrationStatusResponse) GetFailedFileCount() int32 {
+	if x != nil {
+		return x.FailedFileCount
+	}
+	return 0
+}
+
+func (x *MigrationStatusResponse) GetStartedAt() int64 {
+	if x != nil {
+		return x.StartedAt
+	}
+	return 0
+}
+
+func (x *MigrationStatusResponse) GetFinishedAt() int64 {
+	if x != nil {
+		return x.FinishedAt
+	}
+	return 0
+}
+
+type RepairRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RepairRequest) Reset() {
+	*x = RepairRequest{}
+	mi := &file_proto_admin_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RepairRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RepairRequest) ProtoMessage() {}
+
+func (x *RepairRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RepairRequest.ProtoReflect.Descriptor instead.
+func (*RepairRequest) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{9}
+}
+
+type RepairResponse struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	KeyCount          int64                  `protobuf:"varint,1,opt,name=key_count,json=keyCount,proto3" json:"key_count,omitempty"`                   // keys in the compared ranges, counted on the owner with the most
+	DivergentCount    int64                  `protobuf:"varint,2,opt,name=divergent_count,json=divergentCount,proto3" json:"divergent_count,omitempty"` // keys missing from an owner or differing between owners
+	RepairedCount     int64                  `protobuf:"varint,3,opt,name=repaired_count,json=repairedCount,proto3" json:"repaired_count,omitempty"`
+	FailedCount       int64                  `protobuf:"varint,4,opt,name=failed_count,json=failedCount,proto3" json:"failed_count,omitempty"`
+	ConflictCount     int64                  `protobuf:"varint,5,opt,name=conflict_count,json=conflictCount,proto3" json:"conflict_count,omitempty"`               // keys with no version held by more owners than any other, left alone
+	SkippedRangeCount int32                  `protobuf:"varint,6,opt,name=skipped_range_count,json=skippedRangeCount,proto3" json:"skipped_range_count,omitempty"` // ring ranges not compared because too few of their owners were up
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *RepairResponse) Reset() {
+	*x = RepairResponse{}
+	mi := &file_proto_admin_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RepairResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RepairResponse) ProtoMessage() {}
+
+func (x *RepairResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RepairResponse.ProtoReflect.Descriptor instead.
+func (*RepairResponse) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *RepairResponse) GetKeyCount() int64 {
+	if x != nil {
+		return x.KeyCount
+	}
+	return 0
+}
+
+func (x *RepairResponse) GetDivergentCount() int64 {
+	if x != nil {
+		return x.DivergentCount
+	}
+	return 0
+}
+
+func (x *RepairResponse) GetRepairedCount() int64 {
+	if x != nil {
+		return x.RepairedCount
+	}
+	return 0
+}
+
+func (x *RepairResponse) GetFailedCount() int64 {
+	if x != nil {
+		return x.FailedCount
+	}
+	return 0
+}
+
+func (x *RepairResponse) GetConflictCount() int64 {
+	if x != nil {
+		return x.ConflictCount
+	}
+	return 0
+}
+
+func (x *RepairResponse) GetSkippedRangeCount() int32 {
+	if x != nil {
+		return x.SkippedRangeCount
+	}
+	return 0
+}
+
+type DrainRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	NodeAddress   string                 `protobuf:"bytes,1,opt,name=node_address,json=nodeAddress,proto3" json:"node_address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DrainRequest) Reset() {
+	*x = DrainRequest{}
+	mi := &file_proto_admin_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DrainRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DrainRequest) ProtoMessage() {}
+
+func (x *DrainRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DrainRequest.ProtoReflect.Descriptor instead.
+func (*DrainRequest) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *DrainRequest) GetNodeAddress() string {
+	if x != nil {
+		return x.NodeAddress
+	}
+	return ""
+}
+
+type DrainResponse struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	MigratedFileCount int32                  `protobuf:"varint,1,opt,name=migrated_file_count,json=migratedFileCount,proto3" json:"migrated_file_count,omitempty"`
+	FailedFileCount   int32                  `protobuf:"varint,2,opt,name=failed_file_count,json=failedFileCount,proto3" json:"failed_file_count,omitempty"` // keys that could not be copied and stay on the node
+	Removed           bool                   `protobuf:"varint,3,opt,name=removed,proto3" json:"removed,omitempty"`                                          // the node held nothing any more and was forgotten
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *DrainResponse) Reset() {
+	*x = DrainResponse{}
+	mi := &file_proto_admin_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DrainResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DrainResponse) ProtoMessage() {}
+
+func (x *DrainResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DrainResponse.ProtoReflect.Descriptor instead.
+func (*DrainResponse) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *DrainResponse) GetMigratedFileCount() int32 {
+	if x != nil {
+		return x.MigratedFileCount
+	}
+	return 0
+}
+
+func (x *DrainResponse) GetFailedFileCount() int32 {
+	if x != nil {
+		return x.FailedFileCount
+	}
+	return 0
+}
+
+func (x *DrainResponse) GetRemoved() bool {
+	if x != nil {
+		return x.Removed
+	}
+	return false
+}
+
+type RebalanceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RebalanceRequest) Reset() {
+	*x = RebalanceRequest{}
+	mi := &file_proto_admin_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RebalanceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RebalanceRequest) ProtoMessage() {}
+
+func (x *RebalanceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RebalanceRequest.ProtoReflect.Descriptor instead.
+func (*RebalanceRequest) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{13}
+}
+
+type RebalanceResponse struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	MigratedFileCount int32                  `protobuf:"varint,1,opt,name=migrated_file_count,json=migratedFileCount,proto3" json:"migrated_file_count,omitempty"`
+	FailedFileCount   int32                  `protobuf:"varint,2,opt,name=failed_file_count,json=failedFileCount,proto3" json:"failed_file_count,omitempty"`
+	Reweighted        []*NodeWeight          `protobuf:"bytes,3,rep,name=reweighted,proto3" json:"reweighted,omitempty"` // nodes whose vnodes changed
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *RebalanceResponse) Reset() {
+	*x = RebalanceResponse{}
+	mi := &file_proto_admin_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RebalanceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RebalanceResponse) ProtoMessage() {}
+
+func (x *RebalanceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RebalanceResponse.ProtoReflect.Descriptor instead.
+func (*RebalanceResponse) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *RebalanceResponse) GetMigratedFileCount() int32 {
+	if x != nil {
+		return x.MigratedFileCount
+	}
+	return 0
+}
+
+func (x *RebalanceResponse) GetFailedFileCount() int32 {
+	if x != nil {
+		return x.FailedFileCount
+	}
+	return 0
+}
+
+func (x *RebalanceResponse) GetReweighted() []*NodeWeight {
+	if x != nil {
+		return x.Reweighted
+	}
+	return nil
+}
+
+type NodeWeight struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Address       string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	OldVnodes     int32                  `protobuf:"varint,2,opt,name=old_vnodes,json=oldVnodes,proto3" json:"old_vnodes,omitempty"`
+	NewVnodes     int32                  `protobuf:"varint,3,opt,name=new_vnodes,json=newVnodes,proto3" json:"new_vnodes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NodeWeight) Reset() {
+	*x = NodeWeight{}
+	mi := &file_proto_admin_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NodeWeight) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NodeWeight) ProtoMessage() {}
+
+func (x *NodeWeight) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NodeWeight.ProtoReflect.Descriptor instead.
+func (*NodeWeight) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *NodeWeight) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *NodeWeight) GetOldVnodes() int32 {
+	if x != nil {
+		return x.OldVnodes
+	}
+	return 0
+}
+
+func (x *NodeWeight) GetNewVnodes() int32 {
+	if x != nil {
+		return x.NewVnodes
+	}
+	return 0
+}
+
+type VerifyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	VideoIds      []string               `protobuf:"bytes,1,rep,name=video_ids,json=videoIds,proto3" json:"video_ids,omitempty"` // the videos to check, all of them if empty
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VerifyRequest) Reset() {
+	*x = VerifyRequest{}
+	mi := &file_proto_admin_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VerifyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyRequest) ProtoMessage() {}
+
+func (x *VerifyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyRequest.ProtoReflect.Descriptor instead.
+func (*VerifyRequest) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *VerifyRequest) GetVideoIds() []string {
+	if x != nil {
+		return x.VideoIds
+	}
+	return nil
+}
+
+type VerifyResponse struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	VideoCount       int32                  `protobuf:"varint,1,opt,name=video_count,json=videoCount,proto3" json:"video_count,omitempty"`
+	FileCount        int32                  `protobuf:"varint,2,opt,name=file_count,json=fileCount,proto3" json:"file_count,omitempty"`
+	Problems         []*VideoProblem        `protobuf:"bytes,3,rep,name=problems,proto3" json:"problems,omitempty"`                                         // videos with files missing, in ID order
+	UnreachableNodes []string               `protobuf:"bytes,4,rep,name=unreachable_nodes,json=unreachableNodes,proto3" json:"unreachable_nodes,omitempty"` // nodes that could not be listed and were not checked
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *VerifyResponse) Reset() {
+	*x = VerifyResponse{}
+	mi := &file_proto_admin_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VerifyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyResponse) ProtoMessage() {}
+
+func (x *VerifyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyResponse.ProtoReflect.Descriptor instead.
+func (*VerifyResponse) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *VerifyResponse) GetVideoCount() int32 {
+	if x != nil {
+		return x.VideoCount
+	}
+	return 0
+}
+
+func (x *VerifyResponse) GetFileCount() int32 {
+	if x != nil {
+		return x.FileCount
+	}
+	return 0
+}
+
+func (x *VerifyResponse) GetProblems() []*VideoProblem {
+	if x != nil {
+		return x.Problems
+	}
+	return nil
+}
+
+func (x *VerifyResponse) GetUnreachableNodes() []string {
+	if x != nil {
+		return x.UnreachableNodes
+	}
+	return nil
+}
+
+type VideoProblem struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	VideoId       string                 `protobuf:"bytes,1,opt,name=video_id,json=videoId,proto3" json:"video_id,omitempty"`
+	MissingFiles  []string               `protobuf:"bytes,2,rep,name=missing_files,json=missingFiles,proto3" json:"missing_files,omitempty"` // files referenced by the video that no owner holds
+	Misplaced     []*FilePlacement       `protobuf:"bytes,3,rep,name=misplaced,proto3" json:"misplaced,omitempty"`                           // files some of their owners lack
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VideoProblem) Reset() {
+	*x = VideoProblem{}
+	mi := &file_proto_admin_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VideoProblem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VideoProblem) ProtoMessage() {}
+
+func (x *VideoProblem) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VideoProblem.ProtoReflect.Descriptor instead.
+func (*VideoProblem) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *VideoProblem) GetVideoId() string {
+	if x != nil {
+		return x.VideoId
+	}
+	return ""
+}
+
+func (x *VideoProblem) GetMissingFiles() []string {
+	if x != nil {
+		return x.MissingFiles
+	}
+	return nil
+}
+
+func (x *VideoProblem) GetMisplaced() []*FilePlacement {
+	if x != nil {
+		return x.Misplaced
+	}
+	return nil
+}
+
+type FilePlacement struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Filename      string                 `protobuf:"bytes,1,opt,name=filename,proto3" json:"filename,omitempty"`
+	MissingFrom   []string               `protobuf:"bytes,2,rep,name=missing_from,json=missingFrom,proto3" json:"missing_from,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FilePlacement) Reset() {
+	*x = FilePlacement{}
+	mi := &file_proto_admin_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FilePlacement) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FilePlacement) ProtoMessage() {}
+
+func (x *FilePlacement) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FilePlacement.ProtoReflect.Descriptor instead.
+func (*FilePlacement) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *FilePlacement) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+func (x *FilePlacement) GetMissingFrom() []string {
+	if x != nil {
+		return x.MissingFrom
+	}
+	return nil
+}
+
+type ClusterStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClusterStatusRequest) Reset() {
+	*x = ClusterStatusRequest{}
+	mi := &file_proto_admin_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClusterStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClusterStatusRequest) ProtoMessage() {}
+
+func (x *ClusterStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClusterStatusRequest.ProtoReflect.Descriptor instead.
+func (*ClusterStatusRequest) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{20}
+}
+
+type ClusterStatusResponse struct {
+	state         protoimpl.MessageState   `protogen:"open.v1"`
+	Nodes         []*NodeStatus            `protobuf:"bytes,1,rep,name=nodes,proto3" json:"nodes,omitempty"`                              // ring nodes in ring order, then draining nodes
+	Replicas      int32                    `protobuf:"varint,2,opt,name=replicas,proto3" json:"replicas,omitempty"`                       // copies of each key, or shards with erasure coding
+	DataShards    int32                    `protobuf:"varint,3,opt,name=data_shards,json=dataShards,proto3" json:"data_shards,omitempty"` // 0 without erasure coding
+	ParityShards  int32                    `protobuf:"varint,4,opt,name=parity_shards,json=parityShards,proto3" json:"parity_shards,omitempty"`
+	VnodeSize     int64                    `protobuf:"varint,5,opt,name=vnode_size,json=vnodeSize,proto3" json:"vnode_size,omitempty"` // capacity per vnode, 0 if nodes are not weighted
+	Migration     *MigrationStatusResponse `protobuf:"bytes,6,opt,name=migration,proto3" json:"migration,omitempty"`                   // current or last migration, unset if none has run
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClusterStatusResponse) Reset() {
+	*x = ClusterStatusResponse{}
+	mi := &file_proto_admin_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClusterStatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClusterStatusResponse) ProtoMessage() {}
+
+func (x *ClusterStatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_admin_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClusterStatusResponse.ProtoReflect.Descriptor instead.
+func (*ClusterStatusResponse) Descriptor() ([]byte, []int) {
+	return file_proto_admin_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *ClusterStatusResponse) GetNodes() []*NodeStatus {
+	if x != nil {
+		return x.Nodes
+	}
+	return nil
+}
+
+func (x *ClusterStatusResponse) GetReplicas() int32 {
+	if x != nil {
+		return x.Replicas
+	}
+	return 0
+}
+
+func (x *ClusterStatusResponse) GetDataShards() int32 {
+	if x != nil {
+		return x.DataShards
+	}
+	return 0
+}
+
+func (x *ClusterStatusResponse) GetParityShards() int32 {
+	if x != nil {
+		return x.ParityShards
+	}
+	return 0
+}
+
+func (x *ClusterStatusResponse) GetVnodeSize() int64 {
+	if x != nil {
+		return x.VnodeSize
+	}
+	return 0
+}
+
+func (x *ClusterStatusResponse) GetMigration() *MigrationStatusResponse {
+	if x != nil {
+		return x.Migration
+	}
+	return nil
+}
+
 var File_proto_admin_proto protoreflect.FileDescriptor
 
 const file_proto_admin_proto_rawDesc = "" +
@@ -291,14 +1283,106 @@ const file_proto_admin_proto_rawDesc = "" +
 	"\fnode_address\x18\x01 \x01(\tR\vnodeAddress\"D\n" +
 	"\x12RemoveNodeResponse\x12.\n" +
 	"\x13migrated_file_count\x18\x01 \x01(\x05R\x11migratedFileCount\"\x12\n" +
-	"\x10ListNodesRequest\")\n" +
+	"\x10ListNodesRequest\"f\n" +
 	"\x11ListNodesResponse\x12\x14\n" +
-	"\x05nodes\x18\x01 \x03(\tR\x05nodes2\xf5\x01\n" +
+	"\x05nodes\x18\x01 \x03(\tR\x05nodes\x12;\n" +
+	"\rnode_statuses\x18\x02 \x03(\v2\x16.tritontube.NodeStatusR\fnodeStatuses\"\xe7\x02\n" +
+	"\n" +
+	"NodeStatus\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\x12\x16\n" +
+	"\x06health\x18\x02 \x01(\tR\x06health\x12\x1b\n" +
+	"\tlast_seen\x18\x03 \x01(\x03R\blastSeen\x121\n" +
+	"\x14consecutive_failures\x18\x04 \x01(\x05R\x13consecutiveFailures\x12%\n" +
+	"\x0ecapacity_bytes\x18\x05 \x01(\x03R\rcapacityBytes\x12\x1d\n" +
+	"\n" +
+	"used_bytes\x18\x06 \x01(\x03R\tusedBytes\x12\x1d\n" +
+	"\n" +
+	"file_count\x18\a \x01(\x03R\tfileCount\x12\x16\n" +
+	"\x06vnodes\x18\b \x01(\x05R\x06vnodes\x12\x1f\n" +
+	"\vstats_error\x18\t \x01(\tR\n" +
+	"statsError\x12\x1a\n" +
+	"\bdraining\x18\n" +
+	" \x01(\bR\bdraining\x12\x1d\n" +
+	"\n" +
+	"ring_share\x18\v \x01(\x01R\tringShare\"\x18\n" +
+	"\x16MigrationStatusRequest\"\xc1\x02\n" +
+	"\x17MigrationStatusResponse\x12\x1f\n" +
+	"\vin_progress\x18\x01 \x01(\bR\n" +
+	"inProgress\x12\x1c\n" +
+	"\toperation\x18\x02 \x01(\tR\toperation\x12!\n" +
+	"\fnode_address\x18\x03 \x01(\tR\vnodeAddress\x12(\n" +
+	"\x10total_file_count\x18\x04 \x01(\x05R\x0etotalFileCount\x12.\n" +
+	"\x13migrated_file_count\x18\x05 \x01(\x05R\x11migratedFileCount\x12*\n" +
+	"\x11failed_file_count\x18\x06 \x01(\x05R\x0ffailedFileCount\x12\x1d\n" +
+	"\n" +
+	"started_at\x18\a \x01(\x03R\tstartedAt\x12\x1f\n" +
+	"\vfinished_at\x18\b \x01(\x03R\n" +
+	"finishedAt\"\x0f\n" +
+	"\rRepairRequest\"\xf7\x01\n" +
+	"\x0eRepairResponse\x12\x1b\n" +
+	"\tkey_count\x18\x01 \x01(\x03R\bkeyCount\x12'\n" +
+	"\x0fdivergent_count\x18\x02 \x01(\x03R\x0edivergentCount\x12%\n" +
+	"\x0erepaired_count\x18\x03 \x01(\x03R\rrepairedCount\x12!\n" +
+	"\ffailed_count\x18\x04 \x01(\x03R\vfailedCount\x12%\n" +
+	"\x0econflict_count\x18\x05 \x01(\x03R\rconflictCount\x12.\n" +
+	"\x13skipped_range_count\x18\x06 \x01(\x05R\x11skippedRangeCount\"1\n" +
+	"\fDrainRequest\x12!\n" +
+	"\fnode_address\x18\x01 \x01(\tR\vnodeAddress\"\x85\x01\n" +
+	"\rDrainResponse\x12.\n" +
+	"\x13migrated_file_count\x18\x01 \x01(\x05R\x11migratedFileCount\x12*\n" +
+	"\x11failed_file_count\x18\x02 \x01(\x05R\x0ffailedFileCount\x12\x18\n" +
+	"\aremoved\x18\x03 \x01(\bR\aremoved\"\x12\n" +
+	"\x10RebalanceRequest\"\xa7\x01\n" +
+	"\x11RebalanceResponse\x12.\n" +
+	"\x13migrated_file_count\x18\x01 \x01(\x05R\x11migratedFileCount\x12*\n" +
+	"\x11failed_file_count\x18\x02 \x01(\x05R\x0ffailedFileCount\x126\n" +
+	"\n" +
+	"reweighted\x18\x03 \x03(\v2\x16.tritontube.NodeWeightR\n" +
+	"reweighted\"d\n" +
+	"\n" +
+	"NodeWeight\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\x12\x1d\n" +
+	"\n" +
+	"old_vnodes\x18\x02 \x01(\x05R\toldVnodes\x12\x1d\n" +
+	"\n" +
+	"new_vnodes\x18\x03 \x01(\x05R\tnewVnodes\",\n" +
+	"\rVerifyRequest\x12\x1b\n" +
+	"\tvideo_ids\x18\x01 \x03(\tR\bvideoIds\"\xb3\x01\n" +
+	"\x0eVerifyResponse\x12\x1f\n" +
+	"\vvideo_count\x18\x01 \x01(\x05R\n" +
+	"videoCount\x12\x1d\n" +
+	"\n" +
+	"file_count\x18\x02 \x01(\x05R\tfileCount\x124\n" +
+	"\bproblems\x18\x03 \x03(\v2\x18.tritontube.VideoProblemR\bproblems\x12+\n" +
+	"\x11unreachable_nodes\x18\x04 \x03(\tR\x10unreachableNodes\"\x87\x01\n" +
+	"\fVideoProblem\x12\x19\n" +
+	"\bvideo_id\x18\x01 \x01(\tR\avideoId\x12#\n" +
+	"\rmissing_files\x18\x02 \x03(\tR\fmissingFiles\x127\n" +
+	"\tmisplaced\x18\x03 \x03(\v2\x19.tritontube.FilePlacementR\tmisplaced\"N\n" +
+	"\rFilePlacement\x12\x1a\n" +
+	"\bfilename\x18\x01 \x01(\tR\bfilename\x12!\n" +
+	"\fmissing_from\x18\x02 \x03(\tR\vmissingFrom\"\x16\n" +
+	"\x14ClusterStatusRequest\"\x89\x02\n" +
+	"\x15ClusterStatusResponse\x12,\n" +
+	"\x05nodes\x18\x01 \x03(\v2\x16.tritontube.NodeStatusR\x05nodes\x12\x1a\n" +
+	"\breplicas\x18\x02 \x01(\x05R\breplicas\x12\x1f\n" +
+	"\vdata_shards\x18\x03 \x01(\x05R\n" +
+	"dataShards\x12#\n" +
+	"\rparity_shards\x18\x04 \x01(\x05R\fparityShards\x12\x1d\n" +
+	"\n" +
+	"vnode_size\x18\x05 \x01(\x03R\tvnodeSize\x12A\n" +
+	"\tmigration\x18\x06 \x01(\v2#.tritontube.MigrationStatusResponseR\tmigration2\xb1\x05\n" +
 	"\x18VideoContentAdminService\x12B\n" +
 	"\aAddNode\x12\x1a.tritontube.AddNodeRequest\x1a\x1b.tritontube.AddNodeResponse\x12K\n" +
 	"\n" +
 	"RemoveNode\x12\x1d.tritontube.RemoveNodeRequest\x1a\x1e.tritontube.RemoveNodeResponse\x12H\n" +
-	"\tListNodes\x12\x1c.tritontube.ListNodesRequest\x1a\x1d.tritontube.ListNodesResponseB\x16Z\x14internal/proto;protob\x06proto3"
+	"\tListNodes\x12\x1c.tritontube.ListNodesRequest\x1a\x1d.tritontube.ListNodesResponse\x12Z\n" +
+	"\x0fMigrationStatus\x12\".tritontube.MigrationStatusRequest\x1a#.tritontube.MigrationStatusResponse\x12?\n" +
+	"\x06Repair\x12\x19.tritontube.RepairRequest\x1a\x1a.tritontube.RepairResponse\x12<\n" +
+	"\x05Drain\x12\x18.tritontube.DrainRequest\x1a\x19.tritontube.DrainResponse\x12H\n" +
+	"\tRebalance\x12\x1c.tritontube.RebalanceRequest\x1a\x1d.tritontube.RebalanceResponse\x12?\n" +
+	"\x06Verify\x12\x19.tritontube.VerifyRequest\x1a\x1a.tritontube.VerifyResponse\x12T\n" +
+	"\rClusterStatus\x12 .tritontube.ClusterStatusRequest\x1a!.tritontube.ClusterStatusResponseB\x16Z\x14internal/proto;protob\x06proto3"
 
 var (
 	file_proto_admin_proto_rawDescOnce sync.Once
@@ -312,27 +1396,61 @@ func file_proto_admin_proto_rawDescGZIP() []byte {
 	return file_proto_admin_proto_rawDescData
 }
 
-var file_proto_admin_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_proto_admin_proto_msgTypes = make([]protoimpl.MessageInfo, 22)
 var file_proto_admin_proto_goTypes = []any{
-	(*AddNodeRequest)(nil),     // 0: tritontube.AddNodeRequest
-	(*AddNodeResponse)(nil),    // 1: tritontube.AddNodeResponse
-	(*RemoveNodeRequest)(nil),  // 2: tritontube.RemoveNodeRequest
-	(*RemoveNodeResponse)(nil), // 3: tritontube.RemoveNodeResponse
-	(*ListNodesRequest)(nil),   // 4: tritontube.ListNodesRequest
-	(*ListNodesResponse)(nil),  // 5: tritontube.ListNodesResponse
+	(*AddNodeRequest)(nil),          // 0: tritontube.AddNodeRequest
+	(*AddNodeResponse)(nil),         // 1: tritontube.AddNodeResponse
+	(*RemoveNodeRequest)(nil),       // 2: tritontube.RemoveNodeRequest
+	(*RemoveNodeResponse)(nil),      // 3: tritontube.RemoveNodeResponse
+	(*ListNodesRequest)(nil),        // 4: tritontube.ListNodesRequest
+	(*ListNodesResponse)(nil),       // 5: tritontube.ListNodesResponse
+	(*NodeStatus)(nil),              // 6: tritontube.NodeStatus
+	(*MigrationStatusRequest)(nil),  // 7: tritontube.MigrationStatusRequest
+	(*MigrationStatusResponse)(nil), // 8: tritontube.MigrationStatusResponse
+	(*RepairRequest)(nil),           // 9: tritontube.RepairRequest
+	(*RepairResponse)(nil),          // 10: tritontube.RepairResponse
+	(*DrainRequest)(nil),            // 11: tritontube.DrainRequest
+	(*DrainResponse)(nil),           // 12: tritontube.DrainResponse
+	(*RebalanceRequest)(nil),        // 13: tritontube.RebalanceRequest
+	(*RebalanceResponse)(nil),       // 14: tritontube.RebalanceResponse
+	(*NodeWeight)(nil),              // 15: tritontube.NodeWeight
+	(*VerifyRequest)(nil),           // 16: tritontube.VerifyRequest
+	(*VerifyResponse)(nil),          // 17: tritontube.VerifyResponse
+	(*VideoProblem)(nil),            // 18: tritontube.VideoProblem
+	(*FilePlacement)(nil),           // 19: tritontube.FilePlacement
+	(*ClusterStatusRequest)(nil),    // 20: tritontube.ClusterStatusRequest
+	(*ClusterStatusResponse)(nil),   // 21: tritontube.ClusterStatusResponse
 }
 var file_proto_admin_proto_depIdxs = []int32{
-	0, // 0: tritontube.VideoContentAdminService.AddNode:input_type -> tritontube.AddNodeRequest
-	2, // 1: tritontube.VideoContentAdminService.RemoveNode:input_type -> tritontube.RemoveNodeRequest
-	4, // 2: tritontube.VideoContentAdminService.ListNodes:input_type -> tritontube.ListNodesRequest
-	1, // 3: tritontube.VideoContentAdminService.AddNode:output_type -> tritontube.AddNodeResponse
-	3, // 4: tritontube.VideoContentAdminService.RemoveNode:output_type -> tritontube.RemoveNodeResponse
-	5, // 5: tritontube.VideoContentAdminService.ListNodes:output_type -> tritontube.ListNodesResponse
-	3, // [3:6] is the sub-list for method output_type
-	0, // [0:3] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	6,  // 0: tritontube.ListNodesResponse.node_statuses:type_name -> tritontube.NodeStatus
+	15, // 1: tritontube.RebalanceResponse.reweighted:type_name -> tritontube.NodeWeight
+	18, // 2: tritontube.VerifyResponse.problems:type_name -> tritontube.VideoProblem
+	19, // 3: tritontube.VideoProblem.misplaced:type_name -> tritontube.FilePlacement
+	6,  // 4: tritontube.ClusterStatusResponse.nodes:type_name -> tritontube.NodeStatus
+	8,  // 5: tritontube.ClusterStatusResponse.migration:type_name -> tritontube.MigrationStatusResponse
+	0,  // 6: tritontube.VideoContentAdminService.AddNode:input_type -> tritontube.AddNodeRequest
+	2,  // 7: tritontube.VideoContentAdminService.RemoveNode:input_type -> tritontube.RemoveNodeRequest
+	4,  // 8: tritontube.VideoContentAdminService.ListNodes:input_type -> tritontube.ListNodesRequest
+	7,  // 9: tritontube.VideoContentAdminService.MigrationStatus:input_type -> tritontube.MigrationStatusRequest
+	9,  // 10: tritontube.VideoContentAdminService.Repair:input_type -> tritontube.RepairRequest
+	11, // 11: tritontube.VideoContentAdminService.Drain:input_type -> tritontube.DrainRequest
+	13, // 12: tritontube.VideoContentAdminService.Rebalance:input_type -> tritontube.RebalanceRequest
+	16, // 13: tritontube.VideoContentAdminService.Verify:input_type -> tritontube.VerifyRequest
+	20, // 14: tritontube.VideoContentAdminService.ClusterStatus:input_type -> tritontube.ClusterStatusRequest
+	1,  // 15: tritontube.VideoContentAdminService.AddNode:output_type -> tritontube.AddNodeResponse
+	3,  // 16: tritontube.VideoContentAdminService.RemoveNode:output_type -> tritontube.RemoveNodeResponse
+	5,  // 17: tritontube.VideoContentAdminService.ListNodes:output_type -> tritontube.ListNodesResponse
+	8,  // 18: tritontube.VideoContentAdminService.MigrationStatus:output_type -> tritontube.MigrationStatusResponse
+	10, // 19: tritontube.VideoContentAdminService.Repair:output_type -> tritontube.RepairResponse
+	12, // 20: tritontube.VideoContentAdminService.Drain:output_type -> tritontube.DrainResponse
+	14, // 21: tritontube.VideoContentAdminService.Rebalance:output_type -> tritontube.RebalanceResponse
+	17, // 22: tritontube.VideoContentAdminService.Verify:output_type -> tritontube.VerifyResponse
+	21, // 23: tritontube.VideoContentAdminService.ClusterStatus:output_type -> tritontube.ClusterStatusResponse
+	15, // [15:24] is the sub-list for method output_type
+	6,  // [6:15] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
 }
 
 func init() { file_proto_admin_proto_init() }
@@ -346,7 +1464,7 @@ func file_proto_admin_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_admin_proto_rawDesc), len(file_proto_admin_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   22,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
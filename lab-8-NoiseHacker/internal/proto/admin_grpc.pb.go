@@ -19,9 +19,15 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	VideoContentAdminService_AddNode_FullMethodName    = "/tritontube.VideoContentAdminService/AddNode"
-	VideoContentAdminService_RemoveNode_FullMethodName = "/tritontube.VideoContentAdminService/RemoveNode"
-	VideoContentAdminService_ListNodes_FullMethodName  = "/tritontube.VideoContentAdminService/ListNodes"
+	VideoContentAdminService_AddNode_FullMethodName         = "/tritontube.VideoContentAdminService/AddNode"
+	VideoContentAdminService_RemoveNode_FullMethodName      = "/tritontube.VideoContentAdminService/RemoveNode"
+	VideoContentAdminService_ListNodes_FullMethodName       = "/tritontube.VideoContentAdminService/ListNodes"
+	VideoContentAdminService_MigrationStatus_FullMethodName = "/tritontube.VideoContentAdminService/MigrationStatus"
+	VideoContentAdminService_Repair_FullMethodName          = "/tritontube.VideoContentAdminService/Repair"
+	VideoContentAdminService_Drain_FullMethodName           = "/tritontube.VideoContentAdminService/Drain"
+	VideoContentAdminService_Rebalance_FullMethodName       = "/tritontube.VideoContentAdminService/Rebalance"
+	VideoContentAdminService_Verify_FullMethodName          = "/tritontube.VideoContentAdminService/Verify"
+	VideoContentAdminService_ClusterStatus_FullMethodName   = "/tritontube.VideoContentAdminService/ClusterStatus"
 )
 
 // VideoContentAdminServiceClient is the client API for VideoContentAdminService service.
@@ -31,6 +37,24 @@ type VideoContentAdminServiceClient interface {
 	AddNode(ctx context.Context, in *AddNodeRequest, opts ...grpc.CallOption) (*AddNodeResponse, error)
 	RemoveNode(ctx context.Context, in *RemoveNodeRequest, opts ...grpc.CallOption) (*RemoveNodeResponse, error)
 	ListNodes(ctx context.Context, in *ListNodesRequest, opts ...grpc.CallOption) (*ListNodesResponse, error)
+	MigrationStatus(ctx context.Context, in *MigrationStatusRequest, opts ...grpc.CallOption) (*MigrationStatusResponse, error)
+	// Compares the storage nodes that own each part of the ring and repairs
+	// keys that are missing from some of them or differ between them
+	Repair(ctx context.Context, in *RepairRequest, opts ...grpc.CallOption) (*RepairResponse, error)
+	// Takes a node out of the ring so no new keys are placed on it, then
+	// migrates its keys to their new owners. The node is forgotten once all of
+	// them are copied, else it stays draining and Drain can be run again.
+	Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainResponse, error)
+	// Reweights the nodes by the capacity they report now and moves keys to
+	// their owners on the reweighted ring, including keys found on nodes that
+	// do not own them
+	Rebalance(ctx context.Context, in *RebalanceRequest, opts ...grpc.CallOption) (*RebalanceResponse, error)
+	// Checks that every file of every video in the metadata service is on all
+	// of its owners on the ring
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error)
+	// Reports the ring's configuration, every node with its usage and health,
+	// and the current or last migration
+	ClusterStatus(ctx context.Context, in *ClusterStatusRequest, opts ...grpc.CallOption) (*ClusterStatusResponse, error)
 }
 
 type videoContentAdminServiceClient struct {
@@ -71,6 +95,66 @@ func (c *videoContentAdminServiceClient) ListNodes(ctx context.Context, in *List
 	return out, nil
 }
 
+func (c *videoContentAdminServiceClient) MigrationStatus(ctx context.Context, in *MigrationStatusRequest, opts ...grpc.CallOption) (*MigrationStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MigrationStatusResponse)
+	err := c.cc.Invoke(ctx, VideoContentAdminService_MigrationStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *videoContentAdminServiceClient) Repair(ctx context.Context, in *RepairRequest, opts ...grpc.CallOption) (*RepairResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RepairResponse)
+	err := c.cc.Invoke(ctx, VideoContentAdminService_Repair_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *videoContentAdminServiceClient) Drain(ctx context.Context, in *DrainRequest, opts ...grpc.CallOption) (*DrainResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DrainResponse)
+	err := c.cc.Invoke(ctx, VideoContentAdminService_Drain_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *videoContentAdminServiceClient) Rebalance(ctx context.Context, in *RebalanceRequest, opts ...grpc.CallOption) (*RebalanceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RebalanceResponse)
+	err := c.cc.Invoke(ctx, VideoContentAdminService_Rebalance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *videoContentAdminServiceClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VerifyResponse)
+	err := c.cc.Invoke(ctx, VideoContentAdminService_Verify_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *videoContentAdminServiceClient) ClusterStatus(ctx context.Context, in *ClusterStatusRequest, opts ...grpc.CallOption) (*ClusterStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClusterStatusResponse)
+	err := c.cc.Invoke(ctx, VideoContentAdminService_ClusterStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // VideoContentAdminServiceServer is the server API for VideoContentAdminService service.
 // All implementations must embed UnimplementedVideoContentAdminServiceServer
 // for forward compatibility.
@@ -78,6 +162,24 @@ type VideoContentAdminServiceServer interface {
 	AddNode(context.Context, *AddNodeRequest) (*AddNodeResponse, error)
 	RemoveNode(context.Context, *RemoveNodeRequest) (*RemoveNodeResponse, error)
 	ListNodes(context.Context, *ListNodesRequest) (*ListNodesResponse, error)
+	MigrationStatus(context.Context, *MigrationStatusRequest) (*MigrationStatusResponse, error)
+	// Compares the storage nodes that own each part of the ring and repairs
+	// keys that are missing from some of them or differ between them
+	Repair(context.Context, *RepairRequest) (*RepairResponse, error)
+	// Takes a node out of the ring so no new keys are placed on it, then
+	// migrates its keys to their new owners. The node is forgotten once all of
+	// them are copied, else it stays draining and Drain can be run again.
+	Drain(context.Context, *DrainRequest) (*DrainResponse, error)
+	// Reweights the nodes by the capacity they report now and moves keys to
+	// their owners on the reweighted ring, including keys found on nodes that
+	// do not own them
+	Rebalance(context.Context, *RebalanceRequest) (*RebalanceResponse, error)
+	// Checks that every file of every video in the metadata service is on all
+	// of its owners on the ring
+	Verify(context.Context, *VerifyRequest) (*VerifyResponse, error)
+	// Reports the ring's configuration, every node with its usage and health,
+	// and the current or last migration
+	ClusterStatus(context.Context, *ClusterStatusRequest) (*ClusterStatusResponse, error)
 	mustEmbedUnimplementedVideoContentAdminServiceServer()
 }
 
@@ -97,6 +199,24 @@ func (UnimplementedVideoContentAdminServiceServer) RemoveNode(context.Context, *
 func (UnimplementedVideoContentAdminServiceServer) ListNodes(context.Context, *ListNodesRequest) (*ListNodesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListNodes not implemented")
 }
+func (UnimplementedVideoContentAdminServiceServer) MigrationStatus(context.Context, *MigrationStatusRequest) (*MigrationStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MigrationStatus not implemented")
+}
+func (UnimplementedVideoContentAdminServiceServer) Repair(context.Context, *RepairRequest) (*RepairResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Repair not implemented")
+}
+func (UnimplementedVideoContentAdminServiceServer) Drain(context.Context, *DrainRequest) (*DrainResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Drain not implemented")
+}
+func (UnimplementedVideoContentAdminServiceServer) Rebalance(context.Context, *RebalanceRequest) (*RebalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rebalance not implemented")
+}
+func (UnimplementedVideoContentAdminServiceServer) Verify(context.Context, *VerifyRequest) (*VerifyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Verify not implemented")
+}
+func (UnimplementedVideoContentAdminServiceServer) ClusterStatus(context.Context, *ClusterStatusRequest) (*ClusterStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClusterStatus not implemented")
+}
 func (UnimplementedVideoContentAdminServiceServer) mustEmbedUnimplementedVideoContentAdminServiceServer() {
 }
 func (UnimplementedVideoContentAdminServiceServer) testEmbeddedByValue() {}
@@ -173,6 +293,114 @@ func _VideoContentAdminService_ListNodes_Handler(srv interface{}, ctx context.Co
 	return interceptor(ctx, in, info, handler)
 }
 
+func _VideoContentAdminService_MigrationStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MigrationStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VideoContentAdminServiceServer).MigrationStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VideoContentAdminService_MigrationStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VideoContentAdminServiceServer).MigrationStatus(ctx, req.(*MigrationStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VideoContentAdminService_Repair_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RepairRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VideoContentAdminServiceServer).Repair(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VideoContentAdminService_Repair_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VideoContentAdminServiceServer).Repair(ctx, req.(*RepairRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VideoContentAdminService_Drain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VideoContentAdminServiceServer).Drain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VideoContentAdminService_Drain_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VideoContentAdminServiceServer).Drain(ctx, req.(*DrainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VideoContentAdminService_Rebalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RebalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VideoContentAdminServiceServer).Rebalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VideoContentAdminService_Rebalance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VideoContentAdminServiceServer).Rebalance(ctx, req.(*RebalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VideoContentAdminService_Verify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VideoContentAdminServiceServer).Verify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VideoContentAdminService_Verify_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VideoContentAdminServiceServer).Verify(ctx, req.(*VerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VideoContentAdminService_ClusterStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClusterStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VideoContentAdminServiceServer).ClusterStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VideoContentAdminService_ClusterStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VideoContentAdminServiceServer).ClusterStatus(ctx, req.(*ClusterStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // VideoContentAdminService_ServiceDesc is the grpc.ServiceDesc for VideoContentAdminService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -192,6 +420,30 @@ var VideoContentAdminService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ListNodes",
 			Handler:    _VideoContentAdminService_ListNodes_Handler,
 		},
+		{
+			MethodName: "MigrationStatus",
+			Handler:    _VideoContentAdminService_MigrationStatus_Handler,
+		},
+		{
+			MethodName: "Repair",
+			Handler:    _VideoContentAdminService_Repair_Handler,
+		},
+		{
+			MethodName: "Drain",
+			Handler:    _VideoContentAdminService_Drain_Handler,
+		},
+		{
+			MethodName: "Rebalance",
+			Handler:    _VideoContentAdminService_Rebalance_Handler,
+		},
+		{
+			MethodName: "Verify",
+			Handler:    _VideoContentAdminService_Verify_Handler,
+		},
+		{
+			MethodName: "ClusterStatus",
+			Handler:    _VideoContentAdminService_ClusterStatus_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/admin.proto",
@@ -109,6 +109,120 @@ func (x *ReadFileResponse) GetData() []byte {
 	return nil
 }
 
+type ReadFileStreamRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadFileStreamRequest) Reset() {
+	*x = ReadFileStreamRequest{}
+	mi := &file_proto_content_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadFileStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadFileStreamRequest) ProtoMessage() {}
+
+func (x *ReadFileStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadFileStreamRequest.ProtoReflect.Descriptor instead.
+func (*ReadFileStreamRequest) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ReadFileStreamRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *ReadFileStreamRequest) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+// The first chunk of a ReadFileStream also carries the file's size and
+// checksum, which may come with no data
+type ReadFileChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	Size          int64                  `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	Sha256        string                 `protobuf:"bytes,3,opt,name=sha256,proto3" json:"sha256,omitempty"` // hex, "" if the node has none for the file
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReadFileChunk) Reset() {
+	*x = ReadFileChunk{}
+	mi := &file_proto_content_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReadFileChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReadFileChunk) ProtoMessage() {}
+
+func (x *ReadFileChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReadFileChunk.ProtoReflect.Descriptor instead.
+func (*ReadFileChunk) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ReadFileChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *ReadFileChunk) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *ReadFileChunk) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
 type WriteFileRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
@@ -119,7 +233,7 @@ type WriteFileRequest struct {
 
 func (x *WriteFileRequest) Reset() {
 	*x = WriteFileRequest{}
-	mi := &file_proto_content_proto_msgTypes[2]
+	mi := &file_proto_content_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -131,7 +245,7 @@ func (x *WriteFileRequest) String() string {
 func (*WriteFileRequest) ProtoMessage() {}
 
 func (x *WriteFileRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_content_proto_msgTypes[2]
+	mi := &file_proto_content_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -144,7 +258,7 @@ func (x *WriteFileRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use WriteFileRequest.ProtoReflect.Descriptor instead.
 func (*WriteFileRequest) Descriptor() ([]byte, []int) {
-	return file_proto_content_proto_rawDescGZIP(), []int{2}
+	return file_proto_content_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *WriteFileRequest) GetKey() string {
@@ -161,6 +275,69 @@ func (x *WriteFileRequest) GetData() []byte {
 	return nil
 }
 
+// Streams one file in pieces; key and tx_id are only read from the first
+// chunk. With a tx_id the file is staged, and stays invisible until the
+// transaction commits.
+type WriteFileChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	TxId          string                 `protobuf:"bytes,3,opt,name=tx_id,json=txId,proto3" json:"tx_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WriteFileChunk) Reset() {
+	*x = WriteFileChunk{}
+	mi := &file_proto_content_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WriteFileChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WriteFileChunk) ProtoMessage() {}
+
+func (x *WriteFileChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WriteFileChunk.ProtoReflect.Descriptor instead.
+func (*WriteFileChunk) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *WriteFileChunk) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *WriteFileChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *WriteFileChunk) GetTxId() string {
+	if x != nil {
+		return x.TxId
+	}
+	return ""
+}
+
 type WriteFileResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
@@ -170,7 +347,7 @@ type WriteFileResponse struct {
 
 func (x *WriteFileResponse) Reset() {
 	*x = WriteFileResponse{}
-	mi := &file_proto_content_proto_msgTypes[3]
+	mi := &file_proto_content_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -182,7 +359,7 @@ func (x *WriteFileResponse) String() string {
 func (*WriteFileResponse) ProtoMessage() {}
 
 func (x *WriteFileResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_content_proto_msgTypes[3]
+	mi := &file_proto_content_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -195,7 +372,7 @@ func (x *WriteFileResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use WriteFileResponse.ProtoReflect.Descriptor instead.
 func (*WriteFileResponse) Descriptor() ([]byte, []int) {
-	return file_proto_content_proto_rawDescGZIP(), []int{3}
+	return file_proto_content_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *WriteFileResponse) GetSuccess() bool {
@@ -214,7 +391,7 @@ type DeleteFileRequest struct {
 
 func (x *DeleteFileRequest) Reset() {
 	*x = DeleteFileRequest{}
-	mi := &file_proto_content_proto_msgTypes[4]
+	mi := &file_proto_content_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -226,7 +403,7 @@ func (x *DeleteFileRequest) String() string {
 func (*DeleteFileRequest) ProtoMessage() {}
 
 func (x *DeleteFileRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_content_proto_msgTypes[4]
+	mi := &file_proto_content_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -239,7 +416,7 @@ func (x *DeleteFileRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DeleteFileRequest.ProtoReflect.Descriptor instead.
 func (*DeleteFileRequest) Descriptor() ([]byte, []int) {
-	return file_proto_content_proto_rawDescGZIP(), []int{4}
+	return file_proto_content_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *DeleteFileRequest) GetKey() string {
@@ -258,7 +435,7 @@ type DeleteFileResponse struct {
 
 func (x *DeleteFileResponse) Reset() {
 	*x = DeleteFileResponse{}
-	mi := &file_proto_content_proto_msgTypes[5]
+	mi := &file_proto_content_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -270,7 +447,7 @@ func (x *DeleteFileResponse) String() string {
 func (*DeleteFileResponse) ProtoMessage() {}
 
 func (x *DeleteFileResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_content_proto_msgTypes[5]
+	mi := &file_proto_content_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -283,7 +460,7 @@ func (x *DeleteFileResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DeleteFileResponse.ProtoReflect.Descriptor instead.
 func (*DeleteFileResponse) Descriptor() ([]byte, []int) {
-	return file_proto_content_proto_rawDescGZIP(), []int{5}
+	return file_proto_content_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *DeleteFileResponse) GetSuccess() bool {
@@ -293,15 +470,17 @@ func (x *DeleteFileResponse) GetSuccess() bool {
 	return false
 }
 
+// Only keys starting with prefix are listed, e.g. "videoId/" for one video
 type ListKeysRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
+	Prefix        string                 `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *ListKeysRequest) Reset() {
 	*x = ListKeysRequest{}
-	mi := &file_proto_content_proto_msgTypes[6]
+	mi := &file_proto_content_proto_msgTypes[9]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -313,7 +492,7 @@ func (x *ListKeysRequest) String() string {
 func (*ListKeysRequest) ProtoMessage() {}
 
 func (x *ListKeysRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_content_proto_msgTypes[6]
+	mi := &file_proto_content_proto_msgTypes[9]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -326,7 +505,14 @@ func (x *ListKeysRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ListKeysRequest.ProtoReflect.Descriptor instead.
 func (*ListKeysRequest) Descriptor() ([]byte, []int) {
-	return file_proto_content_proto_rawDescGZIP(), []int{6}
+	return file_proto_content_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ListKeysRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
 }
 
 type ListKeysResponse struct {
@@ -338,7 +524,7 @@ type ListKeysResponse struct {
 
 func (x *ListKeysResponse) Reset() {
 	*x = ListKeysResponse{}
-	mi := &file_proto_content_proto_msgTypes[7]
+	mi := &file_proto_content_proto_msgTypes[10]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -350,7 +536,7 @@ func (x *ListKeysResponse) String() string {
 func (*ListKeysResponse) ProtoMessage() {}
 
 func (x *ListKeysResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_content_proto_msgTypes[7]
+	mi := &file_proto_content_proto_msgTypes[10]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -363,7 +549,7 @@ func (x *ListKeysResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ListKeysResponse.ProtoReflect.Descriptor instead.
 func (*ListKeysResponse) Descriptor() ([]byte, []int) {
-	return file_proto_content_proto_rawDescGZIP(), []int{7}
+	return file_proto_content_proto_rawDescGZIP(), []int{10}
 }
 
 func (x *ListKeysResponse) GetKeys() []string {
@@ -373,33 +559,754 @@ func (x *ListKeysResponse) GetKeys() []string {
 	return nil
 }
 
-var File_proto_content_proto protoreflect.FileDescriptor
+type TransactionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TxId          string                 `protobuf:"bytes,1,opt,name=tx_id,json=txId,proto3" json:"tx_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
 
-const file_proto_content_proto_rawDesc = "" +
-	"\n" +
-	"\x13proto/content.proto\x12\x05proto\"#\n" +
-	"\x0fReadFileRequest\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\tR\x03key\"&\n" +
-	"\x10ReadFileResponse\x12\x12\n" +
-	"\x04data\x18\x01 \x01(\fR\x04data\"8\n" +
-	"\x10WriteFileRequest\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\tR\x03key\x12\x12\n" +
-	"\x04data\x18\x02 \x01(\fR\x04data\"-\n" +
-	"\x11WriteFileResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess\"%\n" +
-	"\x11DeleteFileRequest\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\tR\x03key\".\n" +
-	"\x12DeleteFileResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess\"\x11\n" +
-	"\x0fListKeysRequest\"&\n" +
-	"\x10ListKeysResponse\x12\x12\n" +
-	"\x04keys\x18\x01 \x03(\tR\x04keys2\x8d\x02\n" +
-	"\x0eStorageService\x12;\n" +
-	"\bReadFile\x12\x16.proto.ReadFileRequest\x1a\x17.proto.ReadFileResponse\x12>\n" +
-	"\tWriteFile\x12\x17.proto.WriteFileRequest\x1a\x18.proto.WriteFileResponse\x12A\n" +
-	"\n" +
-	"DeleteFile\x12\x18.proto.DeleteFileRequest\x1a\x19.proto.DeleteFileResponse\x12;\n" +
-	"\bListKeys\x12\x16.proto.ListKeysRequest\x1a\x17.proto.ListKeysResponseB\x10Z\x0einternal/protob\x06proto3"
+func (x *TransactionRequest) Reset() {
+	*x = TransactionRequest{}
+	mi := &file_proto_content_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransactionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransactionRequest) ProtoMessage() {}
+
+func (x *TransactionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransactionRequest.ProtoReflect.Descriptor instead.
+func (*TransactionRequest) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *TransactionRequest) GetTxId() string {
+	if x != nil {
+		return x.TxId
+	}
+	return ""
+}
+
+type TransactionResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FileCount     int32                  `protobuf:"varint,1,opt,name=file_count,json=fileCount,proto3" json:"file_count,omitempty"` // files published by a commit
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransactionResponse) Reset() {
+	*x = TransactionResponse{}
+	mi := &file_proto_content_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransactionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransactionResponse) ProtoMessage() {}
+
+func (x *TransactionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransactionResponse.ProtoReflect.Descriptor instead.
+func (*TransactionResponse) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *TransactionResponse) GetFileCount() int32 {
+	if x != nil {
+		return x.FileCount
+	}
+	return 0
+}
+
+type StatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatsRequest) Reset() {
+	*x = StatsRequest{}
+	mi := &file_proto_content_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsRequest) ProtoMessage() {}
+
+func (x *StatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsRequest.ProtoReflect.Descriptor instead.
+func (*StatsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{13}
+}
+
+// Writes that would take used_bytes past capacity_bytes fail with
+// RESOURCE_EXHAUSTED
+type StatsResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	CapacityBytes  int64                  `protobuf:"varint,1,opt,name=capacity_bytes,json=capacityBytes,proto3" json:"capacity_bytes,omitempty"`      // the quota if one is set, else the size of the disk
+	UsedBytes      int64                  `protobuf:"varint,2,opt,name=used_bytes,json=usedBytes,proto3" json:"used_bytes,omitempty"`                  // bytes under the base directory, staged files included
+	QuotaBytes     int64                  `protobuf:"varint,3,opt,name=quota_bytes,json=quotaBytes,proto3" json:"quota_bytes,omitempty"`               // 0 if the node has no quota
+	DiskTotalBytes int64                  `protobuf:"varint,4,opt,name=disk_total_bytes,json=diskTotalBytes,proto3" json:"disk_total_bytes,omitempty"` // size of the filesystem holding the base directory
+	DiskFreeBytes  int64                  `protobuf:"varint,5,opt,name=disk_free_bytes,json=diskFreeBytes,proto3" json:"disk_free_bytes,omitempty"`    // space left on it for this node's user
+	FileCount      int64                  `protobuf:"varint,6,opt,name=file_count,json=fileCount,proto3" json:"file_count,omitempty"`                  // committed files
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *StatsResponse) Reset() {
+	*x = StatsResponse{}
+	mi := &file_proto_content_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsResponse) ProtoMessage() {}
+
+func (x *StatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsResponse.ProtoReflect.Descriptor instead.
+func (*StatsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *StatsResponse) GetCapacityBytes() int64 {
+	if x != nil {
+		return x.CapacityBytes
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetUsedBytes() int64 {
+	if x != nil {
+		return x.UsedBytes
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetQuotaBytes() int64 {
+	if x != nil {
+		return x.QuotaBytes
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetDiskTotalBytes() int64 {
+	if x != nil {
+		return x.DiskTotalBytes
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetDiskFreeBytes() int64 {
+	if x != nil {
+		return x.DiskFreeBytes
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetFileCount() int64 {
+	if x != nil {
+		return x.FileCount
+	}
+	return 0
+}
+
+// Only keys starting with prefix are scrubbed, "" for all of them
+type ScrubRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Prefix        string                 `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScrubRequest) Reset() {
+	*x = ScrubRequest{}
+	mi := &file_proto_content_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScrubRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScrubRequest) ProtoMessage() {}
+
+func (x *ScrubRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScrubRequest.ProtoReflect.Descriptor instead.
+func (*ScrubRequest) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *ScrubRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+type ScrubResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	FileCount       int64                  `protobuf:"varint,1,opt,name=file_count,json=fileCount,proto3" json:"file_count,omitempty"` // files read back
+	ByteCount       int64                  `protobuf:"varint,2,opt,name=byte_count,json=byteCount,proto3" json:"byte_count,omitempty"`
+	Corrupt         []string               `protobuf:"bytes,3,rep,name=corrupt,proto3" json:"corrupt,omitempty"`                                         // keys whose content no longer matches its checksum
+	Missing         []string               `protobuf:"bytes,4,rep,name=missing,proto3" json:"missing,omitempty"`                                         // keys with a checksum whose file is gone
+	UnrecordedCount int64                  `protobuf:"varint,5,opt,name=unrecorded_count,json=unrecordedCount,proto3" json:"unrecorded_count,omitempty"` // files without a checksum, which now have one
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *ScrubResponse) Reset() {
+	*x = ScrubResponse{}
+	mi := &file_proto_content_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScrubResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScrubResponse) ProtoMessage() {}
+
+func (x *ScrubResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScrubResponse.ProtoReflect.Descriptor instead.
+func (*ScrubResponse) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *ScrubResponse) GetFileCount() int64 {
+	if x != nil {
+		return x.FileCount
+	}
+	return 0
+}
+
+func (x *ScrubResponse) GetByteCount() int64 {
+	if x != nil {
+		return x.ByteCount
+	}
+	return 0
+}
+
+func (x *ScrubResponse) GetCorrupt() []string {
+	if x != nil {
+		return x.Corrupt
+	}
+	return nil
+}
+
+func (x *ScrubResponse) GetMissing() []string {
+	if x != nil {
+		return x.Missing
+	}
+	return nil
+}
+
+func (x *ScrubResponse) GetUnrecordedCount() int64 {
+	if x != nil {
+		return x.UnrecordedCount
+	}
+	return 0
+}
+
+// Keys hashing into (start, end] on the ring of the network content service.
+// The range wraps around when start >= end, so start == end is the whole ring.
+type HashRange struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Start         uint64                 `protobuf:"varint,1,opt,name=start,proto3" json:"start,omitempty"`
+	End           uint64                 `protobuf:"varint,2,opt,name=end,proto3" json:"end,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HashRange) Reset() {
+	*x = HashRange{}
+	mi := &file_proto_content_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HashRange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HashRange) ProtoMessage() {}
+
+func (x *HashRange) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HashRange.ProtoReflect.Descriptor instead.
+func (*HashRange) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *HashRange) GetStart() uint64 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *HashRange) GetEnd() uint64 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+// The tree has 2^depth leaves, leaf i holding the keys whose hash starts with
+// the depth bits of i
+type MerkleTreeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ranges        []*HashRange           `protobuf:"bytes,1,rep,name=ranges,proto3" json:"ranges,omitempty"`
+	Depth         uint32                 `protobuf:"varint,2,opt,name=depth,proto3" json:"depth,omitempty"`
+	KeysOnly      bool                   `protobuf:"varint,3,opt,name=keys_only,json=keysOnly,proto3" json:"keys_only,omitempty"` // hash the keys alone, for nodes whose contents differ by design
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MerkleTreeRequest) Reset() {
+	*x = MerkleTreeRequest{}
+	mi := &file_proto_content_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MerkleTreeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MerkleTreeRequest) ProtoMessage() {}
+
+func (x *MerkleTreeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MerkleTreeRequest.ProtoReflect.Descriptor instead.
+func (*MerkleTreeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *MerkleTreeRequest) GetRanges() []*HashRange {
+	if x != nil {
+		return x.Ranges
+	}
+	return nil
+}
+
+func (x *MerkleTreeRequest) GetDepth() uint32 {
+	if x != nil {
+		return x.Depth
+	}
+	return 0
+}
+
+func (x *MerkleTreeRequest) GetKeysOnly() bool {
+	if x != nil {
+		return x.KeysOnly
+	}
+	return false
+}
+
+type MerkleTreeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Nodes         [][]byte               `protobuf:"bytes,1,rep,name=nodes,proto3" json:"nodes,omitempty"` // SHA-256 hashes in heap order: the root, then the children of node i at 2i+1 and 2i+2
+	KeyCount      int64                  `protobuf:"varint,2,opt,name=key_count,json=keyCount,proto3" json:"key_count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MerkleTreeResponse) Reset() {
+	*x = MerkleTreeResponse{}
+	mi := &file_proto_content_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MerkleTreeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MerkleTreeResponse) ProtoMessage() {}
+
+func (x *MerkleTreeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MerkleTreeResponse.ProtoReflect.Descriptor instead.
+func (*MerkleTreeResponse) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *MerkleTreeResponse) GetNodes() [][]byte {
+	if x != nil {
+		return x.Nodes
+	}
+	return nil
+}
+
+func (x *MerkleTreeResponse) GetKeyCount() int64 {
+	if x != nil {
+		return x.KeyCount
+	}
+	return 0
+}
+
+type ListChecksumsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ranges        []*HashRange           `protobuf:"bytes,1,rep,name=ranges,proto3" json:"ranges,omitempty"`
+	Depth         uint32                 `protobuf:"varint,2,opt,name=depth,proto3" json:"depth,omitempty"`
+	Leaves        []uint32               `protobuf:"varint,3,rep,packed,name=leaves,proto3" json:"leaves,omitempty"` // leaves of the tree of that depth to list
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListChecksumsRequest) Reset() {
+	*x = ListChecksumsRequest{}
+	mi := &file_proto_content_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListChecksumsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListChecksumsRequest) ProtoMessage() {}
+
+func (x *ListChecksumsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListChecksumsRequest.ProtoReflect.Descriptor instead.
+func (*ListChecksumsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *ListChecksumsRequest) GetRanges() []*HashRange {
+	if x != nil {
+		return x.Ranges
+	}
+	return nil
+}
+
+func (x *ListChecksumsRequest) GetDepth() uint32 {
+	if x != nil {
+		return x.Depth
+	}
+	return 0
+}
+
+func (x *ListChecksumsRequest) GetLeaves() []uint32 {
+	if x != nil {
+		return x.Leaves
+	}
+	return nil
+}
+
+type ListChecksumsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Checksums     []*KeyChecksum         `protobuf:"bytes,1,rep,name=checksums,proto3" json:"checksums,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListChecksumsResponse) Reset() {
+	*x = ListChecksumsResponse{}
+	mi := &file_proto_content_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListChecksumsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListChecksumsResponse) ProtoMessage() {}
+
+func (x *ListChecksumsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListChecksumsResponse.ProtoReflect.Descriptor instead.
+func (*ListChecksumsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *ListChecksumsResponse) GetChecksums() []*KeyChecksum {
+	if x != nil {
+		return x.Checksums
+	}
+	return nil
+}
+
+type KeyChecksum struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Sha256        string                 `protobuf:"bytes,2,opt,name=sha256,proto3" json:"sha256,omitempty"` // hex
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KeyChecksum) Reset() {
+	*x = KeyChecksum{}
+	mi := &file_proto_content_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KeyChecksum) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KeyChecksum) ProtoMessage() {}
+
+func (x *KeyChecksum) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_content_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KeyChecksum.ProtoReflect.Descriptor instead.
+func (*KeyChecksum) Descriptor() ([]byte, []int) {
+	return file_proto_content_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *KeyChecksum) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *KeyChecksum) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+var File_proto_content_proto protoreflect.FileDescriptor
+
+const file_proto_content_proto_rawDesc = "" +
+	"\n" +
+	"\x13proto/content.proto\x12\x05proto\"#\n" +
+	"\x0fReadFileRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\"&\n" +
+	"\x10ReadFileResponse\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\"A\n" +
+	"\x15ReadFileStreamRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\"O\n" +
+	"\rReadFileChunk\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x03R\x04size\x12\x16\n" +
+	"\x06sha256\x18\x03 \x01(\tR\x06sha256\"8\n" +
+	"\x10WriteFileRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\"K\n" +
+	"\x0eWriteFileChunk\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\x12\x13\n" +
+	"\x05tx_id\x18\x03 \x01(\tR\x04txId\"-\n" +
+	"\x11WriteFileResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\"%\n" +
+	"\x11DeleteFileRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\".\n" +
+	"\x12DeleteFileResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\")\n" +
+	"\x0fListKeysRequest\x12\x16\n" +
+	"\x06prefix\x18\x01 \x01(\tR\x06prefix\"&\n" +
+	"\x10ListKeysResponse\x12\x12\n" +
+	"\x04keys\x18\x01 \x03(\tR\x04keys\")\n" +
+	"\x12TransactionRequest\x12\x13\n" +
+	"\x05tx_id\x18\x01 \x01(\tR\x04txId\"4\n" +
+	"\x13TransactionResponse\x12\x1d\n" +
+	"\n" +
+	"file_count\x18\x01 \x01(\x05R\tfileCount\"\x0e\n" +
+	"\fStatsRequest\"\xe7\x01\n" +
+	"\rStatsResponse\x12%\n" +
+	"\x0ecapacity_bytes\x18\x01 \x01(\x03R\rcapacityBytes\x12\x1d\n" +
+	"\n" +
+	"used_bytes\x18\x02 \x01(\x03R\tusedBytes\x12\x1f\n" +
+	"\vquota_bytes\x18\x03 \x01(\x03R\n" +
+	"quotaBytes\x12(\n" +
+	"\x10disk_total_bytes\x18\x04 \x01(\x03R\x0ediskTotalBytes\x12&\n" +
+	"\x0fdisk_free_bytes\x18\x05 \x01(\x03R\rdiskFreeBytes\x12\x1d\n" +
+	"\n" +
+	"file_count\x18\x06 \x01(\x03R\tfileCount\"&\n" +
+	"\fScrubRequest\x12\x16\n" +
+	"\x06prefix\x18\x01 \x01(\tR\x06prefix\"\xac\x01\n" +
+	"\rScrubResponse\x12\x1d\n" +
+	"\n" +
+	"file_count\x18\x01 \x01(\x03R\tfileCount\x12\x1d\n" +
+	"\n" +
+	"byte_count\x18\x02 \x01(\x03R\tbyteCount\x12\x18\n" +
+	"\acorrupt\x18\x03 \x03(\tR\acorrupt\x12\x18\n" +
+	"\amissing\x18\x04 \x03(\tR\amissing\x12)\n" +
+	"\x10unrecorded_count\x18\x05 \x01(\x03R\x0funrecordedCount\"3\n" +
+	"\tHashRange\x12\x14\n" +
+	"\x05start\x18\x01 \x01(\x04R\x05start\x12\x10\n" +
+	"\x03end\x18\x02 \x01(\x04R\x03end\"p\n" +
+	"\x11MerkleTreeRequest\x12(\n" +
+	"\x06ranges\x18\x01 \x03(\v2\x10.proto.HashRangeR\x06ranges\x12\x14\n" +
+	"\x05depth\x18\x02 \x01(\rR\x05depth\x12\x1b\n" +
+	"\tkeys_only\x18\x03 \x01(\bR\bkeysOnly\"G\n" +
+	"\x12MerkleTreeResponse\x12\x14\n" +
+	"\x05nodes\x18\x01 \x03(\fR\x05nodes\x12\x1b\n" +
+	"\tkey_count\x18\x02 \x01(\x03R\bkeyCount\"n\n" +
+	"\x14ListChecksumsRequest\x12(\n" +
+	"\x06ranges\x18\x01 \x03(\v2\x10.proto.HashRangeR\x06ranges\x12\x14\n" +
+	"\x05depth\x18\x02 \x01(\rR\x05depth\x12\x16\n" +
+	"\x06leaves\x18\x03 \x03(\rR\x06leaves\"I\n" +
+	"\x15ListChecksumsResponse\x120\n" +
+	"\tchecksums\x18\x01 \x03(\v2\x12.proto.KeyChecksumR\tchecksums\"7\n" +
+	"\vKeyChecksum\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x16\n" +
+	"\x06sha256\x18\x02 \x01(\tR\x06sha2562\xa9\x06\n" +
+	"\x0eStorageService\x12;\n" +
+	"\bReadFile\x12\x16.proto.ReadFileRequest\x1a\x17.proto.ReadFileResponse\x12F\n" +
+	"\x0eReadFileStream\x12\x1c.proto.ReadFileStreamRequest\x1a\x14.proto.ReadFileChunk0\x01\x12>\n" +
+	"\tWriteFile\x12\x17.proto.WriteFileRequest\x1a\x18.proto.WriteFileResponse\x12D\n" +
+	"\x0fWriteFileStream\x12\x15.proto.WriteFileChunk\x1a\x18.proto.WriteFileResponse(\x01\x12A\n" +
+	"\n" +
+	"DeleteFile\x12\x18.proto.DeleteFileRequest\x1a\x19.proto.DeleteFileResponse\x12;\n" +
+	"\bListKeys\x12\x16.proto.ListKeysRequest\x1a\x17.proto.ListKeysResponse\x12J\n" +
+	"\x11CommitTransaction\x12\x19.proto.TransactionRequest\x1a\x1a.proto.TransactionResponse\x12I\n" +
+	"\x10AbortTransaction\x12\x19.proto.TransactionRequest\x1a\x1a.proto.TransactionResponse\x122\n" +
+	"\x05Stats\x12\x13.proto.StatsRequest\x1a\x14.proto.StatsResponse\x122\n" +
+	"\x05Scrub\x12\x13.proto.ScrubRequest\x1a\x14.proto.ScrubResponse\x12A\n" +
+	"\n" +
+	"MerkleTree\x12\x18.proto.MerkleTreeRequest\x1a\x19.proto.MerkleTreeResponse\x12J\n" +
+	"\rListChecksums\x12\x1b.proto.ListChecksumsRequest\x1a\x1c.proto.ListChecksumsResponseB\x10Z\x0einternal/protob\x06proto3"
 
 var (
 	file_proto_content_proto_rawDescOnce sync.Once
@@ -413,31 +1320,65 @@ func file_proto_content_proto_rawDescGZIP() []byte {
 	return file_proto_content_proto_rawDescData
 }
 
-var file_proto_content_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_proto_content_proto_msgTypes = make([]protoimpl.MessageInfo, 23)
 var file_proto_content_proto_goTypes = []any{
-	(*ReadFileRequest)(nil),    // 0: proto.ReadFileRequest
-	(*ReadFileResponse)(nil),   // 1: proto.ReadFileResponse
-	(*WriteFileRequest)(nil),   // 2: proto.WriteFileRequest
-	(*WriteFileResponse)(nil),  // 3: proto.WriteFileResponse
-	(*DeleteFileRequest)(nil),  // 4: proto.DeleteFileRequest
-	(*DeleteFileResponse)(nil), // 5: proto.DeleteFileResponse
-	(*ListKeysRequest)(nil),    // 6: proto.ListKeysRequest
-	(*ListKeysResponse)(nil),   // 7: proto.ListKeysResponse
+	(*ReadFileRequest)(nil),       // 0: proto.ReadFileRequest
+	(*ReadFileResponse)(nil),      // 1: proto.ReadFileResponse
+	(*ReadFileStreamRequest)(nil), // 2: proto.ReadFileStreamRequest
+	(*ReadFileChunk)(nil),         // 3: proto.ReadFileChunk
+	(*WriteFileRequest)(nil),      // 4: proto.WriteFileRequest
+	(*WriteFileChunk)(nil),        // 5: proto.WriteFileChunk
+	(*WriteFileResponse)(nil),     // 6: proto.WriteFileResponse
+	(*DeleteFileRequest)(nil),     // 7: proto.DeleteFileRequest
+	(*DeleteFileResponse)(nil),    // 8: proto.DeleteFileResponse
+	(*ListKeysRequest)(nil),       // 9: proto.ListKeysRequest
+	(*ListKeysResponse)(nil),      // 10: proto.ListKeysResponse
+	(*TransactionRequest)(nil),    // 11: proto.TransactionRequest
+	(*TransactionResponse)(nil),   // 12: proto.TransactionResponse
+	(*StatsRequest)(nil),          // 13: proto.StatsRequest
+	(*StatsResponse)(nil),         // 14: proto.StatsResponse
+	(*ScrubRequest)(nil),          // 15: proto.ScrubRequest
+	(*ScrubResponse)(nil),         // 16: proto.ScrubResponse
+	(*HashRange)(nil),             // 17: proto.HashRange
+	(*MerkleTreeRequest)(nil),     // 18: proto.MerkleTreeRequest
+	(*MerkleTreeResponse)(nil),    // 19: proto.MerkleTreeResponse
+	(*ListChecksumsRequest)(nil),  // 20: proto.ListChecksumsRequest
+	(*ListChecksumsResponse)(nil), // 21: proto.ListChecksumsResponse
+	(*KeyChecksum)(nil),           // 22: proto.KeyChecksum
 }
 var file_proto_content_proto_depIdxs = []int32{
-	0, // 0: proto.StorageService.ReadFile:input_type -> proto.ReadFileRequest
-	2, // 1: proto.StorageService.WriteFile:input_type -> proto.WriteFileRequest
-	4, // 2: proto.StorageService.DeleteFile:input_type -> proto.DeleteFileRequest
-	6, // 3: proto.StorageService.ListKeys:input_type -> proto.ListKeysRequest
-	1, // 4: proto.StorageService.ReadFile:output_type -> proto.ReadFileResponse
-	3, // 5: proto.StorageService.WriteFile:output_type -> proto.WriteFileResponse
-	5, // 6: proto.StorageService.DeleteFile:output_type -> proto.DeleteFileResponse
-	7, // 7: proto.StorageService.ListKeys:output_type -> proto.ListKeysResponse
-	4, // [4:8] is the sub-list for method output_type
-	0, // [0:4] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	17, // 0: proto.MerkleTreeRequest.ranges:type_name -> proto.HashRange
+	17, // 1: proto.ListChecksumsRequest.ranges:type_name -> proto.HashRange
+	22, // 2: proto.ListChecksumsResponse.checksums:type_name -> proto.KeyChecksum
+	0,  // 3: proto.StorageService.ReadFile:input_type -> proto.ReadFileRequest
+	2,  // 4: proto.StorageService.ReadFileStream:input_type -> proto.ReadFileStreamRequest
+	4,  // 5: proto.StorageService.WriteFile:input_type -> proto.WriteFileRequest
+	5,  // 6: proto.StorageService.WriteFileStream:input_type -> proto.WriteFileChunk
+	7,  // 7: proto.StorageService.DeleteFile:input_type -> proto.DeleteFileRequest
+	9,  // 8: proto.StorageService.ListKeys:input_type -> proto.ListKeysRequest
+	11, // 9: proto.StorageService.CommitTransaction:input_type -> proto.TransactionRequest
+	11, // 10: proto.StorageService.AbortTransaction:input_type -> proto.TransactionRequest
+	13, // 11: proto.StorageService.Stats:input_type -> proto.StatsRequest
+	15, // 12: proto.StorageService.Scrub:input_type -> proto.ScrubRequest
+	18, // 13: proto.StorageService.MerkleTree:input_type -> proto.MerkleTreeRequest
+	20, // 14: proto.StorageService.ListChecksums:input_type -> proto.ListChecksumsRequest
+	1,  // 15: proto.StorageService.ReadFile:output_type -> proto.ReadFileResponse
+	3,  // 16: proto.StorageService.ReadFileStream:output_type -> proto.ReadFileChunk
+	6,  // 17: proto.StorageService.WriteFile:output_type -> proto.WriteFileResponse
+	6,  // 18: proto.StorageService.WriteFileStream:output_type -> proto.WriteFileResponse
+	8,  // 19: proto.StorageService.DeleteFile:output_type -> proto.DeleteFileResponse
+	10, // 20: proto.StorageService.ListKeys:output_type -> proto.ListKeysResponse
+	12, // 21: proto.StorageService.CommitTransaction:output_type -> proto.TransactionResponse
+	12, // 22: proto.StorageService.AbortTransaction:output_type -> proto.TransactionResponse
+	14, // 23: proto.StorageService.Stats:output_type -> proto.StatsResponse
+	16, // 24: proto.StorageService.Scrub:output_type -> proto.ScrubResponse
+	19, // 25: proto.StorageService.MerkleTree:output_type -> proto.MerkleTreeResponse
+	21, // 26: proto.StorageService.ListChecksums:output_type -> proto.ListChecksumsResponse
+	15, // [15:27] is the sub-list for method output_type
+	3,  // [3:15] is the sub-list for method input_type
+	3,  // [3:3] is the sub-list for extension type_name
+	3,  // [3:3] is the sub-list for extension extendee
+	0,  // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_proto_content_proto_init() }
@@ -451,7 +1392,7 @@ func file_proto_content_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_content_proto_rawDesc), len(file_proto_content_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   8,
+			NumMessages:   23,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
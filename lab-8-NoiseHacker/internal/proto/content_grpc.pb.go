@@ -19,10 +19,18 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	StorageService_ReadFile_FullMethodName   = "/proto.StorageService/ReadFile"
-	StorageService_WriteFile_FullMethodName  = "/proto.StorageService/WriteFile"
-	StorageService_DeleteFile_FullMethodName = "/proto.StorageService/DeleteFile"
-	StorageService_ListKeys_FullMethodName   = "/proto.StorageService/ListKeys"
+	StorageService_ReadFile_FullMethodName          = "/proto.StorageService/ReadFile"
+	StorageService_ReadFileStream_FullMethodName    = "/proto.StorageService/ReadFileStream"
+	StorageService_WriteFile_FullMethodName         = "/proto.StorageService/WriteFile"
+	StorageService_WriteFileStream_FullMethodName   = "/proto.StorageService/WriteFileStream"
+	StorageService_DeleteFile_FullMethodName        = "/proto.StorageService/DeleteFile"
+	StorageService_ListKeys_FullMethodName          = "/proto.StorageService/ListKeys"
+	StorageService_CommitTransaction_FullMethodName = "/proto.StorageService/CommitTransaction"
+	StorageService_AbortTransaction_FullMethodName  = "/proto.StorageService/AbortTransaction"
+	StorageService_Stats_FullMethodName             = "/proto.StorageService/Stats"
+	StorageService_Scrub_FullMethodName             = "/proto.StorageService/Scrub"
+	StorageService_MerkleTree_FullMethodName        = "/proto.StorageService/MerkleTree"
+	StorageService_ListChecksums_FullMethodName     = "/proto.StorageService/ListChecksums"
 )
 
 // StorageServiceClient is the client API for StorageService service.
@@ -30,9 +38,27 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type StorageServiceClient interface {
 	ReadFile(ctx context.Context, in *ReadFileRequest, opts ...grpc.CallOption) (*ReadFileResponse, error)
+	// Streams a file from an offset to its end, so a client can serve part of
+	// it, or all of it, without holding it in memory
+	ReadFileStream(ctx context.Context, in *ReadFileStreamRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ReadFileChunk], error)
 	WriteFile(ctx context.Context, in *WriteFileRequest, opts ...grpc.CallOption) (*WriteFileResponse, error)
+	WriteFileStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[WriteFileChunk, WriteFileResponse], error)
 	DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*DeleteFileResponse, error)
 	ListKeys(ctx context.Context, in *ListKeysRequest, opts ...grpc.CallOption) (*ListKeysResponse, error)
+	// Publish or discard the files staged by WriteFileStream under a transaction
+	CommitTransaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*TransactionResponse, error)
+	AbortTransaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*TransactionResponse, error)
+	// Reports how much this node can store and how much it holds
+	Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error)
+	// Reads back every file and checks it against the checksum taken when it
+	// was written. Files that fail are left out of reads and Merkle trees until
+	// they are written again.
+	Scrub(ctx context.Context, in *ScrubRequest, opts ...grpc.CallOption) (*ScrubResponse, error)
+	// Hashes the checksums of the keys in some ranges of the hash ring into a
+	// Merkle tree, so two nodes can find where their keys differ cheaply
+	MerkleTree(ctx context.Context, in *MerkleTreeRequest, opts ...grpc.CallOption) (*MerkleTreeResponse, error)
+	// Lists the keys under some leaves of a Merkle tree with their checksums
+	ListChecksums(ctx context.Context, in *ListChecksumsRequest, opts ...grpc.CallOption) (*ListChecksumsResponse, error)
 }
 
 type storageServiceClient struct {
@@ -53,6 +79,25 @@ func (c *storageServiceClient) ReadFile(ctx context.Context, in *ReadFileRequest
 	return out, nil
 }
 
+func (c *storageServiceClient) ReadFileStream(ctx context.Context, in *ReadFileStreamRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ReadFileChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &StorageService_ServiceDesc.Streams[0], StorageService_ReadFileStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ReadFileStreamRequest, ReadFileChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type StorageService_ReadFileStreamClient = grpc.ServerStreamingClient[ReadFileChunk]
+
 func (c *storageServiceClient) WriteFile(ctx context.Context, in *WriteFileRequest, opts ...grpc.CallOption) (*WriteFileResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(WriteFileResponse)
@@ -63,6 +108,19 @@ func (c *storageServiceClient) WriteFile(ctx context.Context, in *WriteFileReque
 	return out, nil
 }
 
+func (c *storageServiceClient) WriteFileStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[WriteFileChunk, WriteFileResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &StorageService_ServiceDesc.Streams[1], StorageService_WriteFileStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WriteFileChunk, WriteFileResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type StorageService_WriteFileStreamClient = grpc.ClientStreamingClient[WriteFileChunk, WriteFileResponse]
+
 func (c *storageServiceClient) DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*DeleteFileResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(DeleteFileResponse)
@@ -83,14 +141,92 @@ func (c *storageServiceClient) ListKeys(ctx context.Context, in *ListKeysRequest
 	return out, nil
 }
 
+func (c *storageServiceClient) CommitTransaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*TransactionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionResponse)
+	err := c.cc.Invoke(ctx, StorageService_CommitTransaction_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) AbortTransaction(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*TransactionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransactionResponse)
+	err := c.cc.Invoke(ctx, StorageService_AbortTransaction_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatsResponse)
+	err := c.cc.Invoke(ctx, StorageService_Stats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) Scrub(ctx context.Context, in *ScrubRequest, opts ...grpc.CallOption) (*ScrubResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ScrubResponse)
+	err := c.cc.Invoke(ctx, StorageService_Scrub_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) MerkleTree(ctx context.Context, in *MerkleTreeRequest, opts ...grpc.CallOption) (*MerkleTreeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MerkleTreeResponse)
+	err := c.cc.Invoke(ctx, StorageService_MerkleTree_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) ListChecksums(ctx context.Context, in *ListChecksumsRequest, opts ...grpc.CallOption) (*ListChecksumsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListChecksumsResponse)
+	err := c.cc.Invoke(ctx, StorageService_ListChecksums_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // StorageServiceServer is the server API for StorageService service.
 // All implementations must embed UnimplementedStorageServiceServer
 // for forward compatibility.
 type StorageServiceServer interface {
 	ReadFile(context.Context, *ReadFileRequest) (*ReadFileResponse, error)
+	// Streams a file from an offset to its end, so a client can serve part of
+	// it, or all of it, without holding it in memory
+	ReadFileStream(*ReadFileStreamRequest, grpc.ServerStreamingServer[ReadFileChunk]) error
 	WriteFile(context.Context, *WriteFileRequest) (*WriteFileResponse, error)
+	WriteFileStream(grpc.ClientStreamingServer[WriteFileChunk, WriteFileResponse]) error
 	DeleteFile(context.Context, *DeleteFileRequest) (*DeleteFileResponse, error)
 	ListKeys(context.Context, *ListKeysRequest) (*ListKeysResponse, error)
+	// Publish or discard the files staged by WriteFileStream under a transaction
+	CommitTransaction(context.Context, *TransactionRequest) (*TransactionResponse, error)
+	AbortTransaction(context.Context, *TransactionRequest) (*TransactionResponse, error)
+	// Reports how much this node can store and how much it holds
+	Stats(context.Context, *StatsRequest) (*StatsResponse, error)
+	// Reads back every file and checks it against the checksum taken when it
+	// was written. Files that fail are left out of reads and Merkle trees until
+	// they are written again.
+	Scrub(context.Context, *ScrubRequest) (*ScrubResponse, error)
+	// Hashes the checksums of the keys in some ranges of the hash ring into a
+	// Merkle tree, so two nodes can find where their keys differ cheaply
+	MerkleTree(context.Context, *MerkleTreeRequest) (*MerkleTreeResponse, error)
+	// Lists the keys under some leaves of a Merkle tree with their checksums
+	ListChecksums(context.Context, *ListChecksumsRequest) (*ListChecksumsResponse, error)
 	mustEmbedUnimplementedStorageServiceServer()
 }
 
@@ -104,15 +240,39 @@ type UnimplementedStorageServiceServer struct{}
 func (UnimplementedStorageServiceServer) ReadFile(context.Context, *ReadFileRequest) (*ReadFileResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ReadFile not implemented")
 }
+func (UnimplementedStorageServiceServer) ReadFileStream(*ReadFileStreamRequest, grpc.ServerStreamingServer[ReadFileChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method ReadFileStream not implemented")
+}
 func (UnimplementedStorageServiceServer) WriteFile(context.Context, *WriteFileRequest) (*WriteFileResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method WriteFile not implemented")
 }
+func (UnimplementedStorageServiceServer) WriteFileStream(grpc.ClientStreamingServer[WriteFileChunk, WriteFileResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method WriteFileStream not implemented")
+}
 func (UnimplementedStorageServiceServer) DeleteFile(context.Context, *DeleteFileRequest) (*DeleteFileResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteFile not implemented")
 }
 func (UnimplementedStorageServiceServer) ListKeys(context.Context, *ListKeysRequest) (*ListKeysResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListKeys not implemented")
 }
+func (UnimplementedStorageServiceServer) CommitTransaction(context.Context, *TransactionRequest) (*TransactionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CommitTransaction not implemented")
+}
+func (UnimplementedStorageServiceServer) AbortTransaction(context.Context, *TransactionRequest) (*TransactionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AbortTransaction not implemented")
+}
+func (UnimplementedStorageServiceServer) Stats(context.Context, *StatsRequest) (*StatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stats not implemented")
+}
+func (UnimplementedStorageServiceServer) Scrub(context.Context, *ScrubRequest) (*ScrubResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Scrub not implemented")
+}
+func (UnimplementedStorageServiceServer) MerkleTree(context.Context, *MerkleTreeRequest) (*MerkleTreeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MerkleTree not implemented")
+}
+func (UnimplementedStorageServiceServer) ListChecksums(context.Context, *ListChecksumsRequest) (*ListChecksumsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListChecksums not implemented")
+}
 func (UnimplementedStorageServiceServer) mustEmbedUnimplementedStorageServiceServer() {}
 func (UnimplementedStorageServiceServer) testEmbeddedByValue()                        {}
 
@@ -152,6 +312,17 @@ func _StorageService_ReadFile_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _StorageService_ReadFileStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReadFileStreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StorageServiceServer).ReadFileStream(m, &grpc.GenericServerStream[ReadFileStreamRequest, ReadFileChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type StorageService_ReadFileStreamServer = grpc.ServerStreamingServer[ReadFileChunk]
+
 func _StorageService_WriteFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(WriteFileRequest)
 	if err := dec(in); err != nil {
@@ -170,6 +341,13 @@ func _StorageService_WriteFile_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _StorageService_WriteFileStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(StorageServiceServer).WriteFileStream(&grpc.GenericServerStream[WriteFileChunk, WriteFileResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type StorageService_WriteFileStreamServer = grpc.ClientStreamingServer[WriteFileChunk, WriteFileResponse]
+
 func _StorageService_DeleteFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(DeleteFileRequest)
 	if err := dec(in); err != nil {
@@ -206,6 +384,114 @@ func _StorageService_ListKeys_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _StorageService_CommitTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).CommitTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StorageService_CommitTransaction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).CommitTransaction(ctx, req.(*TransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_AbortTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).AbortTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StorageService_AbortTransaction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).AbortTransaction(ctx, req.(*TransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_Stats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).Stats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StorageService_Stats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).Stats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_Scrub_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScrubRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).Scrub(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StorageService_Scrub_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).Scrub(ctx, req.(*ScrubRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_MerkleTree_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MerkleTreeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).MerkleTree(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StorageService_MerkleTree_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).MerkleTree(ctx, req.(*MerkleTreeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_ListChecksums_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListChecksumsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).ListChecksums(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StorageService_ListChecksums_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).ListChecksums(ctx, req.(*ListChecksumsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // StorageService_ServiceDesc is the grpc.ServiceDesc for StorageService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -229,7 +515,42 @@ var StorageService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ListKeys",
 			Handler:    _StorageService_ListKeys_Handler,
 		},
+		{
+			MethodName: "CommitTransaction",
+			Handler:    _StorageService_CommitTransaction_Handler,
+		},
+		{
+			MethodName: "AbortTransaction",
+			Handler:    _StorageService_AbortTransaction_Handler,
+		},
+		{
+			MethodName: "Stats",
+			Handler:    _StorageService_Stats_Handler,
+		},
+		{
+			MethodName: "Scrub",
+			Handler:    _StorageService_Scrub_Handler,
+		},
+		{
+			MethodName: "MerkleTree",
+			Handler:    _StorageService_MerkleTree_Handler,
+		},
+		{
+			MethodName: "ListChecksums",
+			Handler:    _StorageService_ListChecksums_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ReadFileStream",
+			Handler:       _StorageService_ReadFileStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WriteFileStream",
+			Handler:       _StorageService_WriteFileStream_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/content.proto",
 }
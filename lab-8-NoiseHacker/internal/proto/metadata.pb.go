@@ -0,0 +1,357 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v6.31.0--rc2
+// source: proto/metadata.proto
+
+package proto
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// One VideoMetadataService call. args and result are the JSON encoded
+// arguments and return value of the method named by op. Failures are gRPC
+// errors: NOT_FOUND for a missing row, ALREADY_EXISTS for a taken video ID or
+// username, INVALID_ARGUMENT for a bad cursor and UNAVAILABLE while there is
+// no leader.
+type MetadataRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Op            string                 `protobuf:"bytes,1,opt,name=op,proto3" json:"op,omitempty"`
+	Args          []byte                 `protobuf:"bytes,2,opt,name=args,proto3" json:"args,omitempty"`
+	RequestId     string                 `protobuf:"bytes,3,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"` // same on every retry of a write, which is applied once
+	Forwarded     bool                   `protobuf:"varint,4,opt,name=forwarded,proto3" json:"forwarded,omitempty"`                 // set by the node that forwarded it, which is not forwarded again
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MetadataRequest) Reset() {
+	*x = MetadataRequest{}
+	mi := &file_proto_metadata_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MetadataRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetadataRequest) ProtoMessage() {}
+
+func (x *MetadataRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metadata_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetadataRequest.ProtoReflect.Descriptor instead.
+func (*MetadataRequest) Descriptor() ([]byte, []int) {
+	return file_proto_metadata_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *MetadataRequest) GetOp() string {
+	if x != nil {
+		return x.Op
+	}
+	return ""
+}
+
+func (x *MetadataRequest) GetArgs() []byte {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *MetadataRequest) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *MetadataRequest) GetForwarded() bool {
+	if x != nil {
+		return x.Forwarded
+	}
+	return false
+}
+
+type MetadataResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Result        []byte                 `protobuf:"bytes,1,opt,name=result,proto3" json:"result,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MetadataResponse) Reset() {
+	*x = MetadataResponse{}
+	mi := &file_proto_metadata_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MetadataResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetadataResponse) ProtoMessage() {}
+
+func (x *MetadataResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metadata_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetadataResponse.ProtoReflect.Descriptor instead.
+func (*MetadataResponse) Descriptor() ([]byte, []int) {
+	return file_proto_metadata_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *MetadataResponse) GetResult() []byte {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+type MetadataStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MetadataStatusRequest) Reset() {
+	*x = MetadataStatusRequest{}
+	mi := &file_proto_metadata_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MetadataStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetadataStatusRequest) ProtoMessage() {}
+
+func (x *MetadataStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metadata_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetadataStatusRequest.ProtoReflect.Descriptor instead.
+func (*MetadataStatusRequest) Descriptor() ([]byte, []int) {
+	return file_proto_metadata_proto_rawDescGZIP(), []int{2}
+}
+
+type MetadataStatusResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	State         string                 `protobuf:"bytes,2,opt,name=state,proto3" json:"state,omitempty"` // "follower", "candidate" or "leader"
+	Term          uint64                 `protobuf:"varint,3,opt,name=term,proto3" json:"term,omitempty"`
+	Leader        string                 `protobuf:"bytes,4,opt,name=leader,proto3" json:"leader,omitempty"` // "" if unknown
+	CommitIndex   uint64                 `protobuf:"varint,5,opt,name=commit_index,json=commitIndex,proto3" json:"commit_index,omitempty"`
+	AppliedIndex  uint64                 `protobuf:"varint,6,opt,name=applied_index,json=appliedIndex,proto3" json:"applied_index,omitempty"`
+	SnapshotIndex uint64                 `protobuf:"varint,7,opt,name=snapshot_index,json=snapshotIndex,proto3" json:"snapshot_index,omitempty"`
+	Peers         []string               `protobuf:"bytes,8,rep,name=peers,proto3" json:"peers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MetadataStatusResponse) Reset() {
+	*x = MetadataStatusResponse{}
+	mi := &file_proto_metadata_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MetadataStatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetadataStatusResponse) ProtoMessage() {}
+
+func (x *MetadataStatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metadata_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetadataStatusResponse.ProtoReflect.Descriptor instead.
+func (*MetadataStatusResponse) Descriptor() ([]byte, []int) {
+	return file_proto_metadata_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *MetadataStatusResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *MetadataStatusResponse) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *MetadataStatusResponse) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+func (x *MetadataStatusResponse) GetLeader() string {
+	if x != nil {
+		return x.Leader
+	}
+	return ""
+}
+
+func (x *MetadataStatusResponse) GetCommitIndex() uint64 {
+	if x != nil {
+		return x.CommitIndex
+	}
+	return 0
+}
+
+func (x *MetadataStatusResponse) GetAppliedIndex() uint64 {
+	if x != nil {
+		return x.AppliedIndex
+	}
+	return 0
+}
+
+func (x *MetadataStatusResponse) GetSnapshotIndex() uint64 {
+	if x != nil {
+		return x.SnapshotIndex
+	}
+	return 0
+}
+
+func (x *MetadataStatusResponse) GetPeers() []string {
+	if x != nil {
+		return x.Peers
+	}
+	return nil
+}
+
+var File_proto_metadata_proto protoreflect.FileDescriptor
+
+const file_proto_metadata_proto_rawDesc = "" +
+	"\n" +
+	"\x14proto/metadata.proto\x12\x05proto\"r\n" +
+	"\x0fMetadataRequest\x12\x0e\n" +
+	"\x02op\x18\x01 \x01(\tR\x02op\x12\x12\n" +
+	"\x04args\x18\x02 \x01(\fR\x04args\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x03 \x01(\tR\trequestId\x12\x1c\n" +
+	"\tforwarded\x18\x04 \x01(\bR\tforwarded\"*\n" +
+	"\x10MetadataResponse\x12\x16\n" +
+	"\x06result\x18\x01 \x01(\fR\x06result\"\x17\n" +
+	"\x15MetadataStatusRequest\"\xef\x01\n" +
+	"\x16MetadataStatusResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05state\x18\x02 \x01(\tR\x05state\x12\x12\n" +
+	"\x04term\x18\x03 \x01(\x04R\x04term\x12\x16\n" +
+	"\x06leader\x18\x04 \x01(\tR\x06leader\x12!\n" +
+	"\fcommit_index\x18\x05 \x01(\x04R\vcommitIndex\x12#\n" +
+	"\rapplied_index\x18\x06 \x01(\x04R\fappliedIndex\x12%\n" +
+	"\x0esnapshot_index\x18\a \x01(\x04R\rsnapshotIndex\x12\x14\n" +
+	"\x05peers\x18\b \x03(\tR\x05peers2\x94\x01\n" +
+	"\x0fMetadataService\x12:\n" +
+	"\aExecute\x12\x16.proto.MetadataRequest\x1a\x17.proto.MetadataResponse\x12E\n" +
+	"\x06Status\x12\x1c.proto.MetadataStatusRequest\x1a\x1d.proto.MetadataStatusResponseB\x10Z\x0einternal/protob\x06proto3"
+
+var (
+	file_proto_metadata_proto_rawDescOnce sync.Once
+	file_proto_metadata_proto_rawDescData []byte
+)
+
+func file_proto_metadata_proto_rawDescGZIP() []byte {
+	file_proto_metadata_proto_rawDescOnce.Do(func() {
+		file_proto_metadata_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_metadata_proto_rawDesc), len(file_proto_metadata_proto_rawDesc)))
+	})
+	return file_proto_metadata_proto_rawDescData
+}
+
+var file_proto_metadata_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_proto_metadata_proto_goTypes = []any{
+	(*MetadataRequest)(nil),        // 0: proto.MetadataRequest
+	(*MetadataResponse)(nil),       // 1: proto.MetadataResponse
+	(*MetadataStatusRequest)(nil),  // 2: proto.MetadataStatusRequest
+	(*MetadataStatusResponse)(nil), // 3: proto.MetadataStatusResponse
+}
+var file_proto_metadata_proto_depIdxs = []int32{
+	0, // 0: proto.MetadataService.Execute:input_type -> proto.MetadataRequest
+	2, // 1: proto.MetadataService.Status:input_type -> proto.MetadataStatusRequest
+	1, // 2: proto.MetadataService.Execute:output_type -> proto.MetadataResponse
+	3, // 3: proto.MetadataService.Status:output_type -> proto.MetadataStatusResponse
+	2, // [2:4] is the sub-list for method output_type
+	0, // [0:2] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_proto_metadata_proto_init() }
+func file_proto_metadata_proto_init() {
+	if File_proto_metadata_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_metadata_proto_rawDesc), len(file_proto_metadata_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_metadata_proto_goTypes,
+		DependencyIndexes: file_proto_metadata_proto_depIdxs,
+		MessageInfos:      file_proto_metadata_proto_msgTypes,
+	}.Build()
+	File_proto_metadata_proto = out.File
+	file_proto_metadata_proto_goTypes = nil
+	file_proto_metadata_proto_depIdxs = nil
+}
@@ -0,0 +1,165 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v6.31.0--rc2
+// source: proto/metadata.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	MetadataService_Execute_FullMethodName = "/proto.MetadataService/Execute"
+	MetadataService_Status_FullMethodName  = "/proto.MetadataService/Status"
+)
+
+// MetadataServiceClient is the client API for MetadataService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Served by every metadata node. Requests to a follower are forwarded to the
+// leader, so clients can talk to any node.
+type MetadataServiceClient interface {
+	Execute(ctx context.Context, in *MetadataRequest, opts ...grpc.CallOption) (*MetadataResponse, error)
+	Status(ctx context.Context, in *MetadataStatusRequest, opts ...grpc.CallOption) (*MetadataStatusResponse, error)
+}
+
+type metadataServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMetadataServiceClient(cc grpc.ClientConnInterface) MetadataServiceClient {
+	return &metadataServiceClient{cc}
+}
+
+func (c *metadataServiceClient) Execute(ctx context.Context, in *MetadataRequest, opts ...grpc.CallOption) (*MetadataResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MetadataResponse)
+	err := c.cc.Invoke(ctx, MetadataService_Execute_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) Status(ctx context.Context, in *MetadataStatusRequest, opts ...grpc.CallOption) (*MetadataStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MetadataStatusResponse)
+	err := c.cc.Invoke(ctx, MetadataService_Status_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MetadataServiceServer is the server API for MetadataService service.
+// All implementations must embed UnimplementedMetadataServiceServer
+// for forward compatibility.
+//
+// Served by every metadata node. Requests to a follower are forwarded to the
+// leader, so clients can talk to any node.
+type MetadataServiceServer interface {
+	Execute(context.Context, *MetadataRequest) (*MetadataResponse, error)
+	Status(context.Context, *MetadataStatusRequest) (*MetadataStatusResponse, error)
+	mustEmbedUnimplementedMetadataServiceServer()
+}
+
+// UnimplementedMetadataServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMetadataServiceServer struct{}
+
+func (UnimplementedMetadataServiceServer) Execute(context.Context, *MetadataRequest) (*MetadataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Execute not implemented")
+}
+func (UnimplementedMetadataServiceServer) Status(context.Context, *MetadataStatusRequest) (*MetadataStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedMetadataServiceServer) mustEmbedUnimplementedMetadataServiceServer() {}
+func (UnimplementedMetadataServiceServer) testEmbeddedByValue()                         {}
+
+// UnsafeMetadataServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MetadataServiceServer will
+// result in compilation errors.
+type UnsafeMetadataServiceServer interface {
+	mustEmbedUnimplementedMetadataServiceServer()
+}
+
+func RegisterMetadataServiceServer(s grpc.ServiceRegistrar, srv MetadataServiceServer) {
+	// If the following call pancis, it indicates UnimplementedMetadataServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&MetadataService_ServiceDesc, srv)
+}
+
+func _MetadataService_Execute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).Execute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetadataService_Execute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).Execute(ctx, req.(*MetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MetadataStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetadataService_Status_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).Status(ctx, req.(*MetadataStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MetadataService_ServiceDesc is the grpc.ServiceDesc for MetadataService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MetadataService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.MetadataService",
+	HandlerType: (*MetadataServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Execute",
+			Handler:    _MetadataService_Execute_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _MetadataService_Status_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/metadata.proto",
+}
@@ -0,0 +1,982 @@
+// Package raft replicates a state machine across a small, fixed group of nodes
+// with the Raft consensus algorithm: leader election (with pre-votes, so a node
+// coming back from a partition does not depose a working leader, and a leader
+// cut off from a majority steps down), log replication, log compaction into
+// snapshots, and linearizable reads that confirm leadership with a round of
+// heartbeats instead of going through the log. Membership changes are not supported; every node must be started with
+// the same peer list.
+//
+// Messages are the RaftService protos, sent through a Transport: GRPCTransport
+// between processes, or MemNetwork within one, where links can be cut to test
+// partitions.
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"tritontube/internal/proto"
+)
+
+// ErrNotLeader is returned by Propose and ReadIndex on a node that is not the
+// leader; Leader tells who is, if anyone
+var ErrNotLeader = errors.New("raft: not the leader")
+
+// ErrStopped is returned once a node has been stopped
+var ErrStopped = errors.New("raft: node stopped")
+
+// Defaults for the node's tunables, suited to a LAN
+const (
+	DefaultElectionTimeout   = 1 * time.Second
+	DefaultHeartbeatInterval = 100 * time.Millisecond
+	DefaultSnapshotThreshold = 1000
+)
+
+// At most this many entries go in one AppendEntries
+const maxAppendEntries = 256
+
+// StateMachine is the replicated state. Apply and Restore are only called from
+// one goroutine, in log order, and Snapshot only between them.
+type StateMachine interface {
+	// Apply applies a committed command and returns its result. It must be
+	// deterministic, since every node applies every command.
+	Apply(command []byte) []byte
+	Snapshot() ([]byte, error)
+	Restore(snapshot []byte) error
+}
+
+// Transport sends RPCs to the other nodes of the group, named by their IDs
+type Transport interface {
+	RequestVote(ctx context.Context, peer string, req *proto.VoteRequest) (*proto.VoteResponse, error)
+	AppendEntries(ctx context.Context, peer string, req *proto.AppendEntriesRequest) (*proto.AppendEntriesResponse, error)
+	InstallSnapshot(ctx context.Context, peer string, req *proto.InstallSnapshotRequest) (*proto.InstallSnapshotResponse, error)
+}
+
+// State is a node's role in its current term
+type State int
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Node is one member of a Raft group
+type Node struct {
+	// Tunables, which must be set before Start. A follower that hears nothing
+	// from a leader for between ElectionTimeout and twice that starts an
+	// election. The log is compacted once SnapshotThreshold entries have been
+	// applied since the last snapshot; 0 never compacts it.
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	SnapshotThreshold uint64
+
+	id        string
+	peers     []string // the other members
+	storage   Storage
+	transport Transport
+	sm        StateMachine
+
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced whenever state that waiters check changes
+	stopped bool
+	stopErr error // why the node stopped itself, nil if it was stopped with Stop
+	stop    chan struct{}
+
+	state    State
+	term     uint64
+	votedFor string
+	leader   string
+
+	// log[0] stands for the last entry in the snapshot, so the log is never
+	// empty and log[i] has index log[0].Index + i
+	log             []*proto.LogEntry
+	snapshot        *Snapshot // latest, sent to followers that are too far behind
+	pendingSnapshot *Snapshot // installed from the leader, not yet restored
+	commitIndex     uint64
+	lastApplied     uint64
+
+	electionDeadline  time.Time
+	lastLeaderContact time.Time
+	lastHeartbeat     time.Time
+
+	// Leader state, reset by each election it wins
+	leaderDone chan struct{} // closed when it stops being leader
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	replicate  map[string]chan struct{} // wakes the replicator of each peer
+	readRound  uint64                   // heartbeat rounds started by ReadIndex
+	ackedRound map[string]uint64        // latest round each peer answered
+	lastAck    map[string]time.Time     // when each peer last answered
+
+	proposals map[uint64]*proposal // by log index
+}
+
+type proposal struct {
+	term   uint64
+	done   bool
+	result []byte
+	err    error
+}
+
+// NewNode creates a member of the group made of id and peers, which should
+// include id. Set the tunables, then call Start.
+func NewNode(id string, peers []string, storage Storage, transport Transport, sm StateMachine) *Node {
+	var others []string
+	for _, p := range peers {
+		if p != id && !slices.Contains(others, p) {
+			others = append(others, p)
+		}
+	}
+	return &Node{
+		ElectionTimeout:   DefaultElectionTimeout,
+		HeartbeatInterval: DefaultHeartbeatInterval,
+		SnapshotThreshold: DefaultSnapshotThreshold,
+		id:                id,
+		peers:             others,
+		storage:           storage,
+		transport:         transport,
+		sm:                sm,
+		changed:           make(chan struct{}),
+		stop:              make(chan struct{}),
+		proposals:         make(map[uint64]*proposal),
+	}
+}
+
+// Start restores the node's saved state and starts it as a follower
+func (n *Node) Start() error {
+	st, snap, entries, err := n.storage.Load()
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.term, n.votedFor = st.Term, st.VotedFor
+	n.log = []*proto.LogEntry{{}}
+	if snap != nil {
+		if err := n.sm.Restore(snap.Data); err != nil {
+			return fmt.Errorf("failed to restore snapshot: %w", err)
+		}
+		n.snapshot = snap
+		n.log[0] = &proto.LogEntry{Index: snap.Index, Term: snap.Term}
+		n.commitIndex, n.lastApplied = snap.Index, snap.Index
+	}
+	for _, e := range entries {
+		if e.Index == n.lastIndex()+1 {
+			n.log = append(n.log, e)
+		}
+	}
+	n.state = Follower
+	n.resetElectionDeadline()
+
+	go n.tick()
+	go n.applyCommitted()
+	return nil
+}
+
+// Stop shuts the node down. It stops answering RPCs, and calls waiting on it fail
+// with ErrStopped.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stopLocked()
+}
+
+// Stops the node because it cannot go on, making calls fail with err, which
+// wraps ErrStopped
+func (n *Node) fail(err error) {
+	slog.Error("Raft node stopped", "node", n.id, "err", err)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.stopped {
+		n.stopErr = fmt.Errorf("%w: %w", ErrStopped, err)
+	}
+	n.stopLocked()
+}
+
+// Requires n.mu
+func (n *Node) stopLocked() {
+	if n.stopped {
+		return
+	}
+	n.stopped = true
+	close(n.stop)
+	if n.state == Leader {
+		close(n.leaderDone)
+		n.state = Follower
+	}
+	n.notify()
+}
+
+// Done is closed once the node has stopped
+func (n *Node) Done() <-chan struct{} {
+	return n.stop
+}
+
+// Err returns why the node stopped itself, such as a snapshot from the leader
+// its state machine could not restore, or nil while it runs or if it was
+// stopped with Stop
+func (n *Node) Err() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stopErr
+}
+
+// ID returns the node's own ID
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Leader returns the ID of the node this one believes is the leader, or ""
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+// Status is a snapshot of a node's view of the group
+type Status struct {
+	ID            string
+	State         State
+	Term          uint64
+	Leader        string
+	CommitIndex   uint64
+	AppliedIndex  uint64
+	SnapshotIndex uint64
+	Peers         []string
+}
+
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{
+		ID:            n.id,
+		State:         n.state,
+		Term:          n.term,
+		Leader:        n.leader,
+		CommitIndex:   n.commitIndex,
+		AppliedIndex:  n.lastApplied,
+		SnapshotIndex: n.log[0].Index,
+		Peers:         slices.Clone(n.peers),
+	}
+}
+
+// Propose appends command to the log and returns the state machine's result
+// once it is applied. It fails with ErrNotLeader on a follower, or if the node
+// lost its leadership and the entry was replaced; the command may still have
+// been applied if the call fails any other way, such as by ctx expiring.
+func (n *Node) Propose(ctx context.Context, command []byte) ([]byte, error) {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return nil, ErrStopped
+	}
+	if n.state != Leader {
+		n.mu.Unlock()
+		return nil, ErrNotLeader
+	}
+	e := &proto.LogEntry{Index: n.lastIndex() + 1, Term: n.term, Command: command}
+	if err := n.storage.Append([]*proto.LogEntry{e}); err != nil {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("failed to append to log: %w", err)
+	}
+	n.log = append(n.log, e)
+	p := &proposal{term: n.term}
+	n.proposals[e.Index] = p
+	n.advanceCommit()
+	n.wakeReplicators()
+	n.mu.Unlock()
+
+	err := n.wait(ctx, func() bool { return p.done })
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.proposals, e.Index)
+	if err != nil {
+		return nil, err
+	}
+	return p.result, p.err
+}
+
+// ReadIndex waits until it is safe to read the state machine for a
+// linearizable read: the node has confirmed it is still the leader, and has
+// applied every entry committed before the call.
+func (n *Node) ReadIndex(ctx context.Context) error {
+	n.mu.Lock()
+	if n.state != Leader {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+	term := n.term
+	n.mu.Unlock()
+	lost := func() bool { return n.state != Leader || n.term != term }
+
+	// A new leader only knows which entries are committed once an entry of its
+	// own term is
+	if err := n.wait(ctx, func() bool { return lost() || n.termAt(n.commitIndex) == term }); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	if lost() {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+	readIndex := n.commitIndex
+	n.readRound++
+	round := n.readRound
+	n.wakeReplicators()
+	n.mu.Unlock()
+
+	// A majority answering a heartbeat sent after the read began means no
+	// other leader could have committed anything newer
+	if err := n.wait(ctx, func() bool { return lost() || n.acked(round) }); err != nil {
+		return err
+	}
+	if err := n.wait(ctx, func() bool { return lost() || n.lastApplied >= readIndex }); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if lost() {
+		return ErrNotLeader
+	}
+	return nil
+}
+
+// Reports whether a majority, counting this node, answered round
+func (n *Node) acked(round uint64) bool {
+	votes := 1
+	for _, p := range n.peers {
+		if n.ackedRound[p] >= round {
+			votes++
+		}
+	}
+	return votes >= n.quorum()
+}
+
+func (n *Node) quorum() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+// Blocks until cond, checked with n.mu held, is true
+func (n *Node) wait(ctx context.Context, cond func() bool) error {
+	for {
+		n.mu.Lock()
+		if n.stopped {
+			err := n.stopErr
+			n.mu.Unlock()
+			if err == nil {
+				err = ErrStopped
+			}
+			return err
+		}
+		if cond() {
+			n.mu.Unlock()
+			return nil
+		}
+		changed := n.changed
+		n.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Wakes everything blocked in wait. Requires n.mu.
+func (n *Node) notify() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// Log helpers, all requiring n.mu
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+// Returns the term of the entry at index, which must not be compacted away
+func (n *Node) termAt(index uint64) uint64 {
+	return n.log[index-n.log[0].Index].Term
+}
+
+// Returns the entries from index on, at most max of them
+func (n *Node) entriesFrom(index uint64, max int) []*proto.LogEntry {
+	start := index - n.log[0].Index
+	end := min(uint64(len(n.log)), start+uint64(max))
+	return slices.Clone(n.log[start:end])
+}
+
+func (n *Node) persist() error {
+	if err := n.storage.SaveHardState(HardState{Term: n.term, VotedFor: n.votedFor}); err != nil {
+		slog.Error("Raft node failed to save its state", "node", n.id, "term", n.term, "err", err)
+		return err
+	}
+	return nil
+}
+
+func (n *Node) resetElectionDeadline() {
+	timeout := n.ElectionTimeout + rand.N(n.ElectionTimeout)
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+// Steps down to follower, moving to term if it is newer. Requires n.mu.
+func (n *Node) becomeFollower(term uint64) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.leader = ""
+		n.persist()
+	}
+	if n.state == Leader {
+		close(n.leaderDone)
+		slog.Info("Raft node stepped down", "node", n.id, "term", n.term)
+	}
+	n.state = Follower
+	n.notify()
+}
+
+// Drives elections and heartbeats
+func (n *Node) tick() {
+	ticker := time.NewTicker(min(n.HeartbeatInterval, n.ElectionTimeout) / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+		n.mu.Lock()
+		now := time.Now()
+		if n.state == Leader {
+			if !n.inContact(now) {
+				// Lets clients of a leader cut off in a minority go elsewhere
+				// instead of waiting on it
+				slog.Warn("Raft node lost contact with a majority", "node", n.id, "term", n.term)
+				n.leader = ""
+				n.becomeFollower(n.term)
+			} else if now.Sub(n.lastHeartbeat) >= n.HeartbeatInterval {
+				n.lastHeartbeat = now
+				n.wakeReplicators()
+			}
+		} else if now.After(n.electionDeadline) {
+			n.resetElectionDeadline()
+			go n.campaign()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// Reports whether a majority, counting this leader, answered it within the
+// last election timeout. Requires n.mu.
+func (n *Node) inContact(now time.Time) bool {
+	answered := 1
+	for _, p := range n.peers {
+		if now.Sub(n.lastAck[p]) < n.ElectionTimeout {
+			answered++
+		}
+	}
+	return answered >= n.quorum()
+}
+
+// Runs a pre-vote and, if a majority would vote for this node, an election
+func (n *Node) campaign() {
+	if !n.poll(true) {
+		return
+	}
+	n.poll(false)
+}
+
+// Asks every peer for its vote and returns whether a majority granted it. A
+// pre-vote changes nothing but the node's term when it learns of a newer one;
+// a real vote makes it a candidate and, if it wins, the leader.
+func (n *Node) poll(preVote bool) bool {
+	n.mu.Lock()
+	if n.stopped || n.state == Leader {
+		n.mu.Unlock()
+		return false
+	}
+	term := n.term + 1
+	if !preVote {
+		n.state = Candidate
+		n.term = term
+		n.votedFor = n.id
+		n.leader = ""
+		if err := n.persist(); err != nil {
+			n.state = Follower
+			n.mu.Unlock()
+			return false
+		}
+		n.notify()
+	}
+	req := &proto.VoteRequest{
+		Term:         term,
+		CandidateId:  n.id,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.lastTerm(),
+		PreVote:      preVote,
+	}
+	startTerm := n.term
+	n.mu.Unlock()
+
+	if n.quorum() == 1 {
+		return n.won(preVote, startTerm)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), n.ElectionTimeout)
+	defer cancel()
+	votes := make(chan bool, len(n.peers))
+	for _, peer := range n.peers {
+		go func() {
+			resp, err := n.transport.RequestVote(ctx, peer, req)
+			if err != nil {
+				votes <- false
+				return
+			}
+			n.mu.Lock()
+			if resp.Term > n.term && !resp.Granted {
+				n.becomeFollower(resp.Term)
+			}
+			n.mu.Unlock()
+			votes <- resp.Granted
+		}()
+	}
+
+	granted := 1
+	for range n.peers {
+		if <-votes {
+			granted++
+		}
+		if granted >= n.quorum() {
+			return n.won(preVote, startTerm)
+		}
+	}
+	return false
+}
+
+// Called once a poll has a majority; only counts if nothing changed meanwhile
+func (n *Node) won(preVote bool, startTerm uint64) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped || n.term != startTerm {
+		return false
+	}
+	if preVote {
+		return n.state != Leader
+	}
+	if n.state != Candidate {
+		return false
+	}
+	n.becomeLeader()
+	return true
+}
+
+// Requires n.mu
+func (n *Node) becomeLeader() {
+	slog.Info("Raft node became leader", "node", n.id, "term", n.term)
+	n.state = Leader
+	n.leader = n.id
+	n.leaderDone = make(chan struct{})
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.replicate = make(map[string]chan struct{})
+	n.ackedRound = make(map[string]uint64)
+	n.readRound = 0
+	n.lastAck = make(map[string]time.Time)
+
+	// Entries from earlier terms only commit along with one of this term
+	noop := &proto.LogEntry{Index: n.lastIndex() + 1, Term: n.term}
+	if err := n.storage.Append([]*proto.LogEntry{noop}); err != nil {
+		slog.Error("Raft node failed to append to its log", "node", n.id, "term", n.term, "err", err)
+		n.becomeFollower(n.term)
+		return
+	}
+	n.log = append(n.log, noop)
+
+	for _, peer := range n.peers {
+		n.lastAck[peer] = time.Now()
+		n.nextIndex[peer] = n.lastIndex()
+		wake := make(chan struct{}, 1)
+		n.replicate[peer] = wake
+		go n.replicateTo(peer, n.term, wake, n.leaderDone)
+	}
+	n.lastHeartbeat = time.Now()
+	n.wakeReplicators()
+	n.advanceCommit()
+	n.notify()
+}
+
+// Requires n.mu
+func (n *Node) wakeReplicators() {
+	for _, wake := range n.replicate {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Keeps one peer's log in line with the leader's for as long as the node
+// leads in term. Each wake sends one AppendEntries, which doubles as the
+// heartbeat; it keeps sending while the peer is behind.
+func (n *Node) replicateTo(peer string, term uint64, wake chan struct{}, done chan struct{}) {
+	for {
+		select {
+		case <-wake:
+		case <-done:
+			return
+		}
+		for n.sendTo(peer, term) {
+		}
+	}
+}
+
+// Sends the peer what it is missing, or a heartbeat, and reports whether it
+// should be sent more right away
+func (n *Node) sendTo(peer string, term uint64) bool {
+	n.mu.Lock()
+	if n.state != Leader || n.term != term {
+		n.mu.Unlock()
+		return false
+	}
+	round := n.readRound
+	next := n.nextIndex[peer]
+	ctx, cancel := context.WithTimeout(context.Background(), n.ElectionTimeout)
+	defer cancel()
+
+	if next <= n.log[0].Index {
+		// The peer needs entries that are only in the snapshot
+		snap := n.snapshot
+		req := &proto.InstallSnapshotRequest{
+			Term:              term,
+			LeaderId:          n.id,
+			LastIncludedIndex: snap.Index,
+			LastIncludedTerm:  snap.Term,
+			Data:              snap.Data,
+		}
+		n.mu.Unlock()
+		resp, err := n.transport.InstallSnapshot(ctx, peer, req)
+		if err != nil {
+			return false
+		}
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if !n.handleReply(peer, term, round, resp.Term) {
+			return false
+		}
+		n.matchIndex[peer] = max(n.matchIndex[peer], snap.Index)
+		n.nextIndex[peer] = n.matchIndex[peer] + 1
+		n.advanceCommit()
+		return n.nextIndex[peer] <= n.lastIndex()
+	}
+
+	req := &proto.AppendEntriesRequest{
+		Term:         term,
+		LeaderId:     n.id,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  n.termAt(next - 1),
+		Entries:      n.entriesFrom(next, maxAppendEntries),
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+	resp, err := n.transport.AppendEntries(ctx, peer, req)
+	if err != nil {
+		return false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.handleReply(peer, term, round, resp.Term) {
+		return false
+	}
+	if resp.Success {
+		n.matchIndex[peer] = max(n.matchIndex[peer], req.PrevLogIndex+uint64(len(req.Entries)))
+		n.nextIndex[peer] = n.matchIndex[peer] + 1
+		n.advanceCommit()
+	} else {
+		n.nextIndex[peer] = max(1, min(resp.ConflictIndex, next-1))
+	}
+	return n.nextIndex[peer] <= n.lastIndex()
+}
+
+// Handles the term of a peer's reply, and reports whether the node is still
+// the leader of term. A reply in the leader's term acknowledges round.
+// Requires n.mu.
+func (n *Node) handleReply(peer string, term, round, replyTerm uint64) bool {
+	if replyTerm > n.term {
+		n.becomeFollower(replyTerm)
+		return false
+	}
+	if n.state != Leader || n.term != term {
+		return false
+	}
+	n.lastAck[peer] = time.Now()
+	if round > n.ackedRound[peer] {
+		n.ackedRound[peer] = round
+		n.notify()
+	}
+	return true
+}
+
+// Commits the newest entry of the current term that a majority has stored.
+// Requires n.mu.
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex && n.termAt(index) == n.term; index-- {
+		stored := 1
+		for _, p := range n.peers {
+			if n.matchIndex[p] >= index {
+				stored++
+			}
+		}
+		if stored >= n.quorum() {
+			n.commitIndex = index
+			n.notify()
+			return
+		}
+	}
+}
+
+// Applies committed entries, and restores installed snapshots, in order
+func (n *Node) applyCommitted() {
+	for {
+		err := n.wait(context.Background(), func() bool {
+			return n.pendingSnapshot != nil || n.lastApplied < n.commitIndex
+		})
+		if err != nil {
+			return
+		}
+
+		n.mu.Lock()
+		if snap := n.pendingSnapshot; snap != nil {
+			n.pendingSnapshot = nil
+			n.mu.Unlock()
+			// The log before the snapshot is gone, so there is no going on without it
+			if err := n.sm.Restore(snap.Data); err != nil {
+				n.fail(fmt.Errorf("failed to restore snapshot %d: %w", snap.Index, err))
+				return
+			}
+			n.mu.Lock()
+			n.lastApplied = max(n.lastApplied, snap.Index)
+			n.notify()
+			n.mu.Unlock()
+			continue
+		}
+		entries := n.entriesFrom(n.lastApplied+1, int(n.commitIndex-n.lastApplied))
+		n.mu.Unlock()
+
+		for _, e := range entries {
+			var result []byte
+			if len(e.Command) > 0 {
+				result = n.sm.Apply(e.Command)
+			}
+			n.mu.Lock()
+			if n.pendingSnapshot != nil {
+				n.mu.Unlock()
+				break
+			}
+			n.lastApplied = e.Index
+			if p := n.proposals[e.Index]; p != nil {
+				p.done = true
+				if p.term == e.Term {
+					p.result = result
+				} else {
+					p.err = ErrNotLeader
+				}
+			}
+			n.notify()
+			n.mu.Unlock()
+		}
+		n.maybeSnapshot()
+	}
+}
+
+// Compacts the log into a snapshot once enough entries have been applied
+// since the last one. Only called by applyCommitted, so the state machine
+// stays at lastApplied while its snapshot is taken.
+func (n *Node) maybeSnapshot() {
+	n.mu.Lock()
+	applied := n.lastApplied
+	due := n.SnapshotThreshold > 0 && n.pendingSnapshot == nil && applied >= n.log[0].Index+n.SnapshotThreshold
+	n.mu.Unlock()
+	if !due {
+		return
+	}
+
+	data, err := n.sm.Snapshot()
+	if err != nil {
+		slog.Error("Raft node failed to snapshot its state", "node", n.id, "err", err)
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if applied <= n.log[0].Index {
+		return
+	}
+	snap := &Snapshot{Index: applied, Term: n.termAt(applied), Data: data}
+	if err := n.storage.SaveSnapshot(snap); err != nil {
+		slog.Error("Raft node failed to save snapshot", "node", n.id, "index", applied, "err", err)
+		return
+	}
+	n.compact(snap)
+}
+
+// Replaces the log up to snap with it, keeping the entries after it if the
+// log agrees with snap. Requires n.mu.
+func (n *Node) compact(snap *Snapshot) {
+	var rest []*proto.LogEntry
+	if snap.Index >= n.log[0].Index && snap.Index <= n.lastIndex() && n.termAt(snap.Index) == snap.Term {
+		rest = n.log[snap.Index-n.log[0].Index+1:]
+	}
+	n.log = append([]*proto.LogEntry{{Index: snap.Index, Term: snap.Term}}, rest...)
+	n.snapshot = snap
+}
+
+// Records that the leader of term was heard from. Requires n.mu.
+func (n *Node) heardFromLeader(term uint64, leader string) {
+	if term > n.term || n.state != Follower {
+		n.becomeFollower(term)
+	}
+	if n.leader != leader {
+		n.leader = leader
+		n.notify()
+	}
+	n.lastLeaderContact = time.Now()
+	n.resetElectionDeadline()
+}
+
+// HandleRequestVote answers a candidate's RequestVote
+func (n *Node) HandleRequestVote(req *proto.VoteRequest) (*proto.VoteResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	upToDate := req.LastLogTerm > n.lastTerm() || (req.LastLogTerm == n.lastTerm() && req.LastLogIndex >= n.lastIndex())
+
+	if req.PreVote {
+		// Refused while a leader is being heard from
+		leaderAlive := n.state == Leader || (n.leader != "" && time.Since(n.lastLeaderContact) < n.ElectionTimeout)
+		return &proto.VoteResponse{Term: n.term, Granted: req.Term > n.term && upToDate && !leaderAlive}, nil
+	}
+
+	if req.Term < n.term {
+		return &proto.VoteResponse{Term: n.term}, nil
+	}
+	if req.Term > n.term {
+		n.becomeFollower(req.Term)
+	}
+	if (n.votedFor != "" && n.votedFor != req.CandidateId) || !upToDate {
+		return &proto.VoteResponse{Term: n.term}, nil
+	}
+	n.votedFor = req.CandidateId
+	if err := n.persist(); err != nil {
+		n.votedFor = ""
+		return nil, err
+	}
+	n.resetElectionDeadline()
+	return &proto.VoteResponse{Term: n.term, Granted: true}, nil
+}
+
+// HandleAppendEntries stores the leader's entries after checking that the log
+// matches its own up to them
+func (n *Node) HandleAppendEntries(req *proto.AppendEntriesRequest) (*proto.AppendEntriesResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	if req.Term < n.term {
+		return &proto.AppendEntriesResponse{Term: n.term}, nil
+	}
+	n.heardFromLeader(req.Term, req.LeaderId)
+	resp := &proto.AppendEntriesResponse{Term: n.term}
+
+	prevIndex, prevTerm, entries := req.PrevLogIndex, req.PrevLogTerm, req.Entries
+	// Entries covered by the snapshot are committed, so they match
+	if prevIndex < n.log[0].Index {
+		skip := min(uint64(len(entries)), n.log[0].Index-prevIndex)
+		entries = entries[skip:]
+		prevIndex, prevTerm = n.log[0].Index, n.log[0].Term
+	}
+	if prevIndex > n.lastIndex() {
+		resp.ConflictIndex = n.lastIndex() + 1
+		return resp, nil
+	}
+	if n.termAt(prevIndex) != prevTerm {
+		// Skips back over the whole conflicting term at once
+		conflictTerm := n.termAt(prevIndex)
+		index := prevIndex
+		for index > n.log[0].Index+1 && n.termAt(index-1) == conflictTerm {
+			index--
+		}
+		resp.ConflictIndex = index
+		return resp, nil
+	}
+
+	for i, e := range entries {
+		if e.Index <= n.lastIndex() && n.termAt(e.Index) == e.Term {
+			continue
+		}
+		if err := n.storage.Append(entries[i:]); err != nil {
+			return nil, fmt.Errorf("failed to append to log: %w", err)
+		}
+		n.log = append(n.log[:e.Index-n.log[0].Index], entries[i:]...)
+		break
+	}
+
+	lastNew := prevIndex + uint64(len(entries))
+	if req.LeaderCommit > n.commitIndex {
+		n.commitIndex = max(n.commitIndex, min(req.LeaderCommit, lastNew))
+		n.notify()
+	}
+	resp.Success = true
+	return resp, nil
+}
+
+// HandleInstallSnapshot replaces the node's state with the leader's snapshot,
+// when the leader has compacted away entries the node is missing
+func (n *Node) HandleInstallSnapshot(req *proto.InstallSnapshotRequest) (*proto.InstallSnapshotResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	if req.Term < n.term {
+		return &proto.InstallSnapshotResponse{Term: n.term}, nil
+	}
+	n.heardFromLeader(req.Term, req.LeaderId)
+	if req.LastIncludedIndex <= n.commitIndex {
+		return &proto.InstallSnapshotResponse{Term: n.term}, nil
+	}
+
+	snap := &Snapshot{Index: req.LastIncludedIndex, Term: req.LastIncludedTerm, Data: req.Data}
+	if err := n.storage.SaveSnapshot(snap); err != nil {
+		return nil, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	n.compact(snap)
+	n.commitIndex = snap.Index
+	n.pendingSnapshot = snap
+	n.notify()
+	slog.Info("Raft node installed snapshot", "node", n.id, "term", n.term, "index", snap.Index, "leader", req.LeaderId)
+	return &proto.InstallSnapshotResponse{Term: n.term}, nil
+}
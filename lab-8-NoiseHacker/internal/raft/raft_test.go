@@ -0,0 +1,344 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testElectionTimeout   = 50 * time.Millisecond
+	testHeartbeatInterval = 10 * time.Millisecond
+)
+
+// kv is a state machine of "key=value" commands
+type kv struct {
+	mu          sync.Mutex
+	data        map[string]string
+	failRestore bool
+}
+
+func newKV() *kv {
+	return &kv{data: make(map[string]string)}
+}
+
+func (s *kv) Apply(command []byte) []byte {
+	key, value, _ := strings.Cut(string(command), "=")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = value
+	return []byte(fmt.Sprint(len(s.data)))
+}
+
+func (s *kv) Snapshot() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.Marshal(s.data)
+}
+
+func (s *kv) Restore(snapshot []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failRestore {
+		return errors.New("restore failed")
+	}
+	data := make(map[string]string)
+	if err := json.Unmarshal(snapshot, &data); err != nil {
+		return err
+	}
+	s.data = data
+	return nil
+}
+
+func (s *kv) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.data[key]
+	return value, ok
+}
+
+type testCluster struct {
+	t       *testing.T
+	network *MemNetwork
+	ids     []string
+	nodes   map[string]*Node
+	sms     map[string]*kv
+}
+
+// Starts a group of size nodes, n1 to n<size>, that compacts its log every
+// snapshotThreshold entries
+func newTestCluster(t *testing.T, size int, snapshotThreshold uint64) *testCluster {
+	t.Helper()
+	c := &testCluster{
+		t:       t,
+		network: NewMemNetwork(),
+		nodes:   make(map[string]*Node),
+		sms:     make(map[string]*kv),
+	}
+	for i := 1; i <= size; i++ {
+		c.ids = append(c.ids, fmt.Sprintf("n%d", i))
+	}
+	for _, id := range c.ids {
+		c.sms[id] = newKV()
+		node := NewNode(id, c.ids, NewMemoryStorage(), c.network.Transport(id), c.sms[id])
+		node.ElectionTimeout = testElectionTimeout
+		node.HeartbeatInterval = testHeartbeatInterval
+		node.SnapshotThreshold = snapshotThreshold
+		c.nodes[id] = node
+		c.network.Add(node)
+	}
+	for _, id := range c.ids {
+		if err := c.nodes[id].Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, node := range c.nodes {
+			node.Stop()
+		}
+	})
+	return c
+}
+
+// Waits until cond holds, failing the test after a few seconds
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Waits until exactly one of ids leads and the others follow it, and returns it
+func (c *testCluster) waitLeader(ids ...string) *Node {
+	c.t.Helper()
+	if len(ids) == 0 {
+		ids = c.ids
+	}
+	var leader *Node
+	eventually(c.t, fmt.Sprintf("a leader among %v", ids), func() bool {
+		leader = nil
+		for _, id := range ids {
+			if c.nodes[id].Status().State == Leader {
+				if leader != nil {
+					return false
+				}
+				leader = c.nodes[id]
+			}
+		}
+		if leader == nil {
+			return false
+		}
+		for _, id := range ids {
+			if c.nodes[id].Leader() != leader.ID() {
+				return false
+			}
+		}
+		return true
+	})
+	return leader
+}
+
+func (c *testCluster) others(id string) []string {
+	var others []string
+	for _, other := range c.ids {
+		if other != id {
+			others = append(others, other)
+		}
+	}
+	return others
+}
+
+func (c *testCluster) propose(node *Node, command string) {
+	c.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := node.Propose(ctx, []byte(command)); err != nil {
+		c.t.Fatalf("Propose(%q) on %s: %v", command, node.ID(), err)
+	}
+}
+
+// Waits until every node in ids has applied key=value
+func (c *testCluster) waitApplied(key, value string, ids ...string) {
+	c.t.Helper()
+	for _, id := range ids {
+		eventually(c.t, fmt.Sprintf("%s to apply %s=%s", id, key, value), func() bool {
+			v, ok := c.sms[id].get(key)
+			return ok && v == value
+		})
+	}
+}
+
+func TestElectsOneLeaderAndReplicates(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	leader := c.waitLeader()
+
+	result, err := leader.Propose(context.Background(), []byte("a=1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != "1" {
+		t.Fatalf("Propose returned %q, want the state machine's result %q", result, "1")
+	}
+	c.waitApplied("a", "1", c.ids...)
+
+	for _, id := range c.others(leader.ID()) {
+		if _, err := c.nodes[id].Propose(context.Background(), []byte("b=2")); !errors.Is(err, ErrNotLeader) {
+			t.Fatalf("Propose on follower %s returned %v, want ErrNotLeader", id, err)
+		}
+	}
+}
+
+func TestLeaderCutOffStepsDownAndRejoins(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	old := c.waitLeader()
+	c.propose(old, "a=1")
+	oldTerm := old.Status().Term
+
+	rest := c.others(old.ID())
+	c.network.Partition([]string{old.ID()}, rest)
+	eventually(t, "the cut off leader to step down", func() bool {
+		return old.Status().State != Leader
+	})
+	leader := c.waitLeader(rest...)
+	if term := leader.Status().Term; term <= oldTerm {
+		t.Fatalf("new leader's term %d, want more than %d", term, oldTerm)
+	}
+	if _, err := old.Propose(context.Background(), []byte("lost=1")); !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("Propose on cut off node returned %v, want ErrNotLeader", err)
+	}
+	c.propose(leader, "b=2")
+
+	c.network.Heal()
+	c.waitLeader()
+	c.waitApplied("b", "2", c.ids...)
+	if _, ok := c.sms[old.ID()].get("lost"); ok {
+		t.Fatal("a command proposed to the cut off leader was applied")
+	}
+}
+
+func TestPreVoteKeepsRejoiningNodeFromDisrupting(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	leader := c.waitLeader()
+	term := leader.Status().Term
+	var isolated string
+	for _, id := range c.others(leader.ID()) {
+		isolated = id
+	}
+
+	c.network.Partition([]string{isolated}, c.others(isolated))
+	time.Sleep(10 * testElectionTimeout)
+	if st := c.nodes[isolated].Status(); st.Term != term {
+		t.Fatalf("isolated node moved from term %d to %d without winning a pre-vote", term, st.Term)
+	}
+
+	c.network.Heal()
+	time.Sleep(10 * testElectionTimeout)
+	if st := leader.Status(); st.State != Leader || st.Term != term {
+		t.Fatalf("leader is %s in term %d after the node rejoined, want leader in term %d", st.State, st.Term, term)
+	}
+	if got := c.nodes[isolated].Leader(); got != leader.ID() {
+		t.Fatalf("rejoined node follows %q, want %q", got, leader.ID())
+	}
+}
+
+func TestReadIndexIsLinearizable(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	old := c.waitLeader()
+	c.propose(old, "a=1")
+	if err := old.ReadIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.sms[old.ID()].get("a"); v != "1" {
+		t.Fatalf("read a=%q after ReadIndex, want 1", v)
+	}
+
+	// A leader that cannot reach a majority must not serve reads, since the
+	// others may elect a leader and commit newer writes meanwhile
+	rest := c.others(old.ID())
+	c.network.Partition([]string{old.ID()}, rest)
+	readErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*testElectionTimeout)
+		defer cancel()
+		readErr <- old.ReadIndex(ctx)
+	}()
+	leader := c.waitLeader(rest...)
+	c.propose(leader, "a=2")
+	if err := <-readErr; err == nil {
+		t.Fatal("ReadIndex succeeded on a leader cut off from the majority")
+	}
+
+	if err := leader.ReadIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.sms[leader.ID()].get("a"); v != "2" {
+		t.Fatalf("read a=%q after ReadIndex on the new leader, want 2", v)
+	}
+	if err := c.nodes[rest[0]].ReadIndex(context.Background()); err != nil && !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("ReadIndex on a follower returned %v, want ErrNotLeader", err)
+	}
+}
+
+func TestSnapshotInstalledOnLaggingFollower(t *testing.T) {
+	c := newTestCluster(t, 3, 5)
+	leader := c.waitLeader()
+	lagging := c.others(leader.ID())[0]
+
+	c.network.Partition([]string{lagging}, c.others(lagging))
+	for i := range 20 {
+		c.propose(leader, fmt.Sprintf("k%d=%d", i, i))
+	}
+	eventually(t, "the leader to compact its log", func() bool {
+		return leader.Status().SnapshotIndex > 1
+	})
+
+	c.network.Heal()
+	c.waitApplied("k19", "19", lagging)
+	for i := range 20 {
+		if v, _ := c.sms[lagging].get(fmt.Sprintf("k%d", i)); v != fmt.Sprint(i) {
+			t.Fatalf("lagging follower has k%d=%q, want %d", i, v, i)
+		}
+	}
+	if st := c.nodes[lagging].Status(); st.SnapshotIndex == 0 {
+		t.Fatal("lagging follower caught up without installing a snapshot")
+	}
+}
+
+func TestSnapshotRestoreFailureStopsNode(t *testing.T) {
+	c := newTestCluster(t, 3, 5)
+	leader := c.waitLeader()
+	lagging := c.others(leader.ID())[0]
+	c.sms[lagging].mu.Lock()
+	c.sms[lagging].failRestore = true
+	c.sms[lagging].mu.Unlock()
+
+	c.network.Partition([]string{lagging}, c.others(lagging))
+	for i := range 20 {
+		c.propose(leader, fmt.Sprintf("k%d=%d", i, i))
+	}
+	eventually(t, "the leader to compact its log", func() bool {
+		return leader.Status().SnapshotIndex > 1
+	})
+	c.network.Heal()
+
+	node := c.nodes[lagging]
+	select {
+	case <-node.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("node that failed to restore a snapshot kept running")
+	}
+	if err := node.Err(); !errors.Is(err, ErrStopped) || !strings.Contains(err.Error(), "restore failed") {
+		t.Fatalf("Err() = %v, want ErrStopped with the restore error", err)
+	}
+	// The rest of the group goes on without it
+	c.propose(leader, "after=1")
+}
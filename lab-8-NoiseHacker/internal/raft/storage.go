@@ -0,0 +1,380 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"tritontube/internal/proto"
+
+	gproto "google.golang.org/protobuf/proto"
+)
+
+// HardState is what a node must remember across restarts so it never votes
+// twice in one term
+type HardState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"votedFor"`
+}
+
+// Snapshot is the state machine as of log entry Index, and replaces the log up
+// to and including it
+type Snapshot struct {
+	Index uint64
+	Term  uint64
+	Data  []byte
+}
+
+// Storage persists a node's hard state, log and latest snapshot. Every method
+// returns only once its data is durable.
+type Storage interface {
+	// Load returns what was saved before: the hard state, the snapshot (nil if
+	// there is none) and the log entries after it. Empty storage returns zero
+	// values.
+	Load() (HardState, *Snapshot, []*proto.LogEntry, error)
+	SaveHardState(st HardState) error
+	// Append adds entries to the log. If the first one does not follow the
+	// last stored entry, the entries from its index on are replaced.
+	Append(entries []*proto.LogEntry) error
+	// SaveSnapshot stores snap and drops the entries it covers. The entries
+	// after it are kept only if the log agrees with snap about its last entry.
+	SaveSnapshot(snap *Snapshot) error
+}
+
+// FileStorage keeps a node's state in a directory: the hard state and the
+// snapshot in files that are replaced atomically, and the log in a file that
+// is appended to. A log record torn by a crash is dropped when loading.
+type FileStorage struct {
+	dir string
+
+	mu      sync.Mutex
+	log     *os.File
+	records []logRecord // one per stored entry, in order
+}
+
+type logRecord struct {
+	index  uint64
+	term   uint64
+	offset int64
+}
+
+const (
+	hardStateFile = "state.json"
+	snapshotFile  = "snapshot"
+	logFile       = "log"
+)
+
+var _ Storage = (*FileStorage)(nil)
+
+// NewFileStorage uses dir for a node's state, creating it if needed
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create raft dir: %w", err)
+	}
+	return &FileStorage{dir: dir}, nil
+}
+
+func (s *FileStorage) Load() (HardState, *Snapshot, []*proto.LogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var st HardState
+	data, err := os.ReadFile(filepath.Join(s.dir, hardStateFile))
+	if err == nil {
+		err = json.Unmarshal(data, &st)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return st, nil, nil, fmt.Errorf("failed to read raft state: %w", err)
+	}
+
+	var snap *Snapshot
+	data, err = os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if err == nil {
+		var msg proto.InstallSnapshotRequest
+		if err := gproto.Unmarshal(data, &msg); err != nil {
+			return st, nil, nil, fmt.Errorf("failed to read raft snapshot: %w", err)
+		}
+		snap = &Snapshot{Index: msg.LastIncludedIndex, Term: msg.LastIncludedTerm, Data: msg.Data}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return st, nil, nil, fmt.Errorf("failed to read raft snapshot: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(s.dir, logFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return st, nil, nil, fmt.Errorf("failed to open raft log: %w", err)
+	}
+	entries, records, end := readLog(file)
+	// Drops the torn record a crash may have left behind
+	if err := file.Truncate(end); err != nil {
+		file.Close()
+		return st, nil, nil, fmt.Errorf("failed to repair raft log: %w", err)
+	}
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		file.Close()
+		return st, nil, nil, err
+	}
+	if s.log != nil {
+		s.log.Close()
+	}
+	s.log = file
+	s.records = records
+
+	// A crash between saving a snapshot and compacting the log leaves entries
+	// the snapshot already covers
+	if snap != nil {
+		i := 0
+		for i < len(entries) && entries[i].Index <= snap.Index {
+			i++
+		}
+		entries = entries[i:]
+	}
+	return st, snap, entries, nil
+}
+
+// Reads records up to the first one that is incomplete or corrupt, and
+// returns the offset it starts at
+func readLog(file *os.File) ([]*proto.LogEntry, []logRecord, int64) {
+	r := bufio.NewReader(file)
+	var entries []*proto.LogEntry
+	var records []logRecord
+	var offset int64
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return entries, records, offset
+		}
+		size := binary.BigEndian.Uint32(header[:4])
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return entries, records, offset
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			return entries, records, offset
+		}
+		var e proto.LogEntry
+		if err := gproto.Unmarshal(payload, &e); err != nil {
+			return entries, records, offset
+		}
+		entries = append(entries, &e)
+		records = append(records, logRecord{index: e.Index, term: e.Term, offset: offset})
+		offset += int64(len(header)) + int64(size)
+	}
+}
+
+func (s *FileStorage) SaveHardState(st HardState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFileAtomic(filepath.Join(s.dir, hardStateFile), data)
+}
+
+func (s *FileStorage) Append(entries []*proto.LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return errors.New("raft log is not loaded")
+	}
+
+	if pos := s.position(entries[0].Index); pos < len(s.records) {
+		offset := s.records[pos].offset
+		if err := s.log.Truncate(offset); err != nil {
+			return err
+		}
+		if _, err := s.log.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		s.records = s.records[:pos]
+	}
+
+	offset, err := s.log.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	var buf []byte
+	for _, e := range entries {
+		payload, err := gproto.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+		buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+		s.records = append(s.records, logRecord{index: e.Index, term: e.Term, offset: offset + int64(len(buf)) - 8})
+		buf = append(buf, payload...)
+	}
+	if _, err := s.log.Write(buf); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+// Returns the position of the record for index, or len(s.records) if it is
+// past the last one
+func (s *FileStorage) position(index uint64) int {
+	if len(s.records) == 0 || index < s.records[0].index {
+		return 0
+	}
+	return min(int(index-s.records[0].index), len(s.records))
+}
+
+func (s *FileStorage) SaveSnapshot(snap *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return errors.New("raft log is not loaded")
+	}
+
+	data, err := gproto.Marshal(&proto.InstallSnapshotRequest{LastIncludedIndex: snap.Index, LastIncludedTerm: snap.Term, Data: snap.Data})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, snapshotFile), data); err != nil {
+		return fmt.Errorf("failed to save raft snapshot: %w", err)
+	}
+
+	// Rewrites the log with only the entries after the snapshot
+	var kept []byte
+	var records []logRecord
+	pos := s.position(snap.Index)
+	if pos < len(s.records) && s.records[pos].index == snap.Index && s.records[pos].term == snap.Term && pos+1 < len(s.records) {
+		start := s.records[pos+1].offset
+		end, err := s.log.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		kept = make([]byte, end-start)
+		if _, err := s.log.ReadAt(kept, start); err != nil {
+			return err
+		}
+		for _, rec := range s.records[pos+1:] {
+			rec.offset -= start
+			records = append(records, rec)
+		}
+	}
+	path := filepath.Join(s.dir, logFile)
+	if err := writeFileAtomic(path, kept); err != nil {
+		return fmt.Errorf("failed to compact raft log: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return err
+	}
+	s.log.Close()
+	s.log = file
+	s.records = records
+	return nil
+}
+
+// Close releases the log file
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return nil
+	}
+	err := s.log.Close()
+	s.log = nil
+	return err
+}
+
+// Replaces path with data so that a crash leaves either the old or the new
+// contents
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// MemoryStorage keeps a node's state in memory. Handing the same MemoryStorage
+// to a new Node simulates a restart, for tests.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	st      HardState
+	snap    *Snapshot
+	entries []*proto.LogEntry
+}
+
+var _ Storage = (*MemoryStorage)(nil)
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) Load() (HardState, *Snapshot, []*proto.LogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.st, s.snap, append([]*proto.LogEntry(nil), s.entries...), nil
+}
+
+func (s *MemoryStorage) SaveHardState(st HardState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.st = st
+	return nil
+}
+
+func (s *MemoryStorage) Append(entries []*proto.LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := 0
+	for i < len(s.entries) && s.entries[i].Index < entries[0].Index {
+		i++
+	}
+	s.entries = append(s.entries[:i:i], entries...)
+	return nil
+}
+
+func (s *MemoryStorage) SaveSnapshot(snap *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snap = snap
+	for i, e := range s.entries {
+		if e.Index == snap.Index && e.Term == snap.Term {
+			s.entries = append([]*proto.LogEntry(nil), s.entries[i+1:]...)
+			return nil
+		}
+	}
+	s.entries = nil
+	return nil
+}
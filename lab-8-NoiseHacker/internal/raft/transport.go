@@ -0,0 +1,218 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"tritontube/internal/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	gproto "google.golang.org/protobuf/proto"
+)
+
+// Metadata snapshots travel in one message, so gRPC's 4 MB default is raised
+const maxMessageSize = 256 << 20
+
+// GRPCTransport reaches peers over the RaftService, using their IDs as
+// addresses
+type GRPCTransport struct {
+	mu      sync.Mutex
+	clients map[string]proto.RaftServiceClient
+}
+
+var _ Transport = (*GRPCTransport)(nil)
+
+func NewGRPCTransport() *GRPCTransport {
+	return &GRPCTransport{clients: make(map[string]proto.RaftServiceClient)}
+}
+
+// Connects lazily, so peers that are not up yet are retried on every call
+func (t *GRPCTransport) client(peer string) (proto.RaftServiceClient, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok := t.clients[peer]; ok {
+		return c, nil
+	}
+	conn, err := grpc.NewClient(peer,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMessageSize), grpc.MaxCallSendMsgSize(maxMessageSize)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to raft peer %s: %w", peer, err)
+	}
+	c := proto.NewRaftServiceClient(conn)
+	t.clients[peer] = c
+	return c, nil
+}
+
+func (t *GRPCTransport) RequestVote(ctx context.Context, peer string, req *proto.VoteRequest) (*proto.VoteResponse, error) {
+	c, err := t.client(peer)
+	if err != nil {
+		return nil, err
+	}
+	return c.RequestVote(ctx, req)
+}
+
+func (t *GRPCTransport) AppendEntries(ctx context.Context, peer string, req *proto.AppendEntriesRequest) (*proto.AppendEntriesResponse, error) {
+	c, err := t.client(peer)
+	if err != nil {
+		return nil, err
+	}
+	return c.AppendEntries(ctx, req)
+}
+
+func (t *GRPCTransport) InstallSnapshot(ctx context.Context, peer string, req *proto.InstallSnapshotRequest) (*proto.InstallSnapshotResponse, error) {
+	c, err := t.client(peer)
+	if err != nil {
+		return nil, err
+	}
+	return c.InstallSnapshot(ctx, req)
+}
+
+// GRPCServer serves a node's side of the RaftService
+type GRPCServer struct {
+	node *Node
+	proto.UnimplementedRaftServiceServer
+}
+
+func NewGRPCServer(node *Node) *GRPCServer {
+	return &GRPCServer{node: node}
+}
+
+// ServerOptions are the options a gRPC server serving a GRPCServer needs
+func ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{grpc.MaxRecvMsgSize(maxMessageSize), grpc.MaxSendMsgSize(maxMessageSize)}
+}
+
+func (s *GRPCServer) RequestVote(ctx context.Context, req *proto.VoteRequest) (*proto.VoteResponse, error) {
+	return s.node.HandleRequestVote(req)
+}
+
+func (s *GRPCServer) AppendEntries(ctx context.Context, req *proto.AppendEntriesRequest) (*proto.AppendEntriesResponse, error) {
+	return s.node.HandleAppendEntries(req)
+}
+
+func (s *GRPCServer) InstallSnapshot(ctx context.Context, req *proto.InstallSnapshotRequest) (*proto.InstallSnapshotResponse, error) {
+	return s.node.HandleInstallSnapshot(req)
+}
+
+// ErrUnreachable is returned by a MemNetwork transport for a peer that is
+// partitioned away or not on the network
+var ErrUnreachable = errors.New("raft: peer unreachable")
+
+// MemNetwork connects the nodes of an in-process group, for tests. Links
+// between nodes can be cut to inject partitions, and nodes removed to simulate
+// crashes.
+type MemNetwork struct {
+	mu    sync.Mutex
+	nodes map[string]*Node
+	cut   map[[2]string]bool // by sender and receiver
+}
+
+func NewMemNetwork() *MemNetwork {
+	return &MemNetwork{nodes: make(map[string]*Node), cut: make(map[[2]string]bool)}
+}
+
+// Transport returns the transport the node id sends with
+func (m *MemNetwork) Transport(id string) Transport {
+	return &memTransport{network: m, from: id}
+}
+
+// Add puts a node on the network, replacing any earlier node with its ID
+func (m *MemNetwork) Add(node *Node) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodes[node.ID()] = node
+}
+
+// Remove takes a node off the network, as if it crashed
+func (m *MemNetwork) Remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.nodes, id)
+}
+
+// Partition splits the network into groups whose nodes can only reach each
+// other. Nodes not in any group are cut off from everyone.
+func (m *MemNetwork) Partition(groups ...[]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	group := make(map[string]int)
+	for i, g := range groups {
+		for _, id := range g {
+			group[id] = i + 1
+		}
+	}
+	m.cut = make(map[[2]string]bool)
+	for a := range m.nodes {
+		for b := range m.nodes {
+			if a != b && (group[a] == 0 || group[a] != group[b]) {
+				m.cut[[2]string{a, b}] = true
+			}
+		}
+	}
+}
+
+// Cut drops the messages from one node to another, leaving the other
+// direction alone
+func (m *MemNetwork) Cut(from, to string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cut[[2]string{from, to}] = true
+}
+
+// Heal restores every link
+func (m *MemNetwork) Heal() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cut = make(map[[2]string]bool)
+}
+
+// Returns the node from can reach at to. Both directions must be up, since a
+// reply needs to get back.
+func (m *MemNetwork) route(from, to string) (*Node, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node, ok := m.nodes[to]
+	if !ok || m.nodes[from] == nil || m.cut[[2]string{from, to}] || m.cut[[2]string{to, from}] {
+		return nil, ErrUnreachable
+	}
+	return node, nil
+}
+
+type memTransport struct {
+	network *MemNetwork
+	from    string
+}
+
+// Messages are copied on the way, as they would be on a real network
+func memCall[Req, Resp gproto.Message](ctx context.Context, t *memTransport, peer string, req Req, handle func(*Node, Req) (Resp, error)) (Resp, error) {
+	var zero Resp
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	node, err := t.network.route(t.from, peer)
+	if err != nil {
+		return zero, err
+	}
+	resp, err := handle(node, gproto.Clone(req).(Req))
+	if err != nil {
+		return zero, err
+	}
+	return gproto.Clone(resp).(Resp), nil
+}
+
+func (t *memTransport) RequestVote(ctx context.Context, peer string, req *proto.VoteRequest) (*proto.VoteResponse, error) {
+	return memCall(ctx, t, peer, req, (*Node).HandleRequestVote)
+}
+
+func (t *memTransport) AppendEntries(ctx context.Context, peer string, req *proto.AppendEntriesRequest) (*proto.AppendEntriesResponse, error) {
+	return memCall(ctx, t, peer, req, (*Node).HandleAppendEntries)
+}
+
+func (t *memTransport) InstallSnapshot(ctx context.Context, peer string, req *proto.InstallSnapshotRequest) (*proto.InstallSnapshotResponse, error) {
+	return memCall(ctx, t, peer, req, (*Node).HandleInstallSnapshot)
+}
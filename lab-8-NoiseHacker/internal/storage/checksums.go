@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"tritontube/internal/contentkey"
+	"tritontube/internal/proto"
+)
+
+// checksumLogName is the file plain stores keep their checksums in. Its
+// leading dot keeps it from being taken for a video directory.
+const checksumLogName = ".checksums"
+
+// Deepest Merkle tree served; 2^13-1 hashes are 256 KB
+const maxMerkleDepth = 12
+
+type checksumEntry struct {
+	sum  string // hex SHA-256 of the content
+	hash uint64 // position of the key on the ring, see contentkey.Hash
+}
+
+// checksums holds the SHA-256 of every published file as it was written, so a
+// scrub can tell content that changed on disk from content that was written
+// that way. It is safe for concurrent use.
+//
+// Plain stores append each change to a log that is compacted on open. A
+// deduplicating store needs no log, since its refs hold the digests already.
+// Whether a file failed its scrub is only kept in memory: after a restart the
+// next scrub finds it again.
+type checksums struct {
+	mu      sync.Mutex
+	entries map[string]checksumEntry     // by key
+	staged  map[string]map[string]string // sums of staged files by transaction, then key
+	corrupt map[string]bool              // keys whose file failed the last scrub
+
+	logPath  string // "" for a deduplicating store
+	log      *os.File
+	logLines int
+}
+
+func newChecksums(sums map[string]string) *checksums {
+	c := &checksums{
+		entries: make(map[string]checksumEntry, len(sums)),
+		staged:  make(map[string]map[string]string),
+		corrupt: make(map[string]bool),
+	}
+	for key, sum := range sums {
+		c.entries[key] = checksumEntry{sum: sum, hash: contentkey.Hash(key)}
+	}
+	return c
+}
+
+// Loads the checksum log at path, if any, and compacts it. A log line is
+// "<sha256> <key>" for a file written and "- <key>" for one deleted; a torn
+// last line from a crash is ignored.
+func openChecksumLog(path string) (*checksums, error) {
+	sums := make(map[string]string)
+	f, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if f != nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			sum, key, ok := strings.Cut(scanner.Text(), " ")
+			if !ok {
+				continue
+			}
+			if sum == "-" {
+				delete(sums, key)
+			} else if len(sum) == sha256.Size*2 {
+				sums[key] = sum
+			}
+		}
+		err := scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	c := newChecksums(sums)
+	c.logPath = path
+	if err := c.compactLocked(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Rewrites the log with one line per file; callers must hold c.mu unless c is
+// not shared yet
+func (c *checksums) compactLocked() error {
+	tmp, err := os.CreateTemp(filepath.Dir(c.logPath), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for _, key := range slices.Sorted(maps.Keys(c.entries)) {
+		fmt.Fprintf(w, "%s %s\n", c.entries[key].sum, key)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.logPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write %s: %w", c.logPath, err)
+	}
+	if c.log != nil {
+		c.log.Close()
+	}
+	c.log, err = os.OpenFile(c.logPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	c.logLines = len(c.entries)
+	return nil
+}
+
+// Appends a change to the log. The entries in memory stay right if that
+// fails, and a scrub after a restart records files that lost their line.
+func (c *checksums) appendLocked(sum, key string) {
+	if c.log == nil {
+		return
+	}
+	if c.logLines > 2*len(c.entries)+1024 {
+		if err := c.compactLocked(); err != nil {
+			slog.Error("Failed to compact checksums", "err", err)
+		}
+	}
+	if _, err := fmt.Fprintf(c.log, "%s %s\n", sum, key); err != nil {
+		slog.Error("Failed to record checksum", "key", key, "err", err)
+		return
+	}
+	c.logLines++
+}
+
+// Records the checksum of a file just published, which clears a failed scrub
+func (c *checksums) set(key, sum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = checksumEntry{sum: sum, hash: contentkey.Hash(key)}
+	delete(c.corrupt, key)
+	c.appendLocked(sum, key)
+}
+
+// Records the checksum of a file a scrub found without one, unless a write
+// recorded one since. Reports whether it did.
+func (c *checksums) adopt(key, sum string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return false
+	}
+	c.entries[key] = checksumEntry{sum: sum, hash: contentkey.Hash(key)}
+	c.appendLocked(sum, key)
+	return true
+}
+
+func (c *checksums) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		return
+	}
+	delete(c.entries, key)
+	delete(c.corrupt, key)
+	c.appendLocked("-", key)
+}
+
+// Returns the checksum recorded for key
+func (c *checksums) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	return entry.sum, ok
+}
+
+// Keeps the checksum of a staged file until its transaction commits
+func (c *checksums) stage(txID, key, sum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.staged[txID] == nil {
+		c.staged[txID] = make(map[string]string)
+	}
+	c.staged[txID][key] = sum
+}
+
+// Records the checksums of the files a transaction published and returns the
+// keys it has none for, staged before a restart, for the caller to hash
+func (c *checksums) commit(txID string, keys []string) []string {
+	c.mu.Lock()
+	sums := c.staged[txID]
+	delete(c.staged, txID)
+	c.mu.Unlock()
+	var unknown []string
+	for _, key := range keys {
+		if sum, ok := sums[key]; ok {
+			c.set(key, sum)
+		} else {
+			unknown = append(unknown, key)
+		}
+	}
+	return unknown
+}
+
+func (c *checksums) discard(txID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.staged, txID)
+}
+
+func (c *checksums) markCorrupt(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.corrupt[key] = true
+}
+
+func (c *checksums) clearCorrupt(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.corrupt, key)
+}
+
+func (c *checksums) isCorrupt(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.corrupt[key]
+}
+
+func (c *checksums) corruptCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.corrupt)
+}
+
+// Returns the keys with a checksum that start with prefix
+func (c *checksums) keys(prefix string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var keys []string
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Returns the leaves of a tree of depth with the keys in ranges that did not
+// fail their scrub, each sorted by key
+func (c *checksums) leaves(ranges []*proto.HashRange, depth int) [][]*proto.KeyChecksum {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	leaves := make([][]*proto.KeyChecksum, 1<<depth)
+	for key, entry := range c.entries {
+		if c.corrupt[key] || !inRanges(entry.hash, ranges) {
+			continue
+		}
+		leaf := entry.hash >> (64 - depth)
+		leaves[leaf] = append(leaves[leaf], &proto.KeyChecksum{Key: key, Sha256: entry.sum})
+	}
+	for _, leaf := range leaves {
+		slices.SortFunc(leaf, func(a, b *proto.KeyChecksum) int {
+			return strings.Compare(a.Key, b.Key)
+		})
+	}
+	return leaves
+}
+
+func inRanges(hash uint64, ranges []*proto.HashRange) bool {
+	for _, r := range ranges {
+		if contentkey.InRange(hash, r.Start, r.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// Builds the Merkle tree over the keys in ranges, in heap order: a leaf hashes
+// its keys with their checksums, or the keys alone with keysOnly, and every
+// other node hashes its two children
+func (c *checksums) merkleTree(ranges []*proto.HashRange, depth int, keysOnly bool) ([][]byte, int64) {
+	leaves := c.leaves(ranges, depth)
+	nodes := make([][]byte, 2<<depth-1)
+	firstLeaf := 1<<depth - 1
+	var count int64
+	for i, leaf := range leaves {
+		hash := sha256.New()
+		for _, entry := range leaf {
+			hash.Write([]byte(entry.Key))
+			if !keysOnly {
+				hash.Write([]byte{0})
+				hash.Write([]byte(entry.Sha256))
+			}
+			hash.Write([]byte{'\n'})
+		}
+		nodes[firstLeaf+i] = hash.Sum(nil)
+		count += int64(len(leaf))
+	}
+	for i := firstLeaf - 1; i >= 0; i-- {
+		sum := sha256.Sum256(append(slices.Clone(nodes[2*i+1]), nodes[2*i+2]...))
+		nodes[i] = sum[:]
+	}
+	return nodes, count
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package storage
+
+import "errors"
+
+func diskSpace(dir string) (total int64, free int64, err error) {
+	return 0, 0, errors.New("disk space is not available on this platform")
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin
+
+package storage
+
+import "syscall"
+
+// Returns the size of the filesystem holding dir and the space left on it for
+// unprivileged users
+func diskSpace(dir string) (total int64, free int64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, 0, err
+	}
+	return int64(st.Blocks) * int64(st.Bsize), int64(st.Bavail) * int64(st.Bsize), nil
+}
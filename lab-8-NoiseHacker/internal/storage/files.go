@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+	"tritontube/internal/cas"
+	"tritontube/internal/contentkey"
+)
+
+// fileStore is where a StorageHandler keeps its files: plain files under the
+// base directory, or a deduplicating cas.Store
+type fileStore interface {
+	WriteFrom(k contentkey.Key, r io.Reader) error
+	ReadFile(k contentkey.Key) ([]byte, error)
+	Open(k contentkey.Key) (*os.File, error)
+	Remove(k contentkey.Key) error
+	StageFrom(txID string, k contentkey.Key, r io.Reader) error
+	CommitStaged(txID string) (int, error)
+	AbortStaged(txID string) error
+	// RefsDir is laid out as {videoId}/{filename}, for listing keys
+	RefsDir() string
+}
+
+var (
+	_ fileStore = plainFiles("")
+	_ fileStore = (*cas.Store)(nil)
+)
+
+// plainFiles stores every file as it is under a directory
+type plainFiles string
+
+func (d plainFiles) WriteFrom(k contentkey.Key, r io.Reader) error {
+	return contentkey.WriteFrom(string(d), k, r)
+}
+
+func (d plainFiles) ReadFile(k contentkey.Key) ([]byte, error) {
+	return contentkey.ReadFile(string(d), k)
+}
+
+func (d plainFiles) Open(k contentkey.Key) (*os.File, error) {
+	return contentkey.Open(string(d), k)
+}
+
+func (d plainFiles) Remove(k contentkey.Key) error {
+	return contentkey.Remove(string(d), k)
+}
+
+func (d plainFiles) StageFrom(txID string, k contentkey.Key, r io.Reader) error {
+	return contentkey.StageFrom(string(d), txID, k, r)
+}
+
+func (d plainFiles) CommitStaged(txID string) (int, error) {
+	return contentkey.CommitStaged(string(d), txID)
+}
+
+func (d plainFiles) AbortStaged(txID string) error {
+	return contentkey.AbortStaged(string(d), txID)
+}
+
+func (d plainFiles) RefsDir() string {
+	return string(d)
+}
+
+// CollectGarbage removes the blobs no file refers to any more once per
+// interval. It blocks, so run it in its own goroutine; without dedup it
+// returns right away.
+func (h *StorageHandler) CollectGarbage(interval time.Duration) {
+	if h.blobs == nil {
+		return
+	}
+	for range time.Tick(interval) {
+		result, err := h.blobs.GC()
+		if err != nil {
+			slog.Error("Blob GC failed", "err", err)
+		}
+		if result.Blobs > 0 {
+			slog.Info("Blob GC removed blobs", "blobs", result.Blobs, "bytes", result.Bytes)
+		}
+		blobGCRemoved.Add(float64(result.Bytes))
+	}
+}
+
+// Check verifies the deduplicating store, see cas.Store.Check, and recounts
+// the node's usage
+func (h *StorageHandler) Check() (*cas.CheckReport, error) {
+	if h.blobs == nil {
+		return nil, errors.New("only deduplicating stores can be checked")
+	}
+	report, err := h.blobs.Check()
+	if err == nil {
+		h.recount()
+	}
+	return report, err
+}
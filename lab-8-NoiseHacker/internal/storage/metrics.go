@@ -0,0 +1,68 @@
+// Prometheus metrics of a storage node
+
+package storage
+
+import (
+	"tritontube/internal/proto"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	scrubs = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tritontube_scrubs_total",
+		Help: "Scrubs that finished, periodic and requested ones.",
+	})
+	scrubbedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tritontube_scrub_bytes_total",
+		Help: "Bytes scrubs read back.",
+	})
+	scrubbedFiles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tritontube_scrub_files_total",
+		Help: "Files scrubs looked at, by result: checked, corrupt, missing, or unrecorded if they had no checksum yet.",
+	}, []string{"result"})
+	blobGCRemoved = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tritontube_blob_gc_removed_bytes_total",
+		Help: "Bytes of unreferenced blobs the blob GC removed with -dedup.",
+	})
+)
+
+// Records the results of a scrub
+func observeScrub(response *proto.ScrubResponse) {
+	scrubs.Inc()
+	scrubbedBytes.Add(float64(response.ByteCount))
+	scrubbedFiles.WithLabelValues("checked").Add(float64(response.FileCount))
+	scrubbedFiles.WithLabelValues("corrupt").Add(float64(len(response.Corrupt)))
+	scrubbedFiles.WithLabelValues("missing").Add(float64(len(response.Missing)))
+	scrubbedFiles.WithLabelValues("unrecorded").Add(float64(response.UnrecordedCount))
+}
+
+var (
+	usedBytesDesc = prometheus.NewDesc("tritontube_storage_used_bytes",
+		"Bytes of files stored, staged ones and writes in progress included.", nil, nil)
+	quotaBytesDesc = prometheus.NewDesc("tritontube_storage_quota_bytes",
+		"Bytes this node may store, 0 for no limit but the disk's.", nil, nil)
+	fileCountDesc = prometheus.NewDesc("tritontube_storage_files",
+		"Published files.", nil, nil)
+	corruptFilesDesc = prometheus.NewDesc("tritontube_storage_corrupt_files",
+		"Files that failed their last scrub and are not served.", nil, nil)
+)
+
+// Describe implements prometheus.Collector, so the node's usage can be
+// registered with a Prometheus registry
+func (h *StorageHandler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usedBytesDesc
+	ch <- quotaBytesDesc
+	ch <- fileCountDesc
+	ch <- corruptFilesDesc
+}
+
+// Collect implements prometheus.Collector with the node's current usage
+func (h *StorageHandler) Collect(ch chan<- prometheus.Metric) {
+	used, files := h.usage.snapshot()
+	ch <- prometheus.MustNewConstMetric(usedBytesDesc, prometheus.GaugeValue, float64(used))
+	ch <- prometheus.MustNewConstMetric(quotaBytesDesc, prometheus.GaugeValue, float64(h.usage.quota))
+	ch <- prometheus.MustNewConstMetric(fileCountDesc, prometheus.GaugeValue, float64(files))
+	ch <- prometheus.MustNewConstMetric(corruptFilesDesc, prometheus.GaugeValue, float64(h.sums.corruptCount()))
+}
@@ -0,0 +1,209 @@
+// Capacity accounting for a storage node.
+//
+// The handler keeps a running count of the bytes and files under its base
+// directory. Writes reserve their bytes as they arrive and fail with
+// RESOURCE_EXHAUSTED once the count would pass the quota, so a node stops
+// taking data before its disk fills. Finished writes, deletes, commits and
+// aborts adjust the count, so Stats costs nothing however many files a node
+// holds. It is only rebuilt from the directory on startup, on a check of the
+// store and after a commit or abort that failed halfway; files changed behind
+// the handler's back are picked up the next time.
+//
+// A deduplicating store takes its byte count from the blobs instead, since
+// writing content that is already stored takes no more space.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"tritontube/internal/contentkey"
+	"tritontube/internal/proto"
+)
+
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+type usage struct {
+	quota     int64        // 0 for no quota
+	blobBytes func() int64 // bytes stored by a deduplicating store, nil without dedup
+
+	mu       sync.Mutex
+	used     int64 // bytes of finished files, staged ones included
+	inflight int64 // bytes reserved by writes still in progress
+	files    int64 // published files
+}
+
+// Returns the bytes stored; callers must hold u.mu
+func (u *usage) usedLocked() int64 {
+	if u.blobBytes != nil {
+		return u.blobBytes()
+	}
+	return u.used
+}
+
+// Reserves n bytes for a write in progress, or fails if they would not fit
+func (u *usage) reserve(n int64) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if used := u.usedLocked() + u.inflight; u.quota > 0 && used+n > u.quota {
+		return fmt.Errorf("%w: %d of %d bytes used, %d more requested", errQuotaExceeded, used, u.quota, n)
+	}
+	u.inflight += n
+	return nil
+}
+
+// Releases the reservation of a finished write and accounts for its outcome:
+// written bytes stored, replacing a file of replaced bytes. A failed write
+// passes zeros.
+func (u *usage) settle(reserved, written, replaced int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.inflight -= reserved
+	u.used += written - replaced
+}
+
+// Accounts for bytes and files stored, or freed if negative, other than by a
+// write: deletes, and transactions published or discarded
+func (u *usage) adjust(bytes, files int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.used += bytes
+	u.files += files
+}
+
+// Replaces the running count with what is actually under baseDir, whose
+// published files are under refsDir
+func (u *usage) recount(baseDir, refsDir string) error {
+	used, files, err := scanDir(baseDir, refsDir)
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.used = used
+	u.files = files
+	return nil
+}
+
+func (u *usage) snapshot() (used int64, files int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.usedLocked() + u.inflight, u.files
+}
+
+// Returns the bytes of the files under baseDir and how many files are
+// published under refsDir. Temp files of writes in progress are left out,
+// since their bytes are still reserved, and so is the checksum log, which
+// grows with every write without the running count seeing it.
+func scanDir(baseDir, refsDir string) (int64, int64, error) {
+	var used, files int64
+	checksumLog := filepath.Join(baseDir, checksumLogName)
+	err := filepath.WalkDir(baseDir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			// Nothing written yet, or removed while walking
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") || path == checksumLog {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		used += info.Size()
+		// Staged files, the checksum log and whatever is outside refsDir all
+		// start with a dot
+		rel, err := filepath.Rel(refsDir, path)
+		if err == nil && !strings.HasPrefix(rel, ".") {
+			files++
+		}
+		return nil
+	})
+	return used, files, err
+}
+
+// Returns the size of the file published for k, and whether there is one
+func (h *StorageHandler) fileSize(k contentkey.Key) (int64, bool) {
+	info, err := os.Stat(k.Path(h.files.RefsDir()))
+	if err != nil {
+		return 0, false
+	}
+	if h.blobs != nil {
+		// Removing or replacing a ref frees nothing until GC
+		return 0, true
+	}
+	return info.Size(), true
+}
+
+// Returns the bytes of the files staged under txID
+func (h *StorageHandler) stagedSize(txID string) int64 {
+	if h.blobs != nil {
+		return 0
+	}
+	dir, err := contentkey.StagingDir(h.baseDir, txID)
+	if err != nil {
+		return 0
+	}
+	keys, _ := contentkey.StagedKeys(h.baseDir, txID)
+	var size int64
+	for _, k := range keys {
+		if info, err := os.Stat(k.Path(dir)); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// quotaReader reserves the bytes it reads, failing once the quota is reached
+type quotaReader struct {
+	r        io.Reader
+	usage    *usage
+	reserved int64
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	if n > 0 {
+		if rerr := q.usage.reserve(int64(n)); rerr != nil {
+			return 0, rerr
+		}
+		q.reserved += int64(n)
+	}
+	return n, err
+}
+
+func (h *StorageHandler) Stats(ctx context.Context, req *proto.StatsRequest) (*proto.StatsResponse, error) {
+	used, files := h.usage.snapshot()
+	response := &proto.StatsResponse{
+		CapacityBytes: h.usage.quota,
+		UsedBytes:     used,
+		QuotaBytes:    h.usage.quota,
+		FileCount:     files,
+	}
+	// The base directory may not exist before the first write
+	dir := h.baseDir
+	if _, err := os.Stat(dir); err != nil {
+		dir = filepath.Dir(dir)
+	}
+	if total, free, err := diskSpace(dir); err == nil {
+		response.DiskTotalBytes = total
+		response.DiskFreeBytes = free
+		if response.CapacityBytes == 0 {
+			response.CapacityBytes = total
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"tritontube/internal/proto"
+
+	"google.golang.org/grpc"
+)
+
+// chunkStream feeds WriteFileStream the chunks of one file
+type chunkStream struct {
+	grpc.ServerStream
+	chunks []*proto.WriteFileChunk
+}
+
+func (s *chunkStream) Recv() (*proto.WriteFileChunk, error) {
+	if len(s.chunks) == 0 {
+		return nil, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (s *chunkStream) SendAndClose(*proto.WriteFileResponse) error { return nil }
+
+func writeFile(t *testing.T, h *StorageHandler, key, data string) {
+	t.Helper()
+	if _, err := h.WriteFile(context.Background(), &proto.WriteFileRequest{Key: key, Data: []byte(data)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func stageFile(t *testing.T, h *StorageHandler, txID, key, data string) {
+	t.Helper()
+	stream := &chunkStream{chunks: []*proto.WriteFileChunk{{Key: key, Data: []byte(data), TxId: txID}}}
+	if err := h.WriteFileStream(stream); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Checks the usage Stats reports, and that it is what a recount finds
+func checkStats(t *testing.T, h *StorageHandler, wantBytes, wantFiles int64) {
+	t.Helper()
+	stats, err := h.Stats(context.Background(), &proto.StatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.UsedBytes != wantBytes || stats.FileCount != wantFiles {
+		t.Fatalf("Stats reports %d bytes in %d files, want %d in %d", stats.UsedBytes, stats.FileCount, wantBytes, wantFiles)
+	}
+	if h.blobs != nil {
+		return
+	}
+	used, files, err := scanDir(h.baseDir, h.files.RefsDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != wantBytes || files != wantFiles {
+		t.Fatalf("a recount finds %d bytes in %d files, want %d in %d", used, files, wantBytes, wantFiles)
+	}
+}
+
+func TestUsageIsCountedWithoutRescanning(t *testing.T) {
+	dir := t.TempDir()
+	h, err := NewStorageHandler(dir, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, h, 0, 0)
+
+	writeFile(t, h, "video/a.m4s", "12345")
+	writeFile(t, h, "video/b.m4s", "123")
+	checkStats(t, h, 8, 2)
+	writeFile(t, h, "video/a.m4s", "1234567")
+	checkStats(t, h, 10, 2)
+
+	// Staged files count as bytes, and as files once published
+	stageFile(t, h, "tx1", "video/b.m4s", "12345678")
+	stageFile(t, h, "tx1", "video/c.m4s", "1")
+	checkStats(t, h, 19, 2)
+	if _, err := h.CommitTransaction(context.Background(), &proto.TransactionRequest{TxId: "tx1"}); err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, h, 16, 3)
+
+	stageFile(t, h, "tx2", "video/d.m4s", "1234")
+	if _, err := h.AbortTransaction(context.Background(), &proto.TransactionRequest{TxId: "tx2"}); err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, h, 16, 3)
+
+	if _, err := h.DeleteFile(context.Background(), &proto.DeleteFileRequest{Key: "video/c.m4s"}); err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, h, 15, 2)
+
+	// Files changed behind the handler's back are picked up on the next open
+	if err := os.WriteFile(filepath.Join(dir, "video", "e.m4s"), []byte("12"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := h.Stats(context.Background(), &proto.StatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.FileCount != 2 {
+		t.Fatalf("Stats rescanned the directory, finding %d files", stats.FileCount)
+	}
+	reopened, err := NewStorageHandler(dir, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, reopened, 17, 3)
+}
+
+func TestDedupUsageCountsBlobs(t *testing.T) {
+	h, err := NewStorageHandler(t.TempDir(), 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, h, "video-a/init-0.m4s", "shared init")
+	writeFile(t, h, "video-b/init-0.m4s", "shared init")
+	stageFile(t, h, "tx", "video-c/init-0.m4s", "shared init")
+	checkStats(t, h, int64(len("shared init")), 2)
+	if _, err := h.CommitTransaction(context.Background(), &proto.TransactionRequest{TxId: "tx"}); err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, h, int64(len("shared init")), 3)
+
+	for _, key := range []string{"video-a/init-0.m4s", "video-b/init-0.m4s", "video-c/init-0.m4s"} {
+		if _, err := h.DeleteFile(context.Background(), &proto.DeleteFileRequest{Key: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The blob takes its space until GC
+	checkStats(t, h, int64(len("shared init")), 0)
+	if _, err := h.blobs.GC(); err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, h, 0, 0)
+}
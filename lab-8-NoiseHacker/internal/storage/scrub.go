@@ -0,0 +1,155 @@
+// Scrubbing, and the Merkle trees the web tier compares storage nodes with to
+// repair keys that went missing or differ between them
+
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+	"tritontube/internal/contentkey"
+	"tritontube/internal/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Scrub reads back every file under the prefix and compares it with the
+// checksum recorded when it was written. Files that fail are kept for
+// inspection but not served any more; files that lost their checksum, such as
+// ones written before checksums were kept, are recorded as they are now.
+func (h *StorageHandler) Scrub(ctx context.Context, req *proto.ScrubRequest) (*proto.ScrubResponse, error) {
+	listed, err := h.ListKeys(ctx, &proto.ListKeysRequest{Prefix: req.Prefix})
+	if err != nil {
+		return nil, err
+	}
+	response := &proto.ScrubResponse{}
+	listedKeys := make(map[string]bool, len(listed.Keys))
+	for _, key := range listed.Keys {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		listedKeys[key] = true
+		k, err := contentkey.Parse(key)
+		if err != nil {
+			continue
+		}
+		recorded, ok := h.sums.get(key)
+		data, err := h.files.ReadFile(k)
+		if errors.Is(err, os.ErrNotExist) {
+			// Deleted since the listing, or a ref whose blob is gone; the
+			// loop below tells them apart
+			delete(listedKeys, key)
+			continue
+		}
+		response.FileCount++
+		response.ByteCount += int64(len(data))
+		if err != nil {
+			slog.ErrorContext(ctx, "Scrub failed to read file", "key", key, "err", err)
+			h.sums.markCorrupt(key)
+			response.Corrupt = append(response.Corrupt, key)
+			continue
+		}
+		hash := sha256.Sum256(data)
+		sum := hex.EncodeToString(hash[:])
+		switch {
+		case !ok:
+			if h.sums.adopt(key, sum) {
+				response.UnrecordedCount++
+			}
+		case sum == recorded:
+			h.sums.clearCorrupt(key)
+		default:
+			// A write that replaced the file while it was read records a new
+			// checksum; only a file still expected to hold recorded is corrupt
+			if now, _ := h.sums.get(key); now != recorded {
+				continue
+			}
+			h.sums.markCorrupt(key)
+			response.Corrupt = append(response.Corrupt, key)
+		}
+	}
+
+	for _, key := range h.sums.keys(req.Prefix) {
+		if listedKeys[key] {
+			continue
+		}
+		k, err := contentkey.Parse(key)
+		if err != nil {
+			continue
+		}
+		// A ref without its blob still has a file under RefsDir
+		if _, err := h.files.ReadFile(k); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if _, ok := h.sums.get(key); ok {
+			h.sums.remove(key)
+			response.Missing = append(response.Missing, key)
+		}
+	}
+	observeScrub(response)
+	return response, nil
+}
+
+// StartScrubbing scrubs all files once per interval and logs what it finds.
+// It blocks, so run it in its own goroutine.
+func (h *StorageHandler) StartScrubbing(interval time.Duration) {
+	for range time.Tick(interval) {
+		start := time.Now()
+		response, err := h.Scrub(context.Background(), &proto.ScrubRequest{})
+		if err != nil {
+			slog.Error("Scrub failed", "err", err)
+			continue
+		}
+		slog.Info("Scrub finished", "files", response.FileCount, "bytes", response.ByteCount,
+			"duration", time.Since(start).Round(time.Millisecond), "corrupt", len(response.Corrupt),
+			"missing", len(response.Missing), "unrecorded", response.UnrecordedCount)
+		for _, key := range response.Corrupt {
+			slog.Warn("Scrub found corrupt file", "key", key)
+		}
+		for _, key := range response.Missing {
+			slog.Warn("Scrub found missing file", "key", key)
+		}
+	}
+}
+
+// MerkleTree hashes the keys in the requested ring ranges, leaving out files
+// that failed their scrub so they look missing to whoever compares
+func (h *StorageHandler) MerkleTree(ctx context.Context, req *proto.MerkleTreeRequest) (*proto.MerkleTreeResponse, error) {
+	if err := validateTree(req.Ranges, req.Depth); err != nil {
+		return nil, err
+	}
+	nodes, count := h.sums.merkleTree(req.Ranges, int(req.Depth), req.KeysOnly)
+	return &proto.MerkleTreeResponse{Nodes: nodes, KeyCount: count}, nil
+}
+
+// ListChecksums lists the keys under some leaves of the tree MerkleTree builds
+// for the same ranges and depth
+func (h *StorageHandler) ListChecksums(ctx context.Context, req *proto.ListChecksumsRequest) (*proto.ListChecksumsResponse, error) {
+	if err := validateTree(req.Ranges, req.Depth); err != nil {
+		return nil, err
+	}
+	leaves := h.sums.leaves(req.Ranges, int(req.Depth))
+	response := &proto.ListChecksumsResponse{}
+	for _, leaf := range req.Leaves {
+		if int(leaf) >= len(leaves) {
+			return nil, status.Errorf(codes.InvalidArgument, "a tree of depth %d has no leaf %d", req.Depth, leaf)
+		}
+		response.Checksums = append(response.Checksums, leaves[leaf]...)
+	}
+	return response, nil
+}
+
+func validateTree(ranges []*proto.HashRange, depth uint32) error {
+	if len(ranges) == 0 {
+		return status.Error(codes.InvalidArgument, "no hash ranges given")
+	}
+	if depth > maxMerkleDepth {
+		return status.Errorf(codes.InvalidArgument, "Merkle trees are at most %d deep, got %d", maxMerkleDepth, depth)
+	}
+	return nil
+}
@@ -4,57 +4,365 @@ package storage
 
 // Implement a network video content service (server)
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"tritontube/internal/cas"
+	"tritontube/internal/contentkey"
 	"tritontube/internal/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type StorageHandler struct {
 	baseDir string
+	files   fileStore
+	blobs   *cas.Store // the same store as files in dedup mode, else nil
+	usage   *usage
+	sums    *checksums
 	proto.UnimplementedStorageServiceServer
 }
 
-func NewStorageHandler(baseDir string) *StorageHandler {
-	return &StorageHandler{baseDir: baseDir}
+// NewStorageHandler serves the files under baseDir, refusing writes that would
+// take them past quota bytes. A quota of 0 means no limit but the disk's. With
+// dedup, files are stored by content in a cas.Store, so identical files are
+// kept once; a base directory must always be opened in the same mode.
+func NewStorageHandler(baseDir string, quota int64, dedup bool) (*StorageHandler, error) {
+	h := &StorageHandler{baseDir: baseDir, files: plainFiles(baseDir)}
+	if dedup {
+		store, err := cas.Open(baseDir)
+		if err != nil {
+			return nil, err
+		}
+		h.files = store
+		h.blobs = store
+		digests, err := store.Digests()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read checksums: %w", err)
+		}
+		h.sums = newChecksums(digests)
+	} else {
+		if cas.IsStore(baseDir) {
+			return nil, fmt.Errorf("%s holds a deduplicating store, serve it in dedup mode", baseDir)
+		}
+		if err := os.MkdirAll(baseDir, 0755); err != nil {
+			return nil, err
+		}
+		sums, err := openChecksumLog(filepath.Join(baseDir, checksumLogName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load checksums: %w", err)
+		}
+		h.sums = sums
+	}
+	h.usage = &usage{quota: quota}
+	if h.blobs != nil {
+		h.usage.blobBytes = h.blobs.BlobBytes
+	}
+	if err := h.usage.recount(baseDir, h.files.RefsDir()); err != nil {
+		return nil, fmt.Errorf("failed to measure %s: %w", baseDir, err)
+	}
+	return h, nil
+}
+
+// Validates a key from a request, rejecting anything that could escape baseDir
+func parseKey(key string) (contentkey.Key, error) {
+	k, err := contentkey.Parse(key)
+	if err != nil {
+		return contentkey.Key{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return k, nil
+}
+
+// Maps filesystem errors to gRPC status codes so clients can tell a missing key
+// or a full node from a broken node
+func fileError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, errQuotaExceeded) || errors.Is(err, syscall.ENOSPC) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
 }
 
 func (h *StorageHandler) WriteFile(ctx context.Context, req *proto.WriteFileRequest) (*proto.WriteFileResponse, error) {
-	path := filepath.Join(h.baseDir, req.Key)
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	k, err := parseKey(req.Key)
+	if err != nil {
 		return &proto.WriteFileResponse{Success: false}, err
 	}
-	err := os.WriteFile(path, req.Data, 0644)
-	return &proto.WriteFileResponse{Success: err == nil}, err
+	size := int64(len(req.Data))
+	if err := h.usage.reserve(size); err != nil {
+		return &proto.WriteFileResponse{Success: false}, fileError(err)
+	}
+	replaced, exists := h.fileSize(k)
+	err = h.files.WriteFrom(k, bytes.NewReader(req.Data))
+	if err != nil {
+		h.usage.settle(size, 0, 0)
+	} else {
+		h.usage.settle(size, size, replaced)
+		if !exists {
+			h.usage.adjust(0, 1)
+		}
+		sum := sha256.Sum256(req.Data)
+		h.sums.set(k.String(), hex.EncodeToString(sum[:]))
+	}
+	return &proto.WriteFileResponse{Success: err == nil}, fileError(err)
+}
+
+// Receives a file in chunks and writes it without holding it all in memory.
+// Files sent with a transaction ID are staged until CommitTransaction.
+func (h *StorageHandler) WriteFileStream(stream grpc.ClientStreamingServer[proto.WriteFileChunk, proto.WriteFileResponse]) error {
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "empty write stream")
+	}
+	if err != nil {
+		return err
+	}
+	k, err := parseKey(first.Key)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	reader := &quotaReader{r: io.TeeReader(&chunkReader{stream: stream, buf: first.Data}, hash), usage: h.usage}
+	var replaced int64
+	exists := true
+	if first.TxId != "" {
+		// Replacing a published file is only accounted for once the commit
+		// publishes this one
+		err = h.files.StageFrom(first.TxId, k, reader)
+	} else {
+		replaced, exists = h.fileSize(k)
+		err = h.files.WriteFrom(k, reader)
+	}
+	if err != nil {
+		h.usage.settle(reader.reserved, 0, 0)
+		return fileError(err)
+	}
+	h.usage.settle(reader.reserved, reader.reserved, replaced)
+	if !exists {
+		h.usage.adjust(0, 1)
+	}
+	if first.TxId != "" {
+		h.sums.stage(first.TxId, k.String(), hex.EncodeToString(hash.Sum(nil)))
+	} else {
+		h.sums.set(k.String(), hex.EncodeToString(hash.Sum(nil)))
+	}
+	return stream.SendAndClose(&proto.WriteFileResponse{Success: true})
+}
+
+// chunkReader adapts a WriteFileChunk stream to an io.Reader
+type chunkReader struct {
+	stream grpc.ClientStreamingServer[proto.WriteFileChunk, proto.WriteFileResponse]
+	buf    []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		chunk, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = chunk.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
 }
 
 func (h *StorageHandler) ReadFile(ctx context.Context, req *proto.ReadFileRequest) (*proto.ReadFileResponse, error) {
-	path := filepath.Join(h.baseDir, req.Key)
-	data, err := os.ReadFile(path)
-	return &proto.ReadFileResponse{Data: data}, err
+	k, err := parseKey(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	if h.sums.isCorrupt(k.String()) {
+		return nil, status.Errorf(codes.DataLoss, "%s failed its scrub, its content is not what was written", k)
+	}
+	data, err := h.files.ReadFile(k)
+	if err != nil {
+		return nil, fileError(err)
+	}
+	return &proto.ReadFileResponse{Data: data}, nil
+}
+
+// readChunkSize is how much of a file each message of ReadFileStream carries
+const readChunkSize = 64 << 10
+
+// Streams a file from req.Offset on, the first chunk carrying its size and
+// checksum. The client stops the stream once it has what it needs.
+func (h *StorageHandler) ReadFileStream(req *proto.ReadFileStreamRequest, stream grpc.ServerStreamingServer[proto.ReadFileChunk]) error {
+	k, err := parseKey(req.Key)
+	if err != nil {
+		return err
+	}
+	if h.sums.isCorrupt(k.String()) {
+		return status.Errorf(codes.DataLoss, "%s failed its scrub, its content is not what was written", k)
+	}
+	f, err := h.files.Open(k)
+	if err != nil {
+		return fileError(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fileError(err)
+	}
+	if req.Offset < 0 || req.Offset > info.Size() {
+		return status.Errorf(codes.OutOfRange, "offset %d is outside %s, which has %d bytes", req.Offset, k, info.Size())
+	}
+
+	sum, _ := h.sums.get(k.String())
+	chunk := &proto.ReadFileChunk{Size: info.Size(), Sha256: sum}
+	r := io.NewSectionReader(f, req.Offset, info.Size()-req.Offset)
+	buf := make([]byte, readChunkSize)
+	for first := true; ; first = false {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fileError(err)
+		}
+		if n > 0 || first {
+			chunk.Data = buf[:n]
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+			chunk = &proto.ReadFileChunk{}
+		}
+		if err != nil {
+			return nil
+		}
+	}
 }
 
 func (h *StorageHandler) DeleteFile(ctx context.Context, req *proto.DeleteFileRequest) (*proto.DeleteFileResponse, error) {
-	path := filepath.Join(h.baseDir, req.Key)
-	err := os.Remove(path)
-	return &proto.DeleteFileResponse{Success: err == nil}, err
+	k, err := parseKey(req.Key)
+	if err != nil {
+		return &proto.DeleteFileResponse{Success: false}, err
+	}
+	size, _ := h.fileSize(k)
+	err = h.files.Remove(k)
+	if err == nil {
+		h.usage.adjust(-size, -1)
+	}
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		h.sums.remove(k.String())
+	}
+	return &proto.DeleteFileResponse{Success: err == nil}, fileError(err)
+}
+
+// Publishes the files staged under a transaction; an unknown transaction has
+// nothing to publish, which is what a repeated commit finds
+func (h *StorageHandler) CommitTransaction(ctx context.Context, req *proto.TransactionRequest) (*proto.TransactionResponse, error) {
+	staged, _ := contentkey.StagedKeys(h.files.RefsDir(), req.TxId)
+	// The staged bytes are counted already; the published files they replace
+	// are freed
+	var replaced, added int64
+	for _, k := range staged {
+		if size, ok := h.fileSize(k); ok {
+			replaced += size
+		} else {
+			added++
+		}
+	}
+	count, err := h.files.CommitStaged(req.TxId)
+	var keyErr *contentkey.InvalidKeyError
+	if errors.As(err, &keyErr) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		// Some files may have moved; counting again is simpler than working out which
+		h.recount()
+		return nil, fileError(err)
+	}
+	h.usage.adjust(-replaced, added)
+	h.recordCommitted(req.TxId, staged)
+	return &proto.TransactionResponse{FileCount: int32(count)}, nil
+}
+
+// Discards the files staged under a transaction
+func (h *StorageHandler) AbortTransaction(ctx context.Context, req *proto.TransactionRequest) (*proto.TransactionResponse, error) {
+	size := h.stagedSize(req.TxId)
+	err := h.files.AbortStaged(req.TxId)
+	h.sums.discard(req.TxId)
+	var keyErr *contentkey.InvalidKeyError
+	if errors.As(err, &keyErr) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		h.recount()
+		return nil, fileError(err)
+	}
+	h.usage.adjust(-size, 0)
+	return &proto.TransactionResponse{}, nil
+}
+
+// Records the checksums of the files a transaction published. Files staged
+// before a restart have none in memory, so they are hashed as they are now.
+func (h *StorageHandler) recordCommitted(txID string, staged []contentkey.Key) {
+	keys := make([]string, len(staged))
+	for i, k := range staged {
+		keys[i] = k.String()
+	}
+	for _, key := range h.sums.commit(txID, keys) {
+		k, _ := contentkey.Parse(key)
+		data, err := h.files.ReadFile(k)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		h.sums.set(key, hex.EncodeToString(sum[:]))
+	}
+}
+
+// Replaces the running count with what is on disk
+func (h *StorageHandler) recount() {
+	if err := h.usage.recount(h.baseDir, h.files.RefsDir()); err != nil {
+		slog.Error("Failed to recount usage", "dir", h.baseDir, "err", err)
+	}
 }
 
 func (h *StorageHandler) ListKeys(ctx context.Context, request *proto.ListKeysRequest) (*proto.ListKeysResponse, error) {
 	var keys []string
 
-	entries, err := os.ReadDir(h.baseDir)
-	if err != nil {
-		return nil, err
+	// A prefix naming one video only needs that video's directory
+	var videoIDs []string
+	if videoID, _, found := strings.Cut(request.Prefix, "/"); found {
+		videoIDs = []string{videoID}
+	} else {
+		entries, err := os.ReadDir(h.files.RefsDir())
+		if errors.Is(err, os.ErrNotExist) {
+			// Nothing has been written to this node yet
+			return &proto.ListKeysResponse{}, nil
+		}
+		if err != nil {
+			return nil, fileError(err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				videoIDs = append(videoIDs, entry.Name())
+			}
+		}
 	}
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
+	for _, videoID := range videoIDs {
+		if contentkey.ValidateComponent(videoID) != nil {
 			continue
 		}
-		videoID := entry.Name()
-		videoDir := filepath.Join(h.baseDir, videoID)
+		videoDir := filepath.Join(h.files.RefsDir(), videoID)
 
 		files, err := os.ReadDir(videoDir)
 		if err != nil {
@@ -65,11 +373,17 @@ func (h *StorageHandler) ListKeys(ctx context.Context, request *proto.ListKeysRe
 			if file.IsDir() {
 				continue
 			}
-			filename := file.Name()
-			key := videoID + "/" + filename
-			keys = append(keys, key)
+			// Skips temp files of in-progress writes and anything else that
+			// could not have been written through a valid key
+			k, err := contentkey.New(videoID, file.Name())
+			if err != nil {
+				continue
+			}
+			if key := k.String(); strings.HasPrefix(key, request.Prefix) {
+				keys = append(keys, key)
+			}
 		}
 	}
 
 	return &proto.ListKeysResponse{Keys: keys}, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,157 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Buckets for gRPC latencies, which run from well under a millisecond for a
+// health check to seconds for a large file
+var grpcBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	grpcServerHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_handled_total",
+		Help: "gRPC calls handled by this server, by method and status code.",
+	}, []string{"method", "code"})
+	grpcServerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_handling_seconds",
+		Help:    "Time taken to handle gRPC calls, by method.",
+		Buckets: grpcBuckets,
+	}, []string{"method"})
+	grpcClientHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_handled_total",
+		Help: "gRPC calls made by this process, by method and status code.",
+	}, []string{"method", "code"})
+	grpcClientDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_client_handling_seconds",
+		Help:    "Time taken by gRPC calls this process made, by method.",
+		Buckets: grpcBuckets,
+	}, []string{"method"})
+)
+
+// ServerOptions instrument a gRPC server: calls are counted and timed, and
+// their handlers get the request ID of the caller in their context
+func ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryServerInterceptor),
+		grpc.ChainStreamInterceptor(streamServerInterceptor),
+	}
+}
+
+// DialOptions instrument a gRPC client: calls are counted and timed, and
+// carry the request ID of their context to the server
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(unaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(streamClientInterceptor),
+	}
+}
+
+// Returns ctx with the request ID from the incoming metadata, or a new one
+func incomingRequestID(ctx context.Context) context.Context {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDMetadataKey); len(ids) > 0 && validRequestID(ids[0]) {
+			return WithRequestID(ctx, ids[0])
+		}
+	}
+	return WithRequestID(ctx, NewRequestID())
+}
+
+func outgoingRequestID(ctx context.Context) context.Context {
+	if id := RequestID(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, requestIDMetadataKey, id)
+	}
+	return ctx
+}
+
+func observeServer(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	elapsed := time.Since(start)
+	grpcServerHandled.WithLabelValues(method, code.String()).Inc()
+	grpcServerDuration.WithLabelValues(method).Observe(elapsed.Seconds())
+	slog.DebugContext(ctx, "gRPC call", "method", method, "code", code.String(), "duration", elapsed)
+}
+
+func unaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx = incomingRequestID(ctx)
+	resp, err := handler(ctx, req)
+	observeServer(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func streamServerInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx := incomingRequestID(ss.Context())
+	err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	observeServer(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// serverStream hands the handler a context with the request ID
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func observeClient(method string, start time.Time, err error) {
+	grpcClientHandled.WithLabelValues(method, status.Code(err).String()).Inc()
+	grpcClientDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func unaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(outgoingRequestID(ctx), method, req, reply, cc, opts...)
+	observeClient(method, start, err)
+	return err
+}
+
+func streamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	start := time.Now()
+	cs, err := streamer(outgoingRequestID(ctx), desc, cc, method, opts...)
+	if err != nil {
+		observeClient(method, start, err)
+		return nil, err
+	}
+	return &clientStream{ClientStream: cs, method: method, start: start, serverStreams: desc.ServerStreams}, nil
+}
+
+// clientStream records a streaming call once it ends: when a receive fails,
+// or io.EOF ends the responses, or the single response of a client stream
+// arrives
+type clientStream struct {
+	grpc.ClientStream
+	method        string
+	start         time.Time
+	serverStreams bool
+	once          sync.Once
+}
+
+func (s *clientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.once.Do(func() {
+			result := err
+			if errors.Is(result, io.EOF) {
+				result = nil
+			}
+			observeClient(s.method, s.start, result)
+		})
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package telemetry
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by route pattern and status code.",
+	}, []string{"route", "code"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests, by route pattern.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+	httpResponseBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_response_bytes_total",
+		Help: "Bytes of HTTP response bodies served, by route pattern.",
+	}, []string{"route"})
+)
+
+// MetricsHandler serves the metrics of the default Prometheus registry
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ServeMetrics serves the metrics on /metrics at addr, for binaries without
+// an HTTP server of their own. It blocks.
+func ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", MetricsHandler())
+	return http.ListenAndServe(addr, mux)
+}
+
+// InstrumentHTTP wraps a handler serving a http.ServeMux: every request gets
+// a request ID, is counted and timed by the mux pattern it matched, and is
+// logged at the debug level, or the warn level if it failed with a 5xx.
+func InstrumentHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		// The mux records the pattern it matched in the request it is given
+		r = r.WithContext(WithRequestID(r.Context(), id))
+		rw := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		elapsed := time.Since(start)
+		httpRequests.WithLabelValues(route, strconv.Itoa(rw.status)).Inc()
+		httpDuration.WithLabelValues(route).Observe(elapsed.Seconds())
+		httpResponseBytes.WithLabelValues(route).Add(float64(rw.bytes))
+
+		level := slog.LevelDebug
+		if rw.status >= 500 {
+			level = slog.LevelWarn
+		}
+		slog.Log(r.Context(), level, "HTTP request",
+			"method", r.Method, "path", r.URL.Path, "route", route,
+			"status", rw.status, "bytes", rw.bytes, "duration", elapsed)
+	})
+}
+
+// responseRecorder remembers the status code and body size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, for
+// flushing and deadlines
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
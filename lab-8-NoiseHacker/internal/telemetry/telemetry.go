@@ -0,0 +1,121 @@
+// Package telemetry holds what the TritonTube binaries share for observing
+// them: structured logging with log/slog, Prometheus metrics for HTTP and gRPC
+// traffic, and request IDs that follow a request from the web server into the
+// storage nodes it calls.
+//
+// A request ID is taken from the X-Request-Id header of an HTTP request, or
+// made up if there is none, and stored in the request's context. Outgoing gRPC
+// calls made with that context carry it in their metadata, and the gRPC server
+// of the node they reach puts it into the handler's context again. Loggers set
+// up by SetupLogging add it to every record logged with a context.
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// RequestIDHeader is the HTTP header a request ID is read from and echoed in
+const RequestIDHeader = "X-Request-Id"
+
+// requestIDMetadataKey carries the request ID in gRPC metadata
+const requestIDMetadataKey = "x-request-id"
+
+// Longest request ID taken from a client; longer ones are replaced
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random request ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID ctx carries, "" if none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Background returns a context for work no request asked for, such as a
+// migration, with a new request ID so the calls it makes can be told apart
+func Background() context.Context {
+	return WithRequestID(context.Background(), NewRequestID())
+}
+
+// Accepts a request ID from a client only if it is safe to log as is
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// SetupLogging makes a logger writing to stderr the default for log/slog, and
+// for the log package, whose output becomes records at the info level. format
+// is "text" or "json", level one of "debug", "info", "warn" and "error".
+func SetupLogging(format string, level string) error {
+	handler, err := NewLogHandler(os.Stderr, format, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// NewLogHandler returns a handler that writes records to w and adds the
+// request ID of the context they are logged with, see SetupLogging
+func NewLogHandler(w io.Writer, format string, level string) (slog.Handler, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "text":
+		return requestIDHandler{slog.NewTextHandler(w, opts)}, nil
+	case "json":
+		return requestIDHandler{slog.NewJSONHandler(w, opts)}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, want text or json", format)
+	}
+}
+
+// requestIDHandler adds a request_id attribute to records logged with a
+// context that has one
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,510 @@
+// JSON API under /api/v1, backed by the same services as the HTML pages.
+//
+// Errors always have the body {"error": {"status": 404, "code": "not_found",
+// "message": "..."}}. Uploads answer 202 with the transcode job; poll it until
+// its status is "ready", then fetch the video from its video_url. Large files
+// can be uploaded in chunks under /api/v1/uploads, see resumable.go. Live
+// streams are sent to PUT /api/v1/live/{videoId}, see live.go.
+//
+// Clients log in with POST /api/v1/sessions and send the returned token as
+// "Authorization: Bearer <token>". Uploading, editing and deleting need a
+// token; private videos are only visible with their owner's token.
+
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Largest page the API returns
+const maxAPIPageSize = 100
+
+// apiVideo is the JSON form of a video
+type apiVideo struct {
+	Id              string    `json:"id"`
+	Title           string    `json:"title"`
+	Description     string    `json:"description"`
+	Uploader        string    `json:"uploader"`
+	UploadedAt      time.Time `json:"uploaded_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Width           int       `json:"width"`
+	Height          int       `json:"height"`
+	Size            int64     `json:"size"`
+	Visibility      string    `json:"visibility"`
+	Live            bool      `json:"live"`
+	SHA256          string    `json:"sha256,omitempty"` // of the uploaded file
+	PageURL         string    `json:"page_url"`
+	ManifestURL     string    `json:"manifest_url"` // signed for private videos, so it expires
+	ThumbnailURL    string    `json:"thumbnail_url,omitempty"`
+}
+
+func (s *server) newAPIVideo(v VideoMetadata) apiVideo {
+	base := s.contentBase(v)
+	video := apiVideo{
+		Id:              v.Id,
+		Title:           v.Title,
+		Description:     v.Description,
+		Uploader:        v.Uploader,
+		UploadedAt:      v.UploadedAt,
+		DurationSeconds: v.Duration.Seconds(),
+		Width:           v.Width,
+		Height:          v.Height,
+		Size:            v.Size,
+		Visibility:      string(v.Visibility),
+		Live:            v.Live,
+		SHA256:          v.SHA256,
+		PageURL:         "/videos/" + url.PathEscape(v.Id),
+		ManifestURL:     base + "/" + DASHManifest,
+	}
+	if v.Thumbnail != "" {
+		video.ThumbnailURL = base + "/" + url.PathEscape(v.Thumbnail)
+	}
+	return video
+}
+
+// apiJob is the JSON form of a transcode job
+type apiJob struct {
+	Id        string    `json:"id"`
+	VideoId   string    `json:"video_id"`
+	Title     string    `json:"title"`
+	Status    JobStatus `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	VideoURL  string    `json:"video_url,omitempty"` // set once the job is ready
+}
+
+func newAPIJob(j TranscodeJob) apiJob {
+	job := apiJob{
+		Id:        j.Id,
+		VideoId:   j.VideoId,
+		Title:     j.Title,
+		Status:    j.Status,
+		Error:     j.Error,
+		CreatedAt: j.CreatedAt,
+		UpdatedAt: j.UpdatedAt,
+	}
+	if j.Status == JobReady {
+		job.VideoURL = "/api/v1/videos/" + url.PathEscape(j.VideoId)
+	}
+	return job
+}
+
+func (s *server) registerAPI() {
+	s.mux.HandleFunc("GET /api/v1/videos", s.apiListVideos)
+	s.mux.HandleFunc("POST /api/v1/videos", s.apiCreateVideo)
+	s.mux.HandleFunc("GET /api/v1/videos/{videoId}", s.apiGetVideo)
+	s.mux.HandleFunc("PUT /api/v1/videos/{videoId}", s.apiPutVideo)
+	s.mux.HandleFunc("PATCH /api/v1/videos/{videoId}", s.apiPatchVideo)
+	s.mux.HandleFunc("DELETE /api/v1/videos/{videoId}", s.apiDeleteVideo)
+	s.mux.HandleFunc("PUT /api/v1/live/{videoId}", s.apiPutLive)
+	s.mux.HandleFunc("POST /api/v1/uploads", s.apiCreateUpload)
+	s.mux.HandleFunc("GET /api/v1/uploads/{uploadId}", s.apiGetUpload)
+	s.mux.HandleFunc("PUT /api/v1/uploads/{uploadId}", s.apiPutUploadChunk)
+	s.mux.HandleFunc("POST /api/v1/uploads/{uploadId}/complete", s.apiCompleteUpload)
+	s.mux.HandleFunc("DELETE /api/v1/uploads/{uploadId}", s.apiDeleteUpload)
+	s.mux.HandleFunc("GET /api/v1/jobs/{jobId}", s.apiGetJob)
+	s.mux.HandleFunc("POST /api/v1/users", s.apiCreateUser)
+	s.mux.HandleFunc("POST /api/v1/sessions", s.apiCreateSession)
+	s.mux.HandleFunc("DELETE /api/v1/sessions", s.apiDeleteSession)
+	s.mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "No such endpoint: "+r.Method+" "+r.URL.Path)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{
+		"error": map[string]any{
+			"status":  status,
+			"code":    strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+			"message": message,
+		},
+	})
+}
+
+// Answers with the status of a *statusError, or 500
+func writeAPIStatusError(w http.ResponseWriter, err error) {
+	var se *statusError
+	if errors.As(err, &se) {
+		writeAPIError(w, se.status, se.msg)
+		return
+	}
+	writeAPIError(w, http.StatusInternalServerError, err.Error())
+}
+
+// GET /api/v1/videos?q=&after=&before=&limit=
+func (s *server) apiListVideos(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	opts := s.apiViewer(r).listOptions(ListOptions{Query: strings.TrimSpace(params.Get("q")), Limit: DefaultPageSize})
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxAPIPageSize {
+			writeAPIError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxAPIPageSize))
+			return
+		}
+		opts.Limit = n
+	}
+	if before := params.Get("before"); before != "" {
+		opts.Cursor, opts.Before = before, true
+	} else {
+		opts.Cursor = params.Get("after")
+	}
+
+	page, err := s.metadataService.List(opts)
+	if errors.Is(err, ErrInvalidCursor) {
+		writeAPIError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Failed to list videos")
+		return
+	}
+
+	videos := make([]apiVideo, 0, len(page.Videos))
+	for _, v := range page.Videos {
+		videos = append(videos, s.newAPIVideo(v))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"videos":      videos,
+		"next_cursor": page.NextCursor,
+		"prev_cursor": page.PrevCursor,
+	})
+}
+
+// GET /api/v1/videos/{videoId}
+func (s *server) apiGetVideo(w http.ResponseWriter, r *http.Request) {
+	meta, err := s.metadataService.Read(r.PathValue("videoId"))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !s.apiViewer(r).canView(meta.OwnerId, meta.Visibility)) {
+		writeAPIError(w, http.StatusNotFound, "Video not found")
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Failed to read video")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.newAPIVideo(*meta))
+}
+
+// POST /api/v1/videos uploads a video under a generated ID, see receiveAPIUpload
+func (s *server) apiCreateVideo(w http.ResponseWriter, r *http.Request) {
+	v := s.apiViewer(r)
+	if !v.loggedIn() {
+		writeAPIError(w, http.StatusUnauthorized, "Log in to upload")
+		return
+	}
+	s.receiveAPIUpload(w, r, upload{videoID: newID(), jobID: newID(), owner: v.user})
+}
+
+// PUT /api/v1/videos/{videoId} uploads a video under an ID chosen by the client.
+// IDs are never reused, so this is 409 for an existing or deleted video.
+func (s *server) apiPutVideo(w http.ResponseWriter, r *http.Request) {
+	v := s.apiViewer(r)
+	if !v.loggedIn() {
+		writeAPIError(w, http.StatusUnauthorized, "Log in to upload")
+		return
+	}
+	videoID := r.PathValue("videoId")
+	if err := s.checkVideoIDFree(videoID); err != nil {
+		writeAPIStatusError(w, err)
+		return
+	}
+	s.receiveAPIUpload(w, r, upload{videoID: videoID, jobID: newID(), owner: v.user})
+}
+
+// Accepts either a multipart form like the upload page's, or the raw video as
+// the body with title, description, visibility and filename as query parameters.
+// Either may also give the file's sha256, which is checked, and on_duplicate,
+// see duplicatePolicy. Answers 202 with the transcode job, or 200 with the job
+// of an identical earlier upload it was linked to.
+func (s *server) receiveAPIUpload(w http.ResponseWriter, r *http.Request, u upload) {
+	var job *TranscodeJob
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		job, err = s.receiveMultipartUpload(w, r, u)
+	} else {
+		params := r.URL.Query()
+		u.filename = filepath.Base(params.Get("filename"))
+		if u.filename == "." || u.filename == "/" {
+			u.filename = u.videoID + ".mp4"
+		}
+		u.title, u.description, u.visibility = params.Get("title"), params.Get("description"), params.Get("visibility")
+		u.checksum, u.onDuplicate = params.Get("sha256"), duplicatePolicy(params.Get("on_duplicate"))
+
+		r.Body = http.MaxBytesReader(w, r.Body, s.MaxUploadSize)
+		var path string
+		path, u.sha256, err = s.spoolUpload(u, r.Body)
+		if err == nil {
+			job, err = s.queueUpload(u, path)
+		}
+	}
+	if err != nil {
+		writeAPIStatusError(w, err)
+		return
+	}
+	writeQueuedJob(w, u, job)
+}
+
+// Answers with the job queueUpload returned for u: 202 for a new one, 200 for
+// an earlier upload's
+func writeQueuedJob(w http.ResponseWriter, u upload, job *TranscodeJob) {
+	status := http.StatusAccepted
+	if job.Id != u.jobID {
+		status = http.StatusOK
+	}
+	w.Header().Set("Location", "/api/v1/jobs/"+url.PathEscape(job.Id))
+	writeJSON(w, status, newAPIJob(*job))
+}
+
+// PUT /api/v1/live/{videoId} broadcasts the body live under an ID chosen by the
+// client, with title, description and visibility as query parameters. Answers
+// once the stream has ended, with the recorded video.
+func (s *server) apiPutLive(w http.ResponseWriter, r *http.Request) {
+	v := s.apiViewer(r)
+	if !v.loggedIn() {
+		writeAPIError(w, http.StatusUnauthorized, "Log in to broadcast")
+		return
+	}
+	videoID := r.PathValue("videoId")
+	if err := s.checkVideoIDFree(videoID); err != nil {
+		writeAPIStatusError(w, err)
+		return
+	}
+	params := r.URL.Query()
+	u := upload{videoID: videoID, filename: videoID, title: params.Get("title"), description: params.Get("description"), visibility: params.Get("visibility")}
+	title, description, visibility, err := u.details()
+	if err != nil {
+		writeAPIStatusError(w, err)
+		return
+	}
+	select {
+	case s.liveSlots <- struct{}{}:
+		defer func() { <-s.liveSlots }()
+	default:
+		writeAPIError(w, http.StatusServiceUnavailable, "Too many live streams, try again later")
+		return
+	}
+
+	now := time.Now()
+	job := TranscodeJob{
+		Id:          newID(),
+		VideoId:     videoID,
+		Status:      JobRunning,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Title:       title,
+		Description: description,
+		Uploader:    v.user.Username,
+		OwnerId:     v.user.Id,
+		Visibility:  visibility,
+		Live:        true,
+	}
+	err = s.metadataService.CreateJob(job)
+	if errors.Is(err, ErrVideoIDTaken) {
+		writeAPIError(w, http.StatusConflict, "Video ID already exists")
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Failed to start live stream")
+		return
+	}
+
+	stream := &broadcastReader{body: r.Body, rc: http.NewResponseController(w)}
+	meta, err := s.transcodeQueue.ingestLive(r.Context(), &job, stream)
+	if stream.err != nil {
+		slog.InfoContext(r.Context(), "Broadcast ended by a read error", "video_id", videoID, "err", stream.err)
+	}
+	// A stream that fails midway keeps its recording up to then
+	status, errMsg := JobReady, ""
+	if err != nil {
+		errMsg = err.Error()
+		if meta == nil {
+			status = JobFailed
+		}
+	}
+	if err := s.metadataService.UpdateJob(job.Id, status, errMsg); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to update job", "job_id", job.Id, "err", err)
+	}
+	if err != nil {
+		// Rejected streams and deleted videos are the broadcaster's doing
+		level := slog.LevelError
+		if se := (*statusError)(nil); errors.As(err, &se) && se.status < http.StatusInternalServerError {
+			level = slog.LevelInfo
+		}
+		slog.Log(r.Context(), level, "Live stream failed", "video_id", videoID, "err", err)
+		writeAPIStatusError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.newAPIVideo(*meta))
+}
+
+// PATCH /api/v1/videos/{videoId} with {"title": ..., "description": ...,
+// "visibility": ...}; missing fields are left unchanged
+func (s *server) apiPatchVideo(w http.ResponseWriter, r *http.Request) {
+	var patch struct {
+		Title       *string     `json:"title"`
+		Description *string     `json:"description"`
+		Visibility  *Visibility `json:"visibility"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormFieldSize)
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return
+	}
+
+	meta, err := s.videoToModify(s.apiViewer(r), r.PathValue("videoId"))
+	if err != nil {
+		writeAPIStatusError(w, err)
+		return
+	}
+	if patch.Title != nil {
+		meta.Title = strings.TrimSpace(*patch.Title)
+	}
+	if patch.Description != nil {
+		meta.Description = strings.TrimSpace(*patch.Description)
+	}
+	if patch.Visibility != nil {
+		meta.Visibility = *patch.Visibility
+	}
+	if meta.Title == "" || len(meta.Title) > maxTitleLength || len(meta.Description) > maxDescriptionLength {
+		writeAPIError(w, http.StatusBadRequest, "Title is missing, or title or description is too long")
+		return
+	}
+	if !meta.Visibility.Valid() {
+		writeAPIError(w, http.StatusBadRequest, "Visibility must be public, unlisted or private")
+		return
+	}
+
+	err = s.metadataService.Update(meta.Id, meta.Title, meta.Description, meta.Visibility)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAPIError(w, http.StatusNotFound, "Video not found")
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Failed to update video")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.newAPIVideo(*meta))
+}
+
+// DELETE /api/v1/videos/{videoId} answers 202; the content is removed in the
+// background
+func (s *server) apiDeleteVideo(w http.ResponseWriter, r *http.Request) {
+	videoId := r.PathValue("videoId")
+	if _, err := s.videoToModify(s.apiViewer(r), videoId); err != nil {
+		writeAPIStatusError(w, err)
+		return
+	}
+	err := s.deleteVideo(r.Context(), videoId)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAPIError(w, http.StatusNotFound, "Video not found")
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Failed to delete video")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// GET /api/v1/jobs/{jobId}
+func (s *server) apiGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.metadataService.ReadJob(r.PathValue("jobId"))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !s.apiViewer(r).canView(job.OwnerId, job.Visibility)) {
+		writeAPIError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Failed to read job")
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIJob(*job))
+}
+
+// apiCredentials is the body of POST /api/v1/users and POST /api/v1/sessions
+type apiCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func readCredentials(w http.ResponseWriter, r *http.Request) (apiCredentials, bool) {
+	var creds apiCredentials
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormFieldSize)
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return creds, false
+	}
+	creds.Username = strings.TrimSpace(creds.Username)
+	return creds, true
+}
+
+// POST /api/v1/users with {"username": ..., "password": ...} creates an account
+func (s *server) apiCreateUser(w http.ResponseWriter, r *http.Request) {
+	creds, ok := readCredentials(w, r)
+	if !ok {
+		return
+	}
+	user, err := s.signUp(creds.Username, creds.Password)
+	if err != nil {
+		writeAPIStatusError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"id":       user.Id,
+		"username": user.Username,
+		"is_admin": user.IsAdmin,
+	})
+}
+
+// POST /api/v1/sessions with {"username": ..., "password": ...} logs in and
+// returns the bearer token
+func (s *server) apiCreateSession(w http.ResponseWriter, r *http.Request) {
+	creds, ok := readCredentials(w, r)
+	if !ok {
+		return
+	}
+	user, err := s.logIn(creds.Username, creds.Password)
+	if err != nil {
+		writeAPIStatusError(w, err)
+		return
+	}
+	token, session, err := s.newSession(user)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Failed to start session")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"token":      token,
+		"expires_at": session.ExpiresAt,
+	})
+}
+
+// DELETE /api/v1/sessions logs out the bearer token
+func (s *server) apiDeleteSession(w http.ResponseWriter, r *http.Request) {
+	v := s.apiViewer(r)
+	if !v.loggedIn() {
+		writeAPIError(w, http.StatusUnauthorized, "Not logged in")
+		return
+	}
+	if err := s.metadataService.DeleteSession(v.session.TokenHash); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
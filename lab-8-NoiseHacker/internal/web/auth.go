@@ -0,0 +1,357 @@
+// Accounts, sessions and access control.
+//
+// Browsers log in through /login and /signup and get a session cookie; every
+// form a session submits carries its CSRF token. API clients get the same kind
+// of session from POST /api/v1/sessions and send it as a bearer token, which
+// browsers never attach on their own, so the API needs no CSRF token.
+
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookie = "tritontube_session"
+	sessionTTL    = 30 * 24 * time.Hour
+
+	// PBKDF2-HMAC-SHA256 iterations for new password hashes
+	passwordIterations = 600000
+	minPasswordLength  = 8
+	maxPasswordLength  = 1024
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,32}$`)
+
+// Hashes a password as "pbkdf2-sha256$iterations$salt$hash"
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Reports whether password matches a hash made by hashPassword
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	salt, err1 := base64.RawStdEncoding.DecodeString(parts[2])
+	want, err2 := base64.RawStdEncoding.DecodeString(parts[3])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// Returns a random token for sessions and CSRF protection
+func newToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// viewer is who a request comes from; both fields are nil when logged out
+type viewer struct {
+	user    *User
+	session *Session
+}
+
+func (v viewer) loggedIn() bool { return v.user != nil }
+
+func (v viewer) userId() string {
+	if v.user == nil {
+		return ""
+	}
+	return v.user.Id
+}
+
+// Reports whether the viewer may watch a video with the given owner and
+// visibility; unlisted videos are watchable by anyone who has the link
+func (v viewer) canView(ownerId string, visibility Visibility) bool {
+	return visibility != Private || v.canModify(ownerId)
+}
+
+// Reports whether the viewer may edit or delete a video with the given owner
+func (v viewer) canModify(ownerId string) bool {
+	if v.user == nil {
+		return false
+	}
+	return v.user.IsAdmin || (ownerId != "" && ownerId == v.user.Id)
+}
+
+// Reports whether a submitted form carries the viewer's CSRF token
+func (v viewer) checkCSRF(token string) bool {
+	return v.session != nil && tokenMatches(token, v.session.CSRFToken)
+}
+
+// Compares tokens in constant time; an empty token never matches
+func tokenMatches(got, want string) bool {
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+func (v viewer) csrfToken() string {
+	if v.session == nil {
+		return ""
+	}
+	return v.session.CSRFToken
+}
+
+// listOptions limits a listing to what the viewer may see
+func (v viewer) listOptions(opts ListOptions) ListOptions {
+	opts.ViewerId = v.userId()
+	opts.AllVideos = v.user != nil && v.user.IsAdmin
+	return opts
+}
+
+// Looks up the session of a raw session token
+func (s *server) viewerForToken(token string) viewer {
+	if token == "" {
+		return viewer{}
+	}
+	session, err := s.metadataService.ReadSession(hashToken(token))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.Warn("Failed to read session", "err", err)
+		}
+		return viewer{}
+	}
+	user, err := s.metadataService.ReadUser(session.UserId)
+	if err != nil {
+		return viewer{}
+	}
+	return viewer{user: user, session: session}
+}
+
+// Returns the viewer of a browser request, from its session cookie
+func (s *server) currentViewer(r *http.Request) viewer {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return viewer{}
+	}
+	return s.viewerForToken(cookie.Value)
+}
+
+// Returns the viewer of an API request, from its bearer token
+func (s *server) apiViewer(r *http.Request) viewer {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return viewer{}
+	}
+	return s.viewerForToken(strings.TrimSpace(token))
+}
+
+// Creates a session for a user, returning its raw token
+func (s *server) newSession(user *User) (string, *Session, error) {
+	token := newToken()
+	now := time.Now()
+	session := Session{
+		TokenHash: hashToken(token),
+		UserId:    user.Id,
+		CSRFToken: newToken(),
+		CreatedAt: now,
+		ExpiresAt: now.Add(sessionTTL),
+	}
+	if err := s.metadataService.CreateSession(session); err != nil {
+		return "", nil, err
+	}
+	return token, &session, nil
+}
+
+// Creates an account. With FirstUserAdmin, the first account is an admin, so a
+// fresh install can be managed without seeding one. Errors are *statusErrors.
+// Other errors come from the metadata service.
+func (s *server) signUp(username, password string) (*User, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, &statusError{http.StatusBadRequest, "Usernames are 3 to 32 letters, digits, '.', '_' or '-'"}
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return nil, &statusError{http.StatusBadRequest, fmt.Sprintf("Passwords need at least %d characters", minPasswordLength)}
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.metadataService.CreateUser(User{
+		Id:           newID(),
+		Username:     username,
+		PasswordHash: hash,
+		CreatedAt:    time.Now(),
+	}, s.FirstUserAdmin)
+	if errors.Is(err, ErrUsernameTaken) {
+		return nil, &statusError{http.StatusConflict, "That username is taken"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Checks a username and password. Errors are *statusErrors.
+func (s *server) logIn(username, password string) (*User, error) {
+	user, err := s.metadataService.ReadUserByName(username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if user == nil || !checkPassword(user.PasswordHash, password) {
+		return nil, &statusError{http.StatusUnauthorized, "Wrong username or password"}
+	}
+	return user, nil
+}
+
+// AuthInfo is the data for the login and signup pages
+type AuthInfo struct {
+	Signup   bool // signup page rather than login page
+	Username string
+	Error    string
+}
+
+func renderAuth(w http.ResponseWriter, status int, info AuthInfo) {
+	temp, err := template.New("auth").Parse(authHTML)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	temp.Execute(w, info)
+}
+
+// Handles GET "/login" and GET "/signup"
+func (s *server) handleAuthPage(w http.ResponseWriter, r *http.Request) {
+	renderAuth(w, http.StatusOK, AuthInfo{Signup: r.URL.Path == "/signup"})
+}
+
+// Handles POST "/login" and POST "/signup", starting a session on success
+func (s *server) handleAuth(w http.ResponseWriter, r *http.Request) {
+	signup := r.URL.Path == "/signup"
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormFieldSize)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	username := strings.TrimSpace(r.PostForm.Get("username"))
+	password := r.PostForm.Get("password")
+
+	var user *User
+	var err error
+	if signup {
+		user, err = s.signUp(username, password)
+	} else {
+		user, err = s.logIn(username, password)
+	}
+	if err != nil {
+		var se *statusError
+		if !errors.As(err, &se) {
+			slog.WarnContext(r.Context(), "Login failed", "err", err)
+			se = &statusError{http.StatusInternalServerError, "Something went wrong, please try again"}
+		}
+		renderAuth(w, se.status, AuthInfo{Signup: signup, Username: username, Error: se.msg})
+		return
+	}
+
+	token, session, err := s.newSession(user)
+	if err != nil {
+		http.Error(w, "Failed to start session", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Handles POST "/logout"
+func (s *server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	v := s.currentViewer(r)
+	if v.loggedIn() {
+		if !v.checkCSRF(r.PostFormValue("csrf_token")) {
+			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		s.metadataService.DeleteSession(v.session.TokenHash)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ******************** Signed content URLs ********************
+
+// DefaultContentURLTTL is how long signed content URLs stay valid unless
+// ContentURLTTL is changed
+const DefaultContentURLTTL = 6 * time.Hour
+
+// Content of private videos is only served through signed URLs of the form
+// /content/t/{token}/{videoId}/{filename}. A token covers every file of one
+// video until it expires, and sits in the path rather than the query so the
+// segment URLs a manifest gives relative to itself carry it too.
+func (s *server) signContent(videoId string) string {
+	expires := time.Now().Add(s.ContentURLTTL).Unix()
+	return strconv.FormatInt(expires, 10) + "." + s.contentMAC(videoId, expires)
+}
+
+func (s *server) contentMAC(videoId string, expires int64) string {
+	mac := hmac.New(sha256.New, s.ContentSigningKey)
+	fmt.Fprintf(mac, "%s\n%d", videoId, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Reports whether token is an unexpired signature for videoId
+func (s *server) verifyContentToken(token, videoId string) bool {
+	expiresText, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiresText, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(s.contentMAC(videoId, expires)))
+}
+
+// Returns the URL prefix a video's content files are served under, signed for
+// private videos
+func (s *server) contentBase(v VideoMetadata) string {
+	if v.Visibility == Private {
+		return "/content/t/" + s.signContent(v.Id) + "/" + url.PathEscape(v.Id)
+	}
+	return "/content/" + url.PathEscape(v.Id)
+}
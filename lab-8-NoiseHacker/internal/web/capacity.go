@@ -0,0 +1,102 @@
+// Capacity-weighted placement for the network content service.
+//
+// Each storage node gets one vnode on the ring per VnodeSize bytes of capacity
+// it reports through Stats, so bigger nodes own proportionally more keys. A
+// node's weight is fixed when it joins and persisted with the membership,
+// since changing it would move keys without a migration. Only a rebalance,
+// which migrates, weighs the nodes again.
+
+package web
+
+import (
+	"context"
+	"sync"
+	"time"
+	"tritontube/internal/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultVnodeSize is a sensible capacity for one vnode to stand for: a 1 TB
+// node gets 256. Weighting is off unless a size is given.
+const DefaultVnodeSize = 4 << 30
+
+// Caps the vnodes of one node, keeping rings of huge nodes small. Nodes past
+// 16 TB at the default size all weigh the same.
+const maxVnodes = 4096
+
+// How long a node gets to answer Stats
+const statsTimeout = time.Second
+
+// Returns the vnodes for a node of capacity bytes, at least one
+func vnodesFor(capacity int64, vnodeSize int64) int {
+	if vnodeSize <= 0 || capacity <= 0 {
+		return 1
+	}
+	return int(min(max((capacity+vnodeSize/2)/vnodeSize, 1), maxVnodes))
+}
+
+// UseCapacityWeights weights the nodes that join through AddNode by the
+// capacity they report, one vnode per vnodeSize bytes. Nodes already in the
+// ring keep their weight, the one persisted in the membership file or 1 for
+// nodes given on the command line, since weighing them again on every start
+// would move keys without a migration; Rebalance reweights them.
+func (n *NetworkVideoContentService) UseCapacityWeights(vnodeSize int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.vnodeSize = vnodeSize
+}
+
+// Returns the vnodes a node joining the ring gets. A node that cannot say how
+// big it is would not take the migration either, so that is an error, unless
+// it predates Stats.
+func (n *NetworkVideoContentService) weightForNewNode(client *storageClient) (int, error) {
+	n.mu.RLock()
+	vnodeSize := n.vnodeSize
+	n.mu.RUnlock()
+	if vnodeSize == 0 {
+		return 1, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+	defer cancel()
+	response, err := client.Stats(ctx, &proto.StatsRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return vnodesFor(response.CapacityBytes, vnodeSize), nil
+}
+
+type nodeStats struct {
+	*proto.StatsResponse
+	err error
+}
+
+// Asks every node in addrs for its Stats in parallel
+func (n *NetworkVideoContentService) collectStats(addrs []string) map[string]nodeStats {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	stats := make(map[string]nodeStats, len(addrs))
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var s nodeStats
+			client, err := n.getStorageClient(addr)
+			if err == nil {
+				ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+				s.StatsResponse, err = client.Stats(ctx, &proto.StatsRequest{})
+				cancel()
+			}
+			s.err = err
+			mu.Lock()
+			stats[addr] = s
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return stats
+}
@@ -0,0 +1,147 @@
+// A local video content service that deduplicates files by content
+
+package web
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+	"tritontube/internal/cas"
+	"tritontube/internal/contentkey"
+)
+
+// CASVideoContentService implements VideoContentService with a cas.Store, so
+// files with the same bytes, such as the init segments of every upload or a
+// video uploaded twice, are stored once.
+type CASVideoContentService struct {
+	store *cas.Store
+}
+
+var _ VideoContentService = (*CASVideoContentService)(nil)
+
+// DefaultBlobGCInterval is how often blobs no file refers to are removed
+const DefaultBlobGCInterval = 10 * time.Minute
+
+func NewCASVideoContentService(root string) (*CASVideoContentService, error) {
+	store, err := cas.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	return &CASVideoContentService{store: store}, nil
+}
+
+func (c *CASVideoContentService) Read(videoId string, filename string) ([]byte, error) {
+	k, err := contentkey.New(videoId, filename)
+	if err != nil {
+		return nil, err
+	}
+	return c.store.ReadFile(k)
+}
+
+// OpenContext opens the blob of a file for serving, with its digest
+func (c *CASVideoContentService) OpenContext(ctx context.Context, videoId string, filename string) (*contentFile, error) {
+	k, err := contentkey.New(videoId, filename)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := c.store.Digest(k)
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.store.Open(k)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &contentFile{ReadSeekCloser: f, size: info.Size(), sha256: digest}, nil
+}
+
+func (c *CASVideoContentService) Write(videoId string, filename string, data []byte) error {
+	k, err := contentkey.New(videoId, filename)
+	if err != nil {
+		return err
+	}
+	return c.store.WriteFile(k, data)
+}
+
+func (c *CASVideoContentService) WriteStream(videoId string, filename string, r io.Reader) error {
+	k, err := contentkey.New(videoId, filename)
+	if err != nil {
+		return err
+	}
+	return c.store.WriteFrom(k, r)
+}
+
+func (c *CASVideoContentService) List(videoId string) ([]string, error) {
+	if err := contentkey.ValidateComponent(videoId); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(c.store.RefsDir(), videoId))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var filenames []string
+	for _, entry := range entries {
+		// Skips temp files of in-progress writes
+		if entry.IsDir() || contentkey.ValidateComponent(entry.Name()) != nil {
+			continue
+		}
+		filenames = append(filenames, entry.Name())
+	}
+	return filenames, nil
+}
+
+// Delete drops the file's ref; its blob goes with the next GC unless other
+// files share it
+func (c *CASVideoContentService) Delete(videoId string, filename string) error {
+	k, err := contentkey.New(videoId, filename)
+	if err != nil {
+		return err
+	}
+	if err := c.store.Remove(k); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (c *CASVideoContentService) StageStream(txId string, videoId string, filename string, r io.Reader) error {
+	k, err := contentkey.New(videoId, filename)
+	if err != nil {
+		return err
+	}
+	return c.store.StageFrom(txId, k, r)
+}
+
+func (c *CASVideoContentService) CommitStaged(txId string) error {
+	_, err := c.store.CommitStaged(txId)
+	return err
+}
+
+func (c *CASVideoContentService) AbortStaged(txId string) error {
+	return c.store.AbortStaged(txId)
+}
+
+// CollectGarbage removes the blobs no file refers to any more once per
+// interval. It blocks, so run it in its own goroutine.
+func (c *CASVideoContentService) CollectGarbage(interval time.Duration) {
+	for range time.Tick(interval) {
+		result, err := c.store.GC()
+		if err != nil {
+			slog.Error("Blob GC failed", "err", err)
+		}
+		if result.Blobs > 0 {
+			slog.Info("Blob GC removed blobs", "blobs", result.Blobs, "bytes", result.Bytes)
+		}
+	}
+}
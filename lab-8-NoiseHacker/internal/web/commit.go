@@ -0,0 +1,292 @@
+// Publishing uploads atomically.
+//
+// A transcoded upload is published with a two-phase commit across the content
+// service and the metadata service. Its files are staged on the content nodes
+// while ffmpeg produces them, and its metadata is prepared hidden, which also
+// checks that its video ID is still free. Only once both halves are prepared is
+// the decision to commit written to the recovery log, and then both halves are
+// committed. If anything fails before that, both are aborted, so a failed
+// upload leaves neither orphaned segments nor a video without content.
+//
+// The log lets a web server that crashed midway finish the job: on restart,
+// and every GC interval after, uploads logged as committing are committed
+// again and every other unfinished one is aborted. Both participants treat a
+// repeated commit or abort as done, so repeating them is safe.
+
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"tritontube/internal/telemetry"
+)
+
+// Name of the recovery log in the spool dir
+const commitLogName = "uploads.log"
+
+// txState is how far an upload transaction got
+type txState string
+
+const (
+	txPreparing  txState = "preparing"  // staging; aborted if found unfinished
+	txCommitting txState = "committing" // both halves prepared, decided to commit
+	txAborting   txState = "aborting"
+	txDone       txState = "done" // both halves committed or aborted
+)
+
+// logRecord is one line of the recovery log
+type logRecord struct {
+	TxId    string    `json:"tx"`
+	JobId   string    `json:"job"`
+	VideoId string    `json:"video"`
+	State   txState   `json:"state"`
+	Time    time.Time `json:"time"`
+}
+
+// commitLog is an append-only file of JSON logRecords, synced after every
+// record. Opening it compacts it down to the unfinished transactions.
+type commitLog struct {
+	mu   sync.Mutex
+	file *os.File
+	open map[string]logRecord // latest record of each unfinished transaction
+}
+
+func openCommitLog(path string) (*commitLog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read upload log: %w", err)
+	}
+	open := make(map[string]logRecord)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var rec logRecord
+		// Skips the torn last line of an append cut short by a crash
+		if len(line) == 0 || json.Unmarshal(line, &rec) != nil {
+			continue
+		}
+		if rec.State == txDone {
+			delete(open, rec.TxId)
+		} else {
+			open[rec.TxId] = rec
+		}
+	}
+
+	var compacted bytes.Buffer
+	for _, rec := range open {
+		line, _ := json.Marshal(rec)
+		compacted.Write(append(line, '\n'))
+	}
+	if err := writeSynced(path+".tmp", compacted.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to compact upload log: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return nil, fmt.Errorf("failed to compact upload log: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload log: %w", err)
+	}
+	return &commitLog{file: file, open: open}, nil
+}
+
+func writeSynced(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Durably records a transaction's new state
+func (l *commitLog) append(rec logRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rec.Time = time.Now()
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	if rec.State == txDone {
+		delete(l.open, rec.TxId)
+	} else {
+		l.open[rec.TxId] = rec
+	}
+	return nil
+}
+
+// Returns the latest record of every unfinished transaction, oldest first
+func (l *commitLog) unfinished() []logRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var recs []logRecord
+	for _, rec := range l.open {
+		recs = append(recs, rec)
+	}
+	slices.SortFunc(recs, func(a, b logRecord) int { return a.Time.Compare(b.Time) })
+	return recs
+}
+
+// uploadCoordinator runs the upload transactions of one web server
+type uploadCoordinator struct {
+	metadataService VideoMetadataService
+	contentService  VideoContentService
+	log             *commitLog
+
+	mu     sync.Mutex
+	active map[string]bool // transactions still being run, which recovery leaves alone
+}
+
+func newUploadCoordinator(metadataService VideoMetadataService, contentService VideoContentService, logPath string) (*uploadCoordinator, error) {
+	l, err := openCommitLog(logPath)
+	if err != nil {
+		return nil, err
+	}
+	return &uploadCoordinator{
+		metadataService: metadataService,
+		contentService:  contentService,
+		log:             l,
+		active:          make(map[string]bool),
+	}, nil
+}
+
+// uploadTx is one upload being published. Files written to it are staged.
+type uploadTx struct {
+	c   *uploadCoordinator
+	ctx context.Context // of the request or job running the upload
+	rec logRecord
+}
+
+// Starts the transaction that publishes a job's video. The content service is
+// called with ctx, except that committing or aborting goes on after it is
+// canceled, so a broadcaster hanging up does not leave the upload for recovery.
+func (c *uploadCoordinator) begin(ctx context.Context, job *TranscodeJob) (*uploadTx, error) {
+	rec := logRecord{TxId: newID(), JobId: job.Id, VideoId: job.VideoId, State: txPreparing}
+	c.mu.Lock()
+	c.active[rec.TxId] = true
+	c.mu.Unlock()
+	if err := c.log.append(rec); err != nil {
+		c.release(rec.TxId)
+		return nil, fmt.Errorf("failed to log upload transaction: %w", err)
+	}
+	return &uploadTx{c: c, ctx: ctx, rec: rec}, nil
+}
+
+func (c *uploadCoordinator) release(txId string) {
+	c.mu.Lock()
+	delete(c.active, txId)
+	c.mu.Unlock()
+}
+
+// WriteStream stages a file of the upload
+func (tx *uploadTx) WriteStream(videoId string, filename string, r io.Reader) error {
+	return stageContent(tx.ctx, tx.c.contentService, tx.rec.TxId, videoId, filename, r)
+}
+
+// Prepares the metadata and commits the upload, or aborts it if preparing
+// fails. Once the decision is logged the upload counts as published, even if a
+// participant has yet to commit; recovery retries those.
+func (tx *uploadTx) commit(meta VideoMetadata) error {
+	if err := tx.c.metadataService.PrepareCreate(tx.rec.TxId, meta); err != nil {
+		tx.abort()
+		if errors.Is(err, ErrVideoIDTaken) {
+			return err
+		}
+		return fmt.Errorf("failed to prepare metadata: %w", err)
+	}
+
+	tx.rec.State = txCommitting
+	if err := tx.c.log.append(tx.rec); err != nil {
+		tx.abort()
+		return fmt.Errorf("failed to log commit: %w", err)
+	}
+	defer tx.c.release(tx.rec.TxId)
+	if err := tx.c.finish(context.WithoutCancel(tx.ctx), tx.rec); err != nil {
+		slog.WarnContext(tx.ctx, "Upload committed but not finished, will retry", "video_id", tx.rec.VideoId, "tx_id", tx.rec.TxId, "err", err)
+	}
+	return nil
+}
+
+// Aborts the upload. Whatever fails to abort now is retried by recovery.
+func (tx *uploadTx) abort() {
+	defer tx.c.release(tx.rec.TxId)
+	tx.rec.State = txAborting
+	if err := tx.c.log.append(tx.rec); err != nil {
+		// Still logged as preparing, which recovery aborts as well
+		slog.ErrorContext(tx.ctx, "Failed to log abort of upload transaction", "tx_id", tx.rec.TxId, "err", err)
+	}
+	if err := tx.c.finish(context.WithoutCancel(tx.ctx), tx.rec); err != nil {
+		slog.WarnContext(tx.ctx, "Upload not fully aborted, will retry", "video_id", tx.rec.VideoId, "tx_id", tx.rec.TxId, "err", err)
+	}
+}
+
+// Commits or aborts both halves of a transaction as its record says, and logs
+// it done once both have
+func (c *uploadCoordinator) finish(ctx context.Context, rec logRecord) error {
+	var contentErr, metadataErr error
+	if rec.State == txCommitting {
+		contentErr = commitStagedContent(ctx, c.contentService, rec.TxId)
+		metadataErr = c.metadataService.CommitCreate(rec.TxId)
+	} else {
+		contentErr = abortStagedContent(ctx, c.contentService, rec.TxId)
+		metadataErr = c.metadataService.AbortCreate(rec.TxId)
+	}
+	if err := errors.Join(contentErr, metadataErr); err != nil {
+		return err
+	}
+	rec.State = txDone
+	return c.log.append(rec)
+}
+
+// Finishes the transactions left unfinished by failures or a crash: those
+// that decided to commit are committed, the rest are aborted. A committed
+// upload's job is marked ready, in case the crash came before the worker did.
+func (c *uploadCoordinator) recover() {
+	ctx := telemetry.Background()
+	for _, rec := range c.log.unfinished() {
+		c.mu.Lock()
+		active := c.active[rec.TxId]
+		c.mu.Unlock()
+		if active {
+			continue
+		}
+
+		if rec.State == txCommitting {
+			if job, err := c.metadataService.ReadJob(rec.JobId); err == nil && job.Status != JobReady {
+				if err := c.metadataService.UpdateJob(rec.JobId, JobReady, ""); err != nil {
+					slog.ErrorContext(ctx, "Failed to mark job ready", "job_id", rec.JobId, "err", err)
+				}
+			}
+		} else {
+			rec.State = txAborting
+		}
+		if err := c.finish(ctx, rec); err != nil {
+			slog.WarnContext(ctx, "Upload transaction not finished", "tx_id", rec.TxId, "video_id", rec.VideoId, "state", rec.State, "err", err)
+			continue
+		}
+		slog.InfoContext(ctx, "Finished upload transaction", "tx_id", rec.TxId, "video_id", rec.VideoId, "state", rec.State)
+	}
+}
@@ -0,0 +1,200 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// fakeParticipants record which transactions were committed and aborted. Its
+// content and metadata halves are the two participants.
+type fakeParticipants struct {
+	committed, aborted map[string][]string // participant names by tx ID
+	failCommit         map[string]bool     // content commits that fail
+	readyJobs          []string
+}
+
+func newFakeParticipants() *fakeParticipants {
+	return &fakeParticipants{
+		committed:  make(map[string][]string),
+		aborted:    make(map[string][]string),
+		failCommit: make(map[string]bool),
+	}
+}
+
+type fakeContent struct {
+	VideoContentService
+	*fakeParticipants
+}
+
+type fakeMetadata struct {
+	VideoMetadataService
+	*fakeParticipants
+}
+
+func (f fakeContent) CommitStaged(txId string) error {
+	if f.failCommit[txId] {
+		return errors.New("storage node unavailable")
+	}
+	f.committed[txId] = append(f.committed[txId], "content")
+	return nil
+}
+
+func (f fakeContent) AbortStaged(txId string) error {
+	f.aborted[txId] = append(f.aborted[txId], "content")
+	return nil
+}
+
+func (f fakeMetadata) CommitCreate(txId string) error {
+	f.committed[txId] = append(f.committed[txId], "metadata")
+	return nil
+}
+
+func (f fakeMetadata) AbortCreate(txId string) error {
+	f.aborted[txId] = append(f.aborted[txId], "metadata")
+	return nil
+}
+
+func (f fakeMetadata) ReadJob(jobId string) (*TranscodeJob, error) {
+	return &TranscodeJob{Id: jobId, Status: JobRunning}, nil
+}
+
+func (f fakeMetadata) UpdateJob(jobId string, status JobStatus, errMsg string) error {
+	if status == JobReady {
+		f.readyJobs = append(f.readyJobs, jobId)
+	}
+	return nil
+}
+
+func writeLog(t *testing.T, path string, recs []logRecord, torn string) {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, rec := range recs {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+	buf.WriteString(torn)
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Transactions in the log file, by ID
+func loggedStates(t *testing.T, path string) map[string]txState {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make(map[string]txState)
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var rec logRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			t.Fatalf("compacted log has a bad line %q: %v", line, err)
+		}
+		states[rec.TxId] = rec.State
+	}
+	return states
+}
+
+func TestRecoverFinishesWhatTheLogSays(t *testing.T) {
+	path := filepath.Join(t.TempDir(), commitLogName)
+	writeLog(t, path, []logRecord{
+		{TxId: "preparing", JobId: "job-1", State: txPreparing},
+		{TxId: "committing", JobId: "job-2", State: txPreparing},
+		{TxId: "committing", JobId: "job-2", State: txCommitting},
+		{TxId: "aborting", JobId: "job-3", State: txPreparing},
+		{TxId: "aborting", JobId: "job-3", State: txAborting},
+		{TxId: "done", JobId: "job-4", State: txPreparing},
+		{TxId: "done", JobId: "job-4", State: txCommitting},
+		{TxId: "done", JobId: "job-4", State: txDone},
+		{TxId: "failing", JobId: "job-5", State: txCommitting},
+		{TxId: "torn", JobId: "job-6", State: txPreparing},
+	}, `{"tx":"torn","job":"job-6","state":"commi`)
+
+	f := newFakeParticipants()
+	f.failCommit["failing"] = true
+	c, err := newUploadCoordinator(fakeMetadata{fakeParticipants: f}, fakeContent{fakeParticipants: f}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Opening compacts the log down to the unfinished transactions, and the
+	// torn commit decision never counted
+	want := map[string]txState{"preparing": txPreparing, "committing": txCommitting, "aborting": txAborting, "failing": txCommitting, "torn": txPreparing}
+	if got := loggedStates(t, path); !maps.Equal(got, want) {
+		t.Fatalf("compacted log holds %v, want %v", got, want)
+	}
+
+	c.recover()
+	both := []string{"content", "metadata"}
+	if !slices.Equal(f.committed["committing"], both) {
+		t.Errorf("committing transaction was committed by %v, want %v", f.committed["committing"], both)
+	}
+	for _, txId := range []string{"preparing", "aborting", "torn"} {
+		if !slices.Equal(f.aborted[txId], both) || f.committed[txId] != nil {
+			t.Errorf("%s transaction was aborted by %v and committed by %v, want aborted by both", txId, f.aborted[txId], f.committed[txId])
+		}
+	}
+	if f.committed["done"] != nil || f.aborted["done"] != nil {
+		t.Error("a finished transaction was finished again")
+	}
+	if f.aborted["failing"] != nil {
+		t.Error("a transaction that decided to commit was aborted")
+	}
+	slices.Sort(f.readyJobs)
+	if want := []string{"job-2", "job-5"}; !slices.Equal(f.readyJobs, want) {
+		t.Errorf("jobs marked ready: %v, want %v", f.readyJobs, want)
+	}
+
+	// Only the commit that failed is left, and reopening compacts to it
+	if got := c.log.unfinished(); len(got) != 1 || got[0].TxId != "failing" {
+		t.Fatalf("unfinished after recovery: %v", got)
+	}
+	c.log.file.Close()
+	c, err = newUploadCoordinator(fakeMetadata{fakeParticipants: f}, fakeContent{fakeParticipants: f}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loggedStates(t, path); !maps.Equal(got, map[string]txState{"failing": txCommitting}) {
+		t.Fatalf("log after recovery holds %v", got)
+	}
+
+	// The next pass retries it, committing the metadata again as well
+	f.failCommit["failing"] = false
+	c.recover()
+	if !slices.Equal(f.committed["failing"], []string{"metadata", "content", "metadata"}) || len(c.log.unfinished()) != 0 {
+		t.Fatalf("retried commit gave %v, unfinished %v", f.committed["failing"], c.log.unfinished())
+	}
+}
+
+// Recovery leaves transactions this web server is still running alone
+func TestRecoverSkipsActiveTransactions(t *testing.T) {
+	f := newFakeParticipants()
+	c, err := newUploadCoordinator(fakeMetadata{fakeParticipants: f}, fakeContent{fakeParticipants: f}, filepath.Join(t.TempDir(), commitLogName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := c.begin(t.Context(), &TranscodeJob{Id: "job", VideoId: "video"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.recover()
+	if f.aborted[tx.rec.TxId] != nil {
+		t.Fatal("recovery aborted a running upload")
+	}
+	tx.abort()
+	if !slices.Equal(f.aborted[tx.rec.TxId], []string{"content", "metadata"}) || len(c.log.unfinished()) != 0 {
+		t.Fatalf("abort gave %v, unfinished %v", f.aborted[tx.rec.TxId], c.log.unfinished())
+	}
+}
@@ -0,0 +1,95 @@
+// Deleting videos.
+//
+// A delete first tombstones the video's metadata, so it disappears from the site
+// right away, and then removes its content. If that fails midway (a storage node
+// is down, the server restarts) the tombstone stays, and the garbage collector
+// keeps retrying until all content is gone before it drops the metadata row.
+
+package web
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"tritontube/internal/telemetry"
+)
+
+// DefaultGCInterval is how often deleted videos are retried unless GCInterval is changed
+const DefaultGCInterval = time.Minute
+
+// Tombstones a video and removes its content in the background, with the
+// request ID of ctx but not its deadline
+func (s *server) deleteVideo(ctx context.Context, videoId string) error {
+	if err := s.metadataService.MarkDeleted(videoId); err != nil {
+		return err
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if err := s.purgeVideo(ctx, videoId); err != nil {
+			slog.WarnContext(ctx, "Deleting video incomplete, will retry", "video_id", videoId, "err", err)
+		}
+	}()
+	return nil
+}
+
+// Removes a tombstoned video's content and then its metadata
+func (s *server) purgeVideo(ctx context.Context, videoId string) error {
+	count, err := deleteVideoContent(ctx, s.contentService, videoId)
+	if err != nil {
+		return err
+	}
+	if err := s.metadataService.Delete(videoId); err != nil {
+		return fmt.Errorf("failed to delete metadata: %w", err)
+	}
+	slog.InfoContext(ctx, "Deleted video", "video_id", videoId, "files", count)
+	return nil
+}
+
+// Removes every file of a video and returns how many there were. Manifests
+// and playlists go first, so players never load a manifest whose segments are
+// gone.
+func deleteVideoContent(ctx context.Context, cs VideoContentService, videoId string) (int, error) {
+	filenames, err := listContent(ctx, cs, videoId)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list content: %w", err)
+	}
+
+	var manifests, rest []string
+	for _, name := range filenames {
+		if strings.HasSuffix(name, ".mpd") || strings.HasSuffix(name, ".m3u8") {
+			manifests = append(manifests, name)
+		} else {
+			rest = append(rest, name)
+		}
+	}
+	for _, name := range append(manifests, rest...) {
+		if err := deleteContent(ctx, cs, videoId, name); err != nil {
+			return 0, fmt.Errorf("failed to delete %s: %w", name, err)
+		}
+	}
+	return len(filenames), nil
+}
+
+// Retries the deletes and upload transactions that did not finish, and removes
+// expired resumable uploads, every interval
+func (s *server) collectGarbage(interval time.Duration) {
+	for {
+		s.transcodeQueue.uploads.recover()
+		s.uploadSessions.expire(s.UploadSessionTTL)
+
+		ids, err := s.metadataService.ListDeleted()
+		if err != nil {
+			slog.Error("Failed to list deleted videos", "err", err)
+		}
+		for _, id := range ids {
+			ctx := telemetry.Background()
+			if err := s.purgeVideo(ctx, id); err != nil {
+				slog.WarnContext(ctx, "Deleting video incomplete, will retry", "video_id", id, "err", err)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
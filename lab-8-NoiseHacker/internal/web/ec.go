@@ -0,0 +1,545 @@
+// Erasure-coded storage for the network content service.
+//
+// With UseErasureCoding, every file is split into k data and m parity shards,
+// which go to the first k+m distinct owners of its key, each stored under the
+// file's own key. A shard starts with a header naming its index, the time of
+// the write and the checksum of the whole file, so a reader can gather any k
+// shards of the same write from whichever nodes hold them, in the current ring
+// or the previous one, and tell them apart from the shards of an older version.
+// A write only needs k+1 shards stored, so older shards can outnumber the
+// newest write's on some nodes; the newest write with k shards is the one read.
+// Up to m shards can be missing or corrupt.
+//
+// Migrations move shards rather than copies: every new owner without a shard
+// of the key gets one of the shards no new owner holds, copied if some node
+// still has it and rebuilt from k others if not. That is also how the shards
+// of a node that was removed, dead or alive, are repaired.
+//
+// Files written before erasure coding was turned on are full copies without a
+// shard header. They are read as they are, and split into shards the next
+// time a migration or repair places them.
+
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+	"tritontube/internal/erasure"
+	"tritontube/internal/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	shardMagic      = "TTEC"
+	shardVersion    = 2
+	shardHeaderSize = 32
+
+	// Version 1 headers had no generation; their shards count as older than
+	// any version 2 write
+	shardVersion1    = 1
+	shardHeader1Size = 24
+)
+
+// errNotShard means a payload is a full copy of a file written before erasure
+// coding was turned on
+var errNotShard = errors.New("not an erasure-coded shard")
+
+// shardHeader precedes the bytes of every shard: magic, version, k, m, index,
+// size (8 bytes), file CRC, shard CRC, generation (8 bytes)
+type shardHeader struct {
+	dataShards   int
+	parityShards int
+	index        int
+	size         int    // of the whole file
+	fileCRC      uint32 // of the whole file, which identifies the write
+	shardCRC     uint32 // of the shard bytes, to detect corruption
+	generation   int64  // when the file was written, in Unix nanoseconds
+}
+
+// shard is one shard read back from a node
+type shard struct {
+	shardHeader
+	data []byte
+	raw  []byte // as stored, header included
+}
+
+// Identifies the write a shard belongs to
+type shardGroup struct {
+	generation int64
+	size       int
+	fileCRC    uint32
+}
+
+func (h shardHeader) group() shardGroup {
+	return shardGroup{generation: h.generation, size: h.size, fileCRC: h.fileCRC}
+}
+
+// Reports whether g was written after other. Writes from the same instant
+// are ordered by checksum, so every reader picks the same one.
+func (g shardGroup) newerThan(other shardGroup) bool {
+	if g.generation != other.generation {
+		return g.generation > other.generation
+	}
+	return g.fileCRC > other.fileCRC
+}
+
+func encodeShard(h shardHeader, data []byte) []byte {
+	buf := make([]byte, shardHeaderSize, shardHeaderSize+len(data))
+	copy(buf, shardMagic)
+	buf[4] = shardVersion
+	buf[5] = byte(h.dataShards)
+	buf[6] = byte(h.parityShards)
+	buf[7] = byte(h.index)
+	binary.BigEndian.PutUint64(buf[8:], uint64(h.size))
+	binary.BigEndian.PutUint32(buf[16:], h.fileCRC)
+	binary.BigEndian.PutUint32(buf[20:], crc32.ChecksumIEEE(data))
+	binary.BigEndian.PutUint64(buf[24:], uint64(h.generation))
+	return append(buf, data...)
+}
+
+func decodeShard(raw []byte) (*shard, error) {
+	if len(raw) < shardHeader1Size || string(raw[:4]) != shardMagic {
+		return nil, errNotShard
+	}
+	headerSize := shardHeaderSize
+	switch raw[4] {
+	case shardVersion:
+		if len(raw) < shardHeaderSize {
+			return nil, errors.New("shard header cut short")
+		}
+	case shardVersion1:
+		headerSize = shardHeader1Size
+	default:
+		return nil, fmt.Errorf("unknown shard version %d", raw[4])
+	}
+	s := &shard{
+		shardHeader: shardHeader{
+			dataShards:   int(raw[5]),
+			parityShards: int(raw[6]),
+			index:        int(raw[7]),
+			size:         int(binary.BigEndian.Uint64(raw[8:])),
+			fileCRC:      binary.BigEndian.Uint32(raw[16:]),
+			shardCRC:     binary.BigEndian.Uint32(raw[20:]),
+		},
+		data: raw[headerSize:],
+		raw:  raw,
+	}
+	if headerSize == shardHeaderSize {
+		s.generation = int64(binary.BigEndian.Uint64(raw[24:]))
+	}
+	if crc32.ChecksumIEEE(s.data) != s.shardCRC {
+		return nil, errors.New("shard checksum mismatch")
+	}
+	return s, nil
+}
+
+// Decodes a shard and checks it fits the code files are stored with
+func (n *NetworkVideoContentService) decodeShard(raw []byte) (*shard, error) {
+	s, err := decodeShard(raw)
+	if err != nil {
+		return nil, err
+	}
+	if s.dataShards != n.code.DataShards() || s.parityShards != n.code.ParityShards() {
+		return nil, fmt.Errorf("shard coded %d+%d", s.dataShards, s.parityShards)
+	}
+	if s.index >= n.code.TotalShards() {
+		return nil, fmt.Errorf("shard index %d out of range", s.index)
+	}
+	return s, nil
+}
+
+// UseErasureCoding stores every file as dataShards data and parityShards
+// parity shards on distinct nodes instead of as full replicas, so the ring
+// needs at least dataShards+parityShards nodes. Files written before stay
+// readable as full copies until a migration or repair splits them.
+func (n *NetworkVideoContentService) UseErasureCoding(dataShards, parityShards int) error {
+	code, err := erasure.New(dataShards, parityShards)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ring.size() < code.TotalShards() {
+		return fmt.Errorf("erasure coding %d+%d needs at least %d storage nodes, have %d", dataShards, parityShards, code.TotalShards(), n.ring.size())
+	}
+	n.code = code
+	n.replicas = code.TotalShards()
+	return nil
+}
+
+// Splits data into shards and stores shard i on the key's i-th owner, staged
+// under txID unless it is "". Owners that are down are skipped; the write
+// succeeds as long as a write quorum of shards is stored, and the rest are
+// left for repair.
+func (n *NetworkVideoContentService) writeShards(ctx context.Context, txID string, key string, data []byte) error {
+	code := n.code
+	shards := code.Split(data)
+	if err := code.Encode(shards); err != nil {
+		return err
+	}
+	header := shardHeader{
+		dataShards:   code.DataShards(),
+		parityShards: code.ParityShards(),
+		size:         len(data),
+		fileCRC:      crc32.ChecksumIEEE(data),
+		generation:   time.Now().UnixNano(),
+	}
+
+	n.mu.RLock()
+	owners := n.ring.owners(key, code.TotalShards())
+	var down []string
+	for _, addr := range owners {
+		if n.nodeStateLocked(addr) == nodeDown {
+			down = append(down, addr)
+		}
+	}
+	n.mu.RUnlock()
+	if len(owners) < code.TotalShards() {
+		return fmt.Errorf("need %d storage nodes for key %s, have %d", code.TotalShards(), key, len(owners))
+	}
+
+	written := 0
+	var lastErr error
+	for i, nodeAddr := range owners {
+		if slices.Contains(down, nodeAddr) {
+			continue
+		}
+		header.index = i
+		if err := n.sendToNode(ctx, nodeAddr, key, txID, encodeShard(header, shards[i])); err != nil {
+			slog.WarnContext(ctx, "Failed to write shard", "node", nodeAddr, "key", key, "err", err)
+			lastErr = err
+			continue
+		}
+		written++
+	}
+	quorum := shardWriteQuorum(code)
+	if written < quorum {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("too many storage nodes down")
+		}
+		return fmt.Errorf("stored %d of %d shards of key %s, need %d: %w", written, code.TotalShards(), key, quorum, lastErr)
+	}
+	if written < code.TotalShards() {
+		slog.WarnContext(ctx, "Stored some shards, the rest are left for repair", "key", key, "written", written, "total", code.TotalShards())
+	} else {
+		slog.DebugContext(ctx, "Wrote shards", "key", key, "written", written)
+	}
+	return nil
+}
+
+// How many shards a write has to store: one more than k, so that an
+// acknowledged file survives losing a node, unless there is no parity
+func shardWriteQuorum(code *erasure.Code) int {
+	return min(code.DataShards()+1, code.TotalShards())
+}
+
+// Reads the shards of key from its owners, and from its previous owners
+// during a migration, and decodes the newest write that has k of them. Every
+// holder is asked, since the first k shards to arrive may be of an older write.
+// A full copy from before erasure coding is returned if no write has k shards.
+func (n *NetworkVideoContentService) readShards(ctx context.Context, key string) ([]byte, error) {
+	n.mu.RLock()
+	owners := n.ring.owners(key, n.replicas)
+	candidates := appendUnique(owners, n.migration.previousOwners(key, owners)...)
+	candidates = n.preferLiveLocked(candidates)
+	n.mu.RUnlock()
+
+	type reply struct {
+		raw []byte
+		err error
+	}
+	replies := make([]reply, len(candidates))
+	var wg sync.WaitGroup
+	for i, nodeAddr := range candidates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			raw, err := n.readFromNode(ctx, nodeAddr, key)
+			replies[i] = reply{raw, err}
+		}()
+	}
+	wg.Wait()
+
+	groups := make(map[shardGroup][][]byte)
+	counts := make(map[shardGroup]int)
+	notFound := 0
+	var legacy []byte
+	var lastErr error
+	for i, nodeAddr := range candidates {
+		raw, err := replies[i].raw, replies[i].err
+		if errors.Is(err, os.ErrNotExist) {
+			notFound++
+			continue
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		s, err := n.decodeShard(raw)
+		if errors.Is(err, errNotShard) {
+			// Shards of a newer write win over an old full copy
+			if legacy == nil {
+				legacy = raw
+			}
+			continue
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("storage node %s has a bad shard of key %s: %w", nodeAddr, key, err)
+			continue
+		}
+		g := s.group()
+		if groups[g] == nil {
+			groups[g] = make([][]byte, n.code.TotalShards())
+		}
+		if groups[g][s.index] != nil {
+			continue
+		}
+		groups[g][s.index] = s.data
+		counts[g]++
+	}
+	if g, ok := newestDecodable(counts, n.code.DataShards()); ok {
+		return n.decodeShards(key, g, groups[g])
+	}
+	if legacy != nil {
+		return legacy, nil
+	}
+	if notFound == len(candidates) {
+		return nil, fmt.Errorf("no storage node has key %s: %w", key, os.ErrNotExist)
+	}
+	if lastErr == nil {
+		lastErr = erasure.ErrTooFewShards
+	}
+	return nil, fmt.Errorf("too few shards of key %s: %w", key, lastErr)
+}
+
+// Returns the newest write of which at least k distinct shards were found
+func newestDecodable(counts map[shardGroup]int, k int) (shardGroup, bool) {
+	var newest shardGroup
+	found := false
+	for g, count := range counts {
+		if count >= k && (!found || g.newerThan(newest)) {
+			newest, found = g, true
+		}
+	}
+	return newest, found
+}
+
+// Rebuilds the file of group g from its shards, of which k are present
+func (n *NetworkVideoContentService) decodeShards(key string, g shardGroup, shards [][]byte) ([]byte, error) {
+	dataMissing := slices.ContainsFunc(shards[:n.code.DataShards()], func(b []byte) bool { return b == nil })
+	if dataMissing {
+		if err := n.code.Reconstruct(shards); err != nil {
+			return nil, fmt.Errorf("failed to reconstruct key %s: %w", key, err)
+		}
+	}
+	data, err := n.code.Join(shards, g.size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconstruct key %s: %w", key, err)
+	}
+	if crc32.ChecksumIEEE(data) != g.fileCRC {
+		return nil, fmt.Errorf("reconstructed key %s does not match its checksum", key)
+	}
+	return data, nil
+}
+
+// Makes sure every shard of key is on a distinct node among targets, reading
+// the shards from holders. A file only found as a full copy from before
+// erasure coding is split into shards. Returns how many shards it wrote.
+func (n *NetworkVideoContentService) placeShards(ctx context.Context, key string, holders []string, targets []string) (int, error) {
+	found := make(map[string]*shard)
+	groupCounts := make(map[shardGroup]int)
+	seen := make(map[shardGroup]map[int]bool)
+	var legacy []byte
+	for _, addr := range appendUnique(slices.Clone(targets), holders...) {
+		raw, err := n.readFromNode(ctx, addr, key)
+		if err != nil {
+			continue
+		}
+		s, err := n.decodeShard(raw)
+		if errors.Is(err, errNotShard) && legacy == nil {
+			legacy = raw
+		}
+		if err != nil {
+			continue
+		}
+		found[addr] = s
+		g := s.group()
+		if seen[g] == nil {
+			seen[g] = make(map[int]bool)
+		}
+		if !seen[g][s.index] {
+			seen[g][s.index] = true
+			groupCounts[g]++
+		}
+	}
+	// The newest write that can be decoded is the one to keep. If none can,
+	// the shards of the write with the most around are at least copied.
+	group, ok := newestDecodable(groupCounts, n.code.DataShards())
+	best := groupCounts[group]
+	if !ok {
+		best = 0
+		for g, count := range groupCounts {
+			if count > best {
+				group, best = g, count
+			}
+		}
+	}
+	if best == 0 && legacy == nil {
+		return 0, fmt.Errorf("no shards of key %s found", key)
+	}
+
+	shards := make([][]byte, n.code.TotalShards())
+	raws := make(map[int][]byte)
+	rebuilt := false
+	if best == 0 {
+		shards = n.code.Split(legacy)
+		if err := n.code.Encode(shards); err != nil {
+			return 0, err
+		}
+		group = shardGroup{size: len(legacy), fileCRC: crc32.ChecksumIEEE(legacy)}
+		rebuilt = true
+	}
+	for _, s := range found {
+		if s.group() == group && shards[s.index] == nil {
+			shards[s.index] = s.data
+			raws[s.index] = s.raw
+		}
+	}
+	placed := make(map[int]bool)
+	var free []string
+	for _, addr := range targets {
+		s, ok := found[addr]
+		if ok && s.group() == group && !placed[s.index] {
+			placed[s.index] = true
+		} else {
+			free = append(free, addr)
+		}
+	}
+
+	written := 0
+	for index := range shards {
+		if placed[index] {
+			continue
+		}
+		if len(free) == 0 {
+			return written, fmt.Errorf("no node left for shard %d of key %s", index, key)
+		}
+		raw, ok := raws[index]
+		if !ok {
+			if !rebuilt {
+				if err := n.code.Reconstruct(shards); err != nil {
+					return written, fmt.Errorf("failed to rebuild shards of key %s: %w", key, err)
+				}
+				rebuilt = true
+			}
+			raw = encodeShard(shardHeader{
+				dataShards:   n.code.DataShards(),
+				parityShards: n.code.ParityShards(),
+				index:        index,
+				size:         group.size,
+				fileCRC:      group.fileCRC,
+				generation:   group.generation,
+			}, shards[index])
+		}
+		if err := n.sendToNode(ctx, free[0], key, "", raw); err != nil {
+			return written, err
+		}
+		free = free[1:]
+		written++
+	}
+	return written, nil
+}
+
+// Moves shards for every key whose owners change, or that has a shard on a
+// node that does not own it, see placeShards. A file counts as migrated once
+// all of its shards are placed.
+func (n *NetworkVideoContentService) migrateShards(ctx context.Context, m *migrationState, sources []string) {
+	listedOn := make(map[string][]string)
+	var keys []string
+	for _, addr := range appendUnique(slices.Clone(sources), m.newRing.nodes()...) {
+		nodeKeys, err := n.getAllKeysFromNode(ctx, addr)
+		if err != nil {
+			slog.ErrorContext(ctx, "Migration failed to list keys", "node", addr, "err", err)
+			continue
+		}
+		for _, key := range nodeKeys {
+			if _, ok := listedOn[key]; !ok {
+				keys = append(keys, key)
+			}
+			listedOn[key] = append(listedOn[key], addr)
+		}
+	}
+	keys = slices.DeleteFunc(keys, func(key string) bool {
+		oldOwners := m.oldRing.owners(key, m.replicas)
+		newOwners := m.newRing.owners(key, m.replicas)
+		sameOwners := slices.Equal(slices.Sorted(slices.Values(oldOwners)), slices.Sorted(slices.Values(newOwners)))
+		return sameOwners && !slices.ContainsFunc(listedOn[key], func(addr string) bool {
+			return !slices.Contains(newOwners, addr)
+		})
+	})
+	m.mu.Lock()
+	m.total = len(keys)
+	m.mu.Unlock()
+
+	for _, key := range keys {
+		holders := appendUnique(m.oldRing.owners(key, m.replicas), listedOn[key]...)
+		newOwners := m.newRing.owners(key, m.replicas)
+		_, err := n.placeShards(ctx, key, holders, newOwners)
+		m.mu.Lock()
+		if err != nil {
+			slog.ErrorContext(ctx, "Migration failed to place shards", "key", key, "err", err)
+			m.failed[key] = true
+		} else {
+			m.copied++
+			m.confirmed[key] = true
+		}
+		m.mu.Unlock()
+	}
+}
+
+// Streams data to one node under key, staged under txID unless it is ""
+func (n *NetworkVideoContentService) sendToNode(ctx context.Context, nodeAddr string, key string, txID string, data []byte) error {
+	client, err := n.getStorageClient(nodeAddr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.WriteFileStream(ctx)
+	if err != nil {
+		return fmt.Errorf("storage node %s failed to write key %s: %w", nodeAddr, key, err)
+	}
+	r := bytes.NewReader(data)
+	buf := make([]byte, streamChunkSize)
+	for first := true; first || r.Len() > 0; first = false {
+		size, _ := io.ReadFull(r, buf)
+		chunk := &proto.WriteFileChunk{Data: buf[:size]}
+		if first {
+			chunk.Key = key
+			chunk.TxId = txID
+		}
+		if err := stream.Send(chunk); err != nil {
+			break // the real error comes with CloseAndRecv
+		}
+	}
+	response, err := stream.CloseAndRecv()
+	if err == nil && !response.Success {
+		err = status.Error(codes.Internal, "write not confirmed")
+	}
+	if err != nil {
+		return fmt.Errorf("storage node %s failed to write key %s: %w", nodeAddr, key, err)
+	}
+	return nil
+}
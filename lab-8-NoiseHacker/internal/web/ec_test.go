@@ -0,0 +1,221 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"net"
+	"slices"
+	"testing"
+
+	"tritontube/internal/proto"
+	"tritontube/internal/storage"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testNode is a storage node serving a temp dir on a local port
+type testNode struct {
+	addr   string
+	server *grpc.Server
+}
+
+func startStorageNodes(t *testing.T, count int) []*testNode {
+	t.Helper()
+	var nodes []*testNode
+	for range count {
+		handler, err := storage.NewStorageHandler(t.TempDir(), 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listen, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := grpc.NewServer()
+		proto.RegisterStorageServiceServer(server, handler)
+		healthServer := health.NewServer()
+		healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+		healthpb.RegisterHealthServer(server, healthServer)
+		go server.Serve(listen)
+		t.Cleanup(server.Stop)
+		nodes = append(nodes, &testNode{addr: listen.Addr().String(), server: server})
+	}
+	return nodes
+}
+
+func nodeAddrs(nodes []*testNode) []string {
+	var addrs []string
+	for _, node := range nodes {
+		addrs = append(addrs, node.addr)
+	}
+	return addrs
+}
+
+func newErasureCodedService(t *testing.T, nodes []*testNode, k, m int) *NetworkVideoContentService {
+	t.Helper()
+	n, err := NewNetworkVideoContentService("", nodeAddrs(nodes), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.UseErasureCoding(k, m); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestErasureCodedReadSurvivesLosingParityCount(t *testing.T) {
+	const k, m = 4, 2
+	nodes := startStorageNodes(t, k+m)
+	n := newErasureCodedService(t, nodes, k, m)
+
+	data := make([]byte, 3*streamChunkSize+17)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := n.Write("video", "chunk-0-00001.m4s", data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every node owns one shard, so stopping m of them drops m shards
+	for _, node := range nodes[:m] {
+		node.server.Stop()
+	}
+	got, err := n.Read("video", "chunk-0-00001.m4s")
+	if err != nil {
+		t.Fatalf("Read with %d of %d shards lost: %v", m, k+m, err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("Read returned different bytes")
+	}
+
+	nodes[m].server.Stop()
+	if _, err := n.Read("video", "chunk-0-00001.m4s"); err == nil {
+		t.Fatalf("Read with %d of %d shards lost succeeded", m+1, k+m)
+	}
+}
+
+func TestPlaceShardsRebuildsLostShards(t *testing.T) {
+	const k, m = 2, 1
+	nodes := startStorageNodes(t, k+m+1)
+	n := newErasureCodedService(t, nodes, k, m)
+	data := []byte("a file that loses a shard and gets it back")
+	if err := n.Write("video", "manifest.mpd", data); err != nil {
+		t.Fatal(err)
+	}
+	key := "video/manifest.mpd"
+	owners := n.ring.owners(key, k+m)
+	byAddr := make(map[string]*testNode)
+	var spare string
+	for _, node := range nodes {
+		byAddr[node.addr] = node
+		if !slices.Contains(owners, node.addr) {
+			spare = node.addr
+		}
+	}
+
+	// The shard on the stopped owner is rebuilt from the other two onto the spare
+	byAddr[owners[0]].server.Stop()
+	targets := append(slices.Clone(owners[1:]), spare)
+	written, err := n.placeShards(t.Context(), key, owners, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 1 {
+		t.Fatalf("placeShards wrote %d shards, want 1", written)
+	}
+	raw, err := n.readFromNode(t.Context(), spare, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.decodeShard(raw); err != nil {
+		t.Fatalf("rebuilt shard does not decode: %v", err)
+	}
+}
+
+func TestShardHeaderRoundTrip(t *testing.T) {
+	h := shardHeader{dataShards: 4, parityShards: 2, index: 5, size: 1 << 33, fileCRC: 0xdeadbeef, generation: 1e18}
+	raw := encodeShard(h, []byte("shard bytes"))
+	s, err := decodeShard(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.group() != h.group() || s.index != h.index || s.dataShards != 4 || s.parityShards != 2 {
+		t.Fatalf("decoded header %+v, want %+v", s.shardHeader, h)
+	}
+	if string(s.data) != "shard bytes" {
+		t.Fatalf("decoded data %q", s.data)
+	}
+
+	corrupt := bytes.Clone(raw)
+	corrupt[len(corrupt)-1] ^= 1
+	if _, err := decodeShard(corrupt); err == nil {
+		t.Fatal("shard with a flipped bit decoded")
+	}
+	// Version 1 shards, from before the generation, read as the oldest writes
+	v1 := append(bytes.Clone(raw[:shardHeader1Size]), "shard bytes"...)
+	v1[4] = shardVersion1
+	s, err = decodeShard(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.generation != 0 || string(s.data) != "shard bytes" || s.fileCRC != h.fileCRC {
+		t.Fatalf("decoded version 1 shard %+v %q", s.shardHeader, s.data)
+	}
+
+	if _, err := decodeShard([]byte("a full copy from before erasure coding")); !errors.Is(err, errNotShard) {
+		t.Fatalf("decoding a full copy returned %v, want errNotShard", err)
+	}
+}
+
+// A rewrite only reaches k+1 nodes for sure, so with m-1 >= k the shards of
+// the previous write can still be k strong
+func TestErasureCodedReadPrefersNewestWrite(t *testing.T) {
+	const k, m = 2, 3
+	nodes := startStorageNodes(t, k+m)
+	n := newErasureCodedService(t, nodes, k, m)
+	key := "video/manifest.mpd"
+	if err := n.Write("video", "manifest.mpd", []byte("old manifest")); err != nil {
+		t.Fatal(err)
+	}
+	old := make(map[string][]byte)
+	for _, addr := range nodeAddrs(nodes) {
+		raw, err := n.readFromNode(t.Context(), addr, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old[addr] = raw
+	}
+	if err := n.Write("video", "manifest.mpd", []byte("new manifest")); err != nil {
+		t.Fatal(err)
+	}
+	// As if the rewrite had missed m-1 nodes, whichever are asked first
+	owners := n.ring.owners(key, k+m)
+	for _, addr := range owners[:m-1] {
+		if err := n.sendToNode(t.Context(), addr, key, "", old[addr]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := n.Read("video", "manifest.mpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new manifest" {
+		t.Fatalf("Read returned %q, want the newest write", got)
+	}
+
+	// Repair replaces the stale shards rather than the new ones
+	if _, err := n.placeShards(t.Context(), key, owners, owners); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range owners[:m-1] {
+		raw, err := n.readFromNode(t.Context(), addr, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(raw, old[addr]) {
+			t.Fatalf("node %s still holds the old shard after placeShards", addr)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+//go:build linux || darwin
+
+package web
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Takes an exclusive advisory lock on path+".lock", blocking until it is free,
+// and returns the function releasing it
+func lockFile(path string) (func(), error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -3,13 +3,30 @@
 package web
 
 import (
-	"fmt"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
+	"tritontube/internal/contentkey"
 )
 
 // FSVideoContentService implements VideoContentService using the local filesystem.
-type FSVideoContentService struct{rootDir string}
+type FSVideoContentService struct {
+	rootDir string
+	digests sync.Map // path to fileDigest, so a file is hashed once
+}
+
+// fileDigest is the SHA-256 of a file as it was at modTime
+type fileDigest struct {
+	size    int64
+	modTime time.Time
+	sha256  string
+}
 
 // Uncomment the following line to ensure FSVideoContentService implements VideoContentService
 var _ VideoContentService = (*FSVideoContentService)(nil)
@@ -19,23 +36,120 @@ func NewFSVideoContentService(root string) *FSVideoContentService {
 	return &FSVideoContentService{rootDir: root}
 }
 
-// Write saves data under {root}/{videoId}/{filename}
+// Write saves data under {root}/{videoId}/{filename}, replacing any previous
+// content atomically. Returns a *contentkey.InvalidKeyError for unsafe names.
 func (fs *FSVideoContentService) Write(videoId string, filename string, data []byte) error {
-	if videoId == "" {
-    	panic("videoID cannot be empty!")
+	k, err := contentkey.New(videoId, filename)
+	if err != nil {
+		return err
+	}
+	return contentkey.WriteFile(fs.rootDir, k, data)
+}
+
+// WriteStream is like Write but copies the content from r
+func (fs *FSVideoContentService) WriteStream(videoId string, filename string, r io.Reader) error {
+	k, err := contentkey.New(videoId, filename)
+	if err != nil {
+		return err
+	}
+	return contentkey.WriteFrom(fs.rootDir, k, r)
+}
+
+// List returns the filenames stored for a video
+func (fs *FSVideoContentService) List(videoId string) ([]string, error) {
+	if err := contentkey.ValidateComponent(videoId); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(fs.rootDir, videoId))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var filenames []string
+	for _, entry := range entries {
+		// Skips temp files of in-progress writes
+		if entry.IsDir() || contentkey.ValidateComponent(entry.Name()) != nil {
+			continue
+		}
+		filenames = append(filenames, entry.Name())
 	}
-	
-	dirPath := filepath.Join(fs.rootDir, videoId)
-	err := os.MkdirAll(dirPath, 0777)
+	return filenames, nil
+}
+
+// Delete removes {root}/{videoId}/{filename}; a missing file is not an error
+func (fs *FSVideoContentService) Delete(videoId string, filename string) error {
+	k, err := contentkey.New(videoId, filename)
 	if err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+		return err
+	}
+	if err := contentkey.Remove(fs.rootDir, k); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
 	}
-	fullPath := filepath.Join(dirPath, filename)
-	return os.WriteFile(fullPath, data, 0777)
+	return nil
 }
 
 // Read reads file from {root}/{videoId}/{filename}
 func (fs *FSVideoContentService) Read(videoId string, filename string) ([]byte, error) {
-	fullPath := filepath.Join(fs.rootDir, videoId, filename)
-	return os.ReadFile(fullPath)
-}
\ No newline at end of file
+	k, err := contentkey.New(videoId, filename)
+	if err != nil {
+		return nil, err
+	}
+	return contentkey.ReadFile(fs.rootDir, k)
+}
+
+// OpenContext opens {root}/{videoId}/{filename} for serving. Its digest is
+// taken the first time, and again whenever the file changes.
+func (fs *FSVideoContentService) OpenContext(ctx context.Context, videoId string, filename string) (*contentFile, error) {
+	k, err := contentkey.New(videoId, filename)
+	if err != nil {
+		return nil, err
+	}
+	f, err := contentkey.Open(fs.rootDir, k)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	path := k.Path(fs.rootDir)
+	cached, ok := fs.digests.Load(path)
+	if d, _ := cached.(fileDigest); ok && d.size == info.Size() && d.modTime.Equal(info.ModTime()) {
+		return &contentFile{ReadSeekCloser: f, size: info.Size(), sha256: d.sha256}, nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	d := fileDigest{size: info.Size(), modTime: info.ModTime(), sha256: hex.EncodeToString(h.Sum(nil))}
+	fs.digests.Store(path, d)
+	return &contentFile{ReadSeekCloser: f, size: d.size, sha256: d.sha256}, nil
+}
+
+// StageStream stages a file under {root}/.staging/{txId} until CommitStaged
+func (fs *FSVideoContentService) StageStream(txId string, videoId string, filename string, r io.Reader) error {
+	k, err := contentkey.New(videoId, filename)
+	if err != nil {
+		return err
+	}
+	return contentkey.StageFrom(fs.rootDir, txId, k, r)
+}
+
+// CommitStaged moves the files staged under txId into place
+func (fs *FSVideoContentService) CommitStaged(txId string) error {
+	_, err := contentkey.CommitStaged(fs.rootDir, txId)
+	return err
+}
+
+// AbortStaged discards the files staged under txId
+func (fs *FSVideoContentService) AbortStaged(txId string) error {
+	return contentkey.AbortStaged(fs.rootDir, txId)
+}
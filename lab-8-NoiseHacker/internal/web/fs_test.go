@@ -0,0 +1,106 @@
+package web
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"tritontube/internal/contentkey"
+)
+
+func TestFSContentRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	s := NewFSVideoContentService(root)
+	if err := s.Write("video", "manifest.mpd", []byte("manifest")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteStream("video", "chunk-0-00001.m4s", strings.NewReader("segment")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.StageStream("tx", "video", "thumbnail.jpg", strings.NewReader("thumbnail")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CommitStaged("tx"); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := s.List("video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	if want := []string{"chunk-0-00001.m4s", "manifest.mpd", "thumbnail.jpg"}; !slices.Equal(files, want) {
+		t.Fatalf("List returned %v, want %v", files, want)
+	}
+	data, err := s.Read("video", "thumbnail.jpg")
+	if err != nil || string(data) != "thumbnail" {
+		t.Fatalf("Read returned %q, %v", data, err)
+	}
+	for _, file := range files {
+		if err := s.Delete("video", file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Read("video", "manifest.mpd"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Read after Delete returned %v, want os.ErrNotExist", err)
+	}
+}
+
+// Every operation refuses an unsafe key before creating, reading or removing
+// anything, inside the root or out of it
+func TestFSContentRejectedKeysNeverTouchTheDisk(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// Targets of the traversals below, which must survive them
+	if err := os.WriteFile(filepath.Join(parent, "victim"), []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewFSVideoContentService(root)
+
+	for _, key := range []struct{ videoId, filename string }{
+		{"..", "victim"},
+		{"video", "../../victim"},
+		{"video", `..\..\victim`},
+		{"video", "/etc/passwd"},
+		{"C:", "x"},
+		{"video", "a\x00b"},
+		{"", "manifest.mpd"},
+		{"video", ""},
+		{".staging", "file"},
+		{"video", ".tmp-1"},
+	} {
+		check := func(op string, err error) {
+			t.Helper()
+			var keyErr *contentkey.InvalidKeyError
+			if !errors.As(err, &keyErr) {
+				t.Errorf("%s(%q, %q) returned %v, want an InvalidKeyError", op, key.videoId, key.filename, err)
+			}
+		}
+		check("Write", s.Write(key.videoId, key.filename, []byte("data")))
+		check("WriteStream", s.WriteStream(key.videoId, key.filename, strings.NewReader("data")))
+		check("StageStream", s.StageStream("tx", key.videoId, key.filename, strings.NewReader("data")))
+		_, err := s.Read(key.videoId, key.filename)
+		check("Read", err)
+		check("Delete", s.Delete(key.videoId, key.filename))
+	}
+
+	var paths []string
+	filepath.WalkDir(parent, func(path string, d fs.DirEntry, err error) error {
+		rel, _ := filepath.Rel(parent, path)
+		paths = append(paths, rel)
+		return err
+	})
+	if want := []string{".", "root", "victim"}; !slices.Equal(paths, want) {
+		t.Fatalf("rejected keys left %v on disk, want %v", paths, want)
+	}
+	if data, err := os.ReadFile(filepath.Join(parent, "victim")); err != nil || string(data) != "keep" {
+		t.Fatalf("victim is %q, %v", data, err)
+	}
+}
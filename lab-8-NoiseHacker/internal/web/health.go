@@ -0,0 +1,152 @@
+// Liveness tracking of storage nodes for the network content service.
+//
+// Each node is probed with the standard gRPC health protocol. A node that fails
+// one probe becomes suspect and is tried last; after downAfterFailures probes it
+// is down and skipped whenever a replica on another node can serve the key.
+
+package web
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+	"tritontube/internal/proto"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type nodeState string
+
+const (
+	nodeUnknown nodeState = "unknown"
+	nodeHealthy nodeState = "healthy"
+	nodeSuspect nodeState = "suspect"
+	nodeDown    nodeState = "down"
+)
+
+const downAfterFailures = 3
+
+type nodeHealth struct {
+	state     nodeState
+	lastSeen  time.Time
+	failures  int
+	lastError string
+}
+
+// Records the outcome of one probe and returns the previous and new state
+func (h *nodeHealth) record(err error) (nodeState, nodeState) {
+	prev := h.state
+	if err == nil {
+		h.state = nodeHealthy
+		h.lastSeen = time.Now()
+		h.failures = 0
+		h.lastError = ""
+		return prev, h.state
+	}
+	h.failures++
+	h.lastError = err.Error()
+	if h.failures >= downAfterFailures {
+		h.state = nodeDown
+	} else {
+		h.state = nodeSuspect
+	}
+	return prev, h.state
+}
+
+// StartHealthChecks probes every storage node once per interval. It blocks, so
+// run it in its own goroutine.
+func (n *NetworkVideoContentService) StartHealthChecks(interval time.Duration) {
+	for {
+		n.checkNodes(interval)
+		time.Sleep(interval)
+	}
+}
+
+func (n *NetworkVideoContentService) checkNodes(timeout time.Duration) {
+	n.mu.RLock()
+	clients := make(map[string]*storageClient, len(n.clients))
+	for addr, client := range n.clients {
+		clients[addr] = client
+	}
+	n.mu.RUnlock()
+
+	// In parallel, so a node that hangs until the timeout does not hold up
+	// the others
+	var wg sync.WaitGroup
+	for addr, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			response, err := client.health.Check(ctx, &healthpb.HealthCheckRequest{})
+			cancel()
+			if err == nil && response.Status != healthpb.HealthCheckResponse_SERVING {
+				err = fmt.Errorf("status %s", response.Status)
+			}
+
+			n.mu.Lock()
+			h, ok := n.health[addr]
+			if !ok {
+				h = &nodeHealth{state: nodeUnknown}
+				n.health[addr] = h
+			}
+			prev, cur := h.record(err)
+			lastError := h.lastError
+			n.mu.Unlock()
+			if prev != cur {
+				slog.Info("Storage node changed state", "node", addr, "from", prev, "to", cur, "err", lastError)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Returns the state of a node; callers must hold n.mu
+func (n *NetworkVideoContentService) nodeStateLocked(addr string) nodeState {
+	h, ok := n.health[addr]
+	if !ok {
+		return nodeUnknown
+	}
+	return h.state
+}
+
+// Orders candidate nodes for a read: healthy and unknown nodes keep their ring
+// order, suspect nodes go after them, and down nodes are dropped unless nothing
+// else is left. Callers must hold n.mu.
+func (n *NetworkVideoContentService) preferLiveLocked(addrs []string) []string {
+	var live, suspect, down []string
+	for _, addr := range addrs {
+		switch n.nodeStateLocked(addr) {
+		case nodeDown:
+			down = append(down, addr)
+		case nodeSuspect:
+			suspect = append(suspect, addr)
+		default:
+			live = append(live, addr)
+		}
+	}
+	ordered := append(live, suspect...)
+	if len(ordered) == 0 {
+		return down
+	}
+	return ordered
+}
+
+// Returns health information for the given nodes; callers must hold n.mu
+func (n *NetworkVideoContentService) nodeStatusesLocked(addrs []string) []*proto.NodeStatus {
+	statuses := make([]*proto.NodeStatus, 0, len(addrs))
+	for _, addr := range addrs {
+		status := &proto.NodeStatus{Address: addr, Health: string(nodeUnknown)}
+		if h, ok := n.health[addr]; ok {
+			status.Health = string(h.state)
+			status.ConsecutiveFailures = int32(h.failures)
+			if !h.lastSeen.IsZero() {
+				status.LastSeen = h.lastSeen.Unix()
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
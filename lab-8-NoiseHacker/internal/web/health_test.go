@@ -0,0 +1,44 @@
+package web
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// Nodes that never answer each take the whole timeout, but only once between
+// them since they are probed in parallel
+func TestCheckNodesProbesInParallel(t *testing.T) {
+	nodes := startStorageNodes(t, 1)
+	addrs := nodeAddrs(nodes)
+	for range 3 {
+		// Accepts connections and never speaks gRPC on them
+		hung, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { hung.Close() })
+		addrs = append(addrs, hung.Addr().String())
+	}
+	n, err := NewNetworkVideoContentService("", addrs, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const timeout = 300 * time.Millisecond
+	start := time.Now()
+	n.checkNodes(timeout)
+	if elapsed := time.Since(start); elapsed >= 2*timeout {
+		t.Fatalf("checking 3 hung nodes took %v, want about one %v timeout", elapsed, timeout)
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if state := n.nodeStateLocked(addrs[0]); state != nodeHealthy {
+		t.Errorf("running node is %s, want healthy", state)
+	}
+	for _, addr := range addrs[1:] {
+		if state := n.nodeStateLocked(addr); state != nodeSuspect {
+			t.Errorf("hung node %s is %s, want suspect", addr, state)
+		}
+	}
+}
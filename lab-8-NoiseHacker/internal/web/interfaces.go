@@ -1,19 +1,287 @@
 package web
 
-import "time"
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
 
 type VideoMetadata struct {
+	Id          string
+	UploadedAt  time.Time
+	Title       string
+	Description string
+	Uploader    string
+	Duration    time.Duration
+	Width       int
+	Height      int
+	Size        int64  // bytes of the uploaded file
+	Thumbnail   string // content filename of the thumbnail image, "" if there is none
+	OwnerId     string // user who uploaded the video, "" for videos from before accounts
+	Visibility  Visibility
+	Live        bool   // a live stream still being broadcast, whose manifest is dynamic
+	SHA256      string // hex digest of the uploaded file, "" for live streams and older videos
+	HLS         bool   // HLS playlists were stored next to the DASH manifest
+}
+
+// Visibility controls who can watch a video
+type Visibility string
+
+const (
+	Public   Visibility = "public"   // listed, and playable by anyone
+	Unlisted Visibility = "unlisted" // playable by anyone with the link, but only listed for its owner
+	Private  Visibility = "private"  // only its owner can see it; content needs a signed URL
+)
+
+// Valid reports whether v is one of the known visibilities
+func (v Visibility) Valid() bool {
+	return v == Public || v == Unlisted || v == Private
+}
+
+// User is an account that can upload videos
+type User struct {
+	Id           string
+	Username     string
+	PasswordHash string // see hashPassword
+	IsAdmin      bool   // can edit and delete any video, see Server.FirstUserAdmin and SetAdmin
+	CreatedAt    time.Time
+}
+
+// Session is a logged in browser or API client. Only a hash of the session
+// token is stored, so a leaked database does not leak sessions.
+type Session struct {
+	TokenHash string
+	UserId    string
+	CSRFToken string // must accompany every form the session submits
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// JobStatus is the state of an upload's transcode job
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobFailed  JobStatus = "failed"
+	JobReady   JobStatus = "ready"
+)
+
+// TranscodeJob tracks an uploaded file until its video is ready to watch
+type TranscodeJob struct {
 	Id         string
-	UploadedAt time.Time
+	VideoId    string
+	SourcePath string // uploaded MP4 waiting to be transcoded
+	Status     JobStatus
+	Error      string // reason the job failed
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+
+	// Given with the upload, copied into the video's metadata once it is ready
+	Title       string
+	Description string
+	Uploader    string
+	OwnerId     string
+	Visibility  Visibility
+	Live        bool   // ingests a live stream rather than transcoding SourcePath, see live.go
+	SHA256      string // hex digest of the file at SourcePath
+}
+
+// ErrInvalidCursor is returned by List for a cursor it did not produce
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// DefaultPageSize is the number of videos List returns unless a limit is given
+const DefaultPageSize = 20
+
+// ListOptions selects one page of videos, newest first
+type ListOptions struct {
+	Query  string // only videos whose title or ID starts with Query, ignoring case
+	Cursor string // from a previous VideoPage; "" for the first page
+	Before bool   // return the page before Cursor instead of the one after it
+	Limit  int
+	// Public videos are listed for everyone; ViewerId's own videos are listed
+	// whatever their visibility, and AllVideos lists everything (for admins)
+	ViewerId  string
+	AllVideos bool
+}
+
+// VideoPage is one page of List results
+type VideoPage struct {
+	Videos     []VideoMetadata
+	NextCursor string // "" on the last page
+	PrevCursor string // "" on the first page
 }
 
 type VideoMetadataService interface {
 	Read(id string) (*VideoMetadata, error)
-	List() ([]VideoMetadata, error)
-	Create(videoId string, uploadedAt time.Time) error
+	List(opts ListOptions) (*VideoPage, error)
+	// Creating a video is the metadata half of an upload transaction, see
+	// commit.go. PrepareCreate stores meta hidden from Read and List, failing
+	// with ErrVideoIDTaken if its ID is in use; CommitCreate then publishes it
+	// and AbortCreate drops it. Both succeed if there is nothing left to do.
+	PrepareCreate(txId string, meta VideoMetadata) error
+	CommitCreate(txId string) error
+	AbortCreate(txId string) error
+	// Update replaces the title, description and visibility of a video
+	Update(videoId string, title string, description string, visibility Visibility) error
+	// EndLive marks a live video as ended and records how long it ran
+	EndLive(videoId string, duration time.Duration) error
+
+	// Deleting is two-step: MarkDeleted hides a video from Read and List right
+	// away, and Delete drops its row once its content is gone. ListDeleted
+	// returns the videos still between the two.
+	MarkDeleted(videoId string) error
+	ListDeleted() ([]string, error)
+	Delete(videoId string) error
+
+	// Transcode jobs live next to the videos so they survive web server
+	// restarts. CreateJob reserves the job's video ID: it fails with
+	// ErrVideoIDTaken if a video or another job already has it.
+	CreateJob(job TranscodeJob) error
+	// CreateUniqueJob is CreateJob for a file the owner must not have uploaded
+	// before. If one of the owner's videos, or queued or running jobs, has the
+	// job's SHA256, it creates nothing and returns that upload's job instead;
+	// otherwise it returns nil.
+	CreateUniqueJob(job TranscodeJob) (*TranscodeJob, error)
+	UpdateJob(jobId string, status JobStatus, errMsg string) error
+	ReadJob(jobId string) (*TranscodeJob, error)
+	// ReadJobByVideo returns the most recent job for a video
+	ReadJobByVideo(videoId string) (*TranscodeJob, error)
+	ListJobs(statuses ...JobStatus) ([]TranscodeJob, error)
+
+	// Accounts and their sessions. CreateUser fails with ErrUsernameTaken if
+	// the username is in use, ignoring case. With adminIfFirst, the account is
+	// made an admin if there is no other one yet, decided as it is created;
+	// the account is returned as stored.
+	CreateUser(user User, adminIfFirst bool) (*User, error)
+	ReadUser(userId string) (*User, error)
+	ReadUserByName(username string) (*User, error)
+	// SetAdmin makes the account with the username an admin
+	SetAdmin(username string) error
+	CreateSession(session Session) error
+	// ReadSession returns sql.ErrNoRows for unknown and expired sessions
+	ReadSession(tokenHash string) (*Session, error)
+	DeleteSession(tokenHash string) error
 }
 
+// ErrUsernameTaken is returned by CreateUser for a username that is in use
+var ErrUsernameTaken = errors.New("username is taken")
+
+// ErrVideoIDTaken is returned by CreateJob and PrepareCreate for a video ID
+// that is in use. IDs are never reused, even after a delete.
+var ErrVideoIDTaken = errors.New("video ID is taken")
+
 type VideoContentService interface {
 	Read(videoId string, filename string) ([]byte, error)
 	Write(videoId string, filename string, data []byte) error
+	// WriteStream stores the content read from r without buffering it all in memory
+	WriteStream(videoId string, filename string, r io.Reader) error
+	// List returns the filenames stored for a video
+	List(videoId string) ([]string, error)
+	// Delete removes one file; deleting a file that does not exist succeeds
+	Delete(videoId string, filename string) error
+
+	// The content half of an upload transaction, see commit.go. StageStream
+	// stores a file that stays invisible until CommitStaged publishes every
+	// file staged under txId, or AbortStaged discards them. Staged files
+	// survive restarts, and committing or aborting an unknown transaction
+	// succeeds, so a coordinator can repeat either after a crash.
+	StageStream(txId string, videoId string, filename string, r io.Reader) error
+	CommitStaged(txId string) error
+	AbortStaged(txId string) error
+}
+
+// contextContentService is implemented by content services that call other
+// processes, so the calls made for a request carry its context and request ID
+type contextContentService interface {
+	ReadContext(ctx context.Context, videoId string, filename string) ([]byte, error)
+	ListContext(ctx context.Context, videoId string) ([]string, error)
+	DeleteContext(ctx context.Context, videoId string, filename string) error
+	WriteContext(ctx context.Context, videoId string, filename string, data []byte) error
+	WriteStreamContext(ctx context.Context, videoId string, filename string, r io.Reader) error
+	StageStreamContext(ctx context.Context, txId string, videoId string, filename string, r io.Reader) error
+	CommitStagedContext(ctx context.Context, txId string) error
+	AbortStagedContext(ctx context.Context, txId string) error
+}
+
+// readContent, listContent, deleteContent and the other helpers below pass
+// ctx on to content services that take one
+func readContent(ctx context.Context, c VideoContentService, videoId string, filename string) ([]byte, error) {
+	if cc, ok := c.(contextContentService); ok {
+		return cc.ReadContext(ctx, videoId, filename)
+	}
+	return c.Read(videoId, filename)
+}
+
+func listContent(ctx context.Context, c VideoContentService, videoId string) ([]string, error) {
+	if cc, ok := c.(contextContentService); ok {
+		return cc.ListContext(ctx, videoId)
+	}
+	return c.List(videoId)
+}
+
+func deleteContent(ctx context.Context, c VideoContentService, videoId string, filename string) error {
+	if cc, ok := c.(contextContentService); ok {
+		return cc.DeleteContext(ctx, videoId, filename)
+	}
+	return c.Delete(videoId, filename)
+}
+
+func writeContent(ctx context.Context, c VideoContentService, videoId string, filename string, data []byte) error {
+	if cc, ok := c.(contextContentService); ok {
+		return cc.WriteContext(ctx, videoId, filename, data)
+	}
+	return c.Write(videoId, filename, data)
+}
+
+func writeStreamContent(ctx context.Context, c VideoContentService, videoId string, filename string, r io.Reader) error {
+	if cc, ok := c.(contextContentService); ok {
+		return cc.WriteStreamContext(ctx, videoId, filename, r)
+	}
+	return c.WriteStream(videoId, filename, r)
+}
+
+func stageContent(ctx context.Context, c VideoContentService, txId string, videoId string, filename string, r io.Reader) error {
+	if cc, ok := c.(contextContentService); ok {
+		return cc.StageStreamContext(ctx, txId, videoId, filename, r)
+	}
+	return c.StageStream(txId, videoId, filename, r)
+}
+
+func commitStagedContent(ctx context.Context, c VideoContentService, txId string) error {
+	if cc, ok := c.(contextContentService); ok {
+		return cc.CommitStagedContext(ctx, txId)
+	}
+	return c.CommitStaged(txId)
+}
+
+func abortStagedContent(ctx context.Context, c VideoContentService, txId string) error {
+	if cc, ok := c.(contextContentService); ok {
+		return cc.AbortStagedContext(ctx, txId)
+	}
+	return c.AbortStaged(txId)
+}
+
+// contentFile is a content file opened for serving. Its bytes are only read
+// as they are asked for.
+type contentFile struct {
+	io.ReadSeekCloser
+	size   int64
+	sha256 string // hex digest of the whole file, "" if the service has none
+}
+
+// contentOpener is implemented by content services that can serve a file, or
+// part of it, without reading all of it into memory
+type contentOpener interface {
+	OpenContext(ctx context.Context, videoId string, filename string) (*contentFile, error)
+}
+
+// Opens a content file for serving. Fails with errors.ErrUnsupported if c
+// can only read whole files.
+func openContent(ctx context.Context, c VideoContentService, videoId string, filename string) (*contentFile, error) {
+	if co, ok := c.(contentOpener); ok {
+		return co.OpenContext(ctx, videoId, filename)
+	}
+	return nil, errors.ErrUnsupported
 }
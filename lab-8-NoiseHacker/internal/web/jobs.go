@@ -0,0 +1,226 @@
+// Asynchronous transcoding of uploads.
+//
+// handleUpload only spools the uploaded file to disk and records a queued job in
+// the metadata service; a fixed pool of workers runs ffmpeg and stores the
+// segments. The segments and the video's metadata row are published together
+// when the job is done (see commit.go), so List never shows a video that cannot
+// be played yet.
+
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"tritontube/internal/telemetry"
+)
+
+// DefaultTranscodeWorkers is the worker pool size used unless TranscodeWorkers is changed
+const DefaultTranscodeWorkers = 2
+
+type transcodeQueue struct {
+	metadataService VideoMetadataService
+	contentService  VideoContentService
+	spoolDir        string
+	workers         int
+	ladder          Ladder
+	hls             bool
+	uploads         *uploadCoordinator
+	jobs            chan string // job IDs, the job itself is read back from metadata
+}
+
+func newTranscodeQueue(metadataService VideoMetadataService, contentService VideoContentService, spoolDir string, workers int, ladder Ladder, hls bool) (*transcodeQueue, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	spoolDir, err := filepath.Abs(spoolDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(spoolDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool dir: %w", err)
+	}
+	uploads, err := newUploadCoordinator(metadataService, contentService, filepath.Join(spoolDir, commitLogName))
+	if err != nil {
+		return nil, err
+	}
+	return &transcodeQueue{
+		metadataService: metadataService,
+		contentService:  contentService,
+		spoolDir:        spoolDir,
+		workers:         workers,
+		ladder:          ladder,
+		hls:             hls,
+		uploads:         uploads,
+		jobs:            make(chan string, 64),
+	}, nil
+}
+
+// Finishes the uploads a previous run left in doubt, then starts the workers
+// and requeues the jobs it left unfinished. Live streams it was ingesting are
+// ended instead.
+func (q *transcodeQueue) start() error {
+	q.uploads.recover()
+	for i := 0; i < q.workers; i++ {
+		go q.work()
+	}
+
+	pending, err := q.metadataService.ListJobs(JobQueued, JobRunning)
+	if err != nil {
+		return fmt.Errorf("failed to list pending jobs: %w", err)
+	}
+	for _, job := range pending {
+		if job.Live {
+			q.recoverLive(job)
+			continue
+		}
+		if job.Status == JobRunning {
+			slog.Info("Restarting interrupted transcode job", "job_id", job.Id, "video_id", job.VideoId)
+		}
+		q.submit(job.Id)
+	}
+	return nil
+}
+
+// Returns a fresh path in the spool dir for an upload that is about to be queued
+func (q *transcodeQueue) spoolPath(jobId, filename string) string {
+	return filepath.Join(q.spoolDir, jobId+filepath.Ext(filename))
+}
+
+// Records a queued job for a spooled upload and hands it to the workers. Id,
+// VideoId, SourcePath and the descriptive fields must be set on job. Fails with
+// ErrVideoIDTaken if another upload got the video ID first.
+func (q *transcodeQueue) enqueue(job TranscodeJob) (*TranscodeJob, error) {
+	job = newQueuedJob(job)
+	if err := q.metadataService.CreateJob(job); err != nil {
+		return nil, err
+	}
+	q.submit(job.Id)
+	return &job, nil
+}
+
+// Like enqueue, but if the owner has a video or another upload of the same
+// file, queues nothing and returns that upload's job instead
+func (q *transcodeQueue) enqueueUnique(job TranscodeJob) (*TranscodeJob, error) {
+	job = newQueuedJob(job)
+	existing, err := q.metadataService.CreateUniqueJob(job)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, nil
+	}
+	q.submit(job.Id)
+	return &job, nil
+}
+
+func newQueuedJob(job TranscodeJob) TranscodeJob {
+	now := time.Now()
+	job.Status = JobQueued
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	return job
+}
+
+// Never blocks the caller; the job is already persisted if the queue is full
+func (q *transcodeQueue) submit(jobId string) {
+	select {
+	case q.jobs <- jobId:
+	default:
+		go func() { q.jobs <- jobId }()
+	}
+}
+
+func (q *transcodeQueue) work() {
+	for jobId := range q.jobs {
+		ctx := telemetry.Background()
+		job, err := q.metadataService.ReadJob(jobId)
+		if err != nil {
+			slog.ErrorContext(ctx, "Transcode job vanished", "job_id", jobId, "err", err)
+			continue
+		}
+		if job.Status != JobQueued && job.Status != JobRunning {
+			continue
+		}
+
+		if err := q.metadataService.UpdateJob(job.Id, JobRunning, ""); err != nil {
+			slog.ErrorContext(ctx, "Failed to mark job running", "job_id", job.Id, "err", err)
+			continue
+		}
+		status, errMsg := JobReady, ""
+		if err := q.run(ctx, job); err != nil {
+			slog.ErrorContext(ctx, "Transcoding failed", "job_id", job.Id, "video_id", job.VideoId, "err", err)
+			status, errMsg = JobFailed, err.Error()
+		}
+		if err := q.metadataService.UpdateJob(job.Id, status, errMsg); err != nil {
+			slog.ErrorContext(ctx, "Failed to update job", "job_id", job.Id, "err", err)
+		}
+		os.Remove(job.SourcePath)
+	}
+}
+
+// Transcodes one job and publishes its video in an upload transaction
+func (q *transcodeQueue) run(ctx context.Context, job *TranscodeJob) error {
+	stat, err := os.Stat(job.SourcePath)
+	if err != nil {
+		return fmt.Errorf("uploaded file is missing: %w", err)
+	}
+	info, err := probeSource(job.SourcePath)
+	if err != nil {
+		return err
+	}
+	outDir, err := os.MkdirTemp("", "transcode-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(outDir)
+
+	tx, err := q.uploads.begin(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	// A missing thumbnail is not worth failing the upload for
+	thumbnail := ""
+	if err := storeThumbnail(tx, job.VideoId, job.SourcePath, outDir, info); err != nil {
+		slog.WarnContext(ctx, "No thumbnail", "video_id", job.VideoId, "err", err)
+	} else {
+		thumbnail = ThumbnailName
+	}
+
+	// Segments are streamed to the content service while ffmpeg produces them
+	if err := transcodeAndStore(ctx, tx, job.VideoId, job.SourcePath, outDir, q.ladder, q.hls, info); err != nil {
+		tx.abort()
+		return err
+	}
+	meta := VideoMetadata{
+		Id:          job.VideoId,
+		UploadedAt:  time.Now(),
+		Title:       job.Title,
+		Description: job.Description,
+		Uploader:    job.Uploader,
+		Duration:    info.Duration,
+		Width:       info.Width,
+		Height:      info.Height,
+		Size:        stat.Size(),
+		Thumbnail:   thumbnail,
+		OwnerId:     job.OwnerId,
+		Visibility:  job.Visibility,
+		SHA256:      job.SHA256,
+		HLS:         q.hls,
+	}
+	return tx.commit(meta)
+}
+
+// Returns a random identifier for jobs
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,134 @@
+// Encoding ladder for adaptive bitrate DASH output
+
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rung is one video representation of the encoding ladder
+type Rung struct {
+	Name         string `json:"name"`          // e.g. "720p", for logs only
+	Height       int    `json:"height"`        // output height in pixels; width keeps the aspect ratio
+	VideoBitrate string `json:"video_bitrate"` // ffmpeg bitrate, e.g. "3000k"
+}
+
+// Ladder lists the representations produced for every upload
+type Ladder struct {
+	Rungs        []Rung `json:"rungs"`
+	AudioBitrate string `json:"audio_bitrate"`
+}
+
+// DefaultLadder is used unless cmd/web is given a ladder file
+var DefaultLadder = Ladder{
+	Rungs: []Rung{
+		{Name: "240p", Height: 240, VideoBitrate: "400k"},
+		{Name: "480p", Height: 480, VideoBitrate: "1000k"},
+		{Name: "720p", Height: 720, VideoBitrate: "3000k"},
+		{Name: "1080p", Height: 1080, VideoBitrate: "6000k"},
+	},
+	AudioBitrate: "128k",
+}
+
+// LoadLadder reads a JSON ladder file, e.g.
+//
+//	{"rungs": [{"name": "360p", "height": 360, "video_bitrate": "800k"}], "audio_bitrate": "96k"}
+func LoadLadder(path string) (Ladder, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Ladder{}, fmt.Errorf("failed to read ladder: %w", err)
+	}
+	var l Ladder
+	if err := json.Unmarshal(data, &l); err != nil {
+		return Ladder{}, fmt.Errorf("failed to parse ladder %s: %w", path, err)
+	}
+	if len(l.Rungs) == 0 {
+		return Ladder{}, fmt.Errorf("ladder %s has no rungs", path)
+	}
+	for _, r := range l.Rungs {
+		if r.Height <= 0 || r.VideoBitrate == "" {
+			return Ladder{}, fmt.Errorf("ladder %s: rung %q needs a positive height and a video_bitrate", path, r.Name)
+		}
+	}
+	if l.AudioBitrate == "" {
+		l.AudioBitrate = DefaultLadder.AudioBitrate
+	}
+	sort.Slice(l.Rungs, func(i, j int) bool { return l.Rungs[i].Height < l.Rungs[j].Height })
+	return l, nil
+}
+
+// Returns the rungs worth encoding for a source of the given height: rungs above
+// it are skipped since upscaling only wastes bits. If every rung is above the
+// source, the lowest one is kept at the source height.
+func (l Ladder) rungsFor(sourceHeight int) []Rung {
+	var rungs []Rung
+	for _, r := range l.Rungs {
+		if r.Height <= sourceHeight {
+			rungs = append(rungs, r)
+		}
+	}
+	if len(rungs) == 0 && len(l.Rungs) > 0 {
+		lowest := l.Rungs[0]
+		lowest.Height = sourceHeight &^ 1 // libx264 needs even dimensions
+		rungs = append(rungs, lowest)
+	}
+	return rungs
+}
+
+// sourceInfo is what ffprobe tells us about an upload
+type sourceInfo struct {
+	Width    int
+	Height   int
+	HasAudio bool
+	Duration time.Duration
+}
+
+// Inspects the first video and audio stream of a file, and its duration, with
+// ffprobe
+func probeSource(videoPath string) (sourceInfo, error) {
+	start := time.Now()
+	out, err := exec.Command("ffprobe",
+		"-v", "error",
+		"-show_entries", "stream=codec_type,width,height:format=duration",
+		"-of", "csv=p=0",
+		videoPath,
+	).Output()
+	observeFFmpeg("probe", start, err)
+	if err != nil {
+		return sourceInfo{}, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	info := parseProbe(string(out))
+	if info.Height == 0 {
+		return sourceInfo{}, fmt.Errorf("no video stream found in %s", videoPath)
+	}
+	return info, nil
+}
+
+// Parses the CSV ffprobe prints for probeSource: a "codec_type,width,height"
+// line per stream, then the duration in seconds
+func parseProbe(out string) sourceInfo {
+	var info sourceInfo
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		switch {
+		case fields[0] == "video" && len(fields) >= 3 && info.Height == 0:
+			info.Width, _ = strconv.Atoi(fields[1])
+			info.Height, _ = strconv.Atoi(fields[2])
+		case fields[0] == "audio":
+			info.HasAudio = true
+		case len(fields) == 1:
+			// The format section comes last and only has the duration
+			if secs, err := strconv.ParseFloat(fields[0], 64); err == nil {
+				info.Duration = time.Duration(secs * float64(time.Second))
+			}
+		}
+	}
+	return info
+}
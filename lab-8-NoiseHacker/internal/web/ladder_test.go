@@ -0,0 +1,106 @@
+package web
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRungsForSkipsRungsAboveTheSource(t *testing.T) {
+	heights := func(rungs []Rung) []int {
+		var hs []int
+		for _, r := range rungs {
+			hs = append(hs, r.Height)
+		}
+		return hs
+	}
+	for _, tc := range []struct {
+		source int
+		want   []int
+	}{
+		{2160, []int{240, 480, 720, 1080}},
+		{1080, []int{240, 480, 720, 1080}},
+		{1079, []int{240, 480, 720}},
+		{720, []int{240, 480, 720}},
+		{480, []int{240, 480}},
+		{240, []int{240}},
+		// Below every rung the lowest is kept at the source's even height
+		{180, []int{180}},
+		{145, []int{144}},
+	} {
+		if got := heights(DefaultLadder.rungsFor(tc.source)); !slices.Equal(got, tc.want) {
+			t.Errorf("rungsFor(%d) = %v, want %v", tc.source, got, tc.want)
+		}
+	}
+	if low := DefaultLadder.rungsFor(145)[0]; low.Name != "240p" || low.VideoBitrate != "400k" {
+		t.Errorf("a small source is encoded as %+v, want the lowest rung's bitrate", low)
+	}
+	if DefaultLadder.Rungs[0].Height != 240 {
+		t.Fatal("rungsFor changed the ladder")
+	}
+	if got := (Ladder{}).rungsFor(720); len(got) != 0 {
+		t.Errorf("an empty ladder gave %v", got)
+	}
+}
+
+func TestLoadLadder(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	l, err := LoadLadder(write("ok.json", `{"rungs": [
+		{"name": "720p", "height": 720, "video_bitrate": "2500k"},
+		{"name": "360p", "height": 360, "video_bitrate": "800k"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Ladder{
+		Rungs:        []Rung{{Name: "360p", Height: 360, VideoBitrate: "800k"}, {Name: "720p", Height: 720, VideoBitrate: "2500k"}},
+		AudioBitrate: DefaultLadder.AudioBitrate,
+	}
+	if !slices.Equal(l.Rungs, want.Rungs) || l.AudioBitrate != want.AudioBitrate {
+		t.Fatalf("LoadLadder returned %+v, want the rungs sorted by height and the default audio bitrate: %+v", l, want)
+	}
+
+	for name, data := range map[string]string{
+		"no-rungs.json":   `{"rungs": [], "audio_bitrate": "96k"}`,
+		"no-height.json":  `{"rungs": [{"name": "x", "video_bitrate": "800k"}]}`,
+		"no-bitrate.json": `{"rungs": [{"name": "x", "height": 360}]}`,
+		"not-json.json":   `rungs: [360p]`,
+		"wrong-type.json": `{"rungs": [{"height": "360"}]}`,
+		"negative.json":   `{"rungs": [{"height": -360, "video_bitrate": "800k"}]}`,
+	} {
+		if _, err := LoadLadder(write(name, data)); err == nil {
+			t.Errorf("LoadLadder accepted %s", name)
+		}
+	}
+	if _, err := LoadLadder(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadLadder accepted a missing file")
+	}
+}
+
+func TestParseProbe(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		out  string
+		want sourceInfo
+	}{
+		{"video and audio", "video,1920,1080\naudio,,\n12.480000\n", sourceInfo{Width: 1920, Height: 1080, HasAudio: true, Duration: 12480 * time.Millisecond}},
+		{"video only", "video,640,360\n3.000000\n", sourceInfo{Width: 640, Height: 360, Duration: 3 * time.Second}},
+		{"first video stream wins", "video,1280,720\nvideo,320,240\nN/A\n", sourceInfo{Width: 1280, Height: 720}},
+		{"audio only", "audio,,\n60.0\n", sourceInfo{HasAudio: true, Duration: time.Minute}},
+		{"nothing", "", sourceInfo{}},
+	} {
+		if got := parseProbe(tc.out); got != tc.want {
+			t.Errorf("%s: parseProbe = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+// Live streaming ingest.
+//
+// A broadcaster sends a continuous stream, e.g. fragmented MP4 or MPEG-TS, as
+// the body of PUT /api/v1/live/{videoId}. The request lasts as long as the
+// broadcast, and the end of the body ends it. The start of the stream is
+// probed to pick the renditions, then the stream is piped through ffmpeg,
+// whose DASH muxer rewrites a dynamic manifest.mpd (type="dynamic") whenever a
+// segment is complete. Each poll stores the segments the current manifest
+// references and then the manifest itself, so viewers never get a manifest
+// that is ahead of the content service. The video is published, marked Live,
+// with its first manifest; when the stream ends, ffmpeg writes a static
+// manifest and EndLive records the duration, after which the recording plays
+// like any upload.
+//
+// Unlike uploads, live content is written straight to the content service
+// instead of being staged, since it is watched while it arrives. A stream that
+// fails before its first manifest has its content removed again. Live streams
+// are DASH only.
+//
+// A broadcast cut off by a web server restart cannot be resumed. On restart
+// its last manifest is made static, so what was recorded stays watchable.
+//
+// For example, with a local file standing in for a camera:
+//
+//	ffmpeg -re -i in.mp4 -c copy -f mp4 -movflags frag_keyframe+empty_moov pipe:1 |
+//	  curl -T - -H "Authorization: Bearer $TOKEN" "http://localhost:8080/api/v1/live/demo?title=Demo"
+
+package web
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+	"tritontube/internal/telemetry"
+)
+
+// DefaultMaxLiveStreams is the number of live streams ingested at once unless
+// MaxLiveStreams is changed
+const DefaultMaxLiveStreams = 2
+
+const (
+	// Bytes at the start of a live stream probed before it is transcoded
+	liveProbeSize = 256 << 10
+	// How long a broadcaster may send nothing before its stream is ended
+	liveIdleTimeout = 30 * time.Second
+)
+
+// broadcastReader reads a live stream from a request body. Every read error
+// ends the broadcast like the end of the body does, so ffmpeg still finishes
+// the recording; err keeps the error for the logs. A broadcaster that sends
+// nothing for liveIdleTimeout is cut off.
+type broadcastReader struct {
+	body io.Reader
+	rc   *http.ResponseController
+	err  error
+}
+
+func (b *broadcastReader) Read(p []byte) (int, error) {
+	// Fails with ErrNotSupported on connections without deadlines, which
+	// then wait for the broadcaster as long as it takes
+	b.rc.SetReadDeadline(time.Now().Add(liveIdleTimeout))
+	n, err := b.body.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+		err = io.EOF
+	}
+	return n, err
+}
+
+// liveStream is the state of one stream being ingested
+type liveStream struct {
+	ctx      context.Context
+	cs       VideoContentService
+	videoID  string
+	dir      string          // where ffmpeg writes
+	stored   map[string]bool // files of the manifest stored so far
+	manifest []byte          // the manifest last stored
+}
+
+// Stores the files the manifest ffmpeg wrote last references, and then the
+// manifest. Reports whether there was a new manifest.
+func (l *liveStream) publish() (bool, error) {
+	data, err := os.ReadFile(filepath.Join(l.dir, DASHManifest))
+	if errors.Is(err, os.ErrNotExist) || err == nil && bytes.Equal(data, l.manifest) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	files, err := manifestFiles(data)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range files {
+		if l.stored[name] {
+			continue
+		}
+		if err := storeFile(contextWriter{l.ctx, l.cs}, l.videoID, l.dir, name); err != nil {
+			return false, err
+		}
+		l.stored[name] = true
+		os.Remove(filepath.Join(l.dir, name))
+	}
+	if err := writeContent(l.ctx, l.cs, l.videoID, DASHManifest, data); err != nil {
+		return false, fmt.Errorf("write %s: %w", DASHManifest, err)
+	}
+	l.manifest = data
+	return true, nil
+}
+
+// Transcodes a live stream and publishes it while it arrives, see the top of
+// this file. Returns the video once the stream has ended, or nil if it never
+// got as far as being published. Errors from probing the stream are
+// *statusErrors.
+func (q *transcodeQueue) ingestLive(ctx context.Context, job *TranscodeJob, stream io.Reader) (*VideoMetadata, error) {
+	headPath := q.spoolPath(job.Id, ".live")
+	head, err := os.Create(headPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(headPath)
+	defer head.Close()
+	if _, err := io.CopyN(head, stream, liveProbeSize); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+	info, err := probeSource(headPath)
+	if err != nil {
+		return nil, &statusError{http.StatusBadRequest, "Stream not recognized: " + err.Error()}
+	}
+	if _, err := head.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	outDir, err := os.MkdirTemp("", "live-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(outDir)
+
+	// The recording up to a hang-up is kept, so the content service is called
+	// with the request ID of ctx but not its cancellation
+	contentCtx := context.WithoutCancel(ctx)
+	tx, err := q.uploads.begin(contentCtx, job)
+	if err != nil {
+		return nil, err
+	}
+	meta := VideoMetadata{
+		Id:          job.VideoId,
+		UploadedAt:  time.Now(),
+		Title:       job.Title,
+		Description: job.Description,
+		Uploader:    job.Uploader,
+		Width:       info.Width,
+		Height:      info.Height,
+		OwnerId:     job.OwnerId,
+		Visibility:  job.Visibility,
+		Live:        true,
+	}
+	// A missing thumbnail is not worth failing the stream for
+	if err := storeThumbnail(contextWriter{contentCtx, q.contentService}, job.VideoId, headPath, outDir, info); err != nil {
+		slog.WarnContext(ctx, "No thumbnail", "video_id", job.VideoId, "err", err)
+	} else {
+		meta.Thumbnail = ThumbnailName
+	}
+
+	rungs := q.ladder.rungsFor(info.Height)
+	slog.InfoContext(ctx, "Ingesting live stream", "video_id", job.VideoId, "width", info.Width, "height", info.Height, "renditions", len(rungs))
+	cmd := ffmpegCommand("pipe:0", outDir, q.ladder, rungs, info, false)
+	cmd.Stdin = io.MultiReader(head, stream)
+	// Once ffmpeg is gone, Wait does not wait for the broadcaster's next write
+	cmd.WaitDelay = time.Second
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		tx.abort()
+		deleteVideoContent(contentCtx, q.contentService, job.VideoId)
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		observeFFmpeg("live", start, err)
+		done <- err
+	}()
+
+	live := &liveStream{ctx: contentCtx, cs: q.contentService, videoID: job.VideoId, dir: outDir, stored: make(map[string]bool)}
+	published := false
+	// Publishes the latest manifest, and the video with the first one. A video
+	// deleted while it is live ends the stream.
+	publish := func() error {
+		if published {
+			if _, err := q.metadataService.Read(job.VideoId); errors.Is(err, sql.ErrNoRows) {
+				return &statusError{http.StatusGone, "Video was deleted"}
+			}
+		}
+		fresh, err := live.publish()
+		if err != nil || !fresh || published {
+			return err
+		}
+		// Not worth publishing before there is something to watch
+		if duration, err := manifestDuration(live.manifest); err != nil || duration == 0 {
+			return err
+		}
+		if err := tx.commit(meta); err != nil {
+			return err
+		}
+		published = true
+		slog.InfoContext(ctx, "Live stream started", "video_id", job.VideoId)
+		return nil
+	}
+
+	ticker := time.NewTicker(segmentPollInterval)
+	defer ticker.Stop()
+ingest:
+	for {
+		select {
+		case err = <-done:
+			if err != nil {
+				err = fmt.Errorf("ffmpeg failed: %w", err)
+			} else {
+				err = publish()
+			}
+			break ingest
+		case <-ticker.C:
+			if err = publish(); err != nil {
+				cmd.Process.Kill()
+				<-done
+				break ingest
+			}
+		}
+	}
+
+	var se *statusError
+	switch {
+	case !published:
+		if err == nil {
+			err = &statusError{http.StatusBadRequest, "Stream ended before its first segment"}
+		}
+		tx.abort()
+		if _, err := deleteVideoContent(contentCtx, q.contentService, job.VideoId); err != nil {
+			slog.WarnContext(ctx, "Failed to remove content of live stream", "video_id", job.VideoId, "err", err)
+		}
+		return nil, err
+	case errors.As(err, &se) && se.status == http.StatusGone:
+		// The delete may have finished before the last files were written
+		deleteVideoContent(contentCtx, q.contentService, job.VideoId)
+		return nil, err
+	}
+	// The manifest ffmpeg writes when the stream ends is static already
+	manifest, duration, finalErr := finalizeManifest(live.manifest)
+	if finalErr == nil && !bytes.Equal(manifest, live.manifest) {
+		finalErr = writeContent(contentCtx, q.contentService, job.VideoId, DASHManifest, manifest)
+	}
+	if finalErr == nil {
+		finalErr = q.metadataService.EndLive(job.VideoId, duration)
+	}
+	if finalErr != nil {
+		return nil, errors.Join(err, fmt.Errorf("failed to end live stream: %w", finalErr))
+	}
+	meta.Live, meta.Duration = false, duration
+	slog.InfoContext(ctx, "Live stream ended", "video_id", job.VideoId, "duration", duration, "err", err)
+	return &meta, err
+}
+
+// Ends a live stream that a previous run was ingesting when it stopped. If
+// the video was published, its last manifest is made static and the job
+// counts as done; otherwise its content is removed and the job fails.
+func (q *transcodeQueue) recoverLive(job TranscodeJob) {
+	ctx := telemetry.Background()
+	meta, err := q.metadataService.Read(job.VideoId)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := deleteVideoContent(ctx, q.contentService, job.VideoId); err != nil {
+			slog.WarnContext(ctx, "Failed to remove content of live stream", "video_id", job.VideoId, "err", err)
+		}
+		if err := q.metadataService.UpdateJob(job.Id, JobFailed, "interrupted by a web server restart"); err != nil {
+			slog.ErrorContext(ctx, "Failed to update job", "job_id", job.Id, "err", err)
+		}
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to read live video", "video_id", job.VideoId, "err", err)
+		return
+	}
+
+	if meta.Live {
+		data, err := readContent(ctx, q.contentService, job.VideoId, DASHManifest)
+		var manifest []byte
+		var duration time.Duration
+		if err == nil {
+			manifest, duration, err = finalizeManifest(data)
+		}
+		if err == nil {
+			err = writeContent(ctx, q.contentService, job.VideoId, DASHManifest, manifest)
+		}
+		if err == nil {
+			err = q.metadataService.EndLive(job.VideoId, duration)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to end interrupted live stream", "video_id", job.VideoId, "err", err)
+			return
+		}
+		slog.InfoContext(ctx, "Ended live stream interrupted by a restart", "video_id", job.VideoId, "duration", duration)
+	}
+	if err := q.metadataService.UpdateJob(job.Id, JobReady, "broadcast cut off by a web server restart"); err != nil {
+		slog.ErrorContext(ctx, "Failed to update job", "job_id", job.Id, "err", err)
+	}
+}
+
+var (
+	mpdStartTag = regexp.MustCompile(`<MPD\b[^>]*>`)
+	// Attributes of the MPD element that only dynamic manifests have
+	dynamicMPDAttr = regexp.MustCompile(`\s+(availabilityStartTime|publishTime|minimumUpdatePeriod|timeShiftBufferDepth|suggestedPresentationDelay)="[^"]*"`)
+	mpdType        = regexp.MustCompile(`\btype="dynamic"`)
+)
+
+// Turns a dynamic manifest into a static one covering the segments it lists,
+// and returns it with the duration of the longest representation. Static
+// manifests are returned as they are.
+func finalizeManifest(data []byte) ([]byte, time.Duration, error) {
+	duration, err := manifestDuration(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	tag := mpdStartTag.Find(data)
+	if tag == nil {
+		return nil, 0, errors.New("manifest has no MPD element")
+	}
+	if !mpdType.Match(tag) {
+		return data, duration, nil
+	}
+	static := dynamicMPDAttr.ReplaceAll(tag, nil)
+	static = mpdType.ReplaceAll(static, []byte(fmt.Sprintf(`type="static" mediaPresentationDuration="PT%.3fS"`, duration.Seconds())))
+	return bytes.Replace(data, tag, static, 1), duration, nil
+}
+
+// Returns how long the longest representation of a manifest lasts, going by
+// its SegmentTimelines
+func manifestDuration(data []byte) (time.Duration, error) {
+	var doc mpd
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return 0, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	var longest time.Duration
+	for _, period := range doc.Periods {
+		for _, set := range period.AdaptationSets {
+			for _, rep := range set.Representations {
+				tmpl := rep.SegmentTemplate
+				if tmpl == nil {
+					tmpl = set.SegmentTemplate
+				}
+				if tmpl == nil {
+					continue
+				}
+				timescale := max(tmpl.Timescale, 1)
+				var ticks int64
+				for _, s := range tmpl.Timeline {
+					ticks += s.D * (max(s.R, 0) + 1)
+				}
+				longest = max(longest, time.Duration(ticks)*time.Second/time.Duration(timescale))
+			}
+		}
+	}
+	return longest, nil
+}
@@ -0,0 +1,228 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A DASH manifest with one representation of count two-second segments, as
+// ffmpeg writes it while a stream is live (dynamic) or once it ended (static)
+func testManifest(dynamic bool, count int) string {
+	attrs := `type="static"`
+	if dynamic {
+		attrs = `type="dynamic" availabilityStartTime="2024-05-01T12:00:00Z" publishTime="2024-05-01T12:00:10Z" minimumUpdatePeriod="PT2S"`
+	}
+	return fmt.Sprintf(`<?xml version="1.0"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" %s profiles="urn:mpeg:dash:profile:isoff-live:2011">
+<Period id="0"><AdaptationSet id="0"><Representation id="0" bandwidth="400000">
+<SegmentTemplate timescale="1000" initialization="init-$RepresentationID$.m4s" media="chunk-$RepresentationID$-$Number%%05d$.m4s" startNumber="1">
+<SegmentTimeline><S t="0" d="2000" r="%d"/></SegmentTimeline>
+</SegmentTemplate></Representation></AdaptationSet></Period></MPD>
+`, attrs, count-1)
+}
+
+// orderedContent records the order files are written in
+type orderedContent struct {
+	*FSVideoContentService
+	written []string
+}
+
+func (c *orderedContent) Write(videoId string, filename string, data []byte) error {
+	c.written = append(c.written, filename)
+	return c.FSVideoContentService.Write(videoId, filename, data)
+}
+
+func (c *orderedContent) WriteStream(videoId string, filename string, r io.Reader) error {
+	c.written = append(c.written, filename)
+	return c.FSVideoContentService.WriteStream(videoId, filename, r)
+}
+
+func TestLiveStreamStoresSegmentsBeforeTheirManifest(t *testing.T) {
+	dir := t.TempDir()
+	cs := &orderedContent{FSVideoContentService: NewFSVideoContentService(t.TempDir())}
+	live := &liveStream{ctx: t.Context(), cs: cs, videoID: "video", dir: dir, stored: make(map[string]bool)}
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fresh, err := live.publish(); fresh || err != nil {
+		t.Fatalf("publish before the first manifest returned %v, %v", fresh, err)
+	}
+	write("init-0.m4s", "init")
+	write("chunk-0-00001.m4s", "segment 1")
+	// ffmpeg is already writing the next segment, which no manifest lists yet
+	write("chunk-0-00002.m4s", "segm")
+	write(DASHManifest, testManifest(true, 1))
+	if fresh, err := live.publish(); !fresh || err != nil {
+		t.Fatalf("publish of the first manifest returned %v, %v", fresh, err)
+	}
+	if want := []string{"init-0.m4s", "chunk-0-00001.m4s", DASHManifest}; !slices.Equal(cs.written, want) {
+		t.Fatalf("stored %v, want %v", cs.written, want)
+	}
+	if fresh, err := live.publish(); fresh || err != nil {
+		t.Fatalf("publish of an unchanged manifest returned %v, %v", fresh, err)
+	}
+
+	write("chunk-0-00002.m4s", "segment 2")
+	write(DASHManifest, testManifest(true, 2))
+	cs.written = nil
+	if fresh, err := live.publish(); !fresh || err != nil {
+		t.Fatalf("publish of the second manifest returned %v, %v", fresh, err)
+	}
+	if want := []string{"chunk-0-00002.m4s", DASHManifest}; !slices.Equal(cs.written, want) {
+		t.Fatalf("stored %v, want only the new segment and the manifest", cs.written)
+	}
+	if data, err := cs.Read("video", "chunk-0-00002.m4s"); err != nil || string(data) != "segment 2" {
+		t.Fatalf("stored segment 2 is %q, %v", data, err)
+	}
+	// Stored segments are removed from the spool dir
+	if _, err := os.Stat(filepath.Join(dir, "chunk-0-00001.m4s")); !os.IsNotExist(err) {
+		t.Fatalf("segment 1 is still in the spool dir: %v", err)
+	}
+}
+
+func TestFinalizeManifest(t *testing.T) {
+	static, duration, err := finalizeManifest([]byte(testManifest(true, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duration != 6*time.Second {
+		t.Errorf("duration %v, want 6s", duration)
+	}
+	for _, attr := range []string{"dynamic", "availabilityStartTime", "publishTime", "minimumUpdatePeriod"} {
+		if bytes.Contains(static, []byte(attr)) {
+			t.Errorf("finalized manifest still has %s", attr)
+		}
+	}
+	if !bytes.Contains(static, []byte(`type="static" mediaPresentationDuration="PT6.000S"`)) {
+		t.Errorf("finalized manifest is not static: %s", static)
+	}
+	if files, err := manifestFiles(static); err != nil || len(files) != 4 {
+		t.Errorf("finalized manifest lists %v, %v", files, err)
+	}
+
+	already := []byte(testManifest(false, 2))
+	got, duration, err := finalizeManifest(already)
+	if err != nil || !bytes.Equal(got, already) || duration != 4*time.Second {
+		t.Errorf("finalizing a static manifest returned %v, %v", duration, err)
+	}
+	if _, _, err := finalizeManifest([]byte("not a manifest")); err == nil {
+		t.Error("finalizing garbage succeeded")
+	}
+}
+
+func TestBroadcastReaderEndsOnReadErrors(t *testing.T) {
+	broken := errors.New("connection reset")
+	b := &broadcastReader{
+		body: io.MultiReader(strings.NewReader("some bytes"), &failingReader{broken}),
+		rc:   http.NewResponseController(httptest.NewRecorder()),
+	}
+	data, err := io.ReadAll(b)
+	if err != nil || string(data) != "some bytes" {
+		t.Fatalf("ReadAll returned %q, %v", data, err)
+	}
+	if !errors.Is(b.err, broken) {
+		t.Fatalf("kept error %v, want %v", b.err, broken)
+	}
+}
+
+type failingReader struct{ err error }
+
+func (r *failingReader) Read([]byte) (int, error) { return 0, r.err }
+
+// Stands in for ffmpeg: writes one segment and a dynamic manifest, waits for
+// the broadcast to end, then writes the last segment and a static manifest
+const fakeLiveFFmpeg = `#!/bin/sh
+case "$*" in *-frames:v*) echo jpg > thumbnail.jpg; exit 0;; esac
+echo init > init-0.m4s
+echo segment 1 > chunk-0-00001.m4s
+cat > manifest.tmp <<'MPD'
+%s
+MPD
+mv manifest.tmp manifest.mpd
+cat > /dev/null
+echo segment 2 > chunk-0-00002.m4s
+cat > manifest.tmp <<'MPD'
+%s
+MPD
+mv manifest.tmp manifest.mpd
+`
+
+func TestIngestLiveSyntheticStream(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	bin := t.TempDir()
+	scripts := map[string]string{
+		"ffmpeg":  fmt.Sprintf(fakeLiveFFmpeg, testManifest(true, 1), testManifest(false, 2)),
+		"ffprobe": "#!/bin/sh\necho video,1280,720\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	metadata := newTestSQLite(t)
+	content := NewFSVideoContentService(t.TempDir())
+	q, err := newTranscodeQueue(metadata, content, t.TempDir(), 1, DefaultLadder, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := &TranscodeJob{Id: "job", VideoId: "live", Title: "Live", Visibility: Public, Live: true}
+
+	// The broadcast goes on until the video has been published as live
+	stream, broadcaster := io.Pipe()
+	go func() {
+		broadcaster.Write(make([]byte, liveProbeSize+1000))
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+			if meta, err := metadata.Read("live"); err == nil && meta.Live {
+				break
+			}
+		}
+		broadcaster.Write([]byte("the rest of the broadcast"))
+		broadcaster.Close()
+	}()
+
+	meta, err := q.ingestLive(t.Context(), job, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Live || meta.Duration != 4*time.Second || meta.Height != 720 || meta.Thumbnail != ThumbnailName {
+		t.Fatalf("ingestLive returned %+v", meta)
+	}
+	stored, err := metadata.Read("live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Live || stored.Duration != 4*time.Second {
+		t.Fatalf("stored metadata %+v, want ended with a duration of 4s", stored)
+	}
+	files, err := content.List("live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	if want := []string{"chunk-0-00001.m4s", "chunk-0-00002.m4s", "init-0.m4s", DASHManifest, ThumbnailName}; !slices.Equal(files, want) {
+		t.Fatalf("stored %v, want %v", files, want)
+	}
+	manifest, err := content.Read("live", DASHManifest)
+	if err != nil || !bytes.Contains(manifest, []byte(`type="static"`)) {
+		t.Fatalf("stored manifest is not static: %s, %v", manifest, err)
+	}
+}
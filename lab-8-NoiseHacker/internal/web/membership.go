@@ -0,0 +1,353 @@
+// Persisted cluster membership for the network content service.
+//
+// The storage node list is written to a JSON file whenever AddNode/RemoveNode
+// changes it, and reloaded on startup. Several web servers may point at the same
+// file: each one polls it and applies any change, including an in-flight
+// migration, so that all of them route keys identically. Writers hold a lock on
+// the file and only replace it if it is still the one they last applied, so a
+// change made by another web server is never silently overwritten.
+
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+	"tritontube/internal/telemetry"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errMembershipChanged means another web server changed the membership file
+// since this one last read it
+var errMembershipChanged = errors.New("membership file was changed by another web server")
+
+type clusterMembership struct {
+	Version   int64                `json:"version"`
+	UpdatedAt time.Time            `json:"updated_at"`
+	Nodes     []string             `json:"nodes"`
+	Weights   map[string]int       `json:"weights,omitempty"` // vnodes by node, 1 if missing
+	Draining  map[string]int       `json:"draining,omitempty"` // vnodes drained nodes had, by node
+	Migration *membershipMigration `json:"migration,omitempty"`
+
+	sum [sha256.Size]byte // of the file as read or written
+}
+
+// membershipMigration describes a migration that has not cut over yet
+type membershipMigration struct {
+	Operation       string         `json:"operation"`
+	NodeAddress     string         `json:"node_address"`
+	PreviousNodes   []string       `json:"previous_nodes"`
+	PreviousWeights map[string]int `json:"previous_weights,omitempty"`
+}
+
+// Reads the membership file; returns nil without error if it does not exist yet
+func loadMembership(path string) (*clusterMembership, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read membership file: %w", err)
+	}
+	var m clusterMembership
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse membership file %s: %w", path, err)
+	}
+	m.sum = sha256.Sum256(data)
+	return &m, nil
+}
+
+// Writes the membership file atomically so watchers never see a partial file
+func saveMembership(path string, m *clusterMembership) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".membership-*")
+	if err != nil {
+		return fmt.Errorf("failed to write membership file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write membership file: %w", err)
+	}
+	// Synced before the rename, so a crash cannot leave the new name on an
+	// empty file
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write membership file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	m.sum = sha256.Sum256(data)
+	return nil
+}
+
+// UseMembershipFile loads the node list from path, replacing the nodes given on
+// the command line, or seeds the file with the current nodes if it is missing.
+// A migration that was interrupted by a restart is resumed in the background;
+// copying is idempotent, so it does not matter if another instance resumes it too.
+func (n *NetworkVideoContentService) UseMembershipFile(path string) error {
+	n.membershipPath = path
+	m, err := loadMembership(path)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		err := n.persistMembership()
+		if !errors.Is(err, errMembershipChanged) {
+			return err
+		}
+		// Another web server seeded it first
+		if m, err = loadMembership(path); err != nil || m == nil {
+			return err
+		}
+	}
+	slog.Info("Loaded storage nodes", "count", len(m.Nodes), "path", path)
+	if err := n.applyMembership(m); err != nil {
+		return err
+	}
+	if m.Migration != nil {
+		slog.Info("Resuming interrupted migration", "operation", m.Migration.Operation, "node", m.Migration.NodeAddress)
+		n.adminMu.Lock()
+		go func() {
+			defer n.adminMu.Unlock()
+			n.mu.RLock()
+			mig := n.migration
+			n.mu.RUnlock()
+			n.runMigration(telemetry.Background(), mig)
+		}()
+	}
+	return nil
+}
+
+// WatchMembership polls the membership file and applies changes made by other
+// web servers sharing it. It blocks, so run it in its own goroutine.
+func (n *NetworkVideoContentService) WatchMembership(interval time.Duration) {
+	for range time.Tick(interval) {
+		m, err := loadMembership(n.membershipPath)
+		if err != nil {
+			slog.Error("Membership watch failed", "err", err)
+			continue
+		}
+		n.mu.RLock()
+		changed := m != nil && m.sum != n.membershipSum
+		n.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		// A local AddNode/RemoveNode owns the membership while it runs
+		if !n.adminMu.TryLock() {
+			continue
+		}
+		if err := n.applyMembership(m); err != nil {
+			slog.Error("Membership watch failed", "err", err)
+		}
+		n.adminMu.Unlock()
+	}
+}
+
+// Applies the changes other web servers made to the membership file, so that
+// an admin operation starts from the current ring. Fails if one of them is
+// migrating. Called with adminMu held.
+func (n *NetworkVideoContentService) syncMembership() error {
+	if n.membershipPath == "" {
+		return nil
+	}
+	m, err := loadMembership(n.membershipPath)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	n.mu.RLock()
+	changed := m != nil && m.sum != n.membershipSum
+	n.mu.RUnlock()
+	if changed {
+		if err := n.applyMembership(m); err != nil {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+	}
+	n.mu.RLock()
+	migrating := n.migration != nil
+	n.mu.RUnlock()
+	if migrating {
+		return status.Error(codes.FailedPrecondition, "another web server is migrating, try again once it has cut over")
+	}
+	return nil
+}
+
+// ringState is what an admin operation changes before persisting it, saved so
+// that a change which cannot be persisted is undone
+type ringState struct {
+	ring     *hashRing
+	draining map[string]int
+	clients  map[string]*storageClient
+}
+
+// Called with mu held
+func (n *NetworkVideoContentService) ringStateLocked() ringState {
+	return ringState{ring: n.ring, draining: maps.Clone(n.draining), clients: maps.Clone(n.clients)}
+}
+
+// Puts back a saved ring state, closing the clients dialed since. Clients the
+// operation replaced must not have been closed yet.
+func (n *NetworkVideoContentService) restoreRingState(s ringState) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for addr, client := range n.clients {
+		if s.clients[addr] != client {
+			client.conn.Close()
+		}
+	}
+	n.ring = s.ring
+	n.draining = s.draining
+	n.clients = s.clients
+	n.migration = nil
+}
+
+// Persists the ring an admin operation just changed from previous, before it
+// migrates. If that fails the change is undone: when another web server
+// changed the membership file in the meantime, the file's membership is
+// applied instead and Aborted is returned, otherwise Unavailable.
+func (n *NetworkVideoContentService) persistRingChange(ctx context.Context, previous ringState) error {
+	err := n.persistMembership()
+	if err == nil {
+		return nil
+	}
+	n.restoreRingState(previous)
+	if errors.Is(err, errMembershipChanged) {
+		m, err := loadMembership(n.membershipPath)
+		if err == nil && m != nil {
+			err = n.applyMembership(m)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to reload membership", "err", err)
+		}
+		return status.Error(codes.Aborted, "storage nodes were changed by another web server, try again")
+	}
+	slog.ErrorContext(ctx, "Failed to persist membership", "err", err)
+	return status.Errorf(codes.Unavailable, "failed to persist storage nodes: %v", err)
+}
+
+// Writes the current ring (and in-flight migration, if any) to the membership
+// file, unless it changed since this web server last read or wrote it
+func (n *NetworkVideoContentService) persistMembership() error {
+	if n.membershipPath == "" {
+		return nil
+	}
+	unlock, err := lockFile(n.membershipPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	current, err := loadMembership(n.membershipPath)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	var currentSum [sha256.Size]byte
+	if current != nil {
+		currentSum = current.sum
+	}
+	if currentSum != n.membershipSum {
+		n.mu.Unlock()
+		return errMembershipChanged
+	}
+	m := &clusterMembership{
+		Version:   n.membershipVersion + 1,
+		UpdatedAt: time.Now(),
+		Nodes:     n.ring.nodes(),
+		Weights:   n.ring.weightMap(),
+		Draining:  maps.Clone(n.draining),
+	}
+	if n.migration != nil {
+		m.Migration = &membershipMigration{
+			Operation:       n.migration.operation,
+			NodeAddress:     n.migration.nodeAddr,
+			PreviousNodes:   n.migration.oldRing.nodes(),
+			PreviousWeights: n.migration.oldRing.weightMap(),
+		}
+	}
+	n.mu.Unlock()
+	if err := saveMembership(n.membershipPath, m); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	n.membershipVersion = m.Version
+	n.membershipSum = m.sum
+	n.mu.Unlock()
+	return nil
+}
+
+// Replaces the ring with the one described by m. An in-flight migration is
+// mirrored without copying anything: reads fall back to the previous owners
+// and writes go to both until the instance running it persists the cut-over.
+func (n *NetworkVideoContentService) applyMembership(m *clusterMembership) error {
+	wanted := slices.Clone(m.Nodes)
+	wanted = append(wanted, slices.Collect(maps.Keys(m.Draining))...)
+	if m.Migration != nil {
+		wanted = append(wanted, m.Migration.PreviousNodes...)
+	}
+
+	n.mu.RLock()
+	var missing []string
+	for _, addr := range wanted {
+		if _, ok := n.clients[addr]; !ok && !slices.Contains(missing, addr) {
+			missing = append(missing, addr)
+		}
+	}
+	n.mu.RUnlock()
+
+	newClients := make(map[string]*storageClient, len(missing))
+	for _, addr := range missing {
+		client, err := dialStorageNode(addr)
+		if err != nil {
+			return err
+		}
+		newClients[addr] = client
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for addr, client := range newClients {
+		n.clients[addr] = client
+	}
+	for addr, client := range n.clients {
+		if !slices.Contains(wanted, addr) {
+			client.conn.Close()
+			delete(n.clients, addr)
+			delete(n.health, addr)
+		}
+	}
+
+	newRing := newHashRing(m.Nodes, m.Weights)
+	if m.Migration != nil {
+		oldRing := newHashRing(m.Migration.PreviousNodes, m.Migration.PreviousWeights)
+		n.migration = newMigrationState(m.Migration.Operation, m.Migration.NodeAddress, oldRing, newRing, n.replicas)
+	} else if n.migration != nil {
+		n.migration.finish()
+		n.lastMigration = n.migration
+		n.migration = nil
+	}
+	n.ring = newRing
+	n.draining = make(map[string]int, len(m.Draining))
+	maps.Copy(n.draining, m.Draining)
+	n.membershipVersion = m.Version
+	n.membershipSum = m.sum
+	return nil
+}
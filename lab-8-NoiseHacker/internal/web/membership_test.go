@@ -0,0 +1,75 @@
+package web
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tritontube/internal/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A ring change that cannot be written to the membership file is undone, so
+// this web server keeps routing like the others sharing the file
+func TestRingChangeIsUndoneWhenItCannotBePersisted(t *testing.T) {
+	nodes := startStorageNodes(t, 3)
+	n, err := NewNetworkVideoContentService("", nodeAddrs(nodes), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "cluster")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "membership.json")
+	if err := n.UseMembershipFile(path); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := loadMembership(path)
+	if err != nil || saved == nil {
+		t.Fatalf("membership file was not seeded: %v", err)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	_, err = n.RemoveNode(t.Context(), &proto.RemoveNodeRequest{NodeAddress: nodes[0].addr})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("RemoveNode returned %v, want Unavailable", err)
+	}
+	if !n.ring.contains(nodes[0].addr) || n.ring.size() != 3 {
+		t.Fatalf("ring has %v after the failed RemoveNode", n.ring.nodes())
+	}
+	if n.migration != nil {
+		t.Fatal("the failed RemoveNode left a migration running")
+	}
+	if _, err := n.getStorageClient(nodes[0].addr); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveMembershipReplacesTheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "membership.json")
+	for version := int64(1); version <= 2; version++ {
+		m := &clusterMembership{Version: version, Nodes: []string{"a:1", "b:2"}}
+		if err := saveMembership(path, m); err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadMembership(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Version != version || got.sum != m.sum || len(got.Nodes) != 2 {
+			t.Fatalf("loaded %+v, want version %d", got, version)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("directory holds %d files, want only the membership file", len(entries))
+	}
+}
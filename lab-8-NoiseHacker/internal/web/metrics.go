@@ -0,0 +1,144 @@
+// Prometheus metrics of the web server beyond the HTTP and gRPC traffic the
+// telemetry package counts: ffmpeg runs, the storage ring, migrations and
+// repairs.
+
+package web
+
+import (
+	"time"
+	"tritontube/internal/proto"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ffmpegDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tritontube_ffmpeg_duration_seconds",
+		Help:    "Time taken by ffmpeg and ffprobe runs, by operation and outcome.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 14), // 50ms to about 7 minutes
+	}, []string{"operation", "outcome"})
+
+	migrationsFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tritontube_migrations_total",
+		Help: "Finished migrations, by operation (add or remove) and outcome (complete, or partial if keys failed to copy).",
+	}, []string{"operation", "outcome"})
+	migrationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tritontube_migration_duration_seconds",
+		Help:    "Time taken by migrations from start to cut-over, by operation.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 14), // 1s to about 2 hours
+	}, []string{"operation"})
+	migrationCopies = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tritontube_migration_copies_total",
+		Help: "Files migrations copied to new owners.",
+	})
+	migrationFailedKeys = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tritontube_migration_failed_keys_total",
+		Help: "Keys migrations failed to copy to at least one new owner, which stay where they were.",
+	})
+
+	repairPasses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tritontube_repair_passes_total",
+		Help: "Repair passes, by outcome (complete, or skipped during a membership change).",
+	}, []string{"outcome"})
+	repairKeys = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tritontube_repair_keys_total",
+		Help: "Keys repair passes looked at, by result: compared, divergent, repaired, failed or conflict.",
+	}, []string{"result"})
+	repairSkippedRanges = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tritontube_repair_skipped_ranges_total",
+		Help: "Ring ranges repair passes could not compare because too few owners were reachable.",
+	})
+)
+
+// Records how long an ffmpeg or ffprobe run that began at start took
+func observeFFmpeg(operation string, start time.Time, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	ffmpegDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+}
+
+// Records a migration that has cut over
+func observeMigration(m *migrationState) {
+	m.mu.Lock()
+	copied, failed := m.copied, len(m.failed)
+	duration := m.finishedAt.Sub(m.startedAt)
+	m.mu.Unlock()
+
+	outcome := "complete"
+	if failed > 0 {
+		outcome = "partial"
+	}
+	migrationsFinished.WithLabelValues(m.operation, outcome).Inc()
+	migrationDuration.WithLabelValues(m.operation).Observe(duration.Seconds())
+	migrationCopies.Add(float64(copied))
+	migrationFailedKeys.Add(float64(failed))
+}
+
+// Records the results of a repair pass
+func observeRepair(response *proto.RepairResponse) {
+	repairPasses.WithLabelValues("complete").Inc()
+	repairKeys.WithLabelValues("compared").Add(float64(response.KeyCount))
+	repairKeys.WithLabelValues("divergent").Add(float64(response.DivergentCount))
+	repairKeys.WithLabelValues("repaired").Add(float64(response.RepairedCount))
+	repairKeys.WithLabelValues("failed").Add(float64(response.FailedCount))
+	repairKeys.WithLabelValues("conflict").Add(float64(response.ConflictCount))
+	repairSkippedRanges.Add(float64(response.SkippedRangeCount))
+}
+
+var (
+	ringNodesDesc = prometheus.NewDesc("tritontube_ring_nodes",
+		"Storage nodes in the ring.", nil, nil)
+	ringVnodesDesc = prometheus.NewDesc("tritontube_ring_vnodes",
+		"Virtual nodes in the ring.", nil, nil)
+	ringReplicasDesc = prometheus.NewDesc("tritontube_ring_replicas",
+		"Nodes each key is stored on, counting every shard with erasure coding.", nil, nil)
+	storageNodesDesc = prometheus.NewDesc("tritontube_storage_nodes",
+		"Storage nodes known to the web server, by health state.", []string{"state"}, nil)
+	migrationInProgressDesc = prometheus.NewDesc("tritontube_migration_in_progress",
+		"1 while a migration is moving keys, else 0.", nil, nil)
+	migrationRemainingDesc = prometheus.NewDesc("tritontube_migration_remaining_copies",
+		"Planned copies of the running migration not made yet, failed ones included.", nil, nil)
+)
+
+// Describe implements prometheus.Collector, so the ring can be registered
+// with a Prometheus registry
+func (n *NetworkVideoContentService) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ringNodesDesc
+	ch <- ringVnodesDesc
+	ch <- ringReplicasDesc
+	ch <- storageNodesDesc
+	ch <- migrationInProgressDesc
+	ch <- migrationRemainingDesc
+}
+
+// Collect implements prometheus.Collector with the current ring and node
+// health
+func (n *NetworkVideoContentService) Collect(ch chan<- prometheus.Metric) {
+	n.mu.RLock()
+	nodes := len(n.ring.weights)
+	vnodes := len(n.ring.nodeHashes)
+	states := map[nodeState]int{nodeUnknown: 0, nodeHealthy: 0, nodeSuspect: 0, nodeDown: 0}
+	for addr := range n.clients {
+		states[n.nodeStateLocked(addr)]++
+	}
+	m := n.migration
+	n.mu.RUnlock()
+
+	ch <- prometheus.MustNewConstMetric(ringNodesDesc, prometheus.GaugeValue, float64(nodes))
+	ch <- prometheus.MustNewConstMetric(ringVnodesDesc, prometheus.GaugeValue, float64(vnodes))
+	ch <- prometheus.MustNewConstMetric(ringReplicasDesc, prometheus.GaugeValue, float64(n.replicas))
+	for state, count := range states {
+		ch <- prometheus.MustNewConstMetric(storageNodesDesc, prometheus.GaugeValue, float64(count), string(state))
+	}
+	inProgress, remaining := 0, 0
+	if m != nil {
+		m.mu.Lock()
+		inProgress, remaining = 1, m.total-m.copied
+		m.mu.Unlock()
+	}
+	ch <- prometheus.MustNewConstMetric(migrationInProgressDesc, prometheus.GaugeValue, float64(inProgress))
+	ch <- prometheus.MustNewConstMetric(migrationRemainingDesc, prometheus.GaugeValue, float64(remaining))
+}
@@ -0,0 +1,251 @@
+// Online rebalancing for the network content service.
+//
+// A membership change installs the new ring immediately and records the old one
+// in a migrationState. Until the migration cuts over, reads of keys whose owners
+// changed go to the previous owners unless the key has been confirmed copied, and
+// writes go to both sets of owners. Moved keys are only deleted from nodes that
+// no longer own them after cut-over, and once every new owner lists them.
+
+package web
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+	"tritontube/internal/proto"
+)
+
+type migrationState struct {
+	operation string // "add" or "remove"
+	nodeAddr  string
+	oldRing   *hashRing
+	newRing   *hashRing
+	replicas  int
+
+	mu         sync.Mutex
+	confirmed  map[string]bool // keys copied to all of their new owners
+	failed     map[string]bool // keys with at least one copy that failed
+	total      int             // planned copies
+	copied     int             // finished copies
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+// fileMove is one key that changes owner between the old and new ring
+type fileMove struct {
+	key      string
+	fromAddr string
+	toAddr   string
+}
+
+func newMigrationState(operation, nodeAddr string, oldRing, newRing *hashRing, replicas int) *migrationState {
+	return &migrationState{
+		operation: operation,
+		nodeAddr:  nodeAddr,
+		oldRing:   oldRing,
+		newRing:   newRing,
+		replicas:  replicas,
+		confirmed: make(map[string]bool),
+		failed:    make(map[string]bool),
+		startedAt: time.Now(),
+	}
+}
+
+// Returns the previous owners of key that are not among newOwners.
+// Safe to call on a nil migration.
+func (m *migrationState) previousOwners(key string, newOwners []string) []string {
+	if m == nil {
+		return nil
+	}
+	var previous []string
+	for _, addr := range m.oldRing.owners(key, m.replicas) {
+		if !slices.Contains(newOwners, addr) {
+			previous = append(previous, addr)
+		}
+	}
+	return previous
+}
+
+// Returns all previous owners of key, in ring order, if its owners changed and it
+// has not been confirmed copied yet. Safe to call on a nil migration.
+func (m *migrationState) pendingOwners(key string, newOwners []string) []string {
+	if len(m.previousOwners(key, newOwners)) == 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.confirmed[key] {
+		return nil
+	}
+	return m.oldRing.owners(key, m.replicas)
+}
+
+func (m *migrationState) finish() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.finishedAt = time.Now()
+}
+
+func (m *migrationState) migratedCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.copied
+}
+
+func (m *migrationState) status() *proto.MigrationStatusResponse {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	response := &proto.MigrationStatusResponse{
+		InProgress:        m.finishedAt.IsZero(),
+		Operation:         m.operation,
+		NodeAddress:       m.nodeAddr,
+		TotalFileCount:    int32(m.total),
+		MigratedFileCount: int32(m.copied),
+		FailedFileCount:   int32(len(m.failed)),
+		StartedAt:         m.startedAt.Unix(),
+	}
+	if !m.finishedAt.IsZero() {
+		response.FinishedAt = m.finishedAt.Unix()
+	}
+	return response
+}
+
+// Copies every key to the new owners that did not hold it before, cuts over to
+// the new ring and then removes keys from nodes that no longer own them.
+// Cancelling ctx does not stop it; ctx only lends its request ID to the calls
+// it makes.
+func (n *NetworkVideoContentService) runMigration(ctx context.Context, m *migrationState) {
+	ctx = context.WithoutCancel(ctx)
+	slog.InfoContext(ctx, "Migration started", "operation", m.operation, "node", m.nodeAddr)
+	sources := m.oldRing.nodes()
+	if n.code != nil {
+		n.migrateShards(ctx, m, sources)
+	} else {
+		n.migrateReplicas(ctx, m, sources)
+	}
+
+	// Cut over: from now on only the new ring is consulted
+	n.mu.Lock()
+	m.finish()
+	n.migration = nil
+	n.lastMigration = m
+	n.mu.Unlock()
+	observeMigration(m)
+	st := m.status()
+	slog.InfoContext(ctx, "Migration cut over", "operation", m.operation, "node", m.nodeAddr,
+		"copied", st.MigratedFileCount, "failed_keys", st.FailedFileCount)
+	if err := n.persistMembership(); err != nil {
+		slog.ErrorContext(ctx, "Migration failed to persist membership", "err", err)
+	}
+
+	// Re-list so keys dual-written during the migration are cleaned up as well.
+	// Keys that failed to copy stay where they are, and so do keys some new
+	// owner does not list, which count as failed.
+	ownerKeys := make(map[string]map[string]bool) // by node, empty if it could not be listed
+	ownerHas := func(addr, key string) bool {
+		keys, ok := ownerKeys[addr]
+		if !ok {
+			listed, err := n.getAllKeysFromNode(ctx, addr)
+			if err != nil {
+				slog.ErrorContext(ctx, "Migration failed to list keys", "node", addr, "err", err)
+			}
+			keys = make(map[string]bool, len(listed))
+			for _, k := range listed {
+				keys[k] = true
+			}
+			ownerKeys[addr] = keys
+		}
+		return keys[key]
+	}
+	for _, addr := range sources {
+		keys, err := n.getAllKeysFromNode(ctx, addr)
+		if err != nil {
+			continue
+		}
+		for _, key := range keys {
+			newOwners := m.newRing.owners(key, m.replicas)
+			m.mu.Lock()
+			failed := m.failed[key]
+			m.mu.Unlock()
+			if slices.Contains(newOwners, addr) || failed {
+				continue
+			}
+			if missing := slices.IndexFunc(newOwners, func(owner string) bool { return !ownerHas(owner, key) }); missing >= 0 {
+				slog.ErrorContext(ctx, "Migration kept moved key, a new owner does not have it", "key", key, "node", addr, "owner", newOwners[missing])
+				m.mu.Lock()
+				m.failed[key] = true
+				m.mu.Unlock()
+				continue
+			}
+			if err := n.deleteFromNode(ctx, addr, key); err != nil {
+				slog.ErrorContext(ctx, "Migration failed to delete moved key", "key", key, "node", addr, "err", err)
+			}
+		}
+	}
+}
+
+// Copies every key to the new owners that do not hold it yet
+func (n *NetworkVideoContentService) migrateReplicas(ctx context.Context, m *migrationState, sources []string) {
+	holders := make(map[string][]string)
+	listed := make(map[string]bool)
+	var listOrder []string
+	for _, addr := range sources {
+		keys, err := n.getAllKeysFromNode(ctx, addr)
+		if err != nil {
+			slog.ErrorContext(ctx, "Migration failed to list keys", "node", addr, "err", err)
+			continue
+		}
+		listed[addr] = true
+		for _, key := range keys {
+			if _, ok := holders[key]; !ok {
+				listOrder = append(listOrder, key)
+			}
+			holders[key] = append(holders[key], addr)
+		}
+	}
+
+	// Plan one copy per (key, new owner), reading from whichever node listed it
+	// first. An old owner that could not be listed is taken to hold its keys.
+	movesByKey := make(map[string][]fileMove)
+	var keyOrder []string
+	for _, key := range listOrder {
+		oldOwners := m.oldRing.owners(key, m.replicas)
+		for _, target := range m.newRing.owners(key, m.replicas) {
+			if slices.Contains(holders[key], target) || !listed[target] && slices.Contains(oldOwners, target) {
+				continue
+			}
+			if _, ok := movesByKey[key]; !ok {
+				keyOrder = append(keyOrder, key)
+			}
+			movesByKey[key] = append(movesByKey[key], fileMove{key: key, fromAddr: holders[key][0], toAddr: target})
+		}
+	}
+	m.mu.Lock()
+	for _, moves := range movesByKey {
+		m.total += len(moves)
+	}
+	m.mu.Unlock()
+
+	for _, key := range keyOrder {
+		ok := true
+		for _, mv := range movesByKey[key] {
+			err := n.copyFile(ctx, mv.key, mv.fromAddr, mv.toAddr)
+			m.mu.Lock()
+			if err != nil {
+				slog.ErrorContext(ctx, "Migration failed to copy key", "key", mv.key, "from", mv.fromAddr, "to", mv.toAddr, "err", err)
+				m.failed[key] = true
+				ok = false
+			} else {
+				m.copied++
+			}
+			m.mu.Unlock()
+		}
+		if ok {
+			m.mu.Lock()
+			m.confirmed[key] = true
+			m.mu.Unlock()
+		}
+	}
+}
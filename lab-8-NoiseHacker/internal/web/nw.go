@@ -4,238 +4,910 @@ package web
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"net"
+	"os"
 	"slices"
+	"sync"
+	"time"
+	"tritontube/internal/contentkey"
+	"tritontube/internal/erasure"
 	"tritontube/internal/proto"
+	"tritontube/internal/telemetry"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 // NetworkVideoContentService implements VideoContentService using a network of nodes.
 type NetworkVideoContentService struct{
 	adminAddr string
-	storageAddrs []string
-	nodeHashes []uint64
-	nodeHashToAddr map[uint64]string
-	clients map[string]proto.StorageServiceClient
+
+	// mu guards ring, clients, health and the migration pointers. Membership changes
+	// are additionally serialized by adminMu so only one migration runs at a time.
+	mu        sync.RWMutex
+	adminMu   sync.Mutex
+	ring      *hashRing
+	replicas  int           // number of distinct nodes each key is stored on
+	vnodeSize int64         // capacity per vnode of nodes joining the ring, 0 for unweighted; see capacity.go
+	code      *erasure.Code // erasure code files are stored with, nil for full replicas; see ec.go
+	clients   map[string]*storageClient
+	health    map[string]*nodeHealth
+	deleted   map[string]time.Time // keys deleted lately, which repair must not copy back; see repair.go
+	draining  map[string]int       // vnodes of nodes that left the ring with Drain but still hold keys; see rebalance.go
+	videos    VideoMetadataService // what Verify checks the content against, nil until UseVideoCatalog
+
+	migration     *migrationState // in-flight migration, nil when the ring is stable
+	lastMigration *migrationState // most recently finished migration, for MigrationStatus
+
+	membershipPath    string // optional file the ring is persisted to, see membership.go
+	membershipVersion int64
+	membershipSum     [32]byte // of the membership file as last read or written
+
 	proto.UnimplementedVideoContentAdminServiceServer
 }
 
 // Uncomment the following line to ensure NetworkVideoContentService implements VideoContentService
 var _ VideoContentService = (*NetworkVideoContentService)(nil)
 
+// Size of the pieces WriteStream sends; well below gRPC's 4 MB message limit
+const streamChunkSize = 256 << 10
+
+// storageClient bundles the RPC stubs for one storage node
+type storageClient struct {
+	proto.StorageServiceClient
+	health healthpb.HealthClient
+	conn   *grpc.ClientConn
+}
+
 
 // ******************** 1. NEW network content service ********************
-// Initializes the network content service with a hash ring and gRPC clients in web/main.go
-func NewNetworkVideoContentService(adminAddr string, storageAddrs []string) (*NetworkVideoContentService, error) {
-	nodeHashes := make([]uint64, 0, len(storageAddrs))
-	nodeHashToAddr := make(map[uint64]string)
-	clients := make(map[string]proto.StorageServiceClient)
+// Initializes the network content service with a hash ring and gRPC clients in web/main.go.
+// Every key is written to `replicas` consecutive nodes on the ring.
+func NewNetworkVideoContentService(adminAddr string, storageAddrs []string, replicas int) (*NetworkVideoContentService, error) {
+	if replicas < 1 {
+		return nil, fmt.Errorf("replication factor must be at least 1, got %d", replicas)
+	}
+	clients := make(map[string]*storageClient)
 
 	for _, nodeAddr := range storageAddrs {
-		hash := hashStringToUint64(nodeAddr)
-		nodeHashes = append(nodeHashes, hash)
-		nodeHashToAddr[hash] = nodeAddr
-
-		connection, err := grpc.Dial(nodeAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		client, err := dialStorageNode(nodeAddr)
 		if err != nil {
-			return nil, fmt.Errorf("failed to connect to storage node %s: %w", nodeAddr, err)
+			return nil, err
 		}
-		clients[nodeAddr] = proto.NewStorageServiceClient(connection)
+		clients[nodeAddr] = client
 	}
 
-	slices.Sort(nodeHashes)
-
 	service := &NetworkVideoContentService{
-		adminAddr:      adminAddr,
-		storageAddrs:   storageAddrs,
-		nodeHashes:     nodeHashes,
-		nodeHashToAddr: nodeHashToAddr,
-		clients:        clients,
+		adminAddr: adminAddr,
+		ring:      newHashRing(storageAddrs, nil),
+		replicas:  replicas,
+		clients:   clients,
+		health:    make(map[string]*nodeHealth),
+		deleted:   make(map[string]time.Time),
+		draining:  make(map[string]int),
 	}
 	return service, nil
 }
 
+func dialStorageNode(nodeAddr string) (*storageClient, error) {
+	connection, err := grpc.Dial(nodeAddr, append(telemetry.DialOptions(), grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to storage node %s: %w", nodeAddr, err)
+	}
+	return &storageClient{
+		StorageServiceClient: proto.NewStorageServiceClient(connection),
+		health:               healthpb.NewHealthClient(connection),
+		conn:                 connection,
+	}, nil
+}
+
 // ******************** 2. Read and Write ******************************
-// Retrieves a content file from the correct storage node using consistent hashing
+// Retrieves a content file from one of the key's replicas, skipping nodes that are
+// down. While the key's range is being migrated and has not been confirmed copied
+// yet, the previous owners are asked first and the new owners are the fallback.
 func (n *NetworkVideoContentService) Read(videoID string, filename string) ([]byte, error) {
-	key := videoID + "/" + filename
-	nodeAddr := n.getNodeForKey(key)
-	client := n.getStorageClient(nodeAddr)
+	return n.ReadContext(context.Background(), videoID, filename)
+}
 
-	req := &proto.ReadFileRequest{Key: key}
-	response, _ := client.ReadFile(context.Background(), req)
-	return response.Data, nil
+// Like Read; the storage nodes are called with ctx
+func (n *NetworkVideoContentService) ReadContext(ctx context.Context, videoID string, filename string) ([]byte, error) {
+	k, err := contentkey.New(videoID, filename)
+	if err != nil {
+		return nil, err
+	}
+	key := k.String()
+	if n.code != nil {
+		return n.readShards(ctx, key)
+	}
+
+	n.mu.RLock()
+	owners := n.ring.owners(key, n.replicas)
+	candidates := appendUnique(n.migration.pendingOwners(key, owners), owners...)
+	candidates = n.preferLiveLocked(candidates)
+	n.mu.RUnlock()
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no storage nodes available for key %s", key)
+	}
+	for _, nodeAddr := range candidates {
+		var data []byte
+		data, err = n.readFromNode(ctx, nodeAddr, key)
+		if err == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+// Opens a content file on the first replica that has it, for serving byte
+// ranges without reading the whole file. Erasure-coded files have no single
+// node to stream from, so they fail with errors.ErrUnsupported.
+func (n *NetworkVideoContentService) OpenContext(ctx context.Context, videoID string, filename string) (*contentFile, error) {
+	k, err := contentkey.New(videoID, filename)
+	if err != nil {
+		return nil, err
+	}
+	key := k.String()
+	if n.code != nil {
+		return nil, errors.ErrUnsupported
+	}
+
+	n.mu.RLock()
+	owners := n.ring.owners(key, n.replicas)
+	candidates := appendUnique(n.migration.pendingOwners(key, owners), owners...)
+	candidates = n.preferLiveLocked(candidates)
+	n.mu.RUnlock()
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no storage nodes available for key %s", key)
+	}
+	for _, nodeAddr := range candidates {
+		var f *nodeFile
+		f, err = n.openOnNode(ctx, nodeAddr, key)
+		if err == nil {
+			return &contentFile{ReadSeekCloser: f, size: f.size, sha256: f.sha256}, nil
+		}
+	}
+	return nil, err
 }
 
-// Stores a content file to the correct storage node using consistent hashing
+// Stores a content file on every replica of the key. Nodes that are down are
+// skipped, and the write succeeds as long as a write quorum of replicas
+// accepts it; missing replicas are left for repair. During a migration
+// the write also goes to the previous owners, so a read that falls back to them,
+// or a copy that already listed their keys, never misses the data.
 func (n *NetworkVideoContentService) Write(videoID string, filename string, data []byte) error {
-	key := videoID + "/" + filename
-	nodeAddr := n.getNodeForKey(key)
-	client := n.getStorageClient(nodeAddr)
+	return n.WriteContext(context.Background(), videoID, filename, data)
+}
+
+// Like Write; the storage nodes are called with ctx
+func (n *NetworkVideoContentService) WriteContext(ctx context.Context, videoID string, filename string, data []byte) error {
+	k, err := contentkey.New(videoID, filename)
+	if err != nil {
+		return err
+	}
+	key := k.String()
+	if n.code != nil {
+		return n.writeShards(ctx, "", key, data)
+	}
+
+	live, quorum, err := n.writeTargets(key)
+	if err != nil {
+		return err
+	}
+	written := 0
+	var lastErr error
+	for _, nodeAddr := range live {
+		if err := n.writeToNode(ctx, nodeAddr, key, data); err != nil {
+			slog.WarnContext(ctx, "Failed to write replica", "node", nodeAddr, "key", key, "err", err)
+			lastErr = err
+			continue
+		}
+		written++
+		slog.DebugContext(ctx, "Wrote replica", "node", nodeAddr, "key", key)
+	}
+	return checkReplicaQuorum(ctx, key, written, quorum, len(live), lastErr)
+}
+
+// Like Write, but streams the content to every replica in chunks of
+// streamChunkSize. A replica whose stream fails is dropped; the write succeeds
+// as long as a write quorum of replicas stores the whole file.
+func (n *NetworkVideoContentService) WriteStream(videoID string, filename string, r io.Reader) error {
+	return n.WriteStreamContext(context.Background(), videoID, filename, r)
+}
+
+// Like WriteStream; the storage nodes are called with ctx
+func (n *NetworkVideoContentService) WriteStreamContext(ctx context.Context, videoID string, filename string, r io.Reader) error {
+	return n.writeStream(ctx, "", videoID, filename, r)
+}
+
+// Like WriteStream, but the replicas stage the file under txID
+func (n *NetworkVideoContentService) StageStream(txID string, videoID string, filename string, r io.Reader) error {
+	return n.StageStreamContext(context.Background(), txID, videoID, filename, r)
+}
+
+// Like StageStream; the storage nodes are called with ctx
+func (n *NetworkVideoContentService) StageStreamContext(ctx context.Context, txID string, videoID string, filename string, r io.Reader) error {
+	if err := contentkey.ValidateComponent(txID); err != nil {
+		return err
+	}
+	return n.writeStream(ctx, txID, videoID, filename, r)
+}
+
+// Publishes the files staged under txID on every storage node, since staging
+// went to whichever replicas were up. Fails if any node could not confirm, so
+// the caller can retry once the node is back.
+func (n *NetworkVideoContentService) CommitStaged(txID string) error {
+	return n.CommitStagedContext(context.Background(), txID)
+}
+
+// Like CommitStaged; the storage nodes are called with ctx
+func (n *NetworkVideoContentService) CommitStagedContext(ctx context.Context, txID string) error {
+	return n.finishTransaction(ctx, txID, true)
+}
+
+// Discards the files staged under txID on every storage node
+func (n *NetworkVideoContentService) AbortStaged(txID string) error {
+	return n.AbortStagedContext(context.Background(), txID)
+}
+
+// Like AbortStaged; the storage nodes are called with ctx
+func (n *NetworkVideoContentService) AbortStagedContext(ctx context.Context, txID string) error {
+	return n.finishTransaction(ctx, txID, false)
+}
+
+func (n *NetworkVideoContentService) finishTransaction(ctx context.Context, txID string, commit bool) error {
+	var lastErr error
+	files := 0 // replicas or shards published, summed over the nodes
+	for _, nodeAddr := range n.knownNodes() {
+		client, err := n.getStorageClient(nodeAddr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		req := &proto.TransactionRequest{TxId: txID}
+		if commit {
+			var response *proto.TransactionResponse
+			response, err = client.CommitTransaction(ctx, req)
+			if err == nil {
+				files += int(response.FileCount)
+			}
+		} else {
+			_, err = client.AbortTransaction(ctx, req)
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("storage node %s failed to finish transaction %s: %w", nodeAddr, txID, err)
+		}
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	if commit {
+		slog.DebugContext(ctx, "Committed staged files", "tx_id", txID, "files", files)
+	} else {
+		slog.DebugContext(ctx, "Aborted staged files", "tx_id", txID)
+	}
+	return nil
+}
+
+// Streams a file to the key's replicas, staged under txID unless it is "".
+// Erasure coding needs the whole file to compute parity, so it is read into
+// memory first.
+func (n *NetworkVideoContentService) writeStream(ctx context.Context, txID string, videoID string, filename string, r io.Reader) error {
+	k, err := contentkey.New(videoID, filename)
+	if err != nil {
+		return err
+	}
+	key := k.String()
+	if n.code != nil {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		return n.writeShards(ctx, txID, key, data)
+	}
+
+	live, quorum, err := n.writeTargets(key)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	streams := make(map[string]proto.StorageService_WriteFileStreamClient)
+	var lastErr error
+	for _, nodeAddr := range live {
+		client, err := n.getStorageClient(nodeAddr)
+		if err == nil {
+			streams[nodeAddr], err = client.WriteFileStream(ctx)
+		}
+		if err != nil {
+			lastErr = err
+			delete(streams, nodeAddr)
+		}
+	}
+
+	buf := make([]byte, streamChunkSize)
+	first := true
+	for len(streams) > 0 {
+		size, readErr := io.ReadFull(r, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read %s: %w", key, readErr)
+		}
+		// Always send the first chunk, even if empty, so the key reaches the node
+		if size > 0 || first {
+			chunk := &proto.WriteFileChunk{Data: buf[:size]}
+			if first {
+				chunk.Key = key
+				chunk.TxId = txID
+			}
+			for nodeAddr, stream := range streams {
+				if err := stream.Send(chunk); err != nil {
+					lastErr = fmt.Errorf("storage node %s failed to write key %s: %w", nodeAddr, key, err)
+					delete(streams, nodeAddr)
+				}
+			}
+			first = false
+		}
+		if readErr != nil {
+			break
+		}
+	}
+
+	written := 0
+	for nodeAddr, stream := range streams {
+		response, err := stream.CloseAndRecv()
+		if err != nil || !response.Success {
+			lastErr = fmt.Errorf("storage node %s failed to write key %s: %v", nodeAddr, key, err)
+			continue
+		}
+		written++
+		slog.DebugContext(ctx, "Streamed replica", "node", nodeAddr, "key", key)
+	}
+	return checkReplicaQuorum(ctx, key, written, quorum, len(live), lastErr)
+}
+
+// How many replicas a write has to store, given the key's owners: two, so that
+// an acknowledged file survives losing a node, unless there is only one owner
+func replicaWriteQuorum(owners int) int {
+	return min(2, owners)
+}
+
+// Fails a write that stored fewer than a quorum of replicas, and logs one
+// that missed some of its targets, which the next repair pass fills in
+func checkReplicaQuorum(ctx context.Context, key string, written int, quorum int, targets int, lastErr error) error {
+	if written < quorum {
+		return fmt.Errorf("stored %d replicas of key %s, need %d: %w", written, key, quorum, lastErr)
+	}
+	if written < targets {
+		slog.WarnContext(ctx, "Stored some replicas, the rest are left for repair", "key", key, "written", written, "targets", targets)
+	}
+	return nil
+}
+
+// Returns the replicas of key, plus its previous owners during a migration,
+// excluding nodes that are down or draining, and the write quorum. Fails if
+// they are fewer than the quorum, before anything is written.
+func (n *NetworkVideoContentService) writeTargets(key string) ([]string, int, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	owners := n.ring.owners(key, n.replicas)
+	targets := append(owners, n.migration.previousOwners(key, owners)...)
+	var live []string
+	for _, addr := range targets {
+		_, draining := n.draining[addr]
+		if n.nodeStateLocked(addr) != nodeDown && !draining {
+			live = append(live, addr)
+		}
+	}
+	quorum := replicaWriteQuorum(len(owners))
+	if len(live) == 0 || len(live) < quorum {
+		return nil, 0, fmt.Errorf("%d live storage nodes available for key %s, need %d", len(live), key, quorum)
+	}
+	return live, quorum, nil
+}
+
+func (n *NetworkVideoContentService) readFromNode(ctx context.Context, nodeAddr string, key string) ([]byte, error) {
+	client, err := n.getStorageClient(nodeAddr)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.ReadFile(ctx, &proto.ReadFileRequest{Key: key})
+	if status.Code(err) == codes.NotFound {
+		// Same error the filesystem content service returns for a missing file
+		return nil, fmt.Errorf("storage node %s has no key %s: %w", nodeAddr, key, os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("storage node %s failed to read key %s: %w", nodeAddr, key, err)
+	}
+	return response.Data, nil
+}
+
+// nodeFile reads a file from one storage node. Seeking only moves the
+// position; the next Read opens a new stream there if it has to.
+type nodeFile struct {
+	ctx      context.Context
+	client   *storageClient
+	nodeAddr string
+	key      string
+	size     int64
+	sha256   string
+
+	pos    int64
+	stream grpc.ServerStreamingClient[proto.ReadFileChunk]
+	cancel context.CancelFunc
+	offset int64  // position the stream is at
+	buf    []byte // received but not yet read
+}
 
+func (n *NetworkVideoContentService) openOnNode(ctx context.Context, nodeAddr string, key string) (*nodeFile, error) {
+	client, err := n.getStorageClient(nodeAddr)
+	if err != nil {
+		return nil, err
+	}
+	f := &nodeFile{ctx: ctx, client: client, nodeAddr: nodeAddr, key: key}
+	first, err := f.open(0)
+	if err != nil {
+		return nil, err
+	}
+	f.size, f.sha256, f.buf = first.Size, first.Sha256, first.Data
+	return f, nil
+}
+
+// Starts a stream at offset and returns its first chunk
+func (f *nodeFile) open(offset int64) (*proto.ReadFileChunk, error) {
+	f.closeStream()
+	ctx, cancel := context.WithCancel(f.ctx)
+	stream, err := f.client.ReadFileStream(ctx, &proto.ReadFileStreamRequest{Key: f.key, Offset: offset})
+	var first *proto.ReadFileChunk
+	if err == nil {
+		first, err = stream.Recv()
+	}
+	if status.Code(err) == codes.NotFound {
+		cancel()
+		return nil, fmt.Errorf("storage node %s has no key %s: %w", f.nodeAddr, f.key, os.ErrNotExist)
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("storage node %s failed to read key %s: %w", f.nodeAddr, f.key, err)
+	}
+	f.stream, f.cancel, f.offset = stream, cancel, offset
+	return first, nil
+}
+
+func (f *nodeFile) Read(p []byte) (int, error) {
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	if f.stream == nil || f.pos != f.offset {
+		first, err := f.open(f.pos)
+		if err != nil {
+			return 0, err
+		}
+		f.buf = first.Data
+	}
+	for len(f.buf) == 0 {
+		chunk, err := f.stream.Recv()
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, fmt.Errorf("storage node %s failed to read key %s: %w", f.nodeAddr, f.key, err)
+		}
+		f.buf = chunk.Data
+	}
+	read := copy(p, f.buf)
+	f.buf = f.buf[read:]
+	f.pos += int64(read)
+	f.offset = f.pos
+	return read, nil
+}
+
+func (f *nodeFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek to negative offset %d", offset)
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *nodeFile) Close() error {
+	f.closeStream()
+	return nil
+}
+
+func (f *nodeFile) closeStream() {
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.stream, f.cancel, f.buf = nil, nil, nil
+}
+
+func (n *NetworkVideoContentService) writeToNode(ctx context.Context, nodeAddr string, key string, data []byte) error {
+	client, err := n.getStorageClient(nodeAddr)
+	if err != nil {
+		return err
+	}
 	req := &proto.WriteFileRequest{
 		Key:  key,
 		Data: data,
 	}
-	response, _ := client.WriteFile(context.Background(), req)
-	if !response.Success {
-		return fmt.Errorf("storage node %s failed to write key %s", nodeAddr, key)
+	response, err := client.WriteFile(ctx, req)
+	if err != nil || !response.Success {
+		return fmt.Errorf("storage node %s failed to write key %s: %v", nodeAddr, key, err)
 	}
-	fmt.Println("Writing to", nodeAddr, "key =", key)
 	return nil
 }
 
+// Lists the files of a video on every storage node, since migrations and
+// failovers can leave copies outside the current owners. Returns the files found
+// together with an error if any node could not be asked.
+func (n *NetworkVideoContentService) List(videoID string) ([]string, error) {
+	return n.ListContext(context.Background(), videoID)
+}
+
+// Like List; the storage nodes are called with ctx
+func (n *NetworkVideoContentService) ListContext(ctx context.Context, videoID string) ([]string, error) {
+	if err := contentkey.ValidateComponent(videoID); err != nil {
+		return nil, err
+	}
+	prefix := videoID + "/"
+
+	var filenames []string
+	seen := make(map[string]bool)
+	var lastErr error
+	for _, nodeAddr := range n.knownNodes() {
+		keys, err := n.listKeysOnNode(ctx, nodeAddr, prefix)
+		if err != nil {
+			lastErr = fmt.Errorf("storage node %s failed to list %s: %w", nodeAddr, prefix, err)
+			continue
+		}
+		for _, key := range keys {
+			if k, err := contentkey.Parse(key); err == nil && !seen[k.Filename] {
+				seen[k.Filename] = true
+				filenames = append(filenames, k.Filename)
+			}
+		}
+	}
+	return filenames, lastErr
+}
+
+// Deletes a file from every storage node. Fails if any node could not confirm
+// the file is gone, so the caller can retry once the node is back.
+func (n *NetworkVideoContentService) Delete(videoID string, filename string) error {
+	return n.DeleteContext(context.Background(), videoID, filename)
+}
+
+// Like Delete; the storage nodes are called with ctx
+func (n *NetworkVideoContentService) DeleteContext(ctx context.Context, videoID string, filename string) error {
+	k, err := contentkey.New(videoID, filename)
+	if err != nil {
+		return err
+	}
+	key := k.String()
+
+	n.mu.Lock()
+	n.deleted[key] = time.Now()
+	n.mu.Unlock()
+
+	var lastErr error
+	for _, nodeAddr := range n.knownNodes() {
+		err := n.deleteFromNode(ctx, nodeAddr, key)
+		if err != nil && status.Code(err) != codes.NotFound {
+			lastErr = fmt.Errorf("storage node %s failed to delete key %s: %w", nodeAddr, key, err)
+			continue
+		}
+		if err == nil {
+			slog.DebugContext(ctx, "Deleted key", "node", nodeAddr, "key", key)
+		}
+	}
+	return lastErr
+}
+
+// Returns every node the service has a client for, including nodes still
+// taking part in a migration
+func (n *NetworkVideoContentService) knownNodes() []string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	nodes := make([]string, 0, len(n.clients))
+	for addr := range n.clients {
+		nodes = append(nodes, addr)
+	}
+	return nodes
+}
+
 
 // ******************** 3. Starts the gRPC server ********************
 // Starts the gRPC server to handle admin CLI requests
 func (n *NetworkVideoContentService) StartAdminGRPCServer() {
 	listener, err := net.Listen("tcp", n.adminAddr)
 	if err != nil {
-		fmt.Println("Failed to listen:", err)
+		slog.Error("Admin gRPC server failed to listen", "addr", n.adminAddr, "err", err)
 		return
 	}
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(telemetry.ServerOptions()...)
 	proto.RegisterVideoContentAdminServiceServer(grpcServer, n)
 	grpcServer.Serve(listener)
 }
 
 
 // ********** 4. Implement Node Operations Specified in admin.proto **********
-// Returns the list of storage nodes in the hash ring in sorted order
+// Returns the list of storage nodes in the hash ring in sorted order, with
+// their health and utilization
 func (n *NetworkVideoContentService) ListNodes(ctx context.Context, req *proto.ListNodesRequest) (*proto.ListNodesResponse, error) {
-	var nodeAddrs []string
-	for _, nodeHash := range n.nodeHashes {
-		nodeAddrs = append(nodeAddrs, n.nodeHashToAddr[nodeHash])
+	n.mu.RLock()
+	nodes := n.ring.nodes()
+	n.mu.RUnlock()
+	response := &proto.ListNodesResponse{
+		Nodes:        nodes,
+		NodeStatuses: n.nodeStatuses(nodes),
 	}
-	response := &proto.ListNodesResponse{Nodes: nodeAddrs}
 	return response, nil
 }
-// Adds a new node to the cluster and migrates affected files to the new node
-func (n *NetworkVideoContentService) AddNode(ctx context.Context, req *proto.AddNodeRequest) (*proto.AddNodeResponse, error) {
-	nodeAddr := req.NodeAddress
-	hash := hashStringToUint64(nodeAddr)
-	n.nodeHashes = append(n.nodeHashes, hash)
-	n.nodeHashToAddr[hash] = nodeAddr
-	slices.Sort(n.nodeHashes)
-
-	conn, _ := grpc.Dial(nodeAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	n.clients[nodeAddr] = proto.NewStorageServiceClient(conn)
-
-	migratedCount := 0
-	for _, h := range n.nodeHashes {
-		addr := n.nodeHashToAddr[h]
-		if addr == nodeAddr {
-			continue
-		}
-		keys, err := n.getAllKeysFromNode(addr)
-		if err != nil {
-			continue
+
+// ClusterStatus reports the ring's configuration, every node with its usage,
+// health and share of the keys, and the current or last migration
+func (n *NetworkVideoContentService) ClusterStatus(ctx context.Context, req *proto.ClusterStatusRequest) (*proto.ClusterStatusResponse, error) {
+	n.mu.RLock()
+	ring := n.ring
+	nodes := append(ring.nodes(), slices.Sorted(maps.Keys(n.draining))...)
+	m := n.migration
+	if m == nil {
+		m = n.lastMigration
+	}
+	response := &proto.ClusterStatusResponse{
+		Replicas:  int32(n.replicas),
+		VnodeSize: n.vnodeSize,
+	}
+	if n.code != nil {
+		response.DataShards = int32(n.code.DataShards())
+		response.ParityShards = int32(n.code.ParityShards())
+	}
+	n.mu.RUnlock()
+
+	shares := ring.shares(int(response.Replicas))
+	response.Nodes = n.nodeStatuses(nodes)
+	for _, st := range response.Nodes {
+		st.RingShare = shares[st.Address]
+		st.Draining = !ring.contains(st.Address)
+	}
+	if m != nil {
+		response.Migration = m.status()
+	}
+	return response, nil
+}
+
+// Returns the health and usage of the given nodes, with their vnodes on the
+// current ring
+func (n *NetworkVideoContentService) nodeStatuses(nodes []string) []*proto.NodeStatus {
+	// Down nodes are not asked, so one of them cannot hold up the listing
+	n.mu.RLock()
+	var reachable []string
+	for _, addr := range nodes {
+		if n.nodeStateLocked(addr) != nodeDown {
+			reachable = append(reachable, addr)
 		}
-		for _, key := range keys {
-			target := n.getNodeForKey(key)
-			if target == nodeAddr {
-				err := n.migrateFile(key, addr, nodeAddr)
-				if err == nil {
-					migratedCount++
-				}
-			}
+	}
+	n.mu.RUnlock()
+	stats := n.collectStats(reachable)
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	statuses := n.nodeStatusesLocked(nodes)
+	for _, st := range statuses {
+		st.Vnodes = int32(n.ring.weight(st.Address))
+		if s, ok := stats[st.Address]; !ok {
+			st.StatsError = "node is down"
+		} else if s.err != nil {
+			st.StatsError = s.err.Error()
+		} else {
+			st.CapacityBytes = s.CapacityBytes
+			st.UsedBytes = s.UsedBytes
+			st.FileCount = s.FileCount
 		}
 	}
+	return statuses
+}
+// Adds a new node to the cluster and migrates affected files to the new node.
+// Returns only after every moved key has been copied and the ring has cut over.
+func (n *NetworkVideoContentService) AddNode(ctx context.Context, req *proto.AddNodeRequest) (*proto.AddNodeResponse, error) {
+	nodeAddr := req.NodeAddress
+	n.adminMu.Lock()
+	defer n.adminMu.Unlock()
+	if err := n.syncMembership(); err != nil {
+		return nil, err
+	}
 
-	response := &proto.AddNodeResponse{MigratedFileCount: int32(migratedCount)}
+	n.mu.RLock()
+	exists := n.ring.contains(nodeAddr)
+	n.mu.RUnlock()
+	if exists {
+		return nil, status.Errorf(codes.AlreadyExists, "node %s is already in the cluster", nodeAddr)
+	}
+
+	client, err := dialStorageNode(nodeAddr)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	weight, err := n.weightForNewNode(client)
+	if err != nil {
+		client.conn.Close()
+		return nil, status.Errorf(codes.Unavailable, "node %s did not report its capacity: %v", nodeAddr, err)
+	}
+
+	n.mu.Lock()
+	previous := n.ringStateLocked()
+	newRing := n.ring.clone()
+	newRing.add(nodeAddr, weight)
+	m := newMigrationState("add", nodeAddr, n.ring, newRing, n.replicas)
+	// Adding a draining node back cancels the drain
+	delete(n.draining, nodeAddr)
+	n.clients[nodeAddr] = client
+	n.ring = newRing
+	n.migration = m
+	n.mu.Unlock()
+	if err := n.persistRingChange(ctx, previous); err != nil {
+		return nil, err
+	}
+	if old, ok := previous.clients[nodeAddr]; ok {
+		old.conn.Close()
+	}
+
+	n.runMigration(ctx, m)
+
+	response := &proto.AddNodeResponse{MigratedFileCount: int32(m.migratedCount())}
 	return response, nil
 }
-// Removes a node from the cluster and migrates its files to remaining nodes
+// Removes a node from the cluster and migrates its files to remaining nodes.
+// Returns only after every moved key has been copied and the ring has cut over.
+// With erasure coding, the shards are repaired in the background instead and
+// the node need not be up; MigrationStatus reports the progress.
 func (n *NetworkVideoContentService) RemoveNode(ctx context.Context, req *proto.RemoveNodeRequest) (*proto.RemoveNodeResponse, error) {
 	nodeAddr := req.NodeAddress
-	nodeHash := hashStringToUint64(nodeAddr)
-
-	delete(n.nodeHashToAddr, nodeHash)
-	for i, h := range n.nodeHashes {
-		if h == nodeHash {
-			n.nodeHashes = append(n.nodeHashes[:i], n.nodeHashes[i+1:]...)
-			break
+	n.adminMu.Lock()
+	background := false
+	defer func() {
+		if !background {
+			n.adminMu.Unlock()
 		}
+	}()
+	if err := n.syncMembership(); err != nil {
+		return nil, err
 	}
 
-	keys, _ := n.getAllKeysFromNode(nodeAddr)
-
-	migratedCount := 0
-	for _, key := range keys {
-		newNodeAddr := n.getNodeForKey(key)
-		if newNodeAddr != nodeAddr {
-			err := n.migrateFile(key, nodeAddr, newNodeAddr)
-			if err == nil {
-				migratedCount++
-			}
+	n.mu.Lock()
+	previous := n.ringStateLocked()
+	if _, draining := n.draining[nodeAddr]; draining && !n.ring.contains(nodeAddr) {
+		// Gives up on the keys a drain could not move off the node
+		delete(n.draining, nodeAddr)
+		n.mu.Unlock()
+		if err := n.persistRingChange(ctx, previous); err != nil {
+			return nil, err
 		}
+		n.dropNode(nodeAddr)
+		return &proto.RemoveNodeResponse{}, nil
 	}
-	delete(n.clients, nodeAddr)
+	if !n.ring.contains(nodeAddr) {
+		n.mu.Unlock()
+		return nil, status.Errorf(codes.NotFound, "node %s is not in the cluster", nodeAddr)
+	}
+	if n.ring.size() == 1 {
+		n.mu.Unlock()
+		return nil, status.Errorf(codes.FailedPrecondition, "cannot remove the last node %s", nodeAddr)
+	}
+	if n.code != nil && n.ring.size() <= n.code.TotalShards() {
+		n.mu.Unlock()
+		return nil, status.Errorf(codes.FailedPrecondition, "erasure coding needs %d storage nodes, cannot remove %s", n.code.TotalShards(), nodeAddr)
+	}
+	newRing := n.ring.clone()
+	newRing.remove(nodeAddr)
+	m := newMigrationState("remove", nodeAddr, n.ring, newRing, n.replicas)
+	n.ring = newRing
+	n.migration = m
+	n.mu.Unlock()
+	if err := n.persistRingChange(ctx, previous); err != nil {
+		return nil, err
+	}
+
+	if n.code != nil {
+		// The migration takes over adminMu
+		background = true
+		go func() {
+			defer n.adminMu.Unlock()
+			n.runMigration(ctx, m)
+			n.dropNode(nodeAddr)
+		}()
+		return &proto.RemoveNodeResponse{}, nil
+	}
+
+	n.runMigration(ctx, m)
+	n.dropNode(nodeAddr)
 
-	response := &proto.RemoveNodeResponse{MigratedFileCount: int32(migratedCount)}
+	response := &proto.RemoveNodeResponse{MigratedFileCount: int32(m.migratedCount())}
 	return response, nil
 }
 
-// ******************** 5. Consistent Hashing Logic ********************
-func hashStringToUint64(s string) uint64 {
-	sum := sha256.Sum256([]byte(s))
-	return binary.BigEndian.Uint64(sum[:8])
+// Forgets a node that has left the ring
+func (n *NetworkVideoContentService) dropNode(nodeAddr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if client, ok := n.clients[nodeAddr]; ok {
+		client.conn.Close()
+		delete(n.clients, nodeAddr)
+	}
+	delete(n.health, nodeAddr)
 }
-// Returns the storage node responsible for the given key
-func (n *NetworkVideoContentService) getNodeForKey(key string) string {
-	keyHash := hashStringToUint64(key)
-	for _, nodeHash := range n.nodeHashes {
-		if keyHash <= nodeHash {
-			return n.nodeHashToAddr[nodeHash]
-		}
+// Reports progress of the running migration, or the result of the last one
+func (n *NetworkVideoContentService) MigrationStatus(ctx context.Context, req *proto.MigrationStatusRequest) (*proto.MigrationStatusResponse, error) {
+	n.mu.RLock()
+	m := n.migration
+	if m == nil {
+		m = n.lastMigration
+	}
+	n.mu.RUnlock()
+
+	if m == nil {
+		return &proto.MigrationStatusResponse{}, nil
 	}
-	// if larger than all nodes, map to the smallest node since its a ring
-	return n.nodeHashToAddr[n.nodeHashes[0]]
+	return m.status(), nil
 }
+
+// ******************** 5. Storage Node Helpers ********************
 // Returns the gRPC client for a given node address
-func (n *NetworkVideoContentService) getStorageClient(nodeAddr string) proto.StorageServiceClient {
+func (n *NetworkVideoContentService) getStorageClient(nodeAddr string) (*storageClient, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
 	client, ok := n.clients[nodeAddr]
 	if !ok {
-		panic("client not found for nodeAddr: " + nodeAddr)
+		return nil, fmt.Errorf("client not found for nodeAddr: %s", nodeAddr)
 	}
-	return client
+	return client, nil
 }
-// Copies a file from one node to another and optionally deletes the original
-func (n *NetworkVideoContentService) migrateFile(key string, fromAddr string, toAddr string) error {
-	fromClient := n.getStorageClient(fromAddr)
-	readRequest := &proto.ReadFileRequest{Key: key}
-	readResponse, err := fromClient.ReadFile(context.Background(), readRequest)
+// Copies a file from one node to another, leaving the original in place
+func (n *NetworkVideoContentService) copyFile(ctx context.Context, key string, fromAddr string, toAddr string) error {
+	data, err := n.readFromNode(ctx, fromAddr, key)
 	if err != nil {
 		return err
 	}
-
-	toClient := n.getStorageClient(toAddr)
-	writeRequest := &proto.WriteFileRequest{Key: key, Data: readResponse.Data}
-	writeResponse, err := toClient.WriteFile(context.Background(), writeRequest)
-	if err != nil || !writeResponse.Success {
-		return fmt.Errorf("failed to write to node %s", toAddr)
-	}
-
-	deleteRequest := &proto.DeleteFileRequest{Key: key}
-	_, err = fromClient.DeleteFile(context.Background(), deleteRequest)
+	return n.writeToNode(ctx, toAddr, key, data)
+}
+// Deletes a file from a node once it is no longer responsible for it
+func (n *NetworkVideoContentService) deleteFromNode(ctx context.Context, nodeAddr string, key string) error {
+	client, err := n.getStorageClient(nodeAddr)
 	if err != nil {
 		return err
 	}
-
-	return nil
+	_, err = client.DeleteFile(ctx, &proto.DeleteFileRequest{Key: key})
+	return err
 }
 // Retrieves all content keys stored at a given node (used during migration)
-func (n *NetworkVideoContentService) getAllKeysFromNode(nodeAddr string) ([]string, error) {
-	client := n.getStorageClient(nodeAddr)
-	request := &proto.ListKeysRequest{}
-	response, err := client.ListKeys(context.Background(), request)
+func (n *NetworkVideoContentService) getAllKeysFromNode(ctx context.Context, nodeAddr string) ([]string, error) {
+	return n.listKeysOnNode(ctx, nodeAddr, "")
+}
+// Retrieves the content keys starting with prefix stored at a given node
+func (n *NetworkVideoContentService) listKeysOnNode(ctx context.Context, nodeAddr string, prefix string) ([]string, error) {
+	client, err := n.getStorageClient(nodeAddr)
+	if err != nil {
+		return nil, err
+	}
+	request := &proto.ListKeysRequest{Prefix: prefix}
+	response, err := client.ListKeys(ctx, request)
 	if err != nil {
 		return nil, err
 	}
 	return response.Keys, nil
-}
\ No newline at end of file
+}
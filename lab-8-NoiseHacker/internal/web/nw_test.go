@@ -0,0 +1,59 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestOpenContextReadsRanges(t *testing.T) {
+	nodes := startStorageNodes(t, 2)
+	n, err := NewNetworkVideoContentService("", nodeAddrs(nodes), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 3*streamChunkSize+17)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := n.Write("video", "chunk-0-00001.m4s", data); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := n.OpenContext(t.Context(), "video", "chunk-0-00001.m4s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sum := sha256.Sum256(data)
+	if f.size != int64(len(data)) || f.sha256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("opened file has size %d and digest %s", f.size, f.sha256)
+	}
+
+	// Backwards and forwards across the storage node's chunks
+	for _, r := range []struct{ offset, length int64 }{{int64(len(data)) - 100, 100}, {5, 2*streamChunkSize + 1}, {0, 10}, {1 << 16, 7}} {
+		if _, err := f.Seek(r.offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, r.length)
+		if _, err := io.ReadFull(f, got); err != nil {
+			t.Fatalf("reading %d bytes at %d: %v", r.length, r.offset, err)
+		}
+		if !bytes.Equal(got, data[r.offset:r.offset+r.length]) {
+			t.Fatalf("%d bytes at %d differ", r.length, r.offset)
+		}
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Read at the end returned %v, want io.EOF", err)
+	}
+
+	if _, err := n.OpenContext(t.Context(), "video", "missing.m4s"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("opening a missing file returned %v, want os.ErrNotExist", err)
+	}
+}
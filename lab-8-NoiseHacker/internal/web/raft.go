@@ -0,0 +1,257 @@
+// A video metadata service replicated by a Raft group of metadata nodes
+
+package web
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"tritontube/internal/proto"
+	"tritontube/internal/telemetry"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// RaftVideoMetadataService implements VideoMetadataService with the metadata
+// nodes of cmd/metadata. Any node will do, since followers forward to the
+// leader; while there is no leader, calls are retried on the other nodes
+// until Timeout runs out.
+type RaftVideoMetadataService struct {
+	Timeout time.Duration // for one call, retries included
+
+	addrs   []string
+	clients []proto.MetadataServiceClient
+
+	mu        sync.Mutex
+	preferred int // the node that answered last
+}
+
+var _ VideoMetadataService = (*RaftVideoMetadataService)(nil)
+
+// DefaultMetadataTimeout is how long a call waits for the group to elect a
+// leader, a few election timeouts
+const DefaultMetadataTimeout = 10 * time.Second
+
+// How long one attempt waits on a node before moving on to the next, so a
+// node that is partitioned away does not hold up the call
+const metadataAttemptTimeout = 2 * time.Second
+
+func NewRaftVideoMetadataService(addrs []string) (*RaftVideoMetadataService, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no metadata nodes given")
+	}
+	m := &RaftVideoMetadataService{Timeout: DefaultMetadataTimeout, addrs: addrs}
+	for _, addr := range addrs {
+		conn, err := grpc.NewClient(addr, append(telemetry.DialOptions(), grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to metadata node %s: %w", addr, err)
+		}
+		m.clients = append(m.clients, proto.NewMetadataServiceClient(conn))
+	}
+	return m, nil
+}
+
+// Calls op with args and decodes its result into result, which may be nil. A
+// retried write keeps its request ID, so it is applied at most once.
+func (m *RaftVideoMetadataService) call(result any, op string, args ...any) error {
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	req := &proto.MetadataRequest{Op: op, Args: encoded, RequestId: newID() + newID()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.Timeout)
+	defer cancel()
+	m.mu.Lock()
+	first := m.preferred
+	m.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		i := (first + attempt) % len(m.clients)
+		attemptCtx, attemptCancel := context.WithTimeout(ctx, metadataAttemptTimeout)
+		resp, err := m.clients[i].Execute(attemptCtx, req)
+		attemptCancel()
+		if err == nil {
+			m.mu.Lock()
+			m.preferred = i
+			m.mu.Unlock()
+			if result == nil || len(resp.Result) == 0 {
+				return nil
+			}
+			return json.Unmarshal(resp.Result, result)
+		}
+
+		code := status.Code(err)
+		if code != codes.Unavailable && code != codes.DeadlineExceeded {
+			return metadataError(err)
+		}
+		// Gives an election time to finish after trying every node
+		if (attempt+1)%len(m.clients) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("metadata service unavailable: %w", err)
+		}
+	}
+}
+
+// Turns the status codes of metadata.proto back into the errors callers check for
+func metadataError(err error) error {
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.NotFound:
+		return sql.ErrNoRows
+	case codes.AlreadyExists:
+		if st.Message() == ErrUsernameTaken.Error() {
+			return ErrUsernameTaken
+		}
+		return ErrVideoIDTaken
+	case codes.InvalidArgument:
+		if st.Message() == ErrInvalidCursor.Error() {
+			return ErrInvalidCursor
+		}
+	}
+	return fmt.Errorf("metadata service: %s", st.Message())
+}
+
+func (m *RaftVideoMetadataService) Read(id string) (*VideoMetadata, error) {
+	var v VideoMetadata
+	if err := m.call(&v, "Read", id); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (m *RaftVideoMetadataService) List(opts ListOptions) (*VideoPage, error) {
+	var page VideoPage
+	if err := m.call(&page, "List", opts); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (m *RaftVideoMetadataService) PrepareCreate(txId string, meta VideoMetadata) error {
+	return m.call(nil, "PrepareCreate", txId, meta)
+}
+
+func (m *RaftVideoMetadataService) CommitCreate(txId string) error {
+	return m.call(nil, "CommitCreate", txId)
+}
+
+func (m *RaftVideoMetadataService) AbortCreate(txId string) error {
+	return m.call(nil, "AbortCreate", txId)
+}
+
+func (m *RaftVideoMetadataService) Update(videoId string, title string, description string, visibility Visibility) error {
+	return m.call(nil, "Update", videoId, title, description, visibility)
+}
+
+func (m *RaftVideoMetadataService) EndLive(videoId string, duration time.Duration) error {
+	return m.call(nil, "EndLive", videoId, duration)
+}
+
+func (m *RaftVideoMetadataService) MarkDeleted(videoId string) error {
+	return m.call(nil, "MarkDeleted", videoId)
+}
+
+func (m *RaftVideoMetadataService) ListDeleted() ([]string, error) {
+	var ids []string
+	err := m.call(&ids, "ListDeleted")
+	return ids, err
+}
+
+func (m *RaftVideoMetadataService) Delete(videoId string) error {
+	return m.call(nil, "Delete", videoId)
+}
+
+func (m *RaftVideoMetadataService) CreateJob(job TranscodeJob) error {
+	return m.call(nil, "CreateJob", job)
+}
+
+func (m *RaftVideoMetadataService) CreateUniqueJob(job TranscodeJob) (*TranscodeJob, error) {
+	var existing *TranscodeJob
+	if err := m.call(&existing, "CreateUniqueJob", job); err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+func (m *RaftVideoMetadataService) UpdateJob(jobId string, status JobStatus, errMsg string) error {
+	return m.call(nil, "UpdateJob", jobId, status, errMsg)
+}
+
+func (m *RaftVideoMetadataService) ReadJob(jobId string) (*TranscodeJob, error) {
+	var job TranscodeJob
+	if err := m.call(&job, "ReadJob", jobId); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (m *RaftVideoMetadataService) ReadJobByVideo(videoId string) (*TranscodeJob, error) {
+	var job TranscodeJob
+	if err := m.call(&job, "ReadJobByVideo", videoId); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (m *RaftVideoMetadataService) ListJobs(statuses ...JobStatus) ([]TranscodeJob, error) {
+	var jobs []TranscodeJob
+	err := m.call(&jobs, "ListJobs", statuses)
+	return jobs, err
+}
+
+func (m *RaftVideoMetadataService) CreateUser(user User, adminIfFirst bool) (*User, error) {
+	var created User
+	if err := m.call(&created, "CreateUser", user, adminIfFirst); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (m *RaftVideoMetadataService) ReadUser(userId string) (*User, error) {
+	var user User
+	if err := m.call(&user, "ReadUser", userId); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (m *RaftVideoMetadataService) ReadUserByName(username string) (*User, error) {
+	var user User
+	if err := m.call(&user, "ReadUserByName", username); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (m *RaftVideoMetadataService) SetAdmin(username string) error {
+	return m.call(nil, "SetAdmin", username)
+}
+
+func (m *RaftVideoMetadataService) CreateSession(session Session) error {
+	return m.call(nil, "CreateSession", session)
+}
+
+func (m *RaftVideoMetadataService) ReadSession(tokenHash string) (*Session, error) {
+	var session Session
+	if err := m.call(&session, "ReadSession", tokenHash); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (m *RaftVideoMetadataService) DeleteSession(tokenHash string) error {
+	return m.call(nil, "DeleteSession", tokenHash)
+}
@@ -0,0 +1,150 @@
+// Draining and rebalancing for the network content service.
+//
+// Drain is a gentler RemoveNode: the node leaves the ring, so no new keys are
+// placed on it, and its keys are migrated to their new owners, but it is only
+// forgotten once none of them failed to copy. Until then it stays draining: it
+// is persisted with the membership, health checked and asked for deletes, and
+// running Drain again retries the keys that are left.
+//
+// Rebalance migrates to a ring reweighted by the capacity the nodes report now,
+// since weights are otherwise fixed when a node joins. Even when no weight
+// changes, the migration copies keys that are missing from some of their
+// owners, or sit on nodes that do not own them, to their owners.
+
+package web
+
+import (
+	"context"
+	"log/slog"
+	"tritontube/internal/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Drain takes a node out of the ring and migrates its keys off it. Returns
+// once the migration has cut over; a caller giving up earlier does not stop it.
+func (n *NetworkVideoContentService) Drain(ctx context.Context, req *proto.DrainRequest) (*proto.DrainResponse, error) {
+	ctx = context.WithoutCancel(ctx)
+	nodeAddr := req.NodeAddress
+	n.adminMu.Lock()
+	defer n.adminMu.Unlock()
+	if err := n.syncMembership(); err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	previous := n.ringStateLocked()
+	oldRing := n.ring
+	newRing := n.ring.clone()
+	weight, draining := n.draining[nodeAddr]
+	switch {
+	case n.ring.contains(nodeAddr):
+		if n.ring.size() == 1 {
+			n.mu.Unlock()
+			return nil, status.Errorf(codes.FailedPrecondition, "cannot drain the last node %s", nodeAddr)
+		}
+		if n.code != nil && n.ring.size() <= n.code.TotalShards() {
+			n.mu.Unlock()
+			return nil, status.Errorf(codes.FailedPrecondition, "erasure coding needs %d storage nodes, cannot drain %s", n.code.TotalShards(), nodeAddr)
+		}
+		newRing.remove(nodeAddr)
+		n.draining[nodeAddr] = n.ring.weight(nodeAddr)
+	case draining:
+		// Migrating from a ring that still has the node moves what is left on it
+		oldRing = n.ring.clone()
+		oldRing.add(nodeAddr, weight)
+	default:
+		n.mu.Unlock()
+		return nil, status.Errorf(codes.NotFound, "node %s is not in the cluster", nodeAddr)
+	}
+	m := newMigrationState("drain", nodeAddr, oldRing, newRing, n.replicas)
+	n.ring = newRing
+	n.migration = m
+	n.mu.Unlock()
+	if err := n.persistRingChange(ctx, previous); err != nil {
+		return nil, err
+	}
+
+	n.runMigration(ctx, m)
+
+	st := m.status()
+	response := &proto.DrainResponse{
+		MigratedFileCount: st.MigratedFileCount,
+		FailedFileCount:   st.FailedFileCount,
+	}
+	// Only an empty node is forgotten. Keys that failed to copy stay on it, and
+	// a node that could not be listed may hold keys nobody else has.
+	keys, err := n.getAllKeysFromNode(ctx, nodeAddr)
+	if err != nil || len(keys) > 0 {
+		slog.WarnContext(ctx, "Drained node still holds keys, it stays draining", "node", nodeAddr, "keys", len(keys), "err", err)
+		return response, nil
+	}
+	// The node stays draining if forgetting it cannot be persisted
+	n.mu.Lock()
+	previous = n.ringStateLocked()
+	delete(n.draining, nodeAddr)
+	n.mu.Unlock()
+	if err := n.persistRingChange(ctx, previous); err != nil {
+		return nil, err
+	}
+	n.dropNode(nodeAddr)
+	response.Removed = true
+	return response, nil
+}
+
+// Rebalance reweights the nodes by the capacity they report now and migrates
+// to the reweighted ring. Nodes that do not answer keep their weight. Returns
+// once the migration has cut over.
+func (n *NetworkVideoContentService) Rebalance(ctx context.Context, req *proto.RebalanceRequest) (*proto.RebalanceResponse, error) {
+	n.adminMu.Lock()
+	defer n.adminMu.Unlock()
+	if err := n.syncMembership(); err != nil {
+		return nil, err
+	}
+
+	n.mu.RLock()
+	oldRing := n.ring
+	vnodeSize := n.vnodeSize
+	n.mu.RUnlock()
+
+	nodes := oldRing.nodes()
+	weights := oldRing.weightMap()
+	response := &proto.RebalanceResponse{}
+	if vnodeSize > 0 {
+		stats := n.collectStats(nodes)
+		for _, addr := range nodes {
+			s := stats[addr]
+			if s.err != nil {
+				slog.WarnContext(ctx, "Storage node did not report its capacity, keeping its weight", "node", addr, "err", s.err)
+				continue
+			}
+			if w := vnodesFor(s.CapacityBytes, vnodeSize); w != weights[addr] {
+				response.Reweighted = append(response.Reweighted, &proto.NodeWeight{
+					Address:   addr,
+					OldVnodes: int32(weights[addr]),
+					NewVnodes: int32(w),
+				})
+				weights[addr] = w
+			}
+		}
+	}
+
+	n.mu.Lock()
+	previous := n.ringStateLocked()
+	newRing := newHashRing(nodes, weights)
+	m := newMigrationState("rebalance", "", oldRing, newRing, n.replicas)
+	n.ring = newRing
+	n.migration = m
+	n.mu.Unlock()
+	if err := n.persistRingChange(ctx, previous); err != nil {
+		return nil, err
+	}
+
+	n.runMigration(ctx, m)
+
+	st := m.status()
+	response.MigratedFileCount = st.MigratedFileCount
+	response.FailedFileCount = st.FailedFileCount
+	return response, nil
+}
@@ -0,0 +1,358 @@
+// Anti-entropy repair for the network content service.
+//
+// Every key should be on each of its owners with the same content, but a write
+// that skipped a node that was down, or a file that rotted or vanished on disk,
+// breaks that silently. A repair pass compares the owners of each part of the
+// ring: each owner hashes the checksums of its keys there into a Merkle tree,
+// so parts that agree cost one tree per owner, and only the leaves whose hashes
+// differ are listed key by key. A key missing from an owner, or with content
+// that differs from what most owners hold, gets the majority's version copied
+// over. Storage nodes leave files that failed their scrub out of their trees,
+// so a corrupt copy looks missing and is replaced too.
+//
+// With erasure coding every owner holds a different shard of a key, so only
+// the keys are compared, and missing shards are rebuilt from the others.
+
+package web
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"tritontube/internal/proto"
+	"tritontube/internal/telemetry"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultRepairInterval is how often a repair pass runs in the background
+const DefaultRepairInterval = time.Hour
+
+const (
+	// 1024 leaves keep the keys listed per differing leaf few for clusters of
+	// millions of keys, and a tree at 64 KB
+	repairTreeDepth = 10
+	// How long a deleted key is kept from being copied back by a repair that
+	// saw it on a node the delete had not reached yet
+	deleteGrace = 10 * time.Minute
+)
+
+// repairGroup is the ranges of the ring owned by the same set of nodes
+type repairGroup struct {
+	owners []string
+	ranges []*proto.HashRange
+}
+
+// Groups the ranges of ring by the nodes that own them
+func repairGroups(ring *hashRing, replicas int) []*repairGroup {
+	groups := make(map[string]*repairGroup)
+	var order []string
+	for _, r := range ring.ranges(replicas) {
+		id := strings.Join(slices.Sorted(slices.Values(r.owners)), ",")
+		g, ok := groups[id]
+		if !ok {
+			g = &repairGroup{owners: r.owners}
+			groups[id] = g
+			order = append(order, id)
+		}
+		g.ranges = append(g.ranges, &proto.HashRange{Start: r.start, End: r.end})
+	}
+	result := make([]*repairGroup, len(order))
+	for i, id := range order {
+		result[i] = groups[id]
+	}
+	return result
+}
+
+// StartRepairs runs a repair pass once per interval. It blocks, so run it in
+// its own goroutine.
+func (n *NetworkVideoContentService) StartRepairs(interval time.Duration) {
+	for range time.Tick(interval) {
+		response, err := n.repair()
+		if err != nil {
+			slog.Info("Repair skipped", "reason", err)
+			continue
+		}
+		if response.DivergentCount > 0 || response.SkippedRangeCount > 0 {
+			slog.Info("Repair finished", "keys", response.KeyCount, "divergent", response.DivergentCount,
+				"repaired", response.RepairedCount, "failed", response.FailedCount,
+				"conflicting", response.ConflictCount, "skipped_ranges", response.SkippedRangeCount)
+		}
+	}
+}
+
+// Repair runs a repair pass right away
+func (n *NetworkVideoContentService) Repair(ctx context.Context, req *proto.RepairRequest) (*proto.RepairResponse, error) {
+	response, err := n.repair()
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return response, nil
+}
+
+func (n *NetworkVideoContentService) repair() (*proto.RepairResponse, error) {
+	// Migrations move keys between owners themselves and hold adminMu while
+	// they do, so a pass would only get in their way
+	if !n.adminMu.TryLock() {
+		repairPasses.WithLabelValues("skipped").Inc()
+		return nil, errors.New("a membership change is in progress")
+	}
+	defer n.adminMu.Unlock()
+
+	n.mu.Lock()
+	if n.migration != nil {
+		n.mu.Unlock()
+		repairPasses.WithLabelValues("skipped").Inc()
+		return nil, errors.New("a migration is in progress")
+	}
+	ring := n.ring
+	for key, deletedAt := range n.deleted {
+		if time.Since(deletedAt) > deleteGrace {
+			delete(n.deleted, key)
+		}
+	}
+	n.mu.Unlock()
+
+	ctx := telemetry.Background()
+	response := &proto.RepairResponse{}
+	for _, group := range repairGroups(ring, n.replicas) {
+		n.repairGroup(ctx, ring, group, response)
+	}
+	observeRepair(response)
+	return response, nil
+}
+
+// Compares the owners of one group of ranges and repairs the keys that differ
+func (n *NetworkVideoContentService) repairGroup(ctx context.Context, ring *hashRing, group *repairGroup, response *proto.RepairResponse) {
+	keysOnly := n.code != nil
+	var live []string
+	n.mu.RLock()
+	for _, addr := range group.owners {
+		if n.nodeStateLocked(addr) != nodeDown {
+			live = append(live, addr)
+		}
+	}
+	n.mu.RUnlock()
+	// Shards can only be rebuilt once every owner is there to take one
+	if len(live) < 2 || keysOnly && len(live) < len(group.owners) {
+		response.SkippedRangeCount += int32(len(group.ranges))
+		return
+	}
+
+	trees := make(map[string][][]byte)
+	var keyCount int64
+	for _, addr := range live {
+		tree, err := n.merkleTreeOnNode(ctx, addr, group.ranges, keysOnly)
+		if err != nil {
+			slog.WarnContext(ctx, "Repair failed to get Merkle tree", "node", addr, "err", err)
+			continue
+		}
+		trees[addr] = tree.Nodes
+		keyCount = max(keyCount, tree.KeyCount)
+	}
+	response.KeyCount += keyCount
+	if len(trees) < 2 || keysOnly && len(trees) < len(group.owners) {
+		response.SkippedRangeCount += int32(len(group.ranges))
+		return
+	}
+	leaves := differingLeaves(trees, repairTreeDepth)
+	if len(leaves) == 0 {
+		return
+	}
+
+	// The checksum each node has for each key under the differing leaves
+	sums := make(map[string]map[string]string)
+	for addr := range trees {
+		checksums, err := n.listChecksumsOnNode(ctx, addr, group.ranges, leaves)
+		if err != nil {
+			// A key would look missing from this node and be copied over for nothing
+			slog.WarnContext(ctx, "Repair failed to list checksums", "node", addr, "err", err)
+			response.SkippedRangeCount += int32(len(group.ranges))
+			return
+		}
+		for _, c := range checksums {
+			if sums[c.Key] == nil {
+				sums[c.Key] = make(map[string]string)
+			}
+			sums[c.Key][addr] = c.Sha256
+		}
+	}
+
+	compared := slices.Sorted(maps.Keys(trees))
+	for _, key := range slices.Sorted(maps.Keys(sums)) {
+		held := sums[key]
+		if len(held) == len(trees) && (keysOnly || allEqual(held)) {
+			continue
+		}
+		response.DivergentCount++
+		if n.recentlyDeleted(key) {
+			continue
+		}
+		if keysOnly {
+			n.repairShards(ctx, ring, group, key, response)
+		} else {
+			n.repairReplicas(ctx, key, held, compared, response)
+		}
+	}
+}
+
+// Copies the version of key most nodes hold to the nodes that lack it
+func (n *NetworkVideoContentService) repairReplicas(ctx context.Context, key string, held map[string]string, nodes []string, response *proto.RepairResponse) {
+	votes := make(map[string]int)
+	for _, sum := range held {
+		votes[sum]++
+	}
+	var best string
+	tie := false
+	for sum, count := range votes {
+		switch {
+		case count > votes[best]:
+			best, tie = sum, false
+		case count == votes[best]:
+			tie = true
+		}
+	}
+	if tie {
+		slog.WarnContext(ctx, "Repair found conflicting versions, none of them on most owners", "key", key)
+		response.ConflictCount++
+		return
+	}
+
+	var source string
+	var targets []string
+	for _, addr := range nodes {
+		sum, ok := held[addr]
+		switch {
+		case sum == best && source == "":
+			source = addr
+		case !ok || sum != best:
+			targets = append(targets, addr)
+		}
+	}
+	for _, target := range targets {
+		if err := n.copyFile(ctx, key, source, target); err != nil {
+			slog.ErrorContext(ctx, "Repair failed to copy key", "key", key, "from", source, "to", target, "err", err)
+			response.FailedCount++
+			return
+		}
+		n.undoIfDeleted(ctx, key, target)
+	}
+	slog.InfoContext(ctx, "Repair copied key", "key", key, "from", source, "to", targets)
+	response.RepairedCount++
+}
+
+// Rebuilds the shards of key its owners are missing
+func (n *NetworkVideoContentService) repairShards(ctx context.Context, ring *hashRing, group *repairGroup, key string, response *proto.RepairResponse) {
+	targets := ring.owners(key, n.replicas)
+	written, err := n.placeShards(ctx, key, group.owners, targets)
+	if err != nil {
+		slog.ErrorContext(ctx, "Repair failed to place shards", "key", key, "err", err)
+		response.FailedCount++
+		return
+	}
+	for _, target := range targets {
+		n.undoIfDeleted(ctx, key, target)
+	}
+	slog.InfoContext(ctx, "Repair rebuilt shards", "key", key, "shards", written)
+	response.RepairedCount++
+}
+
+// Returns the leaves at which the trees are not all the same. Trees of the
+// wrong shape count as differing everywhere.
+func differingLeaves(trees map[string][][]byte, depth int) []uint32 {
+	size := 2<<depth - 1
+	firstLeaf := 1<<depth - 1
+	var nodes [][][]byte
+	for _, tree := range trees {
+		if len(tree) != size {
+			tree = make([][]byte, size)
+		}
+		nodes = append(nodes, tree)
+	}
+	var leaves []uint32
+	pending := []int{0}
+	for len(pending) > 0 {
+		i := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		same := true
+		for _, tree := range nodes[1:] {
+			if tree[i] == nil || !bytes.Equal(tree[i], nodes[0][i]) {
+				same = false
+				break
+			}
+		}
+		switch {
+		case same:
+		case i >= firstLeaf:
+			leaves = append(leaves, uint32(i-firstLeaf))
+		default:
+			pending = append(pending, 2*i+1, 2*i+2)
+		}
+	}
+	slices.Sort(leaves)
+	return leaves
+}
+
+func allEqual(sums map[string]string) bool {
+	var first string
+	for _, sum := range sums {
+		if first == "" {
+			first = sum
+		} else if sum != first {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether key was deleted within deleteGrace
+func (n *NetworkVideoContentService) recentlyDeleted(key string) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	deletedAt, ok := n.deleted[key]
+	return ok && time.Since(deletedAt) <= deleteGrace
+}
+
+// Deletes a copy a repair just made if the key was deleted meanwhile
+func (n *NetworkVideoContentService) undoIfDeleted(ctx context.Context, key string, nodeAddr string) {
+	if !n.recentlyDeleted(key) {
+		return
+	}
+	if err := n.deleteFromNode(ctx, nodeAddr, key); err != nil && status.Code(err) != codes.NotFound {
+		slog.ErrorContext(ctx, "Repair failed to delete key again", "key", key, "node", nodeAddr, "err", err)
+	}
+}
+
+func (n *NetworkVideoContentService) merkleTreeOnNode(ctx context.Context, nodeAddr string, ranges []*proto.HashRange, keysOnly bool) (*proto.MerkleTreeResponse, error) {
+	client, err := n.getStorageClient(nodeAddr)
+	if err != nil {
+		return nil, err
+	}
+	return client.MerkleTree(ctx, &proto.MerkleTreeRequest{
+		Ranges:   ranges,
+		Depth:    repairTreeDepth,
+		KeysOnly: keysOnly,
+	})
+}
+
+func (n *NetworkVideoContentService) listChecksumsOnNode(ctx context.Context, nodeAddr string, ranges []*proto.HashRange, leaves []uint32) ([]*proto.KeyChecksum, error) {
+	client, err := n.getStorageClient(nodeAddr)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.ListChecksums(ctx, &proto.ListChecksumsRequest{
+		Ranges: ranges,
+		Depth:  repairTreeDepth,
+		Leaves: leaves,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.Checksums, nil
+}
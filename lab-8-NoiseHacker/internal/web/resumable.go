@@ -0,0 +1,503 @@
+// Resumable uploads.
+//
+// Large files can be uploaded through the API in chunks, so a dropped
+// connection costs a chunk instead of the whole upload:
+//
+//	POST   /api/v1/uploads                      starts one, with the file's size and the video's details
+//	PUT    /api/v1/uploads/{uploadId}?offset=N  writes the body at byte N
+//	GET    /api/v1/uploads/{uploadId}           tells how many bytes have arrived
+//	POST   /api/v1/uploads/{uploadId}/complete  checks the file's SHA-256 and queues it
+//	DELETE /api/v1/uploads/{uploadId}           gives up
+//
+// An upload is a partial file in the spool dir with a JSON file describing
+// it, so it survives web server restarts. How much has arrived is the size of
+// the partial file, which is synced before a chunk is acknowledged. A chunk
+// is received into a file of its own and lands whole or not at all. It may
+// start before the end of what arrived, overwriting it, so a client that does
+// not know whether its last chunk got through can send it again. Completing
+// checks the whole file against the digest the client gives before it is
+// handed to transcoding, where it goes through the same checks as any upload,
+// duplicates included.
+//
+// Uploads are local to the web server that started them, like the spool dir.
+// The garbage collector removes the ones nobody has written to for
+// UploadSessionTTL.
+
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultUploadSessionTTL is how long an idle resumable upload is kept unless
+// UploadSessionTTL is changed
+const DefaultUploadSessionTTL = 24 * time.Hour
+
+// uploadSession describes a resumable upload; it is stored as JSON next to
+// the partial file
+type uploadSession struct {
+	Id          string    `json:"id"`
+	VideoId     string    `json:"video_id"`
+	OwnerId     string    `json:"owner_id"`
+	Filename    string    `json:"filename"`
+	Size        int64     `json:"size"` // of the whole file
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Visibility  string    `json:"visibility"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// uploadSessions keeps the resumable uploads in a directory, two files each
+type uploadSessions struct {
+	dir string
+
+	mu   sync.Mutex
+	busy map[string]bool // uploads a request is writing to, completing or removing
+}
+
+func openUploadSessions(dir string) (*uploadSessions, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload dir: %w", err)
+	}
+	return &uploadSessions{dir: dir, busy: make(map[string]bool)}, nil
+}
+
+func (u *uploadSessions) infoPath(id string) string  { return filepath.Join(u.dir, id+".json") }
+func (u *uploadSessions) partPath(id string) string  { return filepath.Join(u.dir, id+".part") }
+func (u *uploadSessions) chunkPath(id string) string { return filepath.Join(u.dir, id+".chunk") }
+
+// Upload IDs come from newID, which keeps them safe to use in paths
+func validUploadID(id string) bool {
+	_, err := hex.DecodeString(id)
+	return err == nil && len(id) == 16
+}
+
+// Records a new upload with nothing received yet
+func (u *uploadSessions) create(sess uploadSession) error {
+	if err := writeSynced(u.partPath(sess.Id), nil); err != nil {
+		return err
+	}
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	// The description is written last, so an upload never exists without its
+	// partial file
+	if err := writeSynced(u.infoPath(sess.Id)+".tmp", data); err != nil {
+		os.Remove(u.partPath(sess.Id))
+		return err
+	}
+	return os.Rename(u.infoPath(sess.Id)+".tmp", u.infoPath(sess.Id))
+}
+
+// Returns an upload and its partial file's state: how much has arrived and
+// when it was last written. Fails with os.ErrNotExist for unknown uploads.
+func (u *uploadSessions) read(id string) (*uploadSession, os.FileInfo, error) {
+	if !validUploadID(id) {
+		return nil, nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(u.infoPath(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	var sess uploadSession
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse upload %s: %w", id, err)
+	}
+	part, err := os.Stat(u.partPath(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &sess, part, nil
+}
+
+// Claims an upload for one request at a time. Returns false if another
+// request has it.
+func (u *uploadSessions) acquire(id string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.busy[id] {
+		return false
+	}
+	u.busy[id] = true
+	return true
+}
+
+func (u *uploadSessions) release(id string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.busy, id)
+}
+
+// Writes a chunk at offset, cutting off whatever followed it. The chunk is
+// received into a file of its own first, and the partial file is only touched
+// once all of it arrived and matches checksum, if one is given. Returns the new
+// size of the partial file. Requires the upload to be acquired.
+func (u *uploadSessions) writeChunk(id string, offset int64, chunk io.Reader, checksum string) (int64, error) {
+	tmp, err := os.Create(u.chunkPath(id))
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), chunk)
+	if err != nil {
+		return 0, err
+	}
+	if checksum != "" && !strings.EqualFold(checksum, hex.EncodeToString(hash.Sum(nil))) {
+		return 0, &statusError{http.StatusUnprocessableEntity, "Chunk checksum mismatch"}
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(u.partPath(id), os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(io.NewOffsetWriter(f, offset), tmp); err == nil {
+		err = f.Truncate(offset + n)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		// What followed offset may be half overwritten
+		f.Truncate(offset)
+		return 0, err
+	}
+	return offset + n, nil
+}
+
+// Returns the hex SHA-256 of an upload's partial file
+func (u *uploadSessions) digest(id string) (string, error) {
+	f, err := os.Open(u.partPath(id))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Forgets an upload. Its description goes first, so a crash in between leaves
+// a stray partial file rather than an upload with its data missing.
+func (u *uploadSessions) remove(id string) error {
+	if err := os.Remove(u.infoPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(u.partPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Removes the uploads nobody has written to for ttl, and the files of creates,
+// removes and chunks a crash cut short
+func (u *uploadSessions) expire(ttl time.Duration) {
+	entries, err := os.ReadDir(u.dir)
+	if err != nil {
+		slog.Error("Failed to list resumable uploads", "err", err)
+		return
+	}
+	for _, entry := range entries {
+		id, ext, _ := strings.Cut(entry.Name(), ".")
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < ttl || !u.acquire(id) {
+			continue
+		}
+		switch ext {
+		case "part":
+			if err := u.remove(id); err != nil {
+				slog.Warn("Failed to remove expired upload", "upload_id", id, "err", err)
+			} else {
+				slog.Info("Removed expired upload", "upload_id", id)
+			}
+		case "json.tmp", "chunk":
+			os.Remove(filepath.Join(u.dir, entry.Name()))
+		}
+		u.release(id)
+	}
+}
+
+// apiUpload is the JSON form of a resumable upload
+type apiUpload struct {
+	Id        string    `json:"id"`
+	VideoId   string    `json:"video_id"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"` // bytes received so far, where the next chunk goes
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (s *server) newAPIUpload(sess *uploadSession, offset int64, lastWrite time.Time) apiUpload {
+	return apiUpload{
+		Id:        sess.Id,
+		VideoId:   sess.VideoId,
+		Filename:  sess.Filename,
+		Size:      sess.Size,
+		Offset:    offset,
+		ExpiresAt: lastWrite.Add(s.UploadSessionTTL),
+	}
+}
+
+// Returns the upload with the ID in the path if it is v's, or writes an error.
+// Other users' uploads are not found.
+func (s *server) readUpload(w http.ResponseWriter, r *http.Request, v viewer) (*uploadSession, os.FileInfo, bool) {
+	sess, part, err := s.uploadSessions.read(r.PathValue("uploadId"))
+	if errors.Is(err, os.ErrNotExist) || err == nil && sess.OwnerId != v.user.Id {
+		writeAPIError(w, http.StatusNotFound, "Upload not found")
+		return nil, nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to read upload", "upload_id", r.PathValue("uploadId"), "err", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to read upload")
+		return nil, nil, false
+	}
+	return sess, part, true
+}
+
+// Claims the upload with the ID in the path for this request and returns it
+// like readUpload. The caller releases it if this succeeds.
+func (s *server) claimUpload(w http.ResponseWriter, r *http.Request, v viewer) (*uploadSession, os.FileInfo, bool) {
+	id := r.PathValue("uploadId")
+	if !s.uploadSessions.acquire(id) {
+		writeAPIError(w, http.StatusConflict, "Another request is using this upload")
+		return nil, nil, false
+	}
+	sess, part, ok := s.readUpload(w, r, v)
+	if !ok {
+		s.uploadSessions.release(id)
+	}
+	return sess, part, ok
+}
+
+// POST /api/v1/uploads with {"size": ..., "filename": ..., "video_id": ...,
+// "title": ..., "description": ..., "visibility": ...}; only size is required.
+// Answers 201 with the upload.
+func (s *server) apiCreateUpload(w http.ResponseWriter, r *http.Request) {
+	v := s.apiViewer(r)
+	if !v.loggedIn() {
+		writeAPIError(w, http.StatusUnauthorized, "Log in to upload")
+		return
+	}
+	var req struct {
+		Size        int64  `json:"size"`
+		Filename    string `json:"filename"`
+		VideoId     string `json:"video_id"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Visibility  string `json:"visibility"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormFieldSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	if req.Size <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "size must be positive")
+		return
+	}
+	if req.Size > s.MaxUploadSize {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "Upload too large")
+		return
+	}
+	if req.VideoId == "" {
+		req.VideoId = newID()
+	} else if err := s.checkVideoIDFree(req.VideoId); err != nil {
+		writeAPIStatusError(w, err)
+		return
+	}
+	filename := filepath.Base(req.Filename)
+	if filename == "." || filename == "/" {
+		filename = req.VideoId + ".mp4"
+	}
+	// Bad details are turned away now rather than after the whole file
+	u := upload{videoID: req.VideoId, filename: filename, title: req.Title, description: req.Description, visibility: req.Visibility}
+	if _, _, _, err := u.details(); err != nil {
+		writeAPIStatusError(w, err)
+		return
+	}
+
+	sess := uploadSession{
+		Id:          newID(),
+		VideoId:     req.VideoId,
+		OwnerId:     v.user.Id,
+		Filename:    filename,
+		Size:        req.Size,
+		Title:       req.Title,
+		Description: req.Description,
+		Visibility:  req.Visibility,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.uploadSessions.create(sess); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create upload", "err", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to create upload")
+		return
+	}
+	w.Header().Set("Location", "/api/v1/uploads/"+url.PathEscape(sess.Id))
+	writeJSON(w, http.StatusCreated, s.newAPIUpload(&sess, 0, sess.CreatedAt))
+}
+
+// GET /api/v1/uploads/{uploadId}
+func (s *server) apiGetUpload(w http.ResponseWriter, r *http.Request) {
+	v := s.apiViewer(r)
+	if !v.loggedIn() {
+		writeAPIError(w, http.StatusUnauthorized, "Log in to upload")
+		return
+	}
+	sess, part, ok := s.readUpload(w, r, v)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.newAPIUpload(sess, part.Size(), part.ModTime()))
+}
+
+// PUT /api/v1/uploads/{uploadId}?offset=N&sha256= writes the body at offset N,
+// which may not be past what has arrived. sha256, if given, is checked against
+// the body. Answers with the upload.
+func (s *server) apiPutUploadChunk(w http.ResponseWriter, r *http.Request) {
+	v := s.apiViewer(r)
+	if !v.loggedIn() {
+		writeAPIError(w, http.StatusUnauthorized, "Log in to upload")
+		return
+	}
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeAPIError(w, http.StatusBadRequest, "offset must be a byte offset")
+		return
+	}
+	sess, part, ok := s.claimUpload(w, r, v)
+	if !ok {
+		return
+	}
+	defer s.uploadSessions.release(sess.Id)
+	if offset > part.Size() {
+		writeAPIError(w, http.StatusConflict, fmt.Sprintf("Chunk starts past the end of the upload, which has %d bytes", part.Size()))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, sess.Size-offset)
+	size, err := s.uploadSessions.writeChunk(sess.Id, offset, r.Body, r.URL.Query().Get("sha256"))
+	var se *statusError
+	switch {
+	case isTooLarge(err):
+		writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Chunk goes past the upload's size of %d bytes", sess.Size))
+	case errors.As(err, &se):
+		writeAPIStatusError(w, err)
+	case err != nil:
+		slog.WarnContext(r.Context(), "Failed to write chunk", "upload_id", sess.Id, "offset", offset, "err", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to write chunk")
+	default:
+		writeJSON(w, http.StatusOK, s.newAPIUpload(sess, size, time.Now()))
+	}
+}
+
+// POST /api/v1/uploads/{uploadId}/complete with {"sha256": ..., "on_duplicate":
+// ...} queues an upload that has fully arrived for transcoding, once its
+// digest matches. Answers like receiveAPIUpload. An upload that is turned
+// away, for a mismatch or anything else, is kept, so the bad part can be sent
+// again or completing retried with different options.
+func (s *server) apiCompleteUpload(w http.ResponseWriter, r *http.Request) {
+	v := s.apiViewer(r)
+	if !v.loggedIn() {
+		writeAPIError(w, http.StatusUnauthorized, "Log in to upload")
+		return
+	}
+	var req struct {
+		SHA256      string          `json:"sha256"`
+		OnDuplicate duplicatePolicy `json:"on_duplicate"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormFieldSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	if _, err := hex.DecodeString(req.SHA256); err != nil || len(req.SHA256) != 2*sha256.Size {
+		writeAPIError(w, http.StatusBadRequest, "sha256 must be the hex SHA-256 of the file")
+		return
+	}
+	sess, part, ok := s.claimUpload(w, r, v)
+	if !ok {
+		return
+	}
+	defer s.uploadSessions.release(sess.Id)
+	if part.Size() != sess.Size {
+		writeAPIError(w, http.StatusConflict, fmt.Sprintf("Upload is incomplete, %d of %d bytes have arrived", part.Size(), sess.Size))
+		return
+	}
+	sum, err := s.uploadSessions.digest(sess.Id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Failed to read upload")
+		return
+	}
+
+	u := upload{
+		videoID:     sess.VideoId,
+		jobID:       newID(),
+		filename:    sess.Filename,
+		owner:       v.user,
+		title:       sess.Title,
+		description: sess.Description,
+		visibility:  sess.Visibility,
+		sha256:      sum,
+		checksum:    req.SHA256,
+		onDuplicate: req.OnDuplicate,
+	}
+	// A second link to the file, so the upload keeps it if queueUpload turns
+	// it away
+	path := s.transcodeQueue.spoolPath(u.jobID, u.filename)
+	if err := os.Link(s.uploadSessions.partPath(sess.Id), path); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to spool upload", "upload_id", sess.Id, "err", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to queue video for converting")
+		return
+	}
+	job, err := s.queueUpload(u, path)
+	if err != nil {
+		writeAPIStatusError(w, err)
+		return
+	}
+	if err := s.uploadSessions.remove(sess.Id); err != nil {
+		slog.WarnContext(r.Context(), "Failed to remove completed upload", "upload_id", sess.Id, "err", err)
+	}
+	slog.InfoContext(r.Context(), "Completed resumable upload", "upload_id", sess.Id, "job_id", job.Id, "video_id", job.VideoId)
+	writeQueuedJob(w, u, job)
+}
+
+// DELETE /api/v1/uploads/{uploadId}
+func (s *server) apiDeleteUpload(w http.ResponseWriter, r *http.Request) {
+	v := s.apiViewer(r)
+	if !v.loggedIn() {
+		writeAPIError(w, http.StatusUnauthorized, "Log in to upload")
+		return
+	}
+	sess, _, ok := s.claimUpload(w, r, v)
+	if !ok {
+		return
+	}
+	defer s.uploadSessions.release(sess.Id)
+	if err := s.uploadSessions.remove(sess.Id); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Failed to remove upload")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,206 @@
+// Consistent hashing ring shared by the network content service and its migrations
+
+package web
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"tritontube/internal/contentkey"
+)
+
+// hashRing maps keys to storage node addresses. Each node takes as many points
+// (virtual nodes) on the ring as its weight, so it owns a share of the keys
+// proportional to it. It is not safe for concurrent mutation;
+// NetworkVideoContentService swaps whole rings under its lock instead.
+type hashRing struct {
+	nodeHashes     []uint64
+	nodeHashToAddr map[uint64]string
+	weights        map[string]int // vnode count by address
+}
+
+// Builds a ring of addrs; nodes missing from weights get a weight of 1
+func newHashRing(addrs []string, weights map[string]int) *hashRing {
+	r := &hashRing{nodeHashToAddr: make(map[uint64]string), weights: make(map[string]int)}
+	for _, addr := range addrs {
+		r.add(addr, weights[addr])
+	}
+	return r
+}
+
+// Returns the ring position of a node's i-th vnode. The first one hashes the
+// bare address, so a ring of weight 1 nodes places keys exactly as rings did
+// before nodes were weighted.
+func vnodeHash(addr string, i int) uint64 {
+	if i == 0 {
+		return contentkey.Hash(addr)
+	}
+	return contentkey.Hash(fmt.Sprintf("%s#%d", addr, i))
+}
+
+// Returns a deep copy so that a migration can keep the previous ring around
+func (r *hashRing) clone() *hashRing {
+	c := &hashRing{
+		nodeHashes:     slices.Clone(r.nodeHashes),
+		nodeHashToAddr: make(map[uint64]string, len(r.nodeHashToAddr)),
+		weights:        make(map[string]int, len(r.weights)),
+	}
+	for h, addr := range r.nodeHashToAddr {
+		c.nodeHashToAddr[h] = addr
+	}
+	for addr, w := range r.weights {
+		c.weights[addr] = w
+	}
+	return c
+}
+
+// Adds a node with weight vnodes, at least one
+func (r *hashRing) add(addr string, weight int) {
+	if _, ok := r.weights[addr]; ok {
+		return
+	}
+	weight = max(weight, 1)
+	r.weights[addr] = weight
+	for i := 0; i < weight; i++ {
+		hash := vnodeHash(addr, i)
+		// Two vnodes colliding is astronomically unlikely; the first one keeps the point
+		if _, ok := r.nodeHashToAddr[hash]; ok {
+			continue
+		}
+		r.nodeHashToAddr[hash] = addr
+		r.nodeHashes = append(r.nodeHashes, hash)
+	}
+	slices.Sort(r.nodeHashes)
+}
+
+func (r *hashRing) remove(addr string) {
+	weight, ok := r.weights[addr]
+	if !ok {
+		return
+	}
+	delete(r.weights, addr)
+	for i := 0; i < weight; i++ {
+		hash := vnodeHash(addr, i)
+		if r.nodeHashToAddr[hash] != addr {
+			continue
+		}
+		delete(r.nodeHashToAddr, hash)
+		if j, ok := slices.BinarySearch(r.nodeHashes, hash); ok {
+			r.nodeHashes = slices.Delete(r.nodeHashes, j, j+1)
+		}
+	}
+}
+
+func (r *hashRing) contains(addr string) bool {
+	_, ok := r.weights[addr]
+	return ok
+}
+
+// Returns the number of nodes, not vnodes
+func (r *hashRing) size() int {
+	return len(r.weights)
+}
+
+// Returns the number of vnodes addr has, 0 if it is not in the ring
+func (r *hashRing) weight(addr string) int {
+	return r.weights[addr]
+}
+
+// Returns the vnode counts by address
+func (r *hashRing) weightMap() map[string]int {
+	weights := make(map[string]int, len(r.weights))
+	for addr, w := range r.weights {
+		weights[addr] = w
+	}
+	return weights
+}
+
+// Returns the node addresses in the ring order of their first vnode
+func (r *hashRing) nodes() []string {
+	addrs := make([]string, 0, len(r.weights))
+	for addr := range r.weights {
+		addrs = append(addrs, addr)
+	}
+	slices.SortFunc(addrs, func(a, b string) int {
+		return cmp.Compare(vnodeHash(a, 0), vnodeHash(b, 0))
+	})
+	return addrs
+}
+
+// Returns the storage node responsible for the given key, or "" for an empty ring
+func (r *hashRing) lookup(key string) string {
+	owners := r.owners(key, 1)
+	if len(owners) == 0 {
+		return ""
+	}
+	return owners[0]
+}
+
+// Returns up to n distinct nodes responsible for the given key: the node the key
+// hashes to followed by its successors on the ring, which hold the replicas.
+func (r *hashRing) owners(key string, n int) []string {
+	if len(r.nodeHashes) == 0 || n <= 0 {
+		return nil
+	}
+	keyHash := contentkey.Hash(key)
+	// if larger than all nodes, start at the smallest node since its a ring
+	start, _ := slices.BinarySearch(r.nodeHashes, keyHash)
+	return r.ownersFrom(start, n)
+}
+
+// Returns up to n distinct nodes walking the ring from the vnode at index start
+func (r *hashRing) ownersFrom(start int, n int) []string {
+	var owners []string
+	for i := 0; i < len(r.nodeHashes) && len(owners) < n; i++ {
+		addr := r.nodeHashToAddr[r.nodeHashes[(start+i)%len(r.nodeHashes)]]
+		if !slices.Contains(owners, addr) {
+			owners = append(owners, addr)
+		}
+	}
+	return owners
+}
+
+// ringRange is the part of the ring between two neighbouring vnodes. Keys
+// hashing into (start, end] belong to owners, see contentkey.InRange.
+type ringRange struct {
+	start, end uint64
+	owners     []string
+}
+
+// Returns the ranges between vnodes with the n nodes owning each, in ring
+// order. A ring with one vnode has one range covering all of it.
+func (r *hashRing) ranges(n int) []ringRange {
+	ranges := make([]ringRange, 0, len(r.nodeHashes))
+	for i, end := range r.nodeHashes {
+		start := r.nodeHashes[(i+len(r.nodeHashes)-1)%len(r.nodeHashes)]
+		ranges = append(ranges, ringRange{start: start, end: end, owners: r.ownersFrom(i, n)})
+	}
+	return ranges
+}
+
+// Returns the fraction of the ring each node holds one of n copies of, which
+// is the share of all keys it should hold
+func (r *hashRing) shares(n int) map[string]float64 {
+	shares := make(map[string]float64, len(r.weights))
+	for _, rr := range r.ranges(n) {
+		// Unsigned subtraction wraps around the end of the ring
+		length := float64(rr.end-rr.start) / (1 << 64)
+		if rr.start == rr.end {
+			length = 1
+		}
+		for _, addr := range rr.owners {
+			shares[addr] += length
+		}
+	}
+	return shares
+}
+
+// Appends the addresses that are not in addrs yet, preserving order
+func appendUnique(addrs []string, more ...string) []string {
+	for _, addr := range more {
+		if !slices.Contains(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
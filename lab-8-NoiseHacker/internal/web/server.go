@@ -3,73 +3,269 @@
 package web
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
-	"log"
+	"log/slog"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
+	"tritontube/internal/contentkey"
+	"tritontube/internal/telemetry"
 )
 
 type server struct {
 	Addr string
 	Port int
 
+	// MaxUploadSize caps the request body of /upload in bytes
+	MaxUploadSize int64
+	// SpoolDir holds uploads until their transcode job has run
+	SpoolDir string
+	// TranscodeWorkers is the number of uploads transcoded concurrently
+	TranscodeWorkers int
+	// Ladder lists the renditions every upload is encoded into
+	Ladder Ladder
+	// HLS also produces HLS playlists for new uploads, for players without MSE
+	HLS bool
+	// GCInterval is how often deletes that failed midway are retried
+	GCInterval time.Duration
+	// ContentSigningKey signs the content URLs of private videos
+	ContentSigningKey []byte
+	// ContentURLTTL is how long a signed content URL stays valid
+	ContentURLTTL time.Duration
+	// MaxLiveStreams is the number of live streams ingested at once
+	MaxLiveStreams int
+	// UploadSessionTTL is how long a resumable upload nobody writes to is kept
+	UploadSessionTTL time.Duration
+	// FirstUserAdmin makes the first account to sign up an admin. Otherwise
+	// admins are made with SetAdmin, see the web server's -admin flag.
+	FirstUserAdmin bool
+
 	metadataService VideoMetadataService
 	contentService  VideoContentService
+	transcodeQueue  *transcodeQueue
+	liveSlots       chan struct{} // holds a token per live stream being ingested
+	uploadSessions  *uploadSessions
 
 	mux *http.ServeMux
 }
 
+type JobInfo struct {
+	Id         string
+	VideoId    string
+	Title      string
+	Status     string
+	Error      string
+	Pending    bool   // still queued or running, the page refreshes itself
+	UploadTime string // human-readable upload time
+}
+
+type IndexInfo struct {
+	Videos  []VideoInfo
+	Query   string // the search box contents
+	NextURL string // "" on the last page
+	PrevURL string // "" on the first page
+
+	Username  string // "" when logged out
+	CSRFToken string
+}
+
 type VideoInfo struct {
 	Id        string // original video ID
 	EscapedId string // path-save video ID
 	UploadTime  string // human-readable upload time
+	HasHLS    bool   // HLS playlists were produced for this video
+	Live      bool   // still being broadcast
+
+	Title       string
+	Description string
+	Uploader    string
+	Duration    string // e.g. "3:07", "" if unknown
+	Resolution  string // e.g. "1280x720", "" if unknown
+	Size        string // e.g. "12.3 MB"
+	Thumbnail   string // URL of the thumbnail, "" if there is none
+
+	ContentBase string // URL prefix of the video's files, signed for private videos
+	Visibility  string
+	CanModify   bool   // the viewer may edit and delete the video
+	CSRFToken   string
 }
 
+// Builds the template data for a video
+func (s *server) newVideoInfo(v VideoMetadata) VideoInfo {
+	info := VideoInfo{
+		Id:          v.Id,
+		EscapedId:   url.PathEscape(v.Id),
+		UploadTime:  v.UploadedAt.Format("2006-01-02 15:04:05"),
+		Title:       v.Title,
+		Description: v.Description,
+		Uploader:    v.Uploader,
+		ContentBase: s.contentBase(v),
+		Visibility:  string(v.Visibility),
+		Live:        v.Live,
+	}
+	if info.Title == "" {
+		// Videos from before titles existed
+		info.Title = v.Id
+	}
+	if v.Duration > 0 {
+		info.Duration = formatDuration(v.Duration)
+	}
+	if v.Size > 0 {
+		info.Size = formatSize(v.Size)
+	}
+	if v.Width > 0 && v.Height > 0 {
+		info.Resolution = fmt.Sprintf("%dx%d", v.Width, v.Height)
+	}
+	if v.Thumbnail != "" {
+		info.Thumbnail = info.ContentBase + "/" + url.PathEscape(v.Thumbnail)
+	}
+	return info
+}
+
+// Formats a duration as m:ss or h:mm:ss
+func formatDuration(d time.Duration) string {
+	secs := int(d.Round(time.Second).Seconds())
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+	}
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
+
+// Formats a byte count with a binary unit
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Cache-Control values for /content/ responses. A segment's bytes never
+// change, but a video can be made private or deleted, so shared caches only
+// keep segments for a minute before revalidating them by ETag.
+const (
+	segmentCacheControl  = "public, max-age=31536000, s-maxage=60"
+	manifestCacheControl = "public, max-age=10"
+	// Manifests of live streams change with every segment
+	liveManifestCacheControl = "public, no-cache"
+)
+
+// DefaultMaxUploadSize is the upload cap used unless MaxUploadSize is changed
+const DefaultMaxUploadSize = 1 << 30
+
 func NewServer(
 	metadataService VideoMetadataService,
 	contentService VideoContentService,
 ) *server {
+	// A random key invalidates signed URLs on restart; set ContentSigningKey to
+	// keep them, or to share them between web servers
+	key := make([]byte, 32)
+	rand.Read(key)
 	return &server{
+		MaxUploadSize:    DefaultMaxUploadSize,
+		SpoolDir:         filepath.Join(os.TempDir(), "tritontube-spool"),
+		TranscodeWorkers: DefaultTranscodeWorkers,
+		Ladder:           DefaultLadder,
+		GCInterval:       DefaultGCInterval,
+		ContentSigningKey: key,
+		ContentURLTTL:    DefaultContentURLTTL,
+		MaxLiveStreams:   DefaultMaxLiveStreams,
+		UploadSessionTTL: DefaultUploadSessionTTL,
 		metadataService: metadataService,
 		contentService:  contentService,
 	}
 }
 
 func (s *server) Start(lis net.Listener) error {
-	s.mux = http.NewServeMux()
-	s.mux.HandleFunc("/upload", s.handleUpload)
-	s.mux.HandleFunc("/videos/", s.handleVideo)
-	s.mux.HandleFunc("/content/", s.handleVideoContent)
-	s.mux.HandleFunc("/", s.handleIndex)
+	queue, err := newTranscodeQueue(s.metadataService, s.contentService, s.SpoolDir, s.TranscodeWorkers, s.Ladder, s.HLS)
+	if err != nil {
+		return err
+	}
+	if err := queue.start(); err != nil {
+		return err
+	}
+	s.transcodeQueue = queue
+	s.liveSlots = make(chan struct{}, max(s.MaxLiveStreams, 1))
+	// Under the spool dir, so completed uploads can be linked into it
+	s.uploadSessions, err = openUploadSessions(filepath.Join(queue.spoolDir, "uploads"))
+	if err != nil {
+		return err
+	}
+	go s.collectGarbage(s.GCInterval)
 
-	return http.Serve(lis, s.mux)
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("POST /upload", s.handleUpload)
+	s.mux.HandleFunc("GET /jobs/{jobId}", s.handleJob)
+	s.mux.HandleFunc("GET /videos/{videoId}", s.handleVideo)
+	s.mux.HandleFunc("DELETE /videos/{videoId}", s.handleDelete)
+	s.mux.HandleFunc("POST /videos/{videoId}/delete", s.handleDelete)
+	s.mux.HandleFunc("POST /videos/{videoId}/edit", s.handleEdit)
+	s.mux.HandleFunc("GET /content/{videoId}/{filename}", s.handleVideoContent)
+	s.mux.HandleFunc("GET /content/t/{token}/{videoId}/{filename}", s.handleSignedContent)
+	s.mux.HandleFunc("GET /login", s.handleAuthPage)
+	s.mux.HandleFunc("POST /login", s.handleAuth)
+	s.mux.HandleFunc("GET /signup", s.handleAuthPage)
+	s.mux.HandleFunc("POST /signup", s.handleAuth)
+	s.mux.HandleFunc("POST /logout", s.handleLogout)
+	s.mux.HandleFunc("GET /{$}", s.handleIndex)
+	s.mux.Handle("GET /metrics", telemetry.MetricsHandler())
+	s.registerAPI()
+
+	return http.Serve(lis, telemetry.InstrumentHTTP(s.mux))
 }
 
 // Handles the "/" endpoint
 func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
-	videos, err := s.metadataService.List()
+	v := s.currentViewer(r)
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	opts := v.listOptions(ListOptions{Query: query, Limit: DefaultPageSize})
+	if before := r.URL.Query().Get("before"); before != "" {
+		opts.Cursor, opts.Before = before, true
+	} else {
+		opts.Cursor = r.URL.Query().Get("after")
+	}
+
+	page, err := s.metadataService.List(opts)
+	if errors.Is(err, ErrInvalidCursor) {
+		http.Error(w, "Invalid page", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to list videos", http.StatusInternalServerError)
 		return
 	}
 
-	var wrapped []VideoInfo
-	for _, v := range videos {
-		wrapped = append(wrapped, VideoInfo{
-			Id:        v.Id,
-			EscapedId: url.PathEscape(v.Id),
-			UploadTime:  v.UploadedAt.Format("2006-01-02 15:04:05"),
-		})
+	wrapped := IndexInfo{Query: query, CSRFToken: v.csrfToken()}
+	if v.loggedIn() {
+		wrapped.Username = v.user.Username
+	}
+	for _, video := range page.Videos {
+		wrapped.Videos = append(wrapped.Videos, s.newVideoInfo(video))
+	}
+	if page.NextCursor != "" {
+		wrapped.NextURL = indexURL(query, "after", page.NextCursor)
+	}
+	if page.PrevCursor != "" {
+		wrapped.PrevURL = indexURL(query, "before", page.PrevCursor)
 	}
 
 	temp, err := template.New("index").Parse(indexHTML) // indexHTML 是 string
@@ -80,110 +276,336 @@ func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
 
 	err = temp.Execute(w, wrapped)
 	if err != nil {
-		log.Println("Template rendering failed:", err)
+		slog.ErrorContext(r.Context(), "Template rendering failed", "err", err)
 	}
 }
 
-// Handles the "/upload" endpoint
+// Returns the index page URL for a search and a page cursor
+func indexURL(query, direction, cursor string) string {
+	values := url.Values{direction: {cursor}}
+	if query != "" {
+		values.Set("q", query)
+	}
+	return "/?" + values.Encode()
+}
+
+// Handles the "/upload" endpoint. The multipart body is streamed straight to the
+// spool dir instead of being parsed into memory, and is capped at MaxUploadSize.
+// Transcoding happens in the background: the response redirects to the video
+// page, which shows the job's progress, and carries the job ID in X-Job-Id.
+// Only logged in users can upload.
 func (s *server) handleUpload(w http.ResponseWriter, r *http.Request) {
-	// Encode with max 100MB memory
-	err := r.ParseMultipartForm(100 << 20)
+	v := s.currentViewer(r)
+	if !v.loggedIn() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	// IDs are generated so uploads of files with the same name do not collide
+	job, err := s.receiveMultipartUpload(w, r, upload{videoID: newID(), jobID: newID(), owner: v.user, csrfToken: v.csrfToken()})
 	if err != nil {
-		http.Error(w, "Failed to parse multipart form", http.StatusBadRequest)
+		writeStatusError(w, err)
 		return
 	}
 
-	file, header, err := r.FormFile("file")
+	w.Header().Set("X-Job-Id", job.Id)
+	http.Redirect(w, r, "/videos/"+url.PathEscape(job.VideoId), http.StatusSeeOther)
+}
+
+// Spools the "file" part of a multipart upload and queues it, taking the title,
+// description and visibility from the form fields around it. If u.csrfToken is
+// set, the form must carry it in a csrf_token field before the file, so forged
+// uploads are turned away before they are spooled. Errors are *statusErrors.
+func (s *server) receiveMultipartUpload(w http.ResponseWriter, r *http.Request, u upload) (*TranscodeJob, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.MaxUploadSize)
+	reader, err := r.MultipartReader()
 	if err != nil {
-		http.Error(w, "File field does not exist", http.StatusBadRequest)
-		return
+		return nil, &statusError{http.StatusBadRequest, "Failed to parse multipart form"}
+	}
+
+	fields := make(map[string]string)
+	file, err := nextFilePart(reader, "file", fields)
+	if err != nil {
+		if isTooLarge(err) {
+			return nil, &statusError{http.StatusRequestEntityTooLarge, "Upload too large"}
+		}
+		return nil, &statusError{http.StatusBadRequest, "File field does not exist"}
 	}
 	defer file.Close()
+	if u.csrfToken != "" && !tokenMatches(fields["csrf_token"], u.csrfToken) {
+		return nil, &statusError{http.StatusForbidden, "Invalid CSRF token"}
+	}
 
-	filename := header.Filename
-	videoID := strings.TrimSuffix(filename, filepath.Ext(filename))
+	u.filename = filepath.Base(file.FileName())
+	path, sum, err := s.spoolUpload(u, file)
+	if err != nil {
+		return nil, err
+	}
+	u.sha256 = sum
 
-	existing, err := s.metadataService.Read(videoID)
-	if err == nil && existing != nil {
-		http.Error(w, "Video ID already exists", http.StatusConflict)
-		return
+	// Fields may also follow the file
+	if err := readFormFields(reader, fields); err != nil {
+		os.Remove(path)
+		if isTooLarge(err) {
+			return nil, &statusError{http.StatusRequestEntityTooLarge, "Upload too large"}
+		}
+		return nil, &statusError{http.StatusBadRequest, "Failed to parse multipart form"}
 	}
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		http.Error(w, "Failed to check existing video", http.StatusInternalServerError)
+	u.title, u.description, u.visibility = fields["title"], fields["description"], fields["visibility"]
+	u.checksum, u.onDuplicate = fields["sha256"], duplicatePolicy(fields["on_duplicate"])
+
+	return s.queueUpload(u, path)
+}
+
+// statusError is an error with the HTTP status it should be answered with
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+// Answers with the status of a *statusError, or 500
+func writeStatusError(w http.ResponseWriter, err error) {
+	var se *statusError
+	if errors.As(err, &se) {
+		http.Error(w, se.msg, se.status)
 		return
 	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// upload is a video being uploaded through the site or the API
+type upload struct {
+	videoID  string
+	jobID    string
+	filename string // as given by the client, for the default title and the extension
 
-	tempDir, err := os.MkdirTemp("", "upload-*")
+	owner     *User
+	csrfToken string // expected in the form, see receiveMultipartUpload
+
+	title       string
+	description string
+	visibility  string // "" for public
+
+	sha256      string          // hex digest of the spooled file
+	checksum    string          // digest the client expects, "" if it gave none
+	onDuplicate duplicatePolicy // for a file the owner has uploaded before
+}
+
+// duplicatePolicy is what queueUpload does with a file identical to one of the
+// owner's videos or pending uploads
+type duplicatePolicy string
+
+const (
+	duplicateAllow  duplicatePolicy = "allow"  // upload it again anyway, the default
+	duplicateReject duplicatePolicy = "reject" // refuse it with 409
+	duplicateLink   duplicatePolicy = "link"   // skip it and return the existing upload's job
+)
+
+// Copies an uploaded file into the spool dir and returns its path and hex
+// SHA-256. Returns a *statusError if the upload exceeds MaxUploadSize or fails
+// to save.
+func (s *server) spoolUpload(u upload, file io.Reader) (string, string, error) {
+	path := s.transcodeQueue.spoolPath(u.jobID, u.filename)
+	dst, err := os.Create(path)
 	if err != nil {
-		http.Error(w, "Failed to create temp dir", http.StatusInternalServerError)
-		return
+		return "", "", &statusError{http.StatusInternalServerError, "Failed to create temp file"}
 	}
-
-	dstPath := filepath.Join(tempDir, filename)
-	dstFile, err := os.Create(dstPath)
-	// fmt.Println("Full path" + dstPath)
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hash), file)
+	dst.Close()
 	if err != nil {
-		http.Error(w, "Failed to create temp file", http.StatusInternalServerError)
-		return
+		os.Remove(path)
+		if isTooLarge(err) {
+			return "", "", &statusError{http.StatusRequestEntityTooLarge, "Upload too large"}
+		}
+		return "", "", &statusError{http.StatusInternalServerError, "Failed to save uploaded file"}
 	}
-	defer dstFile.Close()
+	return path, hex.EncodeToString(hash.Sum(nil)), nil
+}
 
-	_, err = io.Copy(dstFile, file)
-	if err != nil {
-		http.Error(w, "Failed to save uploaded file", http.StatusInternalServerError)
-		return
+// Returns the title, description and visibility of an upload, with the
+// defaults filled in. Errors are *statusErrors.
+func (u upload) details() (string, string, Visibility, error) {
+	title := strings.TrimSpace(u.title)
+	if title == "" {
+		title = strings.TrimSuffix(u.filename, filepath.Ext(u.filename))
+	}
+	description := strings.TrimSpace(u.description)
+	if len(title) > maxTitleLength || len(description) > maxDescriptionLength {
+		return "", "", "", &statusError{http.StatusBadRequest, "Title or description is too long"}
+	}
+	visibility := Visibility(u.visibility)
+	if visibility == "" {
+		visibility = Public
 	}
+	if !visibility.Valid() {
+		return "", "", "", &statusError{http.StatusBadRequest, "Visibility must be public, unlisted or private"}
+	}
+	return title, description, visibility, nil
+}
 
-	err = runFFmpeg(dstPath, tempDir)
+// Validates an upload's details and checksum and queues its spooled file for
+// transcoding. A file the owner has uploaded before is handled by
+// u.onDuplicate; when it is linked, the job returned is the earlier upload's
+// rather than a new one with u.jobID. The spooled file is removed unless it was
+// queued.
+func (s *server) queueUpload(u upload, path string) (*TranscodeJob, error) {
+	title, description, visibility, err := u.details()
 	if err != nil {
-		http.Error(w, "Video converting failed", http.StatusInternalServerError)
-		return
+		os.Remove(path)
+		return nil, err
+	}
+	if u.checksum != "" && !strings.EqualFold(u.checksum, u.sha256) {
+		os.Remove(path)
+		return nil, &statusError{http.StatusUnprocessableEntity, "Checksum mismatch: the uploaded file has SHA-256 " + u.sha256}
+	}
+	enqueue := s.transcodeQueue.enqueue
+	switch u.onDuplicate {
+	case "", duplicateAllow:
+	case duplicateReject, duplicateLink:
+		// Only the owner's own uploads count, so nothing is given away about
+		// anyone else's
+		enqueue = s.transcodeQueue.enqueueUnique
+	default:
+		os.Remove(path)
+		return nil, &statusError{http.StatusBadRequest, "on_duplicate must be allow, reject or link"}
 	}
 
-	err = StoreInContentService(s.contentService, videoID, tempDir)
+	job, err := enqueue(TranscodeJob{
+		Id:          u.jobID,
+		VideoId:     u.videoID,
+		SourcePath:  path,
+		Title:       title,
+		Description: description,
+		Uploader:    u.owner.Username,
+		OwnerId:     u.owner.Id,
+		Visibility:  visibility,
+		SHA256:      u.sha256,
+	})
+	if errors.Is(err, ErrVideoIDTaken) {
+		os.Remove(path)
+		return nil, &statusError{http.StatusConflict, "Video ID already exists"}
+	}
 	if err != nil {
-		http.Error(w, "Failed to save video content", http.StatusInternalServerError)
-		return
+		os.Remove(path)
+		return nil, &statusError{http.StatusInternalServerError, "Failed to queue video for converting"}
+	}
+	if job.Id != u.jobID {
+		os.Remove(path)
+		if u.onDuplicate == duplicateReject {
+			return nil, &statusError{http.StatusConflict, "Identical to the upload of video " + job.VideoId}
+		}
+	}
+	return job, nil
+}
+
+// Returns a *statusError if a video or an upload, finished or not, already uses
+// videoID. IDs of deleted videos stay taken, since their jobs are kept.
+func (s *server) checkVideoIDFree(videoID string) error {
+	if err := contentkey.ValidateComponent(videoID); err != nil {
+		return &statusError{http.StatusBadRequest, "Invalid video ID: " + err.Error()}
+	}
+	_, err := s.metadataService.Read(videoID)
+	if err == nil {
+		return &statusError{http.StatusConflict, "Video ID already exists"}
 	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return &statusError{http.StatusInternalServerError, "Failed to check existing video"}
+	}
+	_, err = s.metadataService.ReadJobByVideo(videoID)
+	if err == nil {
+		return &statusError{http.StatusConflict, "Video ID already exists"}
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return &statusError{http.StatusInternalServerError, "Failed to check existing video"}
+	}
+	return nil
+}
+
+// Limits on the descriptive fields of an upload, in bytes
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 5000
+	maxFormFieldSize     = 64 << 10
+)
 
-	// data, err := os.ReadFile(dstPath)
-	// if err != nil {
-	// 	http.Error(w, "Failed to read temp file", http.StatusInternalServerError)
-	// 	return
-	// }
+// Skips to the file part with the given name, collecting the form fields before
+// it into fields
+func nextFilePart(reader *multipart.Reader, name string, fields map[string]string) (*multipart.Part, error) {
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == name && part.FileName() != "" {
+			return part, nil
+		}
+		if err := readFormField(part, fields); err != nil {
+			return nil, err
+		}
+	}
+}
 
-	// err = s.contentService.Write(videoID, filename, data)
-	// if err != nil {
-	// 	http.Error(w, "Failed to write content", http.StatusInternalServerError)
-	// 	return
-	// }
+// Collects the remaining form fields into fields
+func readFormFields(reader *multipart.Reader, fields map[string]string) error {
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := readFormField(part, fields); err != nil {
+			return err
+		}
+	}
+}
 
-	err = s.metadataService.Create(videoID, time.Now())
+func readFormField(part *multipart.Part, fields map[string]string) error {
+	defer part.Close()
+	if part.FileName() != "" {
+		return nil
+	}
+	value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
 	if err != nil {
-		http.Error(w, "Failed to save metadata", http.StatusInternalServerError)
-		return
+		return err
+	}
+	if len(value) > maxFormFieldSize {
+		return fmt.Errorf("form field %s is too large", part.FormName())
 	}
+	fields[part.FormName()] = string(value)
+	return nil
+}
 
-	// Redirect to "/"
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+// Reports whether err was caused by exceeding http.MaxBytesReader's limit
+func isTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
 }
 
 // Handles the "/videos/:videoId" endpoint
 func (s *server) handleVideo(w http.ResponseWriter, r *http.Request) {
-	videoId := r.URL.Path[len("/videos/"):]
-	log.Println("Video ID:", videoId)
+	v := s.currentViewer(r)
+	videoId := r.PathValue("videoId")
 	// Lookup metadata
 	meta, err := s.metadataService.Read(videoId)
 	if err != nil || meta == nil {
+		s.renderProcessing(w, v, videoId)
+		return
+	}
+	// Private videos 404 rather than 403, so their IDs are not confirmed
+	if !v.canView(meta.OwnerId, meta.Visibility) {
 		http.Error(w, "Video not found", http.StatusNotFound)
 		return
 	}
 
-	info := VideoInfo{
-		Id:        meta.Id,
-		EscapedId: url.PathEscape(meta.Id),
-		UploadTime:  meta.UploadedAt.Format("2006-01-02 15:04:05"),
-	}
+	info := s.newVideoInfo(*meta)
+	info.CanModify = v.canModify(meta.OwnerId)
+	info.CSRFToken = v.csrfToken()
+	// Videos uploaded while HLS was off only have the DASH manifest
+	info.HasHLS = meta.HLS
 
 	temp, err := template.New("video").Parse(videoHTML)
 	if err != nil {
@@ -193,94 +615,259 @@ func (s *server) handleVideo(w http.ResponseWriter, r *http.Request) {
 	temp.Execute(w, info)	
 }
 
-// Handles the "/content/:videoId/:filename" endpoint
+// Returns a video if the viewer may edit or delete it. Errors are
+// *statusErrors: 404 for videos the viewer cannot see, 403 for ones they can see
+// but not change.
+func (s *server) videoToModify(v viewer, videoId string) (*VideoMetadata, error) {
+	meta, err := s.metadataService.Read(videoId)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !v.canView(meta.OwnerId, meta.Visibility)) {
+		return nil, &statusError{http.StatusNotFound, "Video not found"}
+	}
+	if err != nil {
+		return nil, &statusError{http.StatusInternalServerError, "Failed to read video"}
+	}
+	if !v.canModify(meta.OwnerId) {
+		return nil, &statusError{http.StatusForbidden, "Only the uploader can change this video"}
+	}
+	return meta, nil
+}
+
+// Deletes a video. The delete form is redirected to the index, and DELETE
+// requests get 202 since the content is removed in the background.
+func (s *server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	v := s.currentViewer(r)
+	videoId := r.PathValue("videoId")
+	if _, err := s.videoToModify(v, videoId); err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	// Browsers only send DELETE cross-site after a CORS preflight, which is
+	// never granted, but forms can be posted from anywhere
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormFieldSize)
+	if r.Method == http.MethodPost && !v.checkCSRF(r.PostFormValue("csrf_token")) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	err := s.deleteVideo(r.Context(), videoId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Video not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete video", http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Updates a video's title, description and visibility from the edit form
+func (s *server) handleEdit(w http.ResponseWriter, r *http.Request) {
+	v := s.currentViewer(r)
+	videoId := r.PathValue("videoId")
+	if _, err := s.videoToModify(v, videoId); err != nil {
+		writeStatusError(w, err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormFieldSize)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	if !v.checkCSRF(r.PostForm.Get("csrf_token")) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	title := strings.TrimSpace(r.PostForm.Get("title"))
+	description := strings.TrimSpace(r.PostForm.Get("description"))
+	if title == "" || len(title) > maxTitleLength || len(description) > maxDescriptionLength {
+		http.Error(w, "Title is missing, or title or description is too long", http.StatusBadRequest)
+		return
+	}
+	visibility := Visibility(r.PostForm.Get("visibility"))
+	if !visibility.Valid() {
+		http.Error(w, "Visibility must be public, unlisted or private", http.StatusBadRequest)
+		return
+	}
+
+	err := s.metadataService.Update(videoId, title, description, visibility)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Video not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update video", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/videos/"+url.PathEscape(videoId), http.StatusSeeOther)
+}
+
+// Shows the transcode status of a video that is not ready yet, or 404s if there
+// is no such upload either
+func (s *server) renderProcessing(w http.ResponseWriter, v viewer, videoId string) {
+	job, err := s.metadataService.ReadJobByVideo(videoId)
+	if err != nil || job.Status == JobReady || !v.canView(job.OwnerId, job.Visibility) {
+		http.Error(w, "Video not found", http.StatusNotFound)
+		return
+	}
+
+	info := JobInfo{
+		Id:         job.Id,
+		VideoId:    job.VideoId,
+		Title:      job.Title,
+		Status:     string(job.Status),
+		Error:      job.Error,
+		Pending:    job.Status == JobQueued || job.Status == JobRunning,
+		UploadTime: job.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	temp, err := template.New("processing").Parse(processingHTML)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	temp.Execute(w, info)
+}
+
+// Handles the "/jobs/:jobId" endpoint, reporting a transcode job as JSON
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	jobId := r.PathValue("jobId")
+	job, err := s.metadataService.ReadJob(jobId)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !s.currentViewer(r).canView(job.OwnerId, job.Visibility)) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read job", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIJob(*job))
+}
+
+// Handles the "/content/:videoId/:filename" endpoint. Private videos are only
+// served through signed URLs, see handleSignedContent. Content is served once
+// its video's metadata exists, so not while processing or after a delete.
 func (s *server) handleVideoContent(w http.ResponseWriter, r *http.Request) {
-	// parse /content/<videoId>/<filename>
-	videoId := r.URL.Path[len("/content/"):]
-	parts := strings.Split(videoId, "/")
-	if len(parts) != 2 {
-		http.Error(w, "Invalid content path", http.StatusBadRequest)
+	meta, err := s.metadataService.Read(r.PathValue("videoId"))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && meta.Visibility == Private) {
+		http.Error(w, "Content not found", http.StatusNotFound)
 		return
 	}
-	videoId = parts[0]
-	filename := parts[1]
-	// log.Println("Video ID:", videoId, "Filename:", filename)
+	if err != nil {
+		http.Error(w, "Failed to read content", http.StatusInternalServerError)
+		return
+	}
+	s.serveContent(w, r, meta, r.PathValue("filename"))
+}
 
-	data, err := s.contentService.Read(videoId, filename)
+// Handles the "/content/t/:token/:videoId/:filename" endpoint, see signContent
+func (s *server) handleSignedContent(w http.ResponseWriter, r *http.Request) {
+	videoId := r.PathValue("videoId")
+	if !s.verifyContentToken(r.PathValue("token"), videoId) {
+		http.Error(w, "Invalid or expired content URL", http.StatusForbidden)
+		return
+	}
+	meta, err := s.metadataService.Read(videoId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Content not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to read content", http.StatusInternalServerError)
 		return
 	}
+	s.serveContent(w, r, meta, r.PathValue("filename"))
+}
+
+// Serves one of a video's content files
+func (s *server) serveContent(w http.ResponseWriter, r *http.Request, meta *VideoMetadata, filename string) {
+	isManifest := strings.HasSuffix(filename, ".mpd") || strings.HasSuffix(filename, ".m3u8")
+	content, digest, err := s.openContentFile(r.Context(), meta.Id, filename, isManifest)
+	if err != nil {
+		var keyErr *contentkey.InvalidKeyError
+		switch {
+		case errors.As(err, &keyErr):
+			http.Error(w, "Invalid content path", http.StatusBadRequest)
+		case errors.Is(err, os.ErrNotExist):
+			http.Error(w, "Content not found", http.StatusNotFound)
+		default:
+			http.Error(w, "Failed to read content", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer content.Close()
 
 	if strings.HasSuffix(filename, ".mpd") {
 		w.Header().Set("Content-Type", "application/dash+xml")
+	} else if strings.HasSuffix(filename, ".jpg") {
+		w.Header().Set("Content-Type", "image/jpeg")
+	} else if strings.HasSuffix(filename, ".m3u8") {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
 	} else if strings.HasSuffix(filename, ".m4s"){
 		w.Header().Set("Content-Type", "video/mp4")
 	} else {
 		w.Header().Set("Content-Type", "application/octet-stream")
 	}
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
-
-	w.WriteHeader(http.StatusOK)
-	w.Write(data)
-}
-
-// Converts MP4 to MPEG-DASH format using ffmpeg
-func runFFmpeg(videoPath, tempDir string) error {
-	cmd := exec.Command("ffmpeg",
-		"-i", videoPath,                          // input file
-		"-c:v", "libx264",                        // video codec
-		"-c:a", "aac",                            // audio codec
-		"-bf", "1",                               // max 1 b-frame
-		"-keyint_min", "30",                     // minimum keyframe interval
-		"-g", "30",                              // keyframe every 25 frames
-		"-sc_threshold", "0",                     // scene change threshold
-		"-b:v", "3000k",                          // video bitrate
-		"-b:a", "128k",                           // audio bitrate
-		"-f", "dash",                             // dash format
-		"-use_timeline", "1",                     // use timeline
-		"-use_template", "1",                     // use template
-		"-init_seg_name", "init-$RepresentationID$.m4s",       // init segment naming
-		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s", // media segment naming
-		"-seg_duration", "2",                     // segment duration in seconds
-		"-min_seg_duration", "2000000",				// minimum segment duration in us
-		"manifest.mpd",                             // output file
-	)
-	cmd.Dir = tempDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
-func StoreInContentService(fs VideoContentService, videoID, dir string) error {
-	fmt.Println("Inspecting tempDir:", dir)
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return fmt.Errorf("failed to list dir: %w", err)
-	}
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		name := entry.Name()
 
-		if strings.HasSuffix(name, ".mp4") {
-			continue
-		}
+	// Segments never change once stored; manifests may be rewritten, so caches
+	// only keep them briefly
+	cacheControl := segmentCacheControl
+	if isManifest && meta.Live {
+		cacheControl = liveManifestCacheControl
+	} else if isManifest {
+		cacheControl = manifestCacheControl
+	}
+	// Shared caches like the edge must not keep private content, since they
+	// would serve it without checking the signature
+	if meta.Visibility == Private {
+		cacheControl = strings.Replace(cacheControl, "public", "private", 1)
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", `"`+digest+`"`)
+
+	// ServeContent handles Range (206/416), If-None-Match (304), If-Modified-Since
+	// and HEAD, and sets Accept-Ranges, Last-Modified and Content-Length
+	//
+	// A video's content is complete by the time its metadata is created, so its
+	// upload time is the content's modification time. A live stream's manifest
+	// keeps changing after that, so it is only validated by its ETag.
+	modTime := meta.UploadedAt
+	if isManifest && meta.Live {
+		modTime = time.Time{}
+	}
+	http.ServeContent(w, r, filename, modTime, content)
+}
 
-		path := filepath.Join(dir, name)
-		// Read back the file content for writing to contentService
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("read %s: %w", name, err)
+// Opens a content file for serving, along with the hex SHA-256 of its bytes.
+// Files are streamed from the content service where it can, so a range
+// request only reads its range. Manifests are small and rewritten in place,
+// so they are read whole and hashed, as is anything the service cannot
+// stream or has no digest for.
+func (s *server) openContentFile(ctx context.Context, videoId string, filename string, isManifest bool) (io.ReadSeekCloser, string, error) {
+	if !isManifest {
+		f, err := openContent(ctx, s.contentService, videoId, filename)
+		if err == nil && f.sha256 != "" {
+			return f, f.sha256, nil
 		}
-
-		// Save to content service
-		err = fs.Write(videoID, name, data)
-		if err != nil {
-			return fmt.Errorf("write %s: %w", name, err)
+		if err == nil {
+			f.Close()
+		} else if !errors.Is(err, errors.ErrUnsupported) {
+			return nil, "", err
 		}
 	}
-	// time.Sleep(5 * time.Second)
-	return nil
+	data, err := readContent(ctx, s.contentService, videoId, filename)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	return nopSeekCloser{bytes.NewReader(data)}, hex.EncodeToString(sum[:]), nil
 }
+
+// nopSeekCloser is an io.ReadSeeker with nothing to close
+type nopSeekCloser struct{ io.ReadSeeker }
+
+func (nopSeekCloser) Close() error { return nil }
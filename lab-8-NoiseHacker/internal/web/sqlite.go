@@ -4,82 +4,583 @@ package web
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+
 type SQLiteVideoMetadataService struct{db *sql.DB}
 
 // Uncomment the following line to ensure SQLiteVideoMetadataService implements VideoMetadataService
 var _ VideoMetadataService = (*SQLiteVideoMetadataService)(nil)
 
-// Constructor + init table
+// Constructor + schema migrations
 func NewSQLiteVideoMetadataService(dbPath string) (*SQLiteVideoMetadataService, error) {
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open db: %w", err)
 	}
+	// Transcode workers write concurrently with requests; a single connection
+	// serializes them instead of failing with "database is locked"
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+	return &SQLiteVideoMetadataService{db: db}, nil
+}
 
-	// Create table if not exists
-	schema := `
+// Schema migrations, applied in order. PRAGMA user_version records how many
+// have been applied, so databases created by older versions are upgraded in
+// place when they are opened.
+var migrations = []string{
+	// 1: videos and transcode jobs. IF NOT EXISTS because databases from before
+	// migrations were tracked already have these tables.
+	`
 	CREATE TABLE IF NOT EXISTS videos (
 		id TEXT PRIMARY KEY,
 		uploaded_at DATETIME
-	)`
-	if _, err := db.Exec(schema); err != nil {
-		return nil, fmt.Errorf("failed to create table: %w", err)
+	);
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		source_path TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME,
+		updated_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS jobs_video_id ON jobs (video_id, created_at)`,
+
+	// 2: descriptive metadata, probed properties and thumbnails
+	`
+	ALTER TABLE videos ADD COLUMN title TEXT NOT NULL DEFAULT '';
+	ALTER TABLE videos ADD COLUMN description TEXT NOT NULL DEFAULT '';
+	ALTER TABLE videos ADD COLUMN uploader TEXT NOT NULL DEFAULT '';
+	ALTER TABLE videos ADD COLUMN duration_ms INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE videos ADD COLUMN width INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE videos ADD COLUMN height INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE videos ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE videos ADD COLUMN thumbnail TEXT NOT NULL DEFAULT '';
+	ALTER TABLE jobs ADD COLUMN title TEXT NOT NULL DEFAULT '';
+	ALTER TABLE jobs ADD COLUMN description TEXT NOT NULL DEFAULT '';
+	ALTER TABLE jobs ADD COLUMN uploader TEXT NOT NULL DEFAULT ''`,
+
+	// 3: tombstones for videos whose content is being deleted
+	`
+	ALTER TABLE videos ADD COLUMN deleted_at DATETIME`,
+
+	// 4: indexes for paging through the listing and searching it by title
+	`
+	CREATE INDEX IF NOT EXISTS videos_uploaded_at ON videos (uploaded_at, id) WHERE deleted_at IS NULL;
+	CREATE INDEX IF NOT EXISTS videos_title ON videos (title COLLATE NOCASE) WHERE deleted_at IS NULL;
+	CREATE INDEX IF NOT EXISTS videos_id_nocase ON videos (id COLLATE NOCASE) WHERE deleted_at IS NULL`,
+
+	// 5: accounts, sessions, and video ownership and visibility
+	`
+	CREATE TABLE users (
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		password_hash TEXT NOT NULL,
+		is_admin INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME
+	);
+	CREATE TABLE sessions (
+		token_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		csrf_token TEXT NOT NULL,
+		created_at DATETIME,
+		expires_at DATETIME
+	);
+	ALTER TABLE videos ADD COLUMN owner_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE videos ADD COLUMN visibility TEXT NOT NULL DEFAULT 'public';
+	ALTER TABLE jobs ADD COLUMN owner_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE jobs ADD COLUMN visibility TEXT NOT NULL DEFAULT 'public';
+	CREATE INDEX videos_owner ON videos (owner_id, uploaded_at, id) WHERE deleted_at IS NULL`,
+
+	// 6: videos prepared by an upload transaction and not yet committed, which
+	// move to videos on commit
+	`
+	CREATE TABLE pending_videos (
+		tx_id TEXT PRIMARY KEY,
+		id TEXT NOT NULL UNIQUE,
+		uploaded_at DATETIME,
+		title TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		uploader TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		width INTEGER NOT NULL DEFAULT 0,
+		height INTEGER NOT NULL DEFAULT 0,
+		size INTEGER NOT NULL DEFAULT 0,
+		thumbnail TEXT NOT NULL DEFAULT '',
+		owner_id TEXT NOT NULL DEFAULT '',
+		visibility TEXT NOT NULL DEFAULT 'public'
+	)`,
+
+	// 7: live streams
+	`
+	ALTER TABLE videos ADD COLUMN live INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE pending_videos ADD COLUMN live INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN live INTEGER NOT NULL DEFAULT 0`,
+
+	// 8: digests of uploaded files, for spotting duplicate uploads
+	`
+	ALTER TABLE videos ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';
+	ALTER TABLE pending_videos ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';
+	ALTER TABLE jobs ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';
+	CREATE INDEX videos_sha256 ON videos (owner_id, sha256) WHERE deleted_at IS NULL`,
+
+	// 9: finding an owner's uploads of a file
+	`CREATE INDEX jobs_sha256 ON jobs (owner_id, sha256)`,
+
+	// 10: whether a video has HLS playlists, so the video page need not look.
+	// Videos from before it was recorded are played as DASH.
+	`
+	ALTER TABLE videos ADD COLUMN hls INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE pending_videos ADD COLUMN hls INTEGER NOT NULL DEFAULT 0`,
+}
+
+// Applies the migrations db has not seen yet, each in its own transaction
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
 	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply schema migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record schema migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to apply schema migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
 
-	return &SQLiteVideoMetadataService{db: db}, nil
+// PrepareCreate stores a video in pending_videos until its transaction commits.
+// The UNIQUE id there keeps two transactions from preparing the same video.
+func (s *SQLiteVideoMetadataService) PrepareCreate(txId string, v VideoMetadata) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM videos WHERE id = ?)", v.Id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrVideoIDTaken
+	}
+	_, err = tx.Exec(
+		"INSERT INTO pending_videos (tx_id, "+videoColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		txId, v.Id, v.UploadedAt, v.Title, v.Description, v.Uploader, v.Duration.Milliseconds(), v.Width, v.Height, v.Size, v.Thumbnail, v.OwnerId, v.Visibility, v.Live, v.SHA256, v.HLS,
+	)
+	if isUniqueViolation(err) {
+		return ErrVideoIDTaken
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CommitCreate moves a prepared video into videos
+func (s *SQLiteVideoMetadataService) CommitCreate(txId string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("INSERT INTO videos ("+videoColumns+") SELECT "+videoColumns+" FROM pending_videos WHERE tx_id = ?", txId)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM pending_videos WHERE tx_id = ?", txId); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// Create inserts a new metadata row
-func (s *SQLiteVideoMetadataService) Create(videoId string, uploadedAt time.Time) error {
-	_, err := s.db.Exec("INSERT INTO videos (id, uploaded_at) VALUES (?, ?)", videoId, uploadedAt)
+// AbortCreate drops a prepared video
+func (s *SQLiteVideoMetadataService) AbortCreate(txId string) error {
+	_, err := s.db.Exec("DELETE FROM pending_videos WHERE tx_id = ?", txId)
 	return err
 }
 
-// List returns all video metadata
-func (s *SQLiteVideoMetadataService) List() ([]VideoMetadata, error) {
-	rows, err := s.db.Query("SELECT id, uploaded_at FROM videos ORDER BY uploaded_at DESC")
+const videoColumns = "id, uploaded_at, title, description, uploader, duration_ms, width, height, size, thumbnail, owner_id, visibility, live, sha256, hls"
+
+func scanVideo(row interface{ Scan(...any) error }) (*VideoMetadata, error) {
+	var v VideoMetadata
+	var durationMs int64
+	if err := row.Scan(&v.Id, &v.UploadedAt, &v.Title, &v.Description, &v.Uploader, &durationMs, &v.Width, &v.Height, &v.Size, &v.Thumbnail, &v.OwnerId, &v.Visibility, &v.Live, &v.SHA256, &v.HLS); err != nil {
+		return nil, err
+	}
+	v.Duration = time.Duration(durationMs) * time.Millisecond
+	return &v, nil
+}
+
+// The format the driver writes time.Time values in
+const sqliteTimestampFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// Cursors are the sort key of a page's first or last row. uploaded_at is kept
+// as the text SQLite stores, so the comparison matches the ORDER BY exactly.
+func encodeCursor(v VideoMetadata) string {
+	sortKey := v.UploadedAt.Format(sqliteTimestampFormat)
+	return base64.RawURLEncoding.EncodeToString([]byte(sortKey + "\x00" + v.Id))
+}
+
+func decodeCursor(cursor string) (string, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", ErrInvalidCursor
+	}
+	uploadedAt, id, ok := strings.Cut(string(raw), "\x00")
+	if !ok {
+		return "", "", ErrInvalidCursor
+	}
+	return uploadedAt, id, nil
+}
+
+// Escapes the LIKE wildcards in a search prefix
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// List returns one page of video metadata, newest first
+func (s *SQLiteVideoMetadataService) List(opts ListOptions) (*VideoPage, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultPageSize
+	}
+	where := []string{"deleted_at IS NULL"}
+	var args []any
+	switch {
+	case opts.AllVideos:
+	case opts.ViewerId != "":
+		where = append(where, "(visibility = 'public' OR owner_id = ?)")
+		args = append(args, opts.ViewerId)
+	default:
+		where = append(where, "visibility = 'public'")
+	}
+	if opts.Query != "" {
+		// LIKE ignores ASCII case, so the NOCASE indexes serve the prefixes
+		where = append(where, `(title LIKE ? ESCAPE '\' OR id LIKE ? ESCAPE '\')`)
+		pattern := likeEscaper.Replace(opts.Query) + "%"
+		args = append(args, pattern, pattern)
+	}
+	order := "DESC"
+	if opts.Cursor != "" {
+		uploadedAt, id, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Before {
+			where = append(where, "(uploaded_at, id) > (?, ?)")
+			order = "ASC"
+		} else {
+			where = append(where, "(uploaded_at, id) < (?, ?)")
+		}
+		args = append(args, uploadedAt, id)
+	}
+	// One extra row tells whether there is another page in this direction
+	query := "SELECT " + videoColumns + " FROM videos WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY uploaded_at " + order + ", id " + order + " LIMIT ?"
+	args = append(args, opts.Limit+1)
+
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var result []VideoMetadata
+	var videos []VideoMetadata
 	for rows.Next() {
-		var v VideoMetadata
-		var ts string
-		err := rows.Scan(&v.Id, &ts)
+		v, err := scanVideo(rows)
 		if err != nil {
 			return nil, err
 		}
-		v.UploadedAt, err = time.Parse(time.RFC3339, ts)
+		videos = append(videos, *v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	more := len(videos) > opts.Limit
+	if more {
+		videos = videos[:opts.Limit]
+	}
+	if opts.Before {
+		slices.Reverse(videos)
+	}
+
+	page := &VideoPage{Videos: videos}
+	if len(videos) == 0 {
+		return page, nil
+	}
+	// Going forward there is a previous page whenever we came from a cursor,
+	// and going backward there is a next page for the same reason
+	hasNext, hasPrev := more, opts.Cursor != ""
+	if opts.Before {
+		hasNext, hasPrev = opts.Cursor != "", more
+	}
+	if hasNext {
+		page.NextCursor = encodeCursor(videos[len(videos)-1])
+	}
+	if hasPrev {
+		page.PrevCursor = encodeCursor(videos[0])
+	}
+	return page, nil
+}
+
+// Read retrieves one video metadata by ID
+func (s *SQLiteVideoMetadataService) Read(videoId string) (*VideoMetadata, error) {
+	return scanVideo(s.db.QueryRow("SELECT "+videoColumns+" FROM videos WHERE id = ? AND deleted_at IS NULL", videoId))
+}
+
+// Update replaces the title, description and visibility of a video
+func (s *SQLiteVideoMetadataService) Update(videoId string, title string, description string, visibility Visibility) error {
+	res, err := s.db.Exec("UPDATE videos SET title = ?, description = ?, visibility = ? WHERE id = ? AND deleted_at IS NULL", title, description, visibility, videoId)
+	return checkAffected(res, err)
+}
+
+// EndLive marks a live video as ended and records its duration
+func (s *SQLiteVideoMetadataService) EndLive(videoId string, duration time.Duration) error {
+	res, err := s.db.Exec("UPDATE videos SET live = 0, duration_ms = ? WHERE id = ? AND deleted_at IS NULL", duration.Milliseconds(), videoId)
+	return checkAffected(res, err)
+}
+
+// MarkDeleted tombstones a video
+func (s *SQLiteVideoMetadataService) MarkDeleted(videoId string) error {
+	res, err := s.db.Exec("UPDATE videos SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", time.Now(), videoId)
+	return checkAffected(res, err)
+}
+
+// ListDeleted returns the IDs of tombstoned videos
+func (s *SQLiteVideoMetadataService) ListDeleted() ([]string, error) {
+	rows, err := s.db.Query("SELECT id FROM videos WHERE deleted_at IS NOT NULL ORDER BY deleted_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Delete removes a tombstoned video's row for good
+func (s *SQLiteVideoMetadataService) Delete(videoId string) error {
+	_, err := s.db.Exec("DELETE FROM videos WHERE id = ? AND deleted_at IS NOT NULL", videoId)
+	return err
+}
+
+// Turns an UPDATE that matched no row into sql.ErrNoRows
+func checkAffected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateJob inserts a new transcode job. The existence checks are part of the
+// INSERT, so two uploads racing for one video ID cannot both get a job.
+func (s *SQLiteVideoMetadataService) CreateJob(job TranscodeJob) error {
+	return insertJob(s.db, job)
+}
+
+// CreateUniqueJob creates job unless the owner has a video, or a queued or
+// running job, from the same file, whose job it returns instead. With the one
+// connection, the check and the insert are a single transaction.
+func (s *SQLiteVideoMetadataService) CreateUniqueJob(job TranscodeJob) (*TranscodeJob, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	existing, err := scanJob(tx.QueryRow(
+		"SELECT "+jobColumns+" FROM jobs WHERE owner_id = ? AND sha256 = ? AND sha256 != ''"+
+			" AND (status IN (?, ?) OR video_id IN (SELECT id FROM videos WHERE owner_id = ? AND sha256 = ? AND deleted_at IS NULL))"+
+			" ORDER BY created_at DESC LIMIT 1",
+		job.OwnerId, job.SHA256, JobQueued, JobRunning, job.OwnerId, job.SHA256,
+	))
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err := insertJob(tx, job); err != nil {
+		return nil, err
+	}
+	return nil, tx.Commit()
+}
+
+// Inserts job unless its video ID is taken
+func insertJob(db interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, job TranscodeJob) error {
+	res, err := db.Exec(
+		"INSERT INTO jobs ("+jobColumns+") SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?"+
+			" WHERE NOT EXISTS (SELECT 1 FROM videos WHERE id = ?) AND NOT EXISTS (SELECT 1 FROM jobs WHERE video_id = ?)",
+		job.Id, job.VideoId, job.SourcePath, job.Status, job.Error, job.CreatedAt, job.UpdatedAt, job.Title, job.Description, job.Uploader, job.OwnerId, job.Visibility, job.Live, job.SHA256,
+		job.VideoId, job.VideoId,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrVideoIDTaken
+	}
+	return nil
+}
+
+// UpdateJob records a job's new status
+func (s *SQLiteVideoMetadataService) UpdateJob(jobId string, status JobStatus, errMsg string) error {
+	res, err := s.db.Exec("UPDATE jobs SET status = ?, error = ?, updated_at = ? WHERE id = ?", status, errMsg, time.Now(), jobId)
+	return checkAffected(res, err)
+}
+
+const jobColumns = "id, video_id, source_path, status, error, created_at, updated_at, title, description, uploader, owner_id, visibility, live, sha256"
+
+func scanJob(row interface{ Scan(...any) error }) (*TranscodeJob, error) {
+	var j TranscodeJob
+	if err := row.Scan(&j.Id, &j.VideoId, &j.SourcePath, &j.Status, &j.Error, &j.CreatedAt, &j.UpdatedAt, &j.Title, &j.Description, &j.Uploader, &j.OwnerId, &j.Visibility, &j.Live, &j.SHA256); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// ReadJob retrieves one job by ID
+func (s *SQLiteVideoMetadataService) ReadJob(jobId string) (*TranscodeJob, error) {
+	return scanJob(s.db.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE id = ?", jobId))
+}
+
+// ReadJobByVideo retrieves the latest job for a video
+func (s *SQLiteVideoMetadataService) ReadJobByVideo(videoId string) (*TranscodeJob, error) {
+	return scanJob(s.db.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE video_id = ? ORDER BY created_at DESC LIMIT 1", videoId))
+}
+
+// ListJobs returns the jobs in any of the given states, oldest first
+func (s *SQLiteVideoMetadataService) ListJobs(statuses ...JobStatus) ([]TranscodeJob, error) {
+	query := "SELECT " + jobColumns + " FROM jobs"
+	var args []any
+	if len(statuses) > 0 {
+		query += " WHERE status IN (?" + strings.Repeat(", ?", len(statuses)-1) + ")"
+		for _, st := range statuses {
+			args = append(args, st)
+		}
+	}
+	query += " ORDER BY created_at"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []TranscodeJob
+	for rows.Next() {
+		j, err := scanJob(rows)
 		if err != nil {
 			return nil, err
 		}
-		result = append(result, v)
+		result = append(result, *j)
 	}
-	return result, nil
+	return result, rows.Err()
 }
 
-// Read retrieves one video metadata by ID
-func (s *SQLiteVideoMetadataService) Read(videoId string) (*VideoMetadata, error) {
-	row := s.db.QueryRow("SELECT id, uploaded_at FROM videos WHERE id = ?", videoId)
+// CreateUser inserts a new account
+func (s *SQLiteVideoMetadataService) CreateUser(user User, adminIfFirst bool) (*User, error) {
+	err := s.db.QueryRow(
+		"INSERT INTO users (id, username, password_hash, is_admin, created_at)"+
+			" SELECT ?, ?, ?, ? OR (? AND NOT EXISTS (SELECT 1 FROM users)), ? RETURNING is_admin",
+		user.Id, user.Username, user.PasswordHash, user.IsAdmin, adminIfFirst, user.CreatedAt,
+	).Scan(&user.IsAdmin)
+	if isUniqueViolation(err) {
+		return nil, ErrUsernameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
 
-	var v VideoMetadata
-	var ts string
-	if err := row.Scan(&v.Id, &ts); err != nil {
+const userColumns = "id, username, password_hash, is_admin, created_at"
+
+func scanUser(row *sql.Row) (*User, error) {
+	var u User
+	if err := row.Scan(&u.Id, &u.Username, &u.PasswordHash, &u.IsAdmin, &u.CreatedAt); err != nil {
 		return nil, err
 	}
+	return &u, nil
+}
+
+// ReadUser retrieves an account by ID
+func (s *SQLiteVideoMetadataService) ReadUser(userId string) (*User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", userId))
+}
+
+// ReadUserByName retrieves an account by username, ignoring case
+func (s *SQLiteVideoMetadataService) ReadUserByName(username string) (*User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
 
-	parsed, err := time.Parse(time.RFC3339, ts)
+// SetAdmin makes an account an admin
+func (s *SQLiteVideoMetadataService) SetAdmin(username string) error {
+	res, err := s.db.Exec("UPDATE users SET is_admin = 1 WHERE username = ?", username)
+	return checkAffected(res, err)
+}
+
+// CreateSession inserts a new session, clearing out expired ones on the way
+func (s *SQLiteVideoMetadataService) CreateSession(session Session) error {
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE expires_at <= ?", time.Now()); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(
+		"INSERT INTO sessions (token_hash, user_id, csrf_token, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		session.TokenHash, session.UserId, session.CSRFToken, session.CreatedAt, session.ExpiresAt,
+	)
+	return err
+}
+
+// ReadSession retrieves a session that has not expired
+func (s *SQLiteVideoMetadataService) ReadSession(tokenHash string) (*Session, error) {
+	var session Session
+	err := s.db.QueryRow(
+		"SELECT token_hash, user_id, csrf_token, created_at, expires_at FROM sessions WHERE token_hash = ? AND expires_at > ?",
+		tokenHash, time.Now(),
+	).Scan(&session.TokenHash, &session.UserId, &session.CSRFToken, &session.CreatedAt, &session.ExpiresAt)
 	if err != nil {
-		return nil, fmt.Errorf("invalid timestamp format: %w", err)
+		return nil, err
 	}
-	v.UploadedAt = parsed
-	return &v, nil
+	return &session, nil
 }
 
+// DeleteSession ends a session
+func (s *SQLiteVideoMetadataService) DeleteSession(tokenHash string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	return err
+}
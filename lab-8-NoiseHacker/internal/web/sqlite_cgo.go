@@ -0,0 +1,15 @@
+//go:build cgo
+
+package web
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Reports whether err is an insert that broke a UNIQUE constraint
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
@@ -0,0 +1,11 @@
+//go:build !cgo
+
+package web
+
+import "strings"
+
+// Reports whether err is an insert that broke a UNIQUE constraint. The
+// driver's error type needs cgo, so without it only the message is left.
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
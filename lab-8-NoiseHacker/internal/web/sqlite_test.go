@@ -0,0 +1,83 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSQLite(t *testing.T) *SQLiteVideoMetadataService {
+	t.Helper()
+	s, err := NewSQLiteVideoMetadataService(filepath.Join(t.TempDir(), "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func createVideo(t *testing.T, s *SQLiteVideoMetadataService, v VideoMetadata) {
+	t.Helper()
+	txId := "tx-" + v.Id
+	if err := s.PrepareCreate(txId, v); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CommitCreate(txId); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Cursors compare against the stored text, so they only page correctly if
+// they are formatted the way the driver stores times
+func TestListPagesThroughEveryVideo(t *testing.T) {
+	s := newTestSQLite(t)
+	zone := time.FixedZone("", -7*60*60)
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, zone)
+	for i := range 7 {
+		// Pairs share an upload time, so the ID breaks the tie
+		uploadedAt := base.Add(time.Duration(i/2) * 1500 * time.Millisecond)
+		createVideo(t, s, VideoMetadata{Id: fmt.Sprintf("video-%d", i), UploadedAt: uploadedAt, Visibility: Public})
+	}
+
+	var ids []string
+	opts := ListOptions{Limit: 3}
+	for {
+		page, err := s.List(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range page.Videos {
+			ids = append(ids, v.Id)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	want := []string{"video-6", "video-5", "video-4", "video-3", "video-2", "video-1", "video-0"}
+	if fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Fatalf("pages listed %v, want %v", ids, want)
+	}
+}
+
+func TestCreateRejectsTakenNames(t *testing.T) {
+	s := newTestSQLite(t)
+	user := User{Id: "u1", Username: "alice", PasswordHash: "x", CreatedAt: time.Now()}
+	if _, err := s.CreateUser(user, false); err != nil {
+		t.Fatal(err)
+	}
+	user.Id = "u2"
+	if _, err := s.CreateUser(user, false); !errors.Is(err, ErrUsernameTaken) {
+		t.Fatalf("creating a second alice returned %v, want ErrUsernameTaken", err)
+	}
+
+	// Two uploads racing for one ID are both still pending
+	v := VideoMetadata{Id: "video", UploadedAt: time.Now(), Visibility: Public}
+	if err := s.PrepareCreate("tx1", v); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PrepareCreate("tx2", v); !errors.Is(err, ErrVideoIDTaken) {
+		t.Fatalf("preparing a taken video ID returned %v, want ErrVideoIDTaken", err)
+	}
+}
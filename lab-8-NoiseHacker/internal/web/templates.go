@@ -11,21 +11,53 @@ const indexHTML = `
   </head>
   <body>
     <h1>Welcome to TritonTube</h1>
+    {{if .Username}}
+    <form action="/logout" method="post">
+      Logged in as {{.Username}}.
+      <input type="hidden" name="csrf_token" value="{{.CSRFToken}}" />
+      <input type="submit" value="Log out" />
+    </form>
     <h2>Upload an MP4 Video</h2>
     <form action="/upload" method="post" enctype="multipart/form-data">
+      <input type="hidden" name="csrf_token" value="{{.CSRFToken}}" />
+      <p><input type="text" name="title" placeholder="Title (defaults to the file name)" maxlength="200" /></p>
+      <p><textarea name="description" placeholder="Description" maxlength="5000"></textarea></p>
+      <p>
+        <select name="visibility">
+          <option value="public">Public</option>
+          <option value="unlisted">Unlisted (anyone with the link)</option>
+          <option value="private">Private (only you)</option>
+        </select>
+      </p>
       <input type="file" name="file" accept="video/mp4" required />
       <input type="submit" value="Upload" />
     </form>
+    {{else}}
+    <p><a href="/login">Log in</a> or <a href="/signup">sign up</a> to upload videos.</p>
+    {{end}}
     <h2>Watchlist</h2>
+    <form action="/" method="get">
+      <input type="search" name="q" value="{{.Query}}" placeholder="Search titles and IDs" />
+      <input type="submit" value="Search" />
+    </form>
     <ul>
-      {{range .}}
+      {{range .Videos}}
       <li>
-        <a href="/videos/{{.EscapedId}}">{{.Id}} ({{.UploadTime}})</a>
+        <a href="/videos/{{.EscapedId}}">
+          {{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="" height="90" />{{end}}
+          {{.Title}}</a>
+        {{if .Live}}[LIVE]{{else if .Duration}}[{{.Duration}}]{{end}}
+        {{if .Uploader}}by {{.Uploader}}{{end}}
+        ({{.UploadTime}})
       </li>
       {{else}}
-      <li>No videos uploaded yet.</li>
+      <li>{{if .Query}}No videos match "{{.Query}}".{{else}}No videos uploaded yet.{{end}}</li>
       {{end}}
     </ul>
+    <p>
+      {{if .PrevURL}}<a href="{{.PrevURL}}">&laquo; Newer</a>{{end}}
+      {{if .NextURL}}<a href="{{.NextURL}}">Older &raquo;</a>{{end}}
+    </p>
   </body>
 </html>
 `
@@ -35,20 +67,105 @@ const videoHTML = `
 <html>
   <head>
     <meta charset="UTF-8" />
-    <title>{{.Id}} - TritonTube</title>
+    <title>{{.Title}} - TritonTube</title>
     <script src="https://cdn.dashjs.org/latest/dash.all.min.js"></script>
   </head>
   <body>
-    <h1>{{.Id}}</h1>
-	  <p>Uploaded at: {{.UploadTime}}</p>
+    <h1>{{.Title}}</h1>
+	  <p>{{if .Live}}<strong>LIVE</strong> since{{else}}Uploaded at:{{end}} {{.UploadTime}}{{if .Uploader}} by {{.Uploader}}{{end}}</p>
 
-    <video id="dashPlayer" controls style="width: 640px; height: 360px"></video>
+    <video id="dashPlayer" controls style="width: 640px; height: 360px"{{if .Thumbnail}} poster="{{.Thumbnail}}"{{end}}></video>
     <script>
-      var url = "/content/{{.EscapedId}}/manifest.mpd";
-      var player = dashjs.MediaPlayer().create();
-      player.initialize(document.querySelector("#dashPlayer"), url, false);
+      var video = document.querySelector("#dashPlayer");
+      var hasHLS = {{.HasHLS}};
+      // Browsers with native HLS (Safari) play the HLS playlists, everyone else
+      // plays DASH through dash.js. Live streams start playing at the live edge
+      // right away; dash.js follows their dynamic manifest as it grows.
+      if (hasHLS && video.canPlayType("application/vnd.apple.mpegurl")) {
+        video.src = "{{.ContentBase}}/master.m3u8";
+      } else {
+        var url = "{{.ContentBase}}/manifest.mpd";
+        var player = dashjs.MediaPlayer().create();
+        player.initialize(video, url, {{.Live}});
+      }
     </script>
 
+    {{if .Description}}<p style="white-space: pre-wrap">{{.Description}}</p>{{end}}
+    <p>
+      {{if .Duration}}Duration: {{.Duration}}<br />{{end}}
+      {{if .Resolution}}Resolution: {{.Resolution}}<br />{{end}}
+      {{if .Size}}Size: {{.Size}}{{end}}
+    </p>
+
+    {{if .CanModify}}
+    <details>
+      <summary>Edit</summary>
+      <form action="/videos/{{.EscapedId}}/edit" method="post">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}" />
+        <p><input type="text" name="title" value="{{.Title}}" maxlength="200" required /></p>
+        <p><textarea name="description" maxlength="5000">{{.Description}}</textarea></p>
+        <p>
+          <select name="visibility">
+            <option value="public"{{if eq .Visibility "public"}} selected{{end}}>Public</option>
+            <option value="unlisted"{{if eq .Visibility "unlisted"}} selected{{end}}>Unlisted (anyone with the link)</option>
+            <option value="private"{{if eq .Visibility "private"}} selected{{end}}>Private (only you)</option>
+          </select>
+        </p>
+        <input type="submit" value="Save" />
+      </form>
+      <form action="/videos/{{.EscapedId}}/delete" method="post" onsubmit="return confirm('Delete this video?')">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}" />
+        <input type="submit" value="Delete video" />
+      </form>
+    </details>
+    {{end}}
+
+    <p><a href="/">Back to Home</a></p>
+  </body>
+</html>
+`
+
+const processingHTML = `
+<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="UTF-8" />
+    <title>{{.Title}} - TritonTube</title>
+    {{if .Pending}}<meta http-equiv="refresh" content="3" />{{end}}
+  </head>
+  <body>
+    <h1>{{.Title}}</h1>
+    <p>Uploaded at: {{.UploadTime}}</p>
+    {{if .Pending}}
+    <p>This video is still being processed ({{.Status}}). This page refreshes automatically.</p>
+    {{else}}
+    <p>Processing this video failed: {{.Error}}</p>
+    {{end}}
+    <p><a href="/">Back to Home</a></p>
+  </body>
+</html>
+`
+
+const authHTML = `
+<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="UTF-8" />
+    <title>{{if .Signup}}Sign up{{else}}Log in{{end}} - TritonTube</title>
+  </head>
+  <body>
+    <h1>{{if .Signup}}Sign up{{else}}Log in{{end}}</h1>
+    {{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+    <form action="{{if .Signup}}/signup{{else}}/login{{end}}" method="post">
+      <p><input type="text" name="username" value="{{.Username}}" placeholder="Username" maxlength="32" required autofocus /></p>
+      <p><input type="password" name="password" placeholder="Password" minlength="8" required /></p>
+      <input type="submit" value="{{if .Signup}}Sign up{{else}}Log in{{end}}" />
+    </form>
+    {{if .Signup}}
+    <p>Already have an account? <a href="/login">Log in</a></p>
+    {{else}}
+    <p>No account yet? <a href="/signup">Sign up</a></p>
+    {{end}}
     <p><a href="/">Back to Home</a></p>
   </body>
 </html>
@@ -0,0 +1,293 @@
+// Transcoding uploads to MPEG-DASH and handing the output to the content service
+
+package web
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often the output directory is checked for finished segments
+const segmentPollInterval = 500 * time.Millisecond
+
+// Names of the top-level manifests a transcoded video may have, and of its
+// thumbnail
+const (
+	DASHManifest      = "manifest.mpd"
+	HLSMasterPlaylist = "master.m3u8"
+	ThumbnailName     = "thumbnail.jpg"
+)
+
+// Height of thumbnails; the width keeps the aspect ratio
+const thumbnailHeight = 180
+
+var (
+	initSegmentName  = regexp.MustCompile(`^init-(\d+)\.m4s$`)
+	mediaSegmentName = regexp.MustCompile(`^chunk-(\d+)-(\d+)\.m4s$`)
+)
+
+// Converts MP4 to MPEG-DASH format using ffmpeg, with one video representation
+// per rung (IDs 0..len(rungs)-1) and, if the source has audio, one audio
+// representation after them. All renditions share the keyframe interval so
+// players can switch between them at segment boundaries. With hls, ffmpeg also
+// writes an HLS master playlist (master.m3u8) and one media playlist per
+// representation (media_N.m3u8) over the same fMP4 segments.
+func ffmpegCommand(videoPath, tempDir string, ladder Ladder, rungs []Rung, info sourceInfo, hls bool) *exec.Cmd {
+	var split strings.Builder
+	fmt.Fprintf(&split, "[0:v]split=%d", len(rungs))
+	for i := range rungs {
+		fmt.Fprintf(&split, "[v%d]", i)
+	}
+	for i, r := range rungs {
+		fmt.Fprintf(&split, ";[v%d]scale=-2:%d[v%dout]", i, r.Height, i)
+	}
+
+	args := []string{
+		"-i", videoPath,                          // input file
+		"-filter_complex", split.String(),        // one scaled copy per rung
+	}
+	for i, r := range rungs {
+		args = append(args,
+			"-map", fmt.Sprintf("[v%dout]", i),
+			fmt.Sprintf("-b:v:%d", i), r.VideoBitrate,     // video bitrate
+			fmt.Sprintf("-maxrate:v:%d", i), r.VideoBitrate,
+			fmt.Sprintf("-bufsize:v:%d", i), r.VideoBitrate,
+		)
+	}
+	adaptationSets := "id=0,streams=v"
+	if info.HasAudio {
+		args = append(args,
+			"-map", "0:a:0",
+			"-c:a", "aac",                            // audio codec
+			"-b:a", ladder.AudioBitrate,              // audio bitrate
+		)
+		adaptationSets += " id=1,streams=a"
+	}
+	args = append(args,
+		"-c:v", "libx264",                        // video codec
+		"-bf", "1",                               // max 1 b-frame
+		"-keyint_min", "30",                     // minimum keyframe interval
+		"-g", "30",                              // keyframe every 25 frames
+		"-sc_threshold", "0",                     // scene change threshold
+		"-f", "dash",                             // dash format
+		"-adaptation_sets", adaptationSets,       // all video rungs in one switchable set
+		"-use_timeline", "1",                     // use timeline
+		"-use_template", "1",                     // use template
+		"-init_seg_name", "init-$RepresentationID$.m4s",       // init segment naming
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s", // media segment naming
+		"-seg_duration", "2",                     // segment duration in seconds
+		"-min_seg_duration", "2000000",				// minimum segment duration in us
+	)
+	if hls {
+		args = append(args,
+			"-hls_playlist", "1",                     // also write HLS playlists
+			"-hls_master_name", HLSMasterPlaylist,    // master playlist naming
+		)
+	}
+	args = append(args, DASHManifest)               // output file
+
+	cmd := exec.Command("ffmpeg", args...)
+	cmd.Dir = tempDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// contentWriter is where transcoded files go: a VideoContentService, a
+// contextWriter, or an upload transaction staging files in one
+type contentWriter interface {
+	WriteStream(videoId string, filename string, r io.Reader) error
+}
+
+// contextWriter is a contentWriter that calls a content service with ctx
+type contextWriter struct {
+	ctx context.Context
+	cs  VideoContentService
+}
+
+func (w contextWriter) WriteStream(videoId string, filename string, r io.Reader) error {
+	return writeStreamContent(w.ctx, w.cs, videoId, filename, r)
+}
+
+// Grabs one frame from early in the video as a JPEG and stores it as
+// ThumbnailName
+func storeThumbnail(cs contentWriter, videoID, videoPath, outDir string, info sourceInfo) error {
+	// One second in skips black intro frames, unless the video is shorter
+	at := time.Second
+	if info.Duration > 0 && info.Duration < 2*at {
+		at = info.Duration / 2
+	}
+	cmd := exec.Command("ffmpeg",
+		"-ss", fmt.Sprintf("%.3f", at.Seconds()), // seek before decoding
+		"-i", videoPath,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=-2:%d", min(thumbnailHeight, info.Height&^1)),
+		"-q:v", "4",
+		ThumbnailName,
+	)
+	cmd.Dir = outDir
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	observeFFmpeg("thumbnail", start, err)
+	if err != nil {
+		return fmt.Errorf("ffmpeg thumbnail failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	defer os.Remove(filepath.Join(outDir, ThumbnailName))
+	return storeFile(cs, videoID, outDir, ThumbnailName)
+}
+
+// Runs ffmpeg on videoPath and streams every segment into the content service as
+// soon as ffmpeg has moved on to the next one, deleting it locally afterwards.
+// The manifests are stored last, once ffmpeg has exited successfully, so a video
+// never references segments that are not in the content service yet.
+func transcodeAndStore(ctx context.Context, cs contentWriter, videoID, videoPath, outDir string, ladder Ladder, hls bool, info sourceInfo) error {
+	rungs := ladder.rungsFor(info.Height)
+	slog.InfoContext(ctx, "Transcoding", "video_id", videoID, "width", info.Width, "height", info.Height, "renditions", len(rungs))
+
+	cmd := ffmpegCommand(videoPath, outDir, ladder, rungs, info, hls)
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		observeFFmpeg("transcode", start, err)
+		done <- err
+	}()
+
+	source := filepath.Base(videoPath)
+	stored := make(map[string]bool)
+	ticker := time.NewTicker(segmentPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return fmt.Errorf("ffmpeg failed: %w", err)
+			}
+			return StoreInContentService(cs, videoID, outDir, source)
+		case <-ticker.C:
+			if err := storeFinishedSegments(cs, videoID, outDir, stored); err != nil {
+				cmd.Process.Kill()
+				<-done
+				return err
+			}
+		}
+	}
+}
+
+// Stores the segments in dir that ffmpeg is known to be done with
+func storeFinishedSegments(cs contentWriter, videoID, dir string, stored map[string]bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list dir: %w", err)
+	}
+	present := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		present[entry.Name()] = true
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || stored[name] || !segmentFinished(name, present) {
+			continue
+		}
+		if err := storeFile(cs, videoID, dir, name); err != nil {
+			return err
+		}
+		stored[name] = true
+		os.Remove(filepath.Join(dir, name))
+	}
+	return nil
+}
+
+// A media segment is finished once the next segment of the same representation
+// exists, and an init segment once any media segment of its representation does.
+func segmentFinished(name string, present map[string]bool) bool {
+	if m := mediaSegmentName.FindStringSubmatch(name); m != nil {
+		num, _ := strconv.Atoi(m[2])
+		next := fmt.Sprintf("chunk-%s-%05d.m4s", m[1], num+1)
+		return present[next]
+	}
+	if m := initSegmentName.FindStringSubmatch(name); m != nil {
+		return present[fmt.Sprintf("chunk-%s-%05d.m4s", m[1], 1)]
+	}
+	return false
+}
+
+// Stores every remaining output file in dir, except the files named in skip,
+// temp files, and source MP4s. Manifests and playlists are stored last, and the
+// top-level ones after everything else.
+func StoreInContentService(fs contentWriter, videoID, dir string, skip ...string) error {
+	slog.Debug("Inspecting transcode output", "dir", dir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list dir: %w", err)
+	}
+	var manifests []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+
+		if strings.HasSuffix(name, ".mp4") || strings.HasSuffix(name, ".tmp") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if slices.Contains(skip, name) {
+			continue
+		}
+		if strings.HasSuffix(name, ".mpd") || strings.HasSuffix(name, ".m3u8") {
+			manifests = append(manifests, name)
+			continue
+		}
+		if err := storeFile(fs, videoID, dir, name); err != nil {
+			return err
+		}
+	}
+	// Media playlists first, so the master playlist never references a playlist
+	// that is missing
+	slices.SortStableFunc(manifests, func(a, b string) int {
+		if isTopLevelManifest(a) == isTopLevelManifest(b) {
+			return 0
+		}
+		if isTopLevelManifest(a) {
+			return 1
+		}
+		return -1
+	})
+	for _, name := range manifests {
+		if err := storeFile(fs, videoID, dir, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isTopLevelManifest(name string) bool {
+	return name == DASHManifest || name == HLSMasterPlaylist
+}
+
+// Streams one file from dir into the content service
+func storeFile(cs contentWriter, videoID, dir, name string) error {
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return fmt.Errorf("read %s: %w", name, err)
+	}
+	defer f.Close()
+	if err := cs.WriteStream(videoID, name, f); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
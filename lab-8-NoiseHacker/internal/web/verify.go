@@ -0,0 +1,323 @@
+// Verifying that the network content service holds every video completely.
+//
+// The files a video should have are the ones its DASH manifest and HLS
+// playlists reference, its thumbnail, and whatever else is stored under its
+// ID. Each of them has to be listed by all of its owners on the ring: a file
+// no owner holds is missing, and one only some owners hold is misplaced, which
+// a repair or rebalance fixes.
+
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"tritontube/internal/contentkey"
+	"tritontube/internal/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Videos listed per metadata page while verifying all of them
+const verifyPageSize = 100
+
+// UseVideoCatalog makes Verify check the videos of metadata
+func (n *NetworkVideoContentService) UseVideoCatalog(metadata VideoMetadataService) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.videos = metadata
+}
+
+// Verify checks that every file of the requested videos, or of all videos, is
+// on each of its owners
+func (n *NetworkVideoContentService) Verify(ctx context.Context, req *proto.VerifyRequest) (*proto.VerifyResponse, error) {
+	n.mu.RLock()
+	metadata := n.videos
+	ring := n.ring
+	migrating := n.migration != nil
+	n.mu.RUnlock()
+	if metadata == nil {
+		return nil, status.Error(codes.FailedPrecondition, "no metadata service to verify against")
+	}
+	// Keys are where the old ring put them until the migration cuts over
+	if migrating {
+		return nil, status.Error(codes.FailedPrecondition, "a migration is in progress")
+	}
+
+	videos, err := n.videosToVerify(metadata, req.VideoIds)
+	if err != nil {
+		return nil, err
+	}
+
+	// Which nodes hold each key. With a few videos, listing their prefixes is
+	// cheaper than listing everything.
+	prefixes := []string{""}
+	if len(req.VideoIds) > 0 {
+		prefixes = nil
+		for _, v := range videos {
+			prefixes = append(prefixes, v.Id+"/")
+		}
+	}
+	response := &proto.VerifyResponse{VideoCount: int32(len(videos))}
+	holders := make(map[string][]string)
+	unreachable := make(map[string]bool)
+	for _, addr := range ring.nodes() {
+		for _, prefix := range prefixes {
+			keys, err := n.listKeysOnNode(ctx, addr, prefix)
+			if err != nil {
+				unreachable[addr] = true
+				break
+			}
+			for _, key := range keys {
+				holders[key] = append(holders[key], addr)
+			}
+		}
+	}
+	response.UnreachableNodes = slices.Sorted(maps.Keys(unreachable))
+
+	// Stored files grouped by video
+	stored := make(map[string][]string)
+	for key := range holders {
+		if k, err := contentkey.Parse(key); err == nil {
+			stored[k.VideoID] = append(stored[k.VideoID], k.Filename)
+		}
+	}
+
+	for _, v := range videos {
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		files := n.expectedFiles(ctx, v, stored[v.Id])
+		problem := &proto.VideoProblem{VideoId: v.Id}
+		for _, filename := range files {
+			response.FileCount++
+			key := v.Id + "/" + filename
+			held := holders[key]
+			if len(held) == 0 {
+				problem.MissingFiles = append(problem.MissingFiles, filename)
+				continue
+			}
+			var missingFrom []string
+			for _, owner := range ring.owners(key, n.replicas) {
+				if !slices.Contains(held, owner) && !unreachable[owner] {
+					missingFrom = append(missingFrom, owner)
+				}
+			}
+			if len(missingFrom) > 0 {
+				problem.Misplaced = append(problem.Misplaced, &proto.FilePlacement{Filename: filename, MissingFrom: missingFrom})
+			}
+		}
+		if len(problem.MissingFiles) > 0 || len(problem.Misplaced) > 0 {
+			response.Problems = append(response.Problems, problem)
+		}
+	}
+	return response, nil
+}
+
+// Returns the videos with the given IDs, or all of them in ID order
+func (n *NetworkVideoContentService) videosToVerify(metadata VideoMetadataService, ids []string) ([]VideoMetadata, error) {
+	var videos []VideoMetadata
+	if len(ids) > 0 {
+		for _, id := range ids {
+			meta, err := metadata.Read(id)
+			if errors.Is(err, sql.ErrNoRows) || err == nil && meta == nil {
+				return nil, status.Errorf(codes.NotFound, "video %s not found", id)
+			}
+			if err != nil {
+				return nil, status.Errorf(codes.Unavailable, "failed to read video %s: %v", id, err)
+			}
+			videos = append(videos, *meta)
+		}
+	} else {
+		opts := ListOptions{AllVideos: true, Limit: verifyPageSize}
+		for {
+			page, err := metadata.List(opts)
+			if err != nil {
+				return nil, status.Errorf(codes.Unavailable, "failed to list videos: %v", err)
+			}
+			videos = append(videos, page.Videos...)
+			if page.NextCursor == "" {
+				break
+			}
+			opts.Cursor = page.NextCursor
+		}
+	}
+	slices.SortFunc(videos, func(a, b VideoMetadata) int { return strings.Compare(a.Id, b.Id) })
+	return videos, nil
+}
+
+// Returns the files video should have, sorted: the stored ones, the manifest,
+// the thumbnail, and the files the manifest and HLS playlists reference
+func (n *NetworkVideoContentService) expectedFiles(ctx context.Context, video VideoMetadata, stored []string) []string {
+	files := make(map[string]bool)
+	for _, name := range stored {
+		files[name] = true
+	}
+	files[DASHManifest] = true
+	if video.Thumbnail != "" {
+		files[video.Thumbnail] = true
+	}
+
+	if data, err := n.ReadContext(ctx, video.Id, DASHManifest); err == nil {
+		if names, err := manifestFiles(data); err == nil {
+			for _, name := range names {
+				files[name] = true
+			}
+		}
+	}
+	if files[HLSMasterPlaylist] {
+		playlists := []string{HLSMasterPlaylist}
+		for len(playlists) > 0 {
+			name := playlists[0]
+			playlists = playlists[1:]
+			data, err := n.ReadContext(ctx, video.Id, name)
+			if err != nil {
+				continue
+			}
+			for _, ref := range playlistFiles(data) {
+				if strings.HasSuffix(ref, ".m3u8") && !files[ref] {
+					playlists = append(playlists, ref)
+				}
+				files[ref] = true
+			}
+		}
+	}
+
+	// References that are not plain filenames cannot be stored under the video
+	for name := range files {
+		if _, err := contentkey.New(video.Id, name); err != nil {
+			delete(files, name)
+		}
+	}
+	return slices.Sorted(maps.Keys(files))
+}
+
+// The parts of an MPD that name files
+type mpd struct {
+	Periods []struct {
+		AdaptationSets []struct {
+			SegmentTemplate *segmentTemplate `xml:"SegmentTemplate"`
+			Representations []struct {
+				ID              string           `xml:"id,attr"`
+				Bandwidth       string           `xml:"bandwidth,attr"`
+				SegmentTemplate *segmentTemplate `xml:"SegmentTemplate"`
+			} `xml:"Representation"`
+		} `xml:"AdaptationSet"`
+	} `xml:"Period"`
+}
+
+type segmentTemplate struct {
+	Initialization string `xml:"initialization,attr"`
+	Media          string `xml:"media,attr"`
+	StartNumber    *int64 `xml:"startNumber,attr"`
+	Timescale      int64  `xml:"timescale,attr"`
+	Timeline       []struct {
+		T *int64 `xml:"t,attr"`
+		D int64  `xml:"d,attr"`
+		R int64  `xml:"r,attr"`
+	} `xml:"SegmentTimeline>S"`
+}
+
+// Matches the identifiers of a SegmentTemplate, with an optional width
+var templateIdentifier = regexp.MustCompile(`\$(RepresentationID|Number|Bandwidth|Time|)(%0(\d+)d)?\$`)
+
+// Returns the initialization and media segments a DASH manifest references
+// through SegmentTemplates with a SegmentTimeline, the kind ffmpeg writes.
+// Open-ended repeats (r="-1") count as a single segment.
+func manifestFiles(data []byte) ([]string, error) {
+	var doc mpd
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	var files []string
+	for _, period := range doc.Periods {
+		for _, set := range period.AdaptationSets {
+			for _, rep := range set.Representations {
+				tmpl := rep.SegmentTemplate
+				if tmpl == nil {
+					tmpl = set.SegmentTemplate
+				}
+				if tmpl == nil {
+					continue
+				}
+				expand := func(pattern string, number, time int64) string {
+					return templateIdentifier.ReplaceAllStringFunc(pattern, func(m string) string {
+						parts := templateIdentifier.FindStringSubmatch(m)
+						var value string
+						switch parts[1] {
+						case "":
+							return "$"
+						case "RepresentationID":
+							return rep.ID
+						case "Bandwidth":
+							value = rep.Bandwidth
+						case "Number":
+							value = strconv.FormatInt(number, 10)
+						case "Time":
+							value = strconv.FormatInt(time, 10)
+						}
+						if width, _ := strconv.Atoi(parts[3]); len(value) < width {
+							value = strings.Repeat("0", width-len(value)) + value
+						}
+						return value
+					})
+				}
+				if tmpl.Initialization != "" {
+					files = append(files, expand(tmpl.Initialization, 0, 0))
+				}
+				if tmpl.Media == "" {
+					continue
+				}
+				number := int64(1)
+				if tmpl.StartNumber != nil {
+					number = *tmpl.StartNumber
+				}
+				var time int64
+				for _, s := range tmpl.Timeline {
+					if s.T != nil {
+						time = *s.T
+					}
+					for range max(s.R, 0) + 1 {
+						files = append(files, expand(tmpl.Media, number, time))
+						number++
+						time += s.D
+					}
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// Matches the URI attribute of tags like EXT-X-MAP
+var playlistURI = regexp.MustCompile(`URI="([^"]*)"`)
+
+// Returns the files an HLS playlist references: its segments or variant
+// playlists, and the URIs in its tags
+func playlistFiles(data []byte) []string {
+	var files []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			for _, m := range playlistURI.FindAllStringSubmatch(line, -1) {
+				files = append(files, m[1])
+			}
+		default:
+			files = append(files, line)
+		}
+	}
+	return files
+}